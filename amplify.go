@@ -0,0 +1,77 @@
+package cliware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Amplifier is middleware that multiplies requests for load generation. It
+// is created using Amplify function.
+type Amplifier struct {
+	factor int32
+}
+
+// Amplify returns middleware that sends every request to next handler factor
+// times concurrently, and returns response to one of them, so existing
+// traffic of test or staging client can be used to generate load against
+// backend. It is load-testing tool and should not be used in production,
+// since every request, including non-idempotent ones, is sent multiple
+// times. Factor of 1 or less sends requests only once, and it can be changed
+// at runtime using SetFactor.
+//
+// Extra requests are copies of original one, with own header, URL and body,
+// which is buffered if request does not have GetBody set, and they are sent
+// with context of original request, so they are cancelled together with it.
+// Middleware waits for all extra requests to complete before returning, so
+// no goroutines outlive request, while their responses are drained and
+// closed, so connections can be reused. Response and error of original
+// request are returned.
+func Amplify(factor int) *Amplifier {
+	amplifier := &Amplifier{}
+	amplifier.SetFactor(factor)
+	return amplifier
+}
+
+// SetFactor sets number of times every request is sent.
+func (a *Amplifier) SetFactor(factor int) {
+	atomic.StoreInt32(&a.factor, int32(factor))
+}
+
+// Factor returns number of times every request is sent.
+func (a *Amplifier) Factor() int {
+	return int(atomic.LoadInt32(&a.factor))
+}
+
+// Exec is implementation of Middleware interface.
+func (a *Amplifier) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		factor := a.Factor()
+		if factor <= 1 || req == nil {
+			return next.Handle(ctx, req)
+		}
+		ctx = ensureContext(ctx)
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			if _, err = bufferRequestBody(req); err != nil {
+				return nil, err
+			}
+		}
+		var wg sync.WaitGroup
+		for i := 1; i < factor; i++ {
+			extra, err := cloneRequest(ctx, req)
+			if err != nil {
+				break
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, _ := next.Handle(ctx, extra)
+				drainAndClose(resp)
+			}()
+		}
+		resp, err = next.Handle(ctx, req)
+		wg.Wait()
+		return resp, err
+	})
+}
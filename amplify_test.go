@@ -0,0 +1,66 @@
+package cliware_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestAmplify(t *testing.T) {
+	var calls, inflight int32
+	var mu sync.Mutex
+	var bodies []*trackedBody
+	var mismatched int32
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		if data, _ := ioutil.ReadAll(req.Body); string(data) != "payload" {
+			atomic.AddInt32(&mismatched, 1)
+		}
+		body := &trackedBody{Reader: strings.NewReader("response")}
+		mu.Lock()
+		bodies = append(bodies, body)
+		mu.Unlock()
+		return &http.Response{StatusCode: 200, Body: body}, nil
+	})
+	amplifier := m.Amplify(4)
+	h := amplifier.Exec(handler)
+
+	req, _ := http.NewRequest("POST", "http://localhost/", strings.NewReader("payload"))
+	resp, err := h.Handle(nil, req)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if calls != 4 || mismatched != 0 {
+		t.Errorf("Wrong amplified requests. Got %d calls, %d with wrong body.", calls, mismatched)
+	}
+	if n := atomic.LoadInt32(&inflight); n != 0 {
+		t.Errorf("Extra requests still in flight after return: %d", n)
+	}
+	closed := 0
+	for _, body := range bodies {
+		if body.isClosed() {
+			closed++
+		}
+	}
+	if closed != 3 {
+		t.Errorf("Wrong number of closed extra responses. Got: %d, expected: 3", closed)
+	}
+	if got := readBody(t, resp); got != "response" {
+		t.Errorf("Wrong body of returned response. Got: %q", got)
+	}
+
+	amplifier.SetFactor(1)
+	calls = 0
+	h.Handle(nil, m.EmptyRequest())
+	if calls != 1 || amplifier.Factor() != 1 {
+		t.Errorf("Request amplified after factor changed. Got %d calls.", calls)
+	}
+}
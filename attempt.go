@@ -0,0 +1,76 @@
+package cliware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+type attemptKey struct{}
+
+// WithAttempt returns copy of provided context that carries attempt number.
+// Middlewares that call next handler multiple times for the same request
+// (e.g. retries) should use it for each call, so that middlewares after them
+// can adjust own behavior to the attempt being executed.
+func WithAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ensureContext(ctx), attemptKey{}, attempt)
+}
+
+// Attempt returns number of attempt stored in context by WithAttempt.
+// Attempts are counted from 1. If context does not carry attempt number, 1 is
+// returned, since every request is at least first attempt.
+func Attempt(ctx context.Context) int {
+	if ctx == nil {
+		return 1
+	}
+	if attempt, ok := ctx.Value(attemptKey{}).(int); ok && attempt > 0 {
+		return attempt
+	}
+	return 1
+}
+
+// Tracer is interface for starting tracing spans. It abstracts tracing
+// library (e.g. OpenTelemetry), so cliware does not depend on any.
+type Tracer interface {
+	// Start starts new span with provided name as child of span carried by
+	// ctx (if any) and returns context carrying new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is single traced operation started by Tracer.
+type Span interface {
+	// SetAttribute sets attribute of span.
+	SetAttribute(key string, value interface{})
+	// End finishes span. Provided error is error operation finished with
+	// or nil if it was successful.
+	End(err error)
+}
+
+// TraceAttempts returns middleware that starts separate tracing span for
+// each attempt of sending request, as child of span in context (the span of
+// logical request). It should be placed after middleware that retries
+// requests, so it is executed on every attempt.
+//
+// Spans are named "HTTP <method> attempt <n>" (e.g. "HTTP GET attempt 2"),
+// where n is attempt number obtained from context (see Attempt). Attempt
+// number is also set as "http.attempt" attribute, along with
+// "http.status_code" if response was received.
+func TraceAttempts(tracer Tracer) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			attempt := Attempt(ctx)
+			var method string
+			if req != nil {
+				method = req.Method
+			}
+			ctx, span := tracer.Start(ensureContext(ctx), "HTTP "+method+" attempt "+strconv.Itoa(attempt))
+			span.SetAttribute("http.attempt", attempt)
+			resp, err = next.Handle(ctx, req)
+			if resp != nil {
+				span.SetAttribute("http.status_code", resp.StatusCode)
+			}
+			span.End(err)
+			return resp, err
+		})
+	})
+}
@@ -0,0 +1,80 @@
+package cliware_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestAttemptDefault(t *testing.T) {
+	if attempt := m.Attempt(context.Background()); attempt != 1 {
+		t.Errorf("Wrong attempt for empty context. Got: %d, expected: 1", attempt)
+	}
+	if attempt := m.Attempt(nil); attempt != 1 {
+		t.Errorf("Wrong attempt for nil context. Got: %d, expected: 1", attempt)
+	}
+}
+
+func TestWithAttempt(t *testing.T) {
+	ctx := m.WithAttempt(context.Background(), 3)
+	if attempt := m.Attempt(ctx); attempt != 3 {
+		t.Errorf("Wrong attempt. Got: %d, expected: 3", attempt)
+	}
+}
+
+type testSpan struct {
+	name       string
+	parent     *testSpan
+	attributes map[string]interface{}
+	ended      bool
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) {
+	s.attributes[key] = value
+}
+
+func (s *testSpan) End(err error) {
+	s.ended = true
+}
+
+type spanKey struct{}
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (tt *testTracer) Start(ctx context.Context, name string) (context.Context, m.Span) {
+	parent, _ := ctx.Value(spanKey{}).(*testSpan)
+	span := &testSpan{name: name, parent: parent, attributes: make(map[string]interface{})}
+	tt.spans = append(tt.spans, span)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func TestTraceAttempts(t *testing.T) {
+	tracer := &testTracer{}
+	ctx, requestSpan := tracer.Start(context.Background(), "request")
+	handler, _ := createHandler()
+	chain := m.NewChain(retryingMiddleware(2), m.TraceAttempts(tracer))
+	chain.Exec(handler).Handle(ctx, m.EmptyRequest())
+
+	if len(tracer.spans) != 3 {
+		t.Fatalf("Wrong number of spans. Got: %d, expected: 3", len(tracer.spans))
+	}
+	for i, span := range tracer.spans[1:] {
+		expectedName := fmt.Sprintf("HTTP GET attempt %d", i+1)
+		if span.name != expectedName {
+			t.Errorf("Wrong span name. Got: %s, expected: %s", span.name, expectedName)
+		}
+		if span.parent != requestSpan {
+			t.Errorf("Span %s is not child of request span.", span.name)
+		}
+		if span.attributes["http.attempt"] != i+1 {
+			t.Errorf("Wrong attempt attribute. Got: %v, expected: %d", span.attributes["http.attempt"], i+1)
+		}
+		if !span.ended {
+			t.Errorf("Span %s not ended.", span.name)
+		}
+	}
+}
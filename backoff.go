@@ -0,0 +1,53 @@
+package cliware
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff is function that returns delay to wait before provided attempt of
+// sending request. Attempts are counted from 1, so first retry is attempt 2.
+type Backoff func(attempt int) time.Duration
+
+// DecorrelatedJitter returns backoff that implements "decorrelated jitter"
+// algorithm described by AWS: every delay is random value between base and
+// three times previous delay, capped at max:
+//
+//	delay = min(max, random(base, previous * 3))
+//
+// In contrast to full jitter, where delay is random value between zero and
+// exponentially growing cap based on attempt number, every delay depends on
+// previous one. This spreads retries of many clients that failed at the same
+// time, so they do not retry in synchronized waves, while delays still grow
+// on average.
+//
+// Random values are taken from src, which allows deterministic tests. If src
+// is nil, source seeded with current time is used. Returned backoff is safe
+// for concurrent use, but it keeps single previous delay, which is reset to
+// base on attempts 1 and 2, so it is best used by one sequence of attempts
+// at a time.
+func DecorrelatedJitter(base, max time.Duration, src rand.Source) Backoff {
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+	random := rand.New(src)
+	var mu sync.Mutex
+	previous := base
+	return func(attempt int) time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		if attempt <= 2 {
+			previous = base
+		}
+		delay := base
+		if upper := previous * 3; upper > base {
+			delay += time.Duration(random.Int63n(int64(upper - base)))
+		}
+		if max > 0 && delay > max {
+			delay = max
+		}
+		previous = delay
+		return delay
+	}
+}
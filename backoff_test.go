@@ -0,0 +1,68 @@
+package cliware_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestDecorrelatedJitterBounds(t *testing.T) {
+	base, max := 10*time.Millisecond, time.Second
+	backoff := m.DecorrelatedJitter(base, max, rand.NewSource(1))
+	for sequence := 0; sequence < 100; sequence++ {
+		previous := base
+		for attempt := 2; attempt <= 10; attempt++ {
+			delay := backoff(attempt)
+			upper := previous * 3
+			if upper > max {
+				upper = max
+			}
+			if delay < base || delay > upper {
+				t.Fatalf("Delay out of bounds for attempt %d. Got: %s, expected between %s and %s", attempt, delay, base, upper)
+			}
+			previous = delay
+		}
+	}
+}
+
+func TestDecorrelatedJitterDistribution(t *testing.T) {
+	base, max := 10*time.Millisecond, 100*time.Millisecond
+	backoff := m.DecorrelatedJitter(base, max, rand.NewSource(1))
+	// Delays of first retry are uniformly distributed between base and three
+	// times base, so their mean is close to twice base.
+	var total time.Duration
+	distinct := make(map[time.Duration]bool)
+	for i := 0; i < 1000; i++ {
+		delay := backoff(2)
+		total += delay
+		distinct[delay] = true
+	}
+	if mean := total / 1000; mean < 18*time.Millisecond || mean > 22*time.Millisecond {
+		t.Errorf("Wrong mean of first retry delays. Got: %s, expected about %s", mean, 2*base)
+	}
+	if len(distinct) < 900 {
+		t.Errorf("Delays are not spread. Distinct delays: %d", len(distinct))
+	}
+
+	capped := 0
+	for i := 0; i < 1000; i++ {
+		if backoff(2+i%20) == max {
+			capped++
+		}
+	}
+	if capped == 0 {
+		t.Error("Delays of late attempts never reach max.")
+	}
+}
+
+func TestDecorrelatedJitterDeterministic(t *testing.T) {
+	first := m.DecorrelatedJitter(time.Millisecond, time.Second, rand.NewSource(42))
+	second := m.DecorrelatedJitter(time.Millisecond, time.Second, rand.NewSource(42))
+	for attempt := 2; attempt < 10; attempt++ {
+		if a, b := first(attempt), second(attempt); a != b {
+			t.Errorf("Same source produced different delays: %s, %s", a, b)
+		}
+	}
+}
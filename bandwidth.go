@@ -0,0 +1,97 @@
+package cliware
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// BandwidthLimiter is middleware that limits rate at which request and
+// response bodies are transferred. It is created using BandwidthLimit.
+type BandwidthLimiter struct {
+	// Shared makes request and response bodies share single limit, instead
+	// of having separate limit for each direction. It has to be set before
+	// limiter is used.
+	Shared bool
+
+	chunk    int
+	upload   *tokenBucket
+	download *tokenBucket
+}
+
+// BandwidthLimit returns middleware that limits transfer rate of request and
+// response bodies to bytesPerSec, which is useful for simulating slow
+// networks or being polite to metered connections. Limit is shared by all
+// requests going through returned middleware, by default separately for
+// upload and download (see BandwidthLimiter.Shared).
+//
+// Bodies are read in chunks of at most 1/10 of bytesPerSec and limiter waits
+// after each chunk, so rate is accurate after first ~100ms of transfer, while
+// short bursts can exceed it. Transfer is limited only as body is read by
+// transport (request) or caller (response). Context cancellation interrupts
+// waiting and read returns context error.
+func BandwidthLimit(bytesPerSec int64) *BandwidthLimiter {
+	chunk := bytesPerSec / 10
+	if chunk < 1 {
+		chunk = 1
+	}
+	return &BandwidthLimiter{
+		chunk:    int(chunk),
+		upload:   newTokenBucket(float64(bytesPerSec), float64(chunk)),
+		download: newTokenBucket(float64(bytesPerSec), float64(chunk)),
+	}
+}
+
+// Exec is implementation of Middleware interface.
+func (bl *BandwidthLimiter) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		ctx = ensureContext(ctx)
+		download := bl.download
+		if bl.Shared {
+			download = bl.upload
+		}
+		if req != nil && req.Body != nil && req.Body != http.NoBody {
+			req.Body = bl.limit(ctx, req.Body, bl.upload)
+			if getBody := req.GetBody; getBody != nil {
+				req.GetBody = func() (io.ReadCloser, error) {
+					body, err := getBody()
+					if err != nil {
+						return nil, err
+					}
+					return bl.limit(ctx, body, bl.upload), nil
+				}
+			}
+		}
+		resp, err = next.Handle(ctx, req)
+		if resp != nil && resp.Body != nil {
+			resp.Body = bl.limit(ctx, resp.Body, download)
+		}
+		return resp, err
+	})
+}
+
+func (bl *BandwidthLimiter) limit(ctx context.Context, body io.ReadCloser, bucket *tokenBucket) io.ReadCloser {
+	return &limitedReader{ReadCloser: body, ctx: ctx, bucket: bucket, chunk: bl.chunk}
+}
+
+// limitedReader is body that waits on token bucket for every byte read.
+type limitedReader struct {
+	io.ReadCloser
+	ctx    context.Context
+	bucket *tokenBucket
+	chunk  int
+}
+
+// Read is implementation of io.Reader interface.
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > lr.chunk {
+		p = p[:lr.chunk]
+	}
+	n, err := lr.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := lr.bucket.wait(lr.ctx, float64(n)); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,50 @@
+package cliware_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestBandwidthLimit(t *testing.T) {
+	var uploadTime time.Duration
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		start := time.Now()
+		ioutil.ReadAll(req.Body)
+		uploadTime = time.Since(start)
+		return &http.Response{Body: ioutil.NopCloser(strings.NewReader(strings.Repeat("x", 300)))}, nil
+	})
+	req, _ := http.NewRequest("POST", "http://localhost", strings.NewReader(strings.Repeat("x", 300)))
+	resp, err := m.BandwidthLimit(1000).Exec(handler).Handle(nil, req)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	start := time.Now()
+	ioutil.ReadAll(resp.Body)
+	downloadTime := time.Since(start)
+
+	// 300 bytes at 1000 bytes/s, with first 100 bytes allowed as burst
+	for name, d := range map[string]time.Duration{"upload": uploadTime, "download": downloadTime} {
+		if d < 150*time.Millisecond || d > 400*time.Millisecond {
+			t.Errorf("Wrong %s time. Got: %s, expected around: 200ms", name, d)
+		}
+	}
+}
+
+func TestBandwidthLimitCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	handler := createBodyHandler("text/plain", strings.Repeat("x", 1000))
+	resp, err := m.BandwidthLimit(100).Exec(handler).Handle(ctx, nil)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if _, err := ioutil.ReadAll(resp.Body); err != context.DeadlineExceeded {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", context.DeadlineExceeded, err)
+	}
+}
@@ -0,0 +1,291 @@
+package cliware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrNoBatchCallback is error returned for batched request whose context does
+// not carry batch callback (see WithBatchCallback).
+var ErrNoBatchCallback = errors.New("cliware: no batch callback in request context")
+
+// ErrEmptyBatch is error returned for batched requests when batch function
+// does not create batch request.
+var ErrEmptyBatch = errors.New("cliware: batch function returned no request")
+
+// BatchCallback is function that extracts response to single batched request
+// from response to batch request. Body of batch response is already read and
+// provided as body, so callbacks of all batched requests can use it.
+type BatchCallback func(batch *http.Response, body []byte) (*http.Response, error)
+
+type batchCallbackKey struct{}
+
+// WithBatchCallback returns copy of provided context that carries batch
+// callback, which is used by Batcher to deliver portion of batch response to
+// request sent with this context.
+func WithBatchCallback(ctx context.Context, callback BatchCallback) context.Context {
+	return context.WithValue(ensureContext(ctx), batchCallbackKey{}, callback)
+}
+
+// BatchResult is result of single batched request.
+type BatchResult struct {
+	Response *http.Response
+	Err      error
+}
+
+// BatchItem is single request collected into batch.
+type BatchItem struct {
+	// Request is original request.
+	Request *http.Request
+	// Context is context original request was sent with.
+	Context context.Context
+	// Result is channel result of request is delivered to. It has buffer
+	// for single result and only first result sent to it is delivered.
+	// Batcher sends results to it on its own, so it only needs to be used
+	// by batch function to fail single item, e.g. one that can not be
+	// included in batch.
+	Result chan<- BatchResult
+
+	callback  BatchCallback
+	mu        sync.Mutex
+	result    chan BatchResult
+	abandoned bool
+}
+
+// Batcher is middleware that collects requests into batches. It is created
+// using BatchWithCallbacks function.
+type Batcher struct {
+	window  time.Duration
+	batchFn func([]*BatchItem) *http.Request
+	target  int
+
+	mu          sync.Mutex
+	pending     []*BatchItem
+	batch       int
+	gap         time.Duration
+	lastArrival time.Time
+}
+
+// BatchWithCallbacks returns middleware that collects requests sent within
+// window of time, starting with first one, and sends them to next handler as
+// single batch request created by batchFn. Every batched request receives
+// own portion of batch response, extracted by callback carried by context of
+// request (see WithBatchCallback), which allows batching of heterogeneous
+// requests. Callbacks receive copy of batch response, so they can not
+// affect each other.
+//
+// If batch request fails, its error is returned for every batched request.
+// Batch request is sent with its own context, so cancellation of single
+// batched request does not affect others in the same batch. If batchFn does
+// not set context of batch request, context detached from the first batched
+// request (see DetachContext) is used, so that batch request carries its
+// values, such as trace IDs. Request whose
+// context is done while it waits for result returns context error at once:
+//   - if batch is not sent yet, request is removed from it, so it neither
+//     counts towards batch size nor is passed to batch function, and batch
+//     whose requests are all cancelled is not sent at all;
+//   - if batch is already sent, result of request is ignored and body of its
+//     response, if any, is closed.
+func BatchWithCallbacks(window time.Duration, batchFn func([]*BatchItem) *http.Request) *Batcher {
+	return &Batcher{window: window, batchFn: batchFn}
+}
+
+// AdaptiveBatch returns Batcher that, like one returned by
+// BatchWithCallbacks, collects requests into batch requests created by
+// batchFn, but adjusts window to observed arrival rate of requests. Batcher
+// keeps exponentially weighted moving average of time between arrivals of
+// requests (new gap has weight of 0.2) and sets window to time in which
+// targetBatchSize requests are expected to arrive, capped at maxWindow:
+//
+//	window = min(maxWindow, targetBatchSize * average gap)
+//
+// Window therefore shrinks under high load, when batches fill up quickly,
+// and grows under low load, up to maxWindow, which bounds latency added to
+// every request. Until gap between two requests is observed, maxWindow is
+// used. Batch is also sent as soon as it reaches targetBatchSize requests,
+// without waiting for window to pass.
+func AdaptiveBatch(targetBatchSize int, maxWindow time.Duration, batchFn func([]*BatchItem) *http.Request) *Batcher {
+	return &Batcher{window: maxWindow, batchFn: batchFn, target: targetBatchSize}
+}
+
+// Window returns current window of batcher.
+func (b *Batcher) Window() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.currentWindow()
+}
+
+// currentWindow returns current window. It must be called with lock held.
+func (b *Batcher) currentWindow() time.Duration {
+	if b.target <= 0 || b.gap == 0 {
+		return b.window
+	}
+	if window := time.Duration(b.target) * b.gap; window < b.window {
+		return window
+	}
+	return b.window
+}
+
+// Exec is implementation of Middleware interface.
+func (b *Batcher) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		ctx = ensureContext(ctx)
+		result := make(chan BatchResult, 1)
+		item := &BatchItem{Request: req, Context: ctx, Result: result, result: result}
+		item.callback, _ = ctx.Value(batchCallbackKey{}).(BatchCallback)
+		if item.callback == nil {
+			return nil, ErrNoBatchCallback
+		}
+
+		b.mu.Lock()
+		if b.target > 0 {
+			now := time.Now()
+			if !b.lastArrival.IsZero() {
+				gap := now.Sub(b.lastArrival)
+				if b.gap == 0 {
+					b.gap = gap
+				} else {
+					b.gap = time.Duration(0.8*float64(b.gap) + 0.2*float64(gap))
+				}
+			}
+			b.lastArrival = now
+		}
+		b.pending = append(b.pending, item)
+		batch := b.batch
+		if len(b.pending) == 1 {
+			time.AfterFunc(b.currentWindow(), func() { b.flush(next, batch) })
+		}
+		if b.target > 0 && len(b.pending) >= b.target {
+			go b.send(next, b.take(batch))
+		}
+		b.mu.Unlock()
+
+		select {
+		case r := <-result:
+			return r.Response, r.Err
+		case <-ctx.Done():
+			b.cancel(item, batch)
+			return nil, ctx.Err()
+		}
+	})
+}
+
+// cancel removes cancelled item from pending batch, if batch is not sent yet,
+// and abandons item, so its result is discarded. If cancelled item was last
+// one in pending batch, batch is abandoned too, so its timer does not flush
+// batch collected after it.
+func (b *Batcher) cancel(item *BatchItem, batch int) {
+	b.mu.Lock()
+	if batch == b.batch {
+		for i, pending := range b.pending {
+			if pending == item {
+				b.pending = append(b.pending[:i], b.pending[i+1:]...)
+				break
+			}
+		}
+		if len(b.pending) == 0 {
+			b.pending = nil
+			b.batch++
+		}
+	}
+	b.mu.Unlock()
+
+	item.mu.Lock()
+	item.abandoned = true
+	select {
+	case r := <-item.result:
+		drainAndClose(r.Response)
+	default:
+	}
+	item.mu.Unlock()
+}
+
+// deliver sends result to item result channel, unless item already received
+// result. Channel is buffered, so this never blocks. Result of abandoned item
+// is discarded and its response body closed.
+func (bi *BatchItem) deliver(r BatchResult) {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+	if bi.abandoned {
+		drainAndClose(r.Response)
+		return
+	}
+	select {
+	case bi.Result <- r:
+	default:
+		drainAndClose(r.Response)
+	}
+}
+
+// flush sends requests collected into provided batch, unless batch was
+// already sent.
+func (b *Batcher) flush(next Handler, batch int) {
+	b.mu.Lock()
+	pending := b.take(batch)
+	b.mu.Unlock()
+	b.send(next, pending)
+}
+
+// take removes and returns requests collected into provided batch, or nil if
+// batch was already taken. It must be called with lock held.
+func (b *Batcher) take(batch int) []*BatchItem {
+	if batch != b.batch {
+		return nil
+	}
+	pending := b.pending
+	b.pending = nil
+	b.batch++
+	return pending
+}
+
+// send sends provided requests as single batch request and delivers results
+// to every batched request.
+func (b *Batcher) send(next Handler, pending []*BatchItem) {
+	items := pending[:0]
+	for _, item := range pending {
+		if item.Context.Err() == nil {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return
+	}
+	fail := func(err error) {
+		for _, item := range items {
+			item.deliver(BatchResult{Err: err})
+		}
+	}
+
+	batchReq := b.batchFn(items)
+	if batchReq == nil {
+		fail(ErrEmptyBatch)
+		return
+	}
+	if batchReq.Context() == context.Background() {
+		batchReq = batchReq.WithContext(DetachContext(items[0].Context))
+	}
+	resp, err := next.Handle(batchReq.Context(), batchReq)
+	if err != nil {
+		fail(err)
+		return
+	}
+	var buffered *bufferedResponse
+	if resp != nil {
+		if buffered, err = newBufferedResponse(resp); err != nil {
+			fail(err)
+			return
+		}
+	}
+	for _, item := range items {
+		var r BatchResult
+		if buffered != nil {
+			r.Response, r.Err = item.callback(buffered.response(), buffered.body)
+		} else {
+			r.Response, r.Err = item.callback(nil, nil)
+		}
+		item.deliver(r)
+	}
+}
@@ -0,0 +1,292 @@
+package cliware_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	m "go.delic.rs/cliware"
+)
+
+// joinBatch creates batch request with paths of batched requests in body,
+// one per line.
+func joinBatch(items []*m.BatchItem) *http.Request {
+	var paths []string
+	for _, item := range items {
+		paths = append(paths, item.Request.URL.Path)
+	}
+	req, _ := http.NewRequest("POST", "http://localhost/batch", strings.NewReader(strings.Join(paths, "\n")))
+	return req
+}
+
+// lineCallback returns batch callback that extracts line with provided index
+// from batch response.
+func lineCallback(i int) m.BatchCallback {
+	return func(batch *http.Response, body []byte) (*http.Response, error) {
+		lines := bytes.Split(body, []byte("\n"))
+		batch.Body = ioutil.NopCloser(bytes.NewReader(lines[i]))
+		return batch, nil
+	}
+}
+
+// upperHandler responds with upper case request body.
+var upperHandler = m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+	body, _ := ioutil.ReadAll(req.Body)
+	return &http.Response{
+		StatusCode: 200,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(bytes.NewReader(bytes.ToUpper(body))),
+	}, nil
+})
+
+func TestBatchWithCallbacks(t *testing.T) {
+	var batches int
+	h := m.BatchWithCallbacks(50*time.Millisecond, func(items []*m.BatchItem) *http.Request {
+		batches++
+		// Record position of each request in batch for its callback.
+		for i, item := range items {
+			item.Request.Header.Set("Index", string(rune('0'+i)))
+		}
+		return joinBatch(items)
+	}).Exec(upperHandler)
+
+	paths := []string{"/a", "/b", "/c"}
+	results := make([]string, len(paths))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for i, path := range paths {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+			// Callback reads position assigned by batch function.
+			ctx := m.WithBatchCallback(context.Background(), func(batch *http.Response, body []byte) (*http.Response, error) {
+				index := int(req.Header.Get("Index")[0] - '0')
+				return lineCallback(index)(batch, body)
+			})
+			resp, err := h.Handle(ctx, req)
+			if err != nil {
+				t.Error("Handle returned error: ", err)
+				return
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			mu.Lock()
+			results[i] = string(body)
+			mu.Unlock()
+		}(i, path)
+	}
+	wg.Wait()
+
+	if batches != 1 {
+		t.Errorf("Wrong number of batches. Got: %d, expected: 1", batches)
+	}
+	for i, path := range paths {
+		if expected := strings.ToUpper(path); results[i] != expected {
+			t.Errorf("Wrong result for %s. Got: %q, expected: %q", path, results[i], expected)
+		}
+	}
+}
+
+func TestBatchWithCallbacksError(t *testing.T) {
+	expected := errors.New("batch failed")
+	failing := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		return nil, expected
+	})
+	h := m.BatchWithCallbacks(10*time.Millisecond, joinBatch).Exec(failing)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := m.WithBatchCallback(context.Background(), lineCallback(i))
+			if _, err := h.Handle(ctx, m.EmptyRequest()); err != expected {
+				t.Errorf("Expected error: \"%s\", got: \"%v\"", expected, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if _, err := h.Handle(nil, m.EmptyRequest()); err != m.ErrNoBatchCallback {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", m.ErrNoBatchCallback, err)
+	}
+}
+
+func TestBatchWithCallbacksCancellation(t *testing.T) {
+	var batched int
+	h := m.BatchWithCallbacks(50*time.Millisecond, func(items []*m.BatchItem) *http.Request {
+		batched = len(items)
+		return joinBatch(items)
+	}).Exec(upperHandler)
+
+	ctx, cancel := context.WithTimeout(m.WithBatchCallback(context.Background(), lineCallback(0)), 10*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req, _ := http.NewRequest("GET", "http://localhost/kept", nil)
+		resp, err := h.Handle(m.WithBatchCallback(context.Background(), lineCallback(0)), req)
+		if err != nil {
+			t.Error("Handle returned error: ", err)
+			return
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if string(body) != "/KEPT" {
+			t.Errorf("Wrong result. Got: %q, expected: \"/KEPT\"", body)
+		}
+	}()
+	if _, err := h.Handle(ctx, m.EmptyRequest()); err != context.DeadlineExceeded {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", context.DeadlineExceeded, err)
+	}
+	<-done
+	if batched != 1 {
+		t.Errorf("Cancelled request included in batch. Batch size: %d", batched)
+	}
+}
+
+func TestAdaptiveBatch(t *testing.T) {
+	var mu sync.Mutex
+	var sizes []int
+	batcher := m.AdaptiveBatch(4, time.Second, func(items []*m.BatchItem) *http.Request {
+		mu.Lock()
+		sizes = append(sizes, len(items))
+		mu.Unlock()
+		return joinBatch(items)
+	})
+	if batcher.Window() != time.Second {
+		t.Errorf("Wrong initial window. Got: %s, expected: 1s", batcher.Window())
+	}
+	h := batcher.Exec(upperHandler)
+	ctx := m.WithBatchCallback(context.Background(), func(batch *http.Response, body []byte) (*http.Response, error) {
+		return batch, nil
+	})
+
+	send := func(n int) {
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := h.Handle(ctx, m.EmptyRequest()); err != nil {
+					t.Error("Handle returned error: ", err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	start := time.Now()
+	send(4)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Full batch not sent immediately. Took: %s", elapsed)
+	}
+	if window := batcher.Window(); window >= 100*time.Millisecond {
+		t.Errorf("Window not shrunk under load. Got: %s", window)
+	}
+
+	send(6)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sizes) < 3 || sizes[0] != 4 || sizes[1] != 4 {
+		t.Errorf("Wrong batch sizes. Got: %v", sizes)
+	}
+}
+
+func TestBatchCancelItemMidWindow(t *testing.T) {
+	var mu sync.Mutex
+	var batches []string
+	batcher := m.AdaptiveBatch(3, 100*time.Millisecond, func(items []*m.BatchItem) *http.Request {
+		req := joinBatch(items)
+		body, _ := ioutil.ReadAll(req.Body)
+		mu.Lock()
+		batches = append(batches, string(body))
+		mu.Unlock()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return req
+	})
+	h := batcher.Exec(upperHandler)
+	callback := func(batch *http.Response, body []byte) (*http.Response, error) {
+		return batch, nil
+	}
+
+	// Cancelled request must not count towards batch size, so batch is
+	// only sent after window passes.
+	ctx, cancel := context.WithCancel(m.WithBatchCallback(context.Background(), callback))
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		req, _ := http.NewRequest("GET", "http://localhost/cancelled", nil)
+		if _, err := h.Handle(ctx, req); err != context.Canceled {
+			t.Errorf("Expected error: \"%s\", got: \"%v\"", context.Canceled, err)
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	for _, path := range []string{"/a", "/b"} {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+			if _, err := h.Handle(m.WithBatchCallback(context.Background(), callback), req); err != nil {
+				t.Error("Handle returned error: ", err)
+			}
+		}(path)
+		if path == "/a" {
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batches) != 1 || strings.Contains(batches[0], "cancelled") || !strings.Contains(batches[0], "/a") || !strings.Contains(batches[0], "/b") {
+		t.Errorf("Wrong batches. Got: %q", batches)
+	}
+}
+
+func TestBatchAllCancelled(t *testing.T) {
+	var batches int
+	h := m.BatchWithCallbacks(30*time.Millisecond, func(items []*m.BatchItem) *http.Request {
+		batches++
+		return joinBatch(items)
+	}).Exec(upperHandler)
+
+	ctx, cancel := context.WithTimeout(m.WithBatchCallback(context.Background(), lineCallback(0)), 5*time.Millisecond)
+	defer cancel()
+	if _, err := h.Handle(ctx, m.EmptyRequest()); err != context.DeadlineExceeded {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", context.DeadlineExceeded, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if batches != 0 {
+		t.Errorf("Batch of cancelled requests sent. Got %d batches.", batches)
+	}
+}
+
+func TestBatchDetachedContext(t *testing.T) {
+	type traceKey struct{}
+	var value interface{}
+	var ctxErr error
+	h := m.BatchWithCallbacks(time.Millisecond, joinBatch).Exec(m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		value, ctxErr = ctx.Value(traceKey{}), ctx.Err()
+		return upperHandler.Handle(ctx, req)
+	}))
+	ctx, cancel := context.WithCancel(m.WithBatchCallback(context.WithValue(context.Background(), traceKey{}, "abc"), lineCallback(0)))
+	defer cancel()
+	req, _ := http.NewRequest("GET", "http://localhost/traced", nil)
+	resp, err := h.Handle(ctx, req)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	resp.Body.Close()
+	if value != "abc" || ctxErr != nil {
+		t.Errorf("Batch request context not detached from batched one. Got value: %v, error: %v", value, ctxErr)
+	}
+}
@@ -0,0 +1,173 @@
+package cliware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// bufferRequestBody reads entire request body to memory and replaces it with
+// one that can be read again. It also sets GetBody on request, so other
+// middlewares (and http.Client) can obtain fresh copy of the body. Read body
+// is returned.
+func bufferRequestBody(req *http.Request) ([]byte, error) {
+	if req == nil || req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	data, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	setRequestBody(req, data)
+	return data, nil
+}
+
+// requestBody returns content of request body without consuming it. If
+// request has GetBody set, it is used, otherwise body is buffered first.
+func requestBody(req *http.Request) ([]byte, error) {
+	if req == nil || req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody == nil {
+		return bufferRequestBody(req)
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
+
+// setRequestBody sets provided data as request body, along with matching
+// ContentLength and GetBody.
+func setRequestBody(req *http.Request, data []byte) {
+	req.ContentLength = int64(len(data))
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+}
+
+// cloneRequest returns copy of request with provided context, own copy of
+// header and URL and fresh body obtained using GetBody. Copy can be sent
+// independently of original request, e.g. concurrently with other copies.
+func cloneRequest(ctx context.Context, req *http.Request) (*http.Request, error) {
+	clone := req.WithContext(ctx)
+	clone.Header = cloneHeader(req.Header)
+	if req.URL != nil {
+		u := *req.URL
+		clone.URL = &u
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = body
+	}
+	return clone, nil
+}
+
+// cancelOnClose makes sure that provided cancel function is called once
+// response is done. If there is no response body, cancel is called
+// immediately, otherwise it will be called when body is closed. This allows
+// caller to read body even after handler returned, while still releasing
+// context resources.
+func cancelOnClose(resp *http.Response, cancel context.CancelFunc) {
+	if resp == nil || resp.Body == nil {
+		cancel()
+		return
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+}
+
+// cancelBody is io.ReadCloser that calls cancel function when closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close closes underlying body and cancels associated context.
+func (cb *cancelBody) Close() error {
+	err := cb.ReadCloser.Close()
+	cb.cancel()
+	return err
+}
+
+// drainAndClose reads remaining response body (up to a limit) and closes it,
+// so underlying connection can be reused.
+func drainAndClose(resp *http.Response) {
+	if resp == nil || resp.Body == nil {
+		return
+	}
+	io.CopyN(ioutil.Discard, resp.Body, 4<<10)
+	resp.Body.Close()
+}
+
+// bufferResponseBody reads entire response body to memory and replaces it
+// with one that reads buffered data, so body can be read again by caller.
+// Read body is returned.
+func bufferResponseBody(resp *http.Response) ([]byte, error) {
+	if resp == nil || resp.Body == nil {
+		return nil, nil
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+// bufferedResponse is response with buffered body, that can be used to
+// create any number of independent copies of the response.
+type bufferedResponse struct {
+	resp *http.Response
+	body []byte
+}
+
+// newBufferedResponse reads body of provided response. Body of provided
+// response is replaced, so it can still be read.
+func newBufferedResponse(resp *http.Response) (*bufferedResponse, error) {
+	body, err := bufferResponseBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	return &bufferedResponse{resp: resp, body: body}, nil
+}
+
+// response returns new copy of buffered response. Body of returned response
+// reads from buffer shared by all copies, which is safe, since buffer is
+// never written to.
+func (br *bufferedResponse) response() *http.Response {
+	resp := *br.resp
+	resp.Header = cloneHeader(br.resp.Header)
+	resp.Trailer = cloneHeader(br.resp.Trailer)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(br.body))
+	return &resp
+}
+
+// responseCopy returns new copy of buffered response, with own copy of body
+// data instead of reader over shared buffer.
+func (br *bufferedResponse) responseCopy() *http.Response {
+	resp := br.response()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(append([]byte(nil), br.body...)))
+	return resp
+}
+
+// cloneHeader returns deep copy of provided header.
+func cloneHeader(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	clone := make(http.Header, len(h))
+	for name, values := range h {
+		clone[name] = append([]string(nil), values...)
+	}
+	return clone
+}
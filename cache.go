@@ -0,0 +1,235 @@
+package cliware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheStore is storage of cached responses used by ResponseCache. Responses
+// are stored serialized in HTTP/1.1 wire format, so store can keep them
+// anywhere (e.g. in memory or in external cache). It must be safe for
+// concurrent use.
+type CacheStore interface {
+	// Get returns data stored under key, if it is stored and not expired.
+	Get(key string) ([]byte, bool)
+	// Set stores data under key for ttl.
+	Set(key string, data []byte, ttl time.Duration)
+}
+
+// MemoryCacheStore is CacheStore that keeps data in memory. It is created
+// using NewMemoryCacheStore function.
+type MemoryCacheStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+}
+
+type memoryCacheEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+// NewMemoryCacheStore returns new empty in memory cache store. Expired
+// entries are removed when they are accessed.
+func NewMemoryCacheStore() *MemoryCacheStore {
+	return &MemoryCacheStore{entries: make(map[string]memoryCacheEntry)}
+}
+
+// Get is implementation of CacheStore interface.
+func (s *MemoryCacheStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !time.Now().Before(entry.expires) {
+		delete(s.entries, key)
+		return nil, false
+	}
+	return entry.data, true
+}
+
+// Set is implementation of CacheStore interface.
+func (s *MemoryCacheStore) Set(key string, data []byte, ttl time.Duration) {
+	s.mu.Lock()
+	s.entries[key] = memoryCacheEntry{data: data, expires: time.Now().Add(ttl)}
+	s.mu.Unlock()
+}
+
+// ResponseCache is middleware that caches responses. It is created using
+// Cache function.
+type ResponseCache struct {
+	coalescingCounters
+	store CacheStore
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	flights map[string]*cacheFlight
+}
+
+type cacheFlight struct {
+	done chan struct{}
+	resp *bufferedResponse
+	err  error
+}
+
+// Cache returns middleware that caches successful (200 OK) responses to GET
+// and HEAD requests in store for ttl, keyed by method and URL. Responses with
+// "Cache-Control: no-store" are not cached. If store is nil, new
+// MemoryCacheStore is used.
+//
+// Vary header of responses is respected: values of request headers it names
+// become part of the key, so that, e.g., compressed and uncompressed
+// responses are cached separately. Names of varied headers are stored as
+// separate entry for method and URL. Responses with "Vary: *" are not
+// cached, since they can not be matched to any request.
+//
+// When response is not in cache, only one of concurrent requests with the
+// same key is sent, while others wait for its response. Until Vary header of
+// responses for method and URL is known, requests are coalesced only if they
+// have the same content negotiation headers (Accept, Accept-Charset,
+// Accept-Encoding and Accept-Language). This prevents
+// stampede of requests to server when popular entry expires. Waiting
+// requests whose context is done return context error.
+func Cache(store CacheStore, ttl time.Duration) *ResponseCache {
+	if store == nil {
+		store = NewMemoryCacheStore()
+	}
+	return &ResponseCache{store: store, ttl: ttl, flights: make(map[string]*cacheFlight)}
+}
+
+// Exec is implementation of Middleware interface.
+func (c *ResponseCache) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		if req == nil || (req.Method != "GET" && req.Method != "HEAD") {
+			return next.Handle(ctx, req)
+		}
+		baseKey := req.Method + " " + req.URL.String()
+		var vary []string
+		data, varyKnown := c.store.Get(varyKey(baseKey))
+		if varyKnown && len(data) > 0 {
+			vary = strings.Split(string(data), ",")
+		}
+		key := variantKey(baseKey, vary, req)
+		if resp, ok := c.cached(key, req); ok {
+			return resp, nil
+		}
+		// Until headers that response varies by are known, only requests
+		// that negotiate the same content are coalesced, since they may get
+		// different responses.
+		flightKey := key
+		if !varyKnown {
+			flightKey = variantKey(baseKey, negotiationHeaders, req)
+		}
+
+		c.mu.Lock()
+		if flight, ok := c.flights[flightKey]; ok {
+			c.mu.Unlock()
+			c.countCoalesced()
+			select {
+			case <-flight.done:
+			case <-ensureContext(ctx).Done():
+				return nil, ctx.Err()
+			}
+			if flight.err != nil || flight.resp == nil {
+				return nil, flight.err
+			}
+			return flight.resp.response(), nil
+		}
+		flight := &cacheFlight{done: make(chan struct{})}
+		c.flights[flightKey] = flight
+		c.mu.Unlock()
+
+		defer c.countSent()()
+		defer func() {
+			c.mu.Lock()
+			delete(c.flights, flightKey)
+			c.mu.Unlock()
+			close(flight.done)
+		}()
+		resp, flight.err = next.Handle(ctx, req)
+		if flight.err != nil || resp == nil {
+			return resp, flight.err
+		}
+		flight.resp, flight.err = newBufferedResponse(resp)
+		if flight.err != nil {
+			return nil, flight.err
+		}
+		if resp.StatusCode == http.StatusOK && !strings.Contains(strings.ToLower(resp.Header.Get("Cache-Control")), "no-store") {
+			if vary, ok := responseVary(resp); ok {
+				if data, err := httputil.DumpResponse(flight.resp.response(), true); err == nil {
+					c.store.Set(varyKey(baseKey), []byte(strings.Join(vary, ",")), c.ttl)
+					c.store.Set(variantKey(baseKey, vary, req), data, c.ttl)
+				}
+			}
+		}
+		return flight.resp.response(), nil
+	})
+}
+
+// cached returns response stored in cache under key.
+func (c *ResponseCache) cached(key string, req *http.Request) (*http.Response, bool) {
+	data, ok := c.store.Get(key)
+	if !ok {
+		return nil, false
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), req)
+	if err != nil {
+		return nil, false
+	}
+	return resp, true
+}
+
+// negotiationHeaders are names of request headers that select content of
+// response, which requests are coalesced by while it is not known which
+// headers response varies by.
+var negotiationHeaders = []string{"Accept", "Accept-Charset", "Accept-Encoding", "Accept-Language"}
+
+// varyKey returns key under which names of headers response varies by are
+// stored.
+func varyKey(baseKey string) string {
+	return "vary " + baseKey
+}
+
+// variantKey returns key of response to request that varies by provided
+// headers.
+func variantKey(baseKey string, vary []string, req *http.Request) string {
+	if len(vary) == 0 {
+		return baseKey
+	}
+	var key bytes.Buffer
+	key.WriteString(baseKey)
+	for _, name := range vary {
+		key.WriteString("\n")
+		key.WriteString(name)
+		key.WriteString(": ")
+		key.WriteString(strings.Join(req.Header[name], ", "))
+	}
+	return key.String()
+}
+
+// responseVary returns sorted canonical names of headers listed in Vary
+// header of response. If response varies by "*", false is returned.
+func responseVary(resp *http.Response) ([]string, bool) {
+	var vary []string
+	for _, value := range resp.Header[http.CanonicalHeaderKey("Vary")] {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return nil, false
+			}
+			if name != "" {
+				vary = append(vary, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(vary)
+	return vary, true
+}
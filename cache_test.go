@@ -0,0 +1,227 @@
+package cliware_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestCache(t *testing.T) {
+	handler, calls := createCountingHandler()
+	h := m.Cache(nil, time.Minute).Exec(handler)
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", "http://localhost/resource", nil)
+		resp, err := h.Handle(nil, req)
+		if err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+		if got := readBody(t, resp); got != "1" {
+			t.Errorf("Wrong cached body. Got: %q, expected: \"1\"", got)
+		}
+	}
+	if *calls != 1 {
+		t.Errorf("Response not cached. Handler calls: %d", *calls)
+	}
+
+	req, _ := http.NewRequest("POST", "http://localhost/resource", nil)
+	h.Handle(nil, req)
+	req, _ = http.NewRequest("GET", "http://localhost/other", nil)
+	h.Handle(nil, req)
+	if *calls != 3 {
+		t.Errorf("Wrong handler calls for uncached requests. Got: %d, expected: 3", *calls)
+	}
+}
+
+func TestCacheExpires(t *testing.T) {
+	handler, calls := createCountingHandler()
+	h := m.Cache(m.NewMemoryCacheStore(), 20*time.Millisecond).Exec(handler)
+	send := func() {
+		req, _ := http.NewRequest("GET", "http://localhost/resource", nil)
+		resp, _ := h.Handle(nil, req)
+		readBody(t, resp)
+	}
+	send()
+	time.Sleep(30 * time.Millisecond)
+	send()
+	if *calls != 2 {
+		t.Errorf("Expired response used. Handler calls: %d", *calls)
+	}
+}
+
+func TestCacheNotCacheable(t *testing.T) {
+	var calls int
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		calls++
+		resp := &http.Response{StatusCode: 200, Header: make(http.Header), Body: ioutil.NopCloser(strings.NewReader("data"))}
+		if req.URL.Path == "/error" {
+			resp.StatusCode = 500
+		} else {
+			resp.Header.Set("Cache-Control", "no-store")
+		}
+		return resp, nil
+	})
+	h := m.Cache(nil, time.Minute).Exec(handler)
+	for _, path := range []string{"/error", "/error", "/private", "/private"} {
+		req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+		resp, _ := h.Handle(nil, req)
+		readBody(t, resp)
+	}
+	if calls != 4 {
+		t.Errorf("Not cacheable response cached. Handler calls: %d", calls)
+	}
+}
+
+func TestCacheStampede(t *testing.T) {
+	release := make(chan struct{})
+	handler, calls := createBlockingHandler(release, nil)
+	h := m.Cache(nil, time.Minute).Exec(handler)
+
+	done := make(chan struct{})
+	var resps []*http.Response
+	var errs []error
+	go func() {
+		resps, errs = runConcurrently(5, h, func(i int) *http.Request {
+			req, _ := http.NewRequest("GET", "http://localhost/hot", nil)
+			return req
+		})
+		close(done)
+	}()
+	waitForCall(calls)
+	close(release)
+	<-done
+
+	if n := atomic.LoadInt32(calls); n != 1 {
+		t.Errorf("Wrong number of downstream calls. Got: %d, expected: 1", n)
+	}
+	for i, resp := range resps {
+		if errs[i] != nil {
+			t.Fatal("Handle returned error: ", errs[i])
+		}
+		if got := readBody(t, resp); got != "created" {
+			t.Errorf("Wrong body. Got: %q, expected: \"created\"", got)
+		}
+	}
+}
+
+func TestCacheWaiterCancelled(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	handler, calls := createBlockingHandler(release, nil)
+	h := m.Cache(nil, time.Minute).Exec(handler)
+
+	go func() {
+		req, _ := http.NewRequest("GET", "http://localhost/hot", nil)
+		h.Handle(nil, req)
+	}()
+	waitForCall(calls)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", "http://localhost/hot", nil)
+	if _, err := h.Handle(ctx, req); err != context.DeadlineExceeded {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", context.DeadlineExceeded, err)
+	}
+}
+
+func TestCacheVary(t *testing.T) {
+	var calls int
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		calls++
+		resp := &http.Response{StatusCode: 200, Header: make(http.Header)}
+		resp.Header.Set("Vary", "Accept-Encoding, accept-language")
+		if req.URL.Path == "/any" {
+			resp.Header.Set("Vary", "*")
+		}
+		body := req.Header.Get("Accept-Encoding") + " " + req.Header.Get("Accept-Language")
+		resp.Body = ioutil.NopCloser(strings.NewReader(body))
+		return resp, nil
+	})
+	h := m.Cache(nil, time.Minute).Exec(handler)
+	send := func(path, encoding, language string) string {
+		req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+		if encoding != "" {
+			req.Header.Set("Accept-Encoding", encoding)
+		}
+		req.Header.Set("Accept-Language", language)
+		resp, err := h.Handle(nil, req)
+		if err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+		return readBody(t, resp)
+	}
+
+	for _, data := range []struct {
+		encoding, language string
+		calls              int
+	}{
+		{"gzip", "en", 1},
+		{"", "en", 2},
+		{"gzip", "en", 2},
+		{"", "en", 2},
+		{"gzip", "de", 3},
+	} {
+		if got, expected := send("/", data.encoding, data.language), data.encoding+" "+data.language; got != expected {
+			t.Errorf("Wrong variant served. Got: %q, expected: %q", got, expected)
+		}
+		if calls != data.calls {
+			t.Errorf("Wrong handler calls after %s/%s. Got: %d, expected: %d", data.encoding, data.language, calls, data.calls)
+		}
+	}
+
+	send("/any", "gzip", "en")
+	send("/any", "gzip", "en")
+	if calls != 5 {
+		t.Errorf("Response with \"Vary: *\" cached. Handler calls: %d", calls)
+	}
+}
+
+func TestCacheVaryColdStampede(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		resp := &http.Response{StatusCode: 200, Header: make(http.Header)}
+		resp.Header.Set("Vary", "Accept-Encoding")
+		encoding := req.Header.Get("Accept-Encoding")
+		resp.Body = ioutil.NopCloser(strings.NewReader(encoding))
+		return resp, nil
+	})
+	h := m.Cache(nil, time.Minute).Exec(handler)
+	encodings := []string{"gzip", "identity", "gzip", "identity", "gzip", "identity"}
+
+	done := make(chan struct{})
+	var resps []*http.Response
+	var errs []error
+	go func() {
+		resps, errs = runConcurrently(len(encodings), h, func(i int) *http.Request {
+			req, _ := http.NewRequest("GET", "http://localhost/hot", nil)
+			req.Header.Set("Accept-Encoding", encodings[i])
+			return req
+		})
+		close(done)
+	}()
+	for start := time.Now(); atomic.LoadInt32(&calls) < 2 && time.Since(start) < time.Second; {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	<-done
+
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("Wrong number of downstream calls. Got: %d, expected: 2", n)
+	}
+	for i, resp := range resps {
+		if errs[i] != nil {
+			t.Fatal("Handle returned error: ", errs[i])
+		}
+		if got := readBody(t, resp); got != encodings[i] {
+			t.Errorf("Wrong variant for %s. Got: %q", encodings[i], got)
+		}
+	}
+}
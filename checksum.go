@@ -0,0 +1,146 @@
+package cliware
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrChecksumMismatch is error returned by Read of response body when
+// checksum of body does not match one sent by server.
+var ErrChecksumMismatch = errors.New("cliware: response body checksum mismatch")
+
+// ErrMissingChecksum is error returned by ChecksumVerifier when it requires
+// checksum and response does not have it.
+var ErrMissingChecksum = errors.New("cliware: response has no checksum")
+
+// ChecksumVerifier is middleware that verifies checksums of response bodies.
+// It is created using VerifyChecksum function.
+type ChecksumVerifier struct {
+	// RequireChecksum makes verifier return ErrMissingChecksum, along with
+	// response, for responses that have body but no checksum. By
+	// default such responses are returned unverified.
+	RequireChecksum bool
+
+	header string
+	algo   func() hash.Hash
+}
+
+// VerifyChecksum returns middleware that verifies that hash of response body,
+// computed using hash created by algo (e.g. md5.New or sha256.New), matches
+// checksum in provided response header (e.g. Content-MD5, Digest or
+// X-Checksum-SHA256). Checksum can be hex or base64 encoded, and it can be
+// prefixed by algorithm name, as in "sha-256=..." values of Digest header.
+// If header lists multiple checksums separated by comma, body has to match
+// one of them. Checksums that are not of hash size are ignored, so response
+// without checksum for used algorithm is treated as one without checksum.
+//
+// Body is hashed while it is read, so it is not buffered. When end of body is
+// reached, Read returns ErrChecksumMismatch instead of io.EOF if checksums do
+// not match, so body must be read until end to be verified and data read
+// before that must not be trusted until then. Checksum is verified against
+// body as received by this middleware, so its position relative to
+// middlewares that decode body (e.g. Decompress) matters.
+func VerifyChecksum(header string, algo func() hash.Hash) *ChecksumVerifier {
+	return &ChecksumVerifier{header: header, algo: algo}
+}
+
+// Exec is implementation of Middleware interface.
+func (cv *ChecksumVerifier) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		resp, err = next.Handle(ctx, req)
+		if err != nil || resp == nil || resp.Body == nil {
+			return resp, err
+		}
+		h := cv.algo()
+		expected := parseChecksums(strings.Join(resp.Header[http.CanonicalHeaderKey(cv.header)], ","), h.Size())
+		if len(expected) == 0 {
+			if cv.RequireChecksum && resp.Body != http.NoBody {
+				return resp, ErrMissingChecksum
+			}
+			return resp, err
+		}
+		resp.Body = &checksumBody{ReadCloser: resp.Body, hash: h, expected: expected}
+		return resp, err
+	})
+}
+
+// parseChecksums decodes comma separated checksums of provided size.
+// Checksums that can not be decoded are omitted.
+func parseChecksums(value string, size int) [][]byte {
+	var checksums [][]byte
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if sum, ok := decodeChecksum(part, size); ok {
+			checksums = append(checksums, sum)
+			continue
+		}
+		// strip algorithm name, like in "sha-256=<base64>"; padding of base64
+		// also contains "=", so whole part is tried first
+		if i := strings.Index(part, "="); i > 0 && isAlgorithmName(part[:i]) {
+			if sum, ok := decodeChecksum(part[i+1:], size); ok {
+				checksums = append(checksums, sum)
+			}
+		}
+	}
+	return checksums
+}
+
+// decodeChecksum decodes hex or base64 encoded checksum of provided size.
+func decodeChecksum(value string, size int) ([]byte, bool) {
+	if sum, err := hex.DecodeString(value); err == nil && len(sum) == size {
+		return sum, true
+	}
+	if sum, err := base64.StdEncoding.DecodeString(value); err == nil && len(sum) == size {
+		return sum, true
+	}
+	return nil, false
+}
+
+// isAlgorithmName returns true if name can be name of digest algorithm.
+func isAlgorithmName(name string) bool {
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// checksumBody hashes data read from underlying body and compares hash with
+// expected checksums when underlying body returns io.EOF.
+type checksumBody struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected [][]byte
+	done     bool
+	err      error
+}
+
+// Read is implementation of io.Reader interface.
+func (cb *checksumBody) Read(p []byte) (int, error) {
+	if cb.done {
+		return 0, cb.err
+	}
+	n, err := cb.ReadCloser.Read(p)
+	cb.hash.Write(p[:n])
+	if err == io.EOF {
+		cb.done = true
+		cb.err = ErrChecksumMismatch
+		sum := cb.hash.Sum(nil)
+		for _, expected := range cb.expected {
+			if bytes.Equal(sum, expected) {
+				cb.err = io.EOF
+				break
+			}
+		}
+		return n, cb.err
+	}
+	return n, err
+}
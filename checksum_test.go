@@ -0,0 +1,84 @@
+package cliware_test
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func createChecksumHandler(body, header, checksum string) m.Handler {
+	return m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		resp := &http.Response{StatusCode: 200, Header: make(http.Header), Body: ioutil.NopCloser(strings.NewReader(body))}
+		if checksum != "" {
+			resp.Header.Set(header, checksum)
+		}
+		return resp, nil
+	})
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	md5Sum := md5.Sum([]byte("payload"))
+	sha256Sum := sha256.Sum256([]byte("payload"))
+	sha512Sum := sha512.Sum512([]byte("payload"))
+	cases := []struct {
+		name     string
+		body     string
+		header   string
+		checksum string
+		verifier *m.ChecksumVerifier
+	}{
+		{"Content-MD5", "payload", "Content-MD5", base64.StdEncoding.EncodeToString(md5Sum[:]), m.VerifyChecksum("Content-MD5", md5.New)},
+		{"padded Content-MD5", "", "Content-MD5", "1B2M2Y8AsgTpgAmY7PhCfg==", m.VerifyChecksum("Content-MD5", md5.New)},
+		{"hex", "payload", "X-Checksum-Sha256", hex.EncodeToString(sha256Sum[:]), m.VerifyChecksum("X-Checksum-SHA256", sha256.New)},
+		{"Digest", "payload", "Digest", "md5=abc, sha-256=" + base64.StdEncoding.EncodeToString(sha256Sum[:]), m.VerifyChecksum("Digest", sha256.New)},
+		{"padded SHA-512", "payload", "X-Checksum-Sha512", base64.StdEncoding.EncodeToString(sha512Sum[:]), m.VerifyChecksum("X-Checksum-SHA512", sha512.New)},
+		{"padded Digest", "payload", "Digest", "sha-512=" + base64.StdEncoding.EncodeToString(sha512Sum[:]), m.VerifyChecksum("Digest", sha512.New)},
+	}
+	for _, c := range cases {
+		c.verifier.RequireChecksum = true
+		resp, err := c.verifier.Exec(createChecksumHandler(c.body, c.header, c.checksum)).Handle(nil, m.EmptyRequest())
+		if err != nil {
+			t.Fatalf("%s: Handle returned error: %s", c.name, err)
+		}
+		if body, err := ioutil.ReadAll(resp.Body); err != nil || string(body) != c.body {
+			t.Errorf("%s: Wrong body. Got: %q, %v", c.name, body, err)
+		}
+	}
+}
+
+func TestVerifyChecksumMismatch(t *testing.T) {
+	sum := sha256.Sum256([]byte("payload"))
+	h := m.VerifyChecksum("X-Checksum", sha256.New).Exec(createChecksumHandler("corrupted", "X-Checksum", hex.EncodeToString(sum[:])))
+	resp, err := h.Handle(nil, m.EmptyRequest())
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if _, err = ioutil.ReadAll(resp.Body); err != m.ErrChecksumMismatch {
+		t.Errorf("Expected error: \"%s\", got: %v", m.ErrChecksumMismatch, err)
+	}
+}
+
+func TestVerifyChecksumMissing(t *testing.T) {
+	verifier := m.VerifyChecksum("Content-MD5", md5.New)
+	h := verifier.Exec(createChecksumHandler("payload", "Content-MD5", ""))
+	if _, err := h.Handle(nil, m.EmptyRequest()); err != nil {
+		t.Error("Response without checksum rejected: ", err)
+	}
+	digest := m.VerifyChecksum("Digest", sha256.New).Exec(createChecksumHandler("payload", "Digest", "md5=ZGlmZmVyZW50IGRpZ2VzdA=="))
+	if resp, err := digest.Handle(nil, m.EmptyRequest()); err != nil || readBody(t, resp) != "payload" {
+		t.Error("Response without checksum for used algorithm rejected: ", err)
+	}
+	verifier.RequireChecksum = true
+	if resp, err := h.Handle(nil, m.EmptyRequest()); err != m.ErrMissingChecksum || resp == nil {
+		t.Errorf("Expected error: \"%s\", got: %v, %v", m.ErrMissingChecksum, resp, err)
+	}
+}
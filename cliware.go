@@ -3,9 +3,13 @@ package cliware
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 )
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -61,10 +65,16 @@ type RequestProcessor func(req *http.Request) error
 
 // Exec is implementation of Middleware interface.
 func (rp RequestProcessor) Exec(handler Handler) Handler {
+	return rp.exec(handler, nil)
+}
+
+// exec executes processor, wrapping errors it returns using wrap, if it is
+// not nil.
+func (rp RequestProcessor) exec(handler Handler, wrap func(error) error) Handler {
 	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
 		err = rp(req)
 		if err != nil {
-			return nil, err
+			return nil, wrapError(wrap, err)
 		}
 
 		resp, err = handler.Handle(ctx, req)
@@ -72,6 +82,60 @@ func (rp RequestProcessor) Exec(handler Handler) Handler {
 	})
 }
 
+// RequestProcessorCtx is function for modification of HTTP request, like
+// RequestProcessor, that also receives context of request. Context is the
+// one passed to Handle, so values set by previous middlewares are visible.
+type RequestProcessorCtx func(ctx context.Context, req *http.Request) error
+
+// Exec is implementation of Middleware interface.
+func (rp RequestProcessorCtx) Exec(handler Handler) Handler {
+	return rp.exec(handler, nil)
+}
+
+// exec executes processor, wrapping errors it returns using wrap, if it is
+// not nil.
+func (rp RequestProcessorCtx) exec(handler Handler, wrap func(error) error) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		if err = rp(ensureContext(ctx), req); err != nil {
+			return nil, wrapError(wrap, err)
+		}
+		return handler.Handle(ctx, req)
+	})
+}
+
+// RequestModifier is function for replacement of HTTP request.
+// It is intended as form of simple Middleware for middlewares that need to
+// pass different request to next handler, e.g. copy of request with new body,
+// URL or context. If returned request is not nil, it is passed to next handler
+// instead of provided one, and if it carries different context than provided
+// request, that context is passed to next handler too. Returning provided
+// request (or nil) passes it further as it is. Returned error (if any) will
+// stop middleware chain execution and same error will be returned to caller.
+type RequestModifier func(req *http.Request) (*http.Request, error)
+
+// Exec is implementation of Middleware interface.
+func (rm RequestModifier) Exec(handler Handler) Handler {
+	return rm.exec(handler, nil)
+}
+
+// exec executes modifier, wrapping errors it returns using wrap, if it is
+// not nil.
+func (rm RequestModifier) exec(handler Handler, wrap func(error) error) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		modified, err := rm(req)
+		if err != nil {
+			return nil, wrapError(wrap, err)
+		}
+		if modified != nil && modified != req {
+			if req == nil || modified.Context() != req.Context() {
+				ctx = modified.Context()
+			}
+			req = modified
+		}
+		return handler.Handle(ctx, req)
+	})
+}
+
 // ResponseProcessor is function for inspection of HTTP response.
 // It is intended as form of a simple Middleware for middlewares that only
 // need to inspect responses. E.g. they can log some information or inspect
@@ -81,20 +145,137 @@ func (rp RequestProcessor) Exec(handler Handler) Handler {
 // middleware. If middleware wants to change error - it should return it.
 // Otherwise, if there is not error or existing (provided) error should not be
 // changed, middleware should return nil.
+//
+// Body of response is owned by caller as long as processor does not return
+// new error. Callers usually do not close bodies of responses that come with
+// error, so when processor returns new error, body of response (if any) is
+// drained and closed before response and error are returned, which keeps
+// connection from leaking. Processors that leave body to caller (e.g. because
+// it describes error) should wrap returned error using KeepBody.
 type ResponseProcessor func(resp *http.Response, err error) error
 
 // Exec is implementation of Middleware interface.
 func (rp ResponseProcessor) Exec(handler Handler) Handler {
+	return rp.exec(handler, nil)
+}
+
+// exec executes processor, wrapping errors it returns using wrap, if it is
+// not nil. Errors of next handler returned by processor are not wrapped.
+func (rp ResponseProcessor) exec(handler Handler, wrap func(error) error) Handler {
 	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
 		resp, err = handler.Handle(ctx, req)
 		newErr := rp(resp, err)
 		if newErr != nil {
-			return resp, newErr
+			keep, newErr := keptBody(newErr)
+			if sameError(newErr, err) {
+				return resp, err
+			}
+			if !keep {
+				drainAndClose(resp)
+			}
+			return resp, wrapError(wrap, newErr)
+		}
+		return resp, err
+	})
+}
+
+// ResponseProcessorCtx is function for inspection of HTTP response, like
+// ResponseProcessor, that also receives context of request. Context is the
+// one passed to Handle, so values set by previous middlewares are visible,
+// which allows correlating response with request. Body of response is
+// closed when processor returns new error, as with ResponseProcessor.
+type ResponseProcessorCtx func(ctx context.Context, resp *http.Response, err error) error
+
+// Exec is implementation of Middleware interface.
+func (rp ResponseProcessorCtx) Exec(handler Handler) Handler {
+	return rp.exec(handler, nil)
+}
+
+// exec executes processor, wrapping errors it returns using wrap, if it is
+// not nil. Errors of next handler returned by processor are not wrapped.
+func (rp ResponseProcessorCtx) exec(handler Handler, wrap func(error) error) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		resp, err = handler.Handle(ctx, req)
+		newErr := rp(ensureContext(ctx), resp, err)
+		if newErr != nil {
+			keep, newErr := keptBody(newErr)
+			if sameError(newErr, err) {
+				return resp, err
+			}
+			if !keep {
+				drainAndClose(resp)
+			}
+			return resp, wrapError(wrap, newErr)
 		}
 		return resp, err
 	})
 }
 
+// keptBodyError marks error returned by response processor whose response
+// body must not be closed.
+type keptBodyError struct {
+	err error
+}
+
+// Error is implementation of error interface.
+func (e keptBodyError) Error() string {
+	return e.err.Error()
+}
+
+// KeepBody marks error returned by ResponseProcessor or ResponseProcessorCtx,
+// so that body of response is left open and owned by caller, who must close
+// it. Error is returned to caller unwrapped.
+func KeepBody(err error) error {
+	if err == nil {
+		return nil
+	}
+	return keptBodyError{err}
+}
+
+// keptBody reports whether err is marked using KeepBody and returns it
+// without mark.
+func keptBody(err error) (bool, error) {
+	if kept, ok := err.(keptBodyError); ok {
+		return true, kept.err
+	}
+	return false, err
+}
+
+// ResponseModifier is function for modification of HTTP response.
+// It is intended as form of simple Middleware for middlewares that need to
+// replace response or error, e.g. to decompress or rewrite response body.
+// Provided response and error are ones returned by next handler, and
+// returned response and error are returned to previous middleware instead of
+// them. Modifier that does not want to change them should return provided
+// values.
+type ResponseModifier func(resp *http.Response, err error) (*http.Response, error)
+
+// Exec is implementation of Middleware interface.
+func (rm ResponseModifier) Exec(handler Handler) Handler {
+	return rm.exec(handler, nil)
+}
+
+// exec executes modifier, wrapping errors it returns using wrap, if it is
+// not nil. Errors of next handler returned by modifier are not wrapped.
+func (rm ResponseModifier) exec(handler Handler, wrap func(error) error) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		resp, err = handler.Handle(ctx, req)
+		modified, newErr := rm(resp, err)
+		if newErr != nil && !sameError(newErr, err) {
+			newErr = wrapError(wrap, newErr)
+		}
+		return modified, newErr
+	})
+}
+
+// wrapError wraps error using wrap, if it is not nil.
+func wrapError(wrap func(error) error, err error) error {
+	if wrap == nil {
+		return err
+	}
+	return wrap(err)
+}
+
 // ContextProcessor is function for managing request context.
 // It is intended as for of simple middleware for middlewares that only
 // need to modify context before sending request.
@@ -108,12 +289,115 @@ func (cp ContextProcessor) Exec(handler Handler) Handler {
 	})
 }
 
+// When returns middleware that executes provided middleware only for
+// requests for which pred returns true. Other requests are passed directly
+// to next handler. Predicate is evaluated for every request, and if request
+// is nil, middleware is skipped without calling it.
+func When(pred func(req *http.Request) bool, mw Middleware) Middleware {
+	return &conditional{pred: pred, mw: mw}
+}
+
+// conditional is middleware returned by When.
+type conditional struct {
+	pred func(req *http.Request) bool
+	mw   Middleware
+}
+
+// Unwrap is implementation of Wrapper interface.
+func (c *conditional) Unwrap() Middleware {
+	return c.mw
+}
+
+// Exec is implementation of Middleware interface.
+func (c *conditional) Exec(next Handler) Handler {
+	wrapped := c.mw.Exec(next)
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		if req == nil || !c.pred(req) {
+			return next.Handle(ctx, req)
+		}
+		return wrapped.Handle(ctx, req)
+	})
+}
+
+// ShortCircuit returns middleware that calls fn for every request and, if fn
+// reports that it handled request, returns its response and error without
+// calling next handler. Otherwise request is passed to next handler. This
+// allows middlewares like caches and mocks to answer requests on their own.
+// Middlewares before ShortCircuit in chain see its response just like
+// response of terminal handler.
+func ShortCircuit(fn func(ctx context.Context, req *http.Request) (resp *http.Response, handled bool, err error)) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			resp, handled, err := fn(ctx, req)
+			if handled {
+				return resp, err
+			}
+			return next.Handle(ctx, req)
+		})
+	})
+}
+
+// Respond returns middleware that responds to every request with copy of
+// provided response, without calling next handler. Body of response is read
+// once, when middleware is created, and every copy gets own reader of it. If
+// reading body fails, its error is returned for every request.
+func Respond(resp *http.Response) Middleware {
+	buffered, bufferErr := newBufferedResponse(resp)
+	return ShortCircuit(func(ctx context.Context, req *http.Request) (*http.Response, bool, error) {
+		if bufferErr != nil {
+			return nil, true, bufferErr
+		}
+		return buffered.response(), true, nil
+	})
+}
+
+// Timed returns middleware that executes provided middleware and reports
+// time it took to handle request to report. Duration is measured from
+// calling handler of middleware until it returned, so it includes time
+// spent in all handlers after it, and time spent in middleware itself can be
+// computed by subtracting duration of next timed middleware. Measurements
+// are kept separately for every request, so middleware can be used
+// concurrently.
+func Timed(mw Middleware, report func(d time.Duration)) Middleware {
+	return &timed{mw: mw, report: report}
+}
+
+// timed is middleware returned by Timed.
+type timed struct {
+	mw     Middleware
+	report func(d time.Duration)
+}
+
+// Unwrap is implementation of Wrapper interface.
+func (t *timed) Unwrap() Middleware {
+	return t.mw
+}
+
+// Exec is implementation of Middleware interface.
+func (t *timed) Exec(next Handler) Handler {
+	handler := t.mw.Exec(next)
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		start := time.Now()
+		resp, err = handler.Handle(ctx, req)
+		t.report(time.Since(start))
+		return resp, err
+	})
+}
+
 // Chain is Middleware implementation capable of executing multiple
 // middlewares. On top of that, chain is aware of its parent middleware and
 // executes it during its own execution.
+//
+// Chain is safe for concurrent use, so middlewares can be added to it while
+// it is executed. Exec uses middlewares chain has at the moment it is called,
+// so handlers it returned are not affected by middlewares added later.
 type Chain struct {
+	parent Middleware
+
+	mu          sync.RWMutex
 	middlewares []Middleware
-	parent      Middleware
+	strict      bool
+	timer       func(index int, name string, d time.Duration)
 }
 
 // NewChain creates and returns middleware chain with provided middlewares
@@ -128,12 +412,51 @@ func NewChain(middlewares ...Middleware) *Chain {
 
 // Copy creates new chain with all middlewares copied to it.
 func (c *Chain) Copy() *Chain {
-	middlewareCopy := make([]Middleware, len(c.middlewares))
-	copy(middlewareCopy, c.middlewares)
+	middlewares, strict, timer := c.snapshot()
 	return &Chain{
-		middlewares: middlewareCopy,
+		middlewares: middlewares,
 		parent:      nil,
+		strict:      strict,
+		timer:       timer,
+	}
+}
+
+// Clone creates new chain that executes the same middlewares as this chain,
+// including middlewares of its parents (see AllMiddlewares), with strict
+// mode and timer of this chain, but has no parent. Clone is fully detached:
+// middlewares are copied, so adding or removing middlewares of clone (e.g.
+// using Use or RemoveAt) does not affect this chain or its parents, and
+// vice versa. This allows customizing shared chain for single request,
+// concurrently with other requests. Unlike Copy, which copies only
+// middlewares of this chain, clone executes parent middlewares too.
+func (c *Chain) Clone() *Chain {
+	clone := NewChain(c.AllMiddlewares()...)
+	_, clone.strict, clone.timer = c.snapshot()
+	return clone
+}
+
+// Append creates new chain that executes middlewares of this chain followed
+// by middlewares of other chain. Middlewares of parents of both chains are
+// included (see AllMiddlewares), in place of their children, so execution
+// order is preserved, but new chain has no parent and changes to either of
+// chains do not affect it. Strict mode and timer are copied from this chain.
+func (c *Chain) Append(other *Chain) *Chain {
+	merged := Merge(c, other)
+	_, merged.strict, merged.timer = c.snapshot()
+	return merged
+}
+
+// Merge creates new chain that executes middlewares of all provided chains,
+// in order, including middlewares of their parents (see AllMiddlewares).
+// New chain has no parent and changes to merged chains do not affect it.
+func Merge(chains ...*Chain) *Chain {
+	var middlewares []Middleware
+	for _, chain := range chains {
+		if chain != nil {
+			middlewares = append(middlewares, chain.AllMiddlewares()...)
+		}
 	}
+	return NewChain(middlewares...)
 }
 
 // ChildChain creates new Middleware chain with current chain as parent.
@@ -145,9 +468,160 @@ func (c *Chain) ChildChain(middlewares ...Middleware) *Chain {
 }
 
 // Middlewares returns all middlewares for this chain. Parent middlewares
-// not included.
+// not included. Returned slice is a copy, so modifying it does not affect
+// the chain.
 func (c *Chain) Middlewares() []Middleware {
-	return c.middlewares
+	middlewares, _, _ := c.snapshot()
+	return middlewares
+}
+
+// Len returns number of middlewares in this chain, not including parent
+// middlewares. Unlike len(c.Middlewares()), it does not copy middlewares.
+func (c *Chain) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.middlewares)
+}
+
+// Empty returns true if there are no middlewares in this chain, not
+// including parent middlewares.
+func (c *Chain) Empty() bool {
+	return c.Len() == 0
+}
+
+// LenAll returns number of all middlewares that chain executes, including
+// parent middlewares, counted the same way as in AllMiddlewares.
+func (c *Chain) LenAll() int {
+	n := c.Len()
+	switch parent := c.parent.(type) {
+	case nil:
+	case *Chain:
+		n += parent.LenAll()
+	default:
+		n++
+	}
+	return n
+}
+
+// snapshot returns copy of middlewares of chain, along with its strict mode
+// and timer.
+func (c *Chain) snapshot() ([]Middleware, bool, func(int, string, time.Duration)) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	middlewares := make([]Middleware, len(c.middlewares))
+	copy(middlewares, c.middlewares)
+	return middlewares, c.strict, c.timer
+}
+
+// AllMiddlewares returns all middlewares that chain executes, including
+// parent middlewares, in exact order of execution: middlewares of the
+// topmost parent first and middlewares of this chain last. Parent that is not
+// a chain is included as single middleware. Returned slice is a copy, so
+// modifying it does not affect the chain.
+func (c *Chain) AllMiddlewares() []Middleware {
+	var middlewares []Middleware
+	switch parent := c.parent.(type) {
+	case nil:
+	case *Chain:
+		middlewares = parent.AllMiddlewares()
+	default:
+		middlewares = append(middlewares, parent)
+	}
+	own, _, _ := c.snapshot()
+	return append(middlewares, own...)
+}
+
+// Compile builds handler that executes all middlewares in chain, including
+// parent middlewares, and then final handler. Returned handler is built once
+// and can be stored and used for any number of requests, concurrently, which
+// avoids wrapping middlewares again for every request, as calling
+// c.Exec(final).Handle for each request does. Middlewares are snapshotted
+// when Compile is called, so middlewares added or removed later (in this
+// chain or in its parents) are not reflected in returned handler, and chain
+// must be compiled again to include them. Handler returned by Exec is the
+// same kind of handler, Compile only names reuse explicitly.
+func (c *Chain) Compile(final Handler) Handler {
+	return c.Exec(final)
+}
+
+// ChainError is error returned by chain for errors that originate in one
+// of its middlewares, which identifies middleware that failed. Chain wraps
+// errors returned by request and response processors and modifiers
+// (RequestProcessor, RequestProcessorCtx, RequestModifier, ResponseProcessor,
+// ResponseProcessorCtx and ResponseModifier), as well as errors of
+// middlewares wrapped using Named, in which case Err is error wrapped by
+// *NamedError, which can still be obtained using errors.As. Errors returned
+// by other middlewares and by final handler are returned as they are.
+// Errors originating in middlewares of parent are identified by parent.
+type ChainError struct {
+	// Index is index of middleware in chain, as in Middlewares.
+	Index int
+	// Name is name of middleware (see Named), or empty string if it does
+	// not have one.
+	Name string
+	// Err is error returned by middleware.
+	Err error
+
+	named *NamedError
+}
+
+// Error is implementation of error interface.
+func (e *ChainError) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("cliware: middleware %d: %s", e.Index, e.Err)
+	}
+	return fmt.Sprintf("cliware: middleware %d (%s): %s", e.Index, e.Name, e.Err)
+}
+
+// Unwrap returns error returned by middleware.
+func (e *ChainError) Unwrap() error {
+	return e.Err
+}
+
+// As finds *NamedError of middleware wrapped using Named, whose error is
+// wrapped by chain error directly, so that its name is not repeated in
+// message.
+func (e *ChainError) As(target interface{}) bool {
+	if named, ok := target.(**NamedError); ok && e.named != nil {
+		*named = e.named
+		return true
+	}
+	return false
+}
+
+// wrappingExecutor is implemented by middlewares that can wrap errors they
+// produce, as opposed to errors of next handler, such as processors and
+// modifiers.
+type wrappingExecutor interface {
+	exec(handler Handler, wrap func(error) error) Handler
+}
+
+// indexed is middleware of chain with its index in chain, that wraps errors
+// originating in middleware into *ChainError.
+type indexed struct {
+	mw    Middleware
+	index int
+}
+
+// Exec is implementation of Middleware interface.
+func (im indexed) Exec(next Handler) Handler {
+	wrap := func(err error) error {
+		return &ChainError{Index: im.index, Name: middlewareName(im.mw), Err: err}
+	}
+	switch mw := im.mw.(type) {
+	case wrappingExecutor:
+		return mw.exec(next, wrap)
+	case *named:
+		handler := mw.Exec(next)
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			resp, err = handler.Handle(ctx, req)
+			if namedErr, ok := err.(*NamedError); ok && namedErr.Name == mw.name {
+				err = &ChainError{Index: im.index, Name: mw.name, Err: namedErr.Err, named: namedErr}
+			}
+			return resp, err
+		})
+	}
+	return im.mw.Exec(next)
 }
 
 // Parent returns parent middleware of this chain.
@@ -158,13 +632,29 @@ func (c *Chain) Parent() Middleware {
 // Exec is implementation of Middleware interface that executes all middlewares
 // in chain, including parent middleware.
 func (c *Chain) Exec(handler Handler) Handler {
+	middlewares, strict, timer := c.snapshot()
+	if strict {
+		if validationErr := c.Validate(); validationErr != nil {
+			return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+				return nil, validationErr
+			})
+		}
+	}
 	finalHandler := handler
 
 	// Make sure to run own middlewares first... Because of the way middlewares
 	// are composed, ones called first will override ones called later and
 	// we want to be able to override middlewares in child chain.
-	for i := len(c.middlewares) - 1; i >= 0; i-- {
-		finalHandler = c.middlewares[i].Exec(finalHandler)
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		mw := indexed{mw: middlewares[i], index: i}
+		if timer != nil {
+			index, name := i, middlewareName(middlewares[i])
+			finalHandler = Timed(mw, func(d time.Duration) {
+				timer(index, name, d)
+			}).Exec(finalHandler)
+			continue
+		}
+		finalHandler = mw.Exec(finalHandler)
 	}
 
 	// if we have parent, make sure to call it too...
@@ -175,14 +665,140 @@ func (c *Chain) Exec(handler Handler) Handler {
 	return finalHandler
 }
 
+// Validator is interface that middlewares can implement to detect
+// misconfiguration of chain they are part of, e.g. when they depend on
+// other middleware that is missing or is in wrong place.
+type Validator interface {
+	// Validate checks if middleware is configured correctly. It receives
+	// all middlewares of chain in order of execution (including parent
+	// middlewares) and index of validated middleware among them. Nested
+	// chains are replaced with their middlewares, and middlewares wrapped
+	// by other middlewares (see Wrapper) follow middlewares that wrap them.
+	// Returned error should describe the problem.
+	Validate(middlewares []Middleware, index int) error
+}
+
+// Wrapper is interface implemented by middlewares that execute other
+// middleware, such as ones returned by Named, When and Timed. It allows
+// validation to find wrapped middlewares.
+type Wrapper interface {
+	// Unwrap returns wrapped middleware.
+	Unwrap() Middleware
+}
+
+// flattenMiddlewares returns provided middlewares with nested chains
+// replaced by their middlewares and with wrapped middlewares following
+// middlewares that wrap them.
+func flattenMiddlewares(middlewares []Middleware) []Middleware {
+	flat := make([]Middleware, 0, len(middlewares))
+	for _, m := range middlewares {
+		for m != nil {
+			if chain, ok := m.(*Chain); ok {
+				flat = append(flat, flattenMiddlewares(chain.AllMiddlewares())...)
+				break
+			}
+			flat = append(flat, m)
+			w, ok := m.(Wrapper)
+			if !ok {
+				break
+			}
+			m = w.Unwrap()
+		}
+	}
+	return flat
+}
+
+// Validate calls Validate method of every middleware in chain (including
+// parent middlewares, middlewares of nested chains and wrapped middlewares)
+// that implements Validator interface and returns first error found.
+func (c *Chain) Validate() error {
+	middlewares := flattenMiddlewares(c.AllMiddlewares())
+	for i, m := range middlewares {
+		if v, ok := m.(Validator); ok {
+			if err := v.Validate(middlewares, i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SetStrict turns strict mode of chain on or off. In strict mode Exec
+// validates chain (see Validate) and, if validation fails, returns Handler
+// that returns validation error for every request instead of executing
+// misconfigured chain.
+func (c *Chain) SetStrict(strict bool) {
+	c.mu.Lock()
+	c.strict = strict
+	c.mu.Unlock()
+}
+
+// UseTimer sets function that chain reports duration of each of its
+// middlewares to, for every request (see Timed). Middleware is identified by
+// its index in chain (as in Middlewares) and its name (see Named), which is
+// empty for middlewares without name. Middlewares of parent are not timed,
+// unless timer is set on parent too. Timer must be set before Exec is
+// called, and nil removes it.
+func (c *Chain) UseTimer(report func(index int, name string, d time.Duration)) {
+	c.mu.Lock()
+	c.timer = report
+	c.mu.Unlock()
+}
+
 // Use adds provided middleware to current middleware chain.
 func (c *Chain) Use(m ...Middleware) {
+	c.mu.Lock()
 	c.middlewares = append(c.middlewares, m...)
+	c.mu.Unlock()
+}
+
+// ErrIndexOutOfRange is error returned when chain is edited at index that is
+// out of range of its middlewares.
+var ErrIndexOutOfRange = errors.New("cliware: middleware index out of range")
+
+// InsertAt inserts provided middleware into current middleware chain at
+// provided index, shifting middleware at that index and ones after it.
+// Index equal to Len appends middleware. Parent middlewares are not affected
+// and are not counted in index.
+func (c *Chain) InsertAt(index int, m Middleware) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if index < 0 || index > len(c.middlewares) {
+		return ErrIndexOutOfRange
+	}
+	c.middlewares = append(c.middlewares, nil)
+	copy(c.middlewares[index+1:], c.middlewares[index:])
+	c.middlewares[index] = m
+	return nil
+}
+
+// RemoveAt removes middleware at provided index from current middleware
+// chain.
+func (c *Chain) RemoveAt(index int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if index < 0 || index >= len(c.middlewares) {
+		return ErrIndexOutOfRange
+	}
+	c.middlewares = append(c.middlewares[:index], c.middlewares[index+1:]...)
+	return nil
+}
+
+// Replace replaces middleware at provided index in current middleware chain
+// with provided one, e.g. to replace real middleware with stub in tests.
+func (c *Chain) Replace(index int, m Middleware) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if index < 0 || index >= len(c.middlewares) {
+		return ErrIndexOutOfRange
+	}
+	c.middlewares[index] = m
+	return nil
 }
 
 // UseFunc adds provided function to current middleware chain.
 func (c *Chain) UseFunc(m func(handler Handler) Handler) {
-	c.middlewares = append(c.middlewares, MiddlewareFunc(m))
+	c.Use(MiddlewareFunc(m))
 }
 
 // UseRequest adds provided function as request middleware.
@@ -190,11 +806,34 @@ func (c *Chain) UseRequest(m func(req *http.Request) error) {
 	c.Use(RequestProcessor(m))
 }
 
+// UseRequestCtx adds provided function as request middleware that receives
+// context of request.
+func (c *Chain) UseRequestCtx(m func(ctx context.Context, req *http.Request) error) {
+	c.Use(RequestProcessorCtx(m))
+}
+
+// UseRequestModifier adds provided function as request replacing middleware.
+func (c *Chain) UseRequestModifier(m func(req *http.Request) (*http.Request, error)) {
+	c.Use(RequestModifier(m))
+}
+
 // UseResponse add provided function as response middleware.
 func (c *Chain) UseResponse(m func(resp *http.Response, err error) error) {
 	c.Use(ResponseProcessor(m))
 }
 
+// UseResponseCtx adds provided function as response middleware that
+// receives context of request.
+func (c *Chain) UseResponseCtx(m func(ctx context.Context, resp *http.Response, err error) error) {
+	c.Use(ResponseProcessorCtx(m))
+}
+
+// UseResponseModifier adds provided function as response modifying
+// middleware.
+func (c *Chain) UseResponseModifier(m func(resp *http.Response, err error) (*http.Response, error)) {
+	c.Use(ResponseModifier(m))
+}
+
 // EmptyRequest creates new empty instance of *http.Request.
 // It is good starting point for initial request instance for middleware chain.
 // In contrast to http.NewRequest, this function does not require any parameters.
@@ -213,3 +852,13 @@ func EmptyRequest() *http.Request {
 	}
 	return req
 }
+
+// ensureContext returns provided context or background context if provided
+// one is nil. Handlers are allowed to be called with nil context, but
+// middlewares that derive new contexts need non-nil parent.
+func ensureContext(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
@@ -2,8 +2,12 @@ package cliware_test
 
 import (
 	"context"
+	"io/ioutil"
 	"net/http"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"errors"
 
@@ -180,6 +184,185 @@ func TestGetParent(t *testing.T) {
 	}
 }
 
+// orderMiddleware is middleware that records its name when executed.
+type orderMiddleware struct {
+	name  string
+	order *[]string
+}
+
+func (om orderMiddleware) Exec(next m.Handler) m.Handler {
+	return m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		*om.order = append(*om.order, om.name)
+		return next.Handle(ctx, req)
+	})
+}
+
+func TestAllMiddlewares(t *testing.T) {
+	var order []string
+	mw := func(name string) m.Middleware { return orderMiddleware{name, &order} }
+	root := m.NewChain(mw("root1"), mw("root2"))
+	parent := root.ChildChain(mw("parent"))
+	child := parent.ChildChain(mw("child1"), mw("child2"))
+
+	handler, _ := createHandler()
+	child.Exec(handler).Handle(nil, nil)
+	var all []string
+	for _, middleware := range child.AllMiddlewares() {
+		all = append(all, middleware.(orderMiddleware).name)
+	}
+	if strings.Join(all, ", ") != strings.Join(order, ", ") {
+		t.Errorf("Order does not match execution. Got: %v, executed: %v", all, order)
+	}
+	if len(all) != 5 || len(child.Middlewares()) != 2 {
+		t.Errorf("Wrong middlewares returned: %v", all)
+	}
+}
+
+func TestChainLen(t *testing.T) {
+	noop := m.RequestProcessor(func(req *http.Request) error { return nil })
+	root := m.NewChain(noop, noop)
+	child := root.ChildChain()
+	if child.Len() != 0 || !child.Empty() || child.LenAll() != 2 {
+		t.Errorf("Wrong length of empty child. Got: %d, all: %d", child.Len(), child.LenAll())
+	}
+	child.Use(noop)
+	if child.Len() != 1 || child.Empty() || child.LenAll() != len(child.AllMiddlewares()) {
+		t.Errorf("Wrong length of child. Got: %d, all: %d", child.Len(), child.LenAll())
+	}
+	if root.Len() != 2 || root.LenAll() != 2 {
+		t.Errorf("Wrong length of root. Got: %d, all: %d", root.Len(), root.LenAll())
+	}
+}
+
+func TestChainEditAt(t *testing.T) {
+	var order []string
+	mw := func(name string) m.Middleware { return orderMiddleware{name, &order} }
+	chain := m.NewChain(mw("auth"), mw("log"))
+	if err := chain.InsertAt(0, mw("first")); err != nil {
+		t.Fatal("InsertAt returned error: ", err)
+	}
+	if err := chain.InsertAt(3, mw("last")); err != nil {
+		t.Fatal("InsertAt returned error: ", err)
+	}
+	if err := chain.Replace(1, mw("stub")); err != nil {
+		t.Fatal("Replace returned error: ", err)
+	}
+	if err := chain.RemoveAt(2); err != nil {
+		t.Fatal("RemoveAt returned error: ", err)
+	}
+	handler, _ := createHandler()
+	chain.Exec(handler).Handle(nil, nil)
+	if got := strings.Join(order, ", "); got != "first, stub, last" {
+		t.Errorf("Wrong middlewares after edit. Got: %s", got)
+	}
+
+	for _, err := range []error{
+		chain.InsertAt(-1, mw("x")),
+		chain.InsertAt(4, mw("x")),
+		chain.RemoveAt(3),
+		chain.Replace(3, mw("x")),
+		m.NewChain().RemoveAt(0),
+	} {
+		if err != m.ErrIndexOutOfRange {
+			t.Errorf("Expected error: \"%s\", got: \"%v\"", m.ErrIndexOutOfRange, err)
+		}
+	}
+	if chain.Len() != 3 {
+		t.Errorf("Chain changed by failed edit. Length: %d", chain.Len())
+	}
+}
+
+func TestChainCompile(t *testing.T) {
+	var order []string
+	mw := func(name string) m.Middleware { return orderMiddleware{name, &order} }
+	parent := m.NewChain(mw("parent"))
+	chain := parent.ChildChain(mw("child"))
+	handler, _ := createHandler()
+	h := chain.Compile(handler)
+	chain.Use(mw("later"))
+	parent.Use(mw("later parent"))
+
+	for i := 0; i < 2; i++ {
+		order = nil
+		h.Handle(nil, nil)
+		if got := strings.Join(order, ", "); got != "parent, child" {
+			t.Errorf("Wrong middlewares executed by compiled handler. Got: %s", got)
+		}
+	}
+}
+
+func TestChainError(t *testing.T) {
+	myErr := errors.New("custom error")
+	noop := m.RequestProcessor(func(req *http.Request) error { return nil })
+	failing := m.RequestProcessor(func(req *http.Request) error { return myErr })
+	passing := m.ResponseProcessor(func(resp *http.Response, err error) error { return err })
+	handler, _ := createHandler()
+
+	for _, data := range []struct {
+		chain *m.Chain
+		index int
+		name  string
+	}{
+		{m.NewChain(noop, passing, failing), 2, ""},
+		{m.NewChain(noop, m.Named("auth", failing)), 1, "auth"},
+		{m.NewChain(passing).ChildChain(passing, failing), 1, ""},
+	} {
+		_, err := data.chain.Exec(handler).Handle(nil, nil)
+		var chainErr *m.ChainError
+		if !errors.As(err, &chainErr) || !errors.Is(err, myErr) {
+			t.Fatalf("Expected chain error wrapping \"%s\", got: \"%v\"", myErr, err)
+		}
+		if chainErr.Index != data.index || chainErr.Name != data.name {
+			t.Errorf("Wrong middleware identified. Got: %d %q, expected: %d %q", chainErr.Index, chainErr.Name, data.index, data.name)
+		}
+	}
+
+	failingHandler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		return nil, myErr
+	})
+	if _, err := m.NewChain(noop, passing).Exec(failingHandler).Handle(nil, nil); err != myErr {
+		t.Errorf("Error of final handler wrapped: \"%v\"", err)
+	}
+	_, err := m.NewChain(noop, m.Named("auth", failing)).Exec(handler).Handle(nil, nil)
+	var namedErr *m.NamedError
+	if !errors.As(err, &namedErr) || namedErr.Name != "auth" || namedErr.Err != myErr {
+		t.Errorf("Named error not found in chain error: \"%v\"", err)
+	}
+	if err.Error() != "cliware: middleware 1 (auth): custom error" {
+		t.Errorf("Wrong error message of named middleware: %s", err)
+	}
+	err = &m.ChainError{Index: 3, Name: "auth", Err: myErr}
+	if err.Error() != "cliware: middleware 3 (auth): custom error" {
+		t.Errorf("Wrong error message: %s", err)
+	}
+}
+
+func TestChainClone(t *testing.T) {
+	var order []string
+	mw := func(name string) m.Middleware { return orderMiddleware{name, &order} }
+	base := m.NewChain(mw("parent")).ChildChain(mw("base"))
+	clone := base.Clone()
+	if clone.Parent() != nil {
+		t.Error("Clone has parent.")
+	}
+	clone.Use(mw("request"))
+	base.Use(mw("later"))
+	if err := clone.RemoveAt(0); err != nil {
+		t.Fatal("RemoveAt returned error: ", err)
+	}
+
+	handler, _ := createHandler()
+	clone.Exec(handler).Handle(nil, nil)
+	if got := strings.Join(order, ", "); got != "base, request" {
+		t.Errorf("Wrong middlewares of clone. Got: %s", got)
+	}
+	order = nil
+	base.Exec(handler).Handle(nil, nil)
+	if got := strings.Join(order, ", "); got != "parent, base, later" {
+		t.Errorf("Base chain affected by clone. Got: %s", got)
+	}
+}
+
 func TestRequestProcessorNoError(t *testing.T) {
 	var processorCalled bool
 	processor := m.RequestProcessor(func(req *http.Request) error {
@@ -210,7 +393,7 @@ func TestRequestProcessorWithError(t *testing.T) {
 	chain := m.NewChain(processor)
 	handler, handlerCalled := createHandler()
 	_, err := chain.Exec(handler).Handle(nil, nil)
-	if err != myErr {
+	if !errors.Is(err, myErr) {
 		t.Errorf("Expected error: \"%s\", got: \"%s\"", myErr, err)
 	}
 	if !processorCalled {
@@ -251,7 +434,7 @@ func TestResponseProcessorWithError(t *testing.T) {
 	chain := m.NewChain(processor)
 	handler, handlerCalled := createHandler()
 	_, err := chain.Exec(handler).Handle(nil, nil)
-	if err != myErr {
+	if !errors.Is(err, myErr) {
 		t.Errorf("Expected error: \"%s\", got: \"%s\"", myErr, err)
 	}
 	if !processorCalled {
@@ -262,6 +445,157 @@ func TestResponseProcessorWithError(t *testing.T) {
 	}
 }
 
+func TestResponseProcessorClosesBody(t *testing.T) {
+	myErr := errors.New("custom error")
+	incoming := errors.New("incoming error")
+	for _, data := range []struct {
+		name     string
+		err      error
+		returned error
+		closed   bool
+	}{
+		{"new error", nil, myErr, true},
+		{"kept body", nil, m.KeepBody(myErr), false},
+		{"same error", incoming, incoming, false},
+		{"kept same error", incoming, m.KeepBody(incoming), false},
+		{"no error", nil, nil, false},
+	} {
+		body := &trackedBody{Reader: strings.NewReader("body")}
+		handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: 200, Body: body}, data.err
+		})
+		processors := []m.Middleware{
+			m.ResponseProcessor(func(resp *http.Response, err error) error { return data.returned }),
+			m.ResponseProcessorCtx(func(ctx context.Context, resp *http.Response, err error) error { return data.returned }),
+		}
+		for _, processor := range processors {
+			body.closed = 0
+			resp, err := m.NewChain(processor).Exec(handler).Handle(nil, m.EmptyRequest())
+			if resp == nil || body.isClosed() != data.closed {
+				t.Errorf("Wrong body handling for %s. Closed: %t, expected: %t", data.name, body.isClosed(), data.closed)
+			}
+			expected := data.err
+			if data.returned != nil && expected == nil {
+				expected = myErr
+			}
+			if !errors.Is(err, expected) || (err == nil) != (expected == nil) {
+				t.Errorf("Wrong error for %s. Got: %v, expected: %v", data.name, err, expected)
+			}
+			var chainErr *m.ChainError
+			if data.err != nil && errors.As(err, &chainErr) {
+				t.Errorf("Incoming error wrapped for %s. Got: %v", data.name, err)
+			}
+		}
+	}
+}
+
+func TestProcessorsCtx(t *testing.T) {
+	type key struct{}
+	var requestValue, responseValue interface{}
+	chain := m.NewChain(m.ContextProcessor(func(ctx context.Context) context.Context {
+		return context.WithValue(ctx, key{}, "request-id")
+	}))
+	chain.UseRequestCtx(func(ctx context.Context, req *http.Request) error {
+		requestValue = ctx.Value(key{})
+		return nil
+	})
+	myErr := errors.New("custom error")
+	chain.UseResponseCtx(func(ctx context.Context, resp *http.Response, err error) error {
+		responseValue = ctx.Value(key{})
+		return myErr
+	})
+	handler, _ := createHandler()
+	_, err := chain.Exec(handler).Handle(context.Background(), m.EmptyRequest())
+	if requestValue != "request-id" || responseValue != "request-id" {
+		t.Errorf("Processors did not get context of request. Got: %v, %v", requestValue, responseValue)
+	}
+	var chainErr *m.ChainError
+	if !errors.As(err, &chainErr) || chainErr.Index != 2 || !errors.Is(err, myErr) {
+		t.Errorf("Expected error: \"%s\" of middleware 2, got: \"%v\"", myErr, err)
+	}
+
+	failing := m.RequestProcessorCtx(func(ctx context.Context, req *http.Request) error { return myErr })
+	handlerCalled := false
+	final := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		handlerCalled = true
+		return nil, nil
+	})
+	if _, err := failing.Exec(final).Handle(nil, nil); err != myErr || handlerCalled {
+		t.Errorf("Expected error: \"%s\" without calling handler, got: \"%v\"", myErr, err)
+	}
+}
+
+func TestRequestModifier(t *testing.T) {
+	type key struct{}
+	var gotReq *http.Request
+	var gotCtx context.Context
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		gotReq, gotCtx = req, ctx
+		return nil, nil
+	})
+	original := m.EmptyRequest()
+	ctx := context.WithValue(context.Background(), key{}, "caller")
+
+	chain := m.NewChain()
+	chain.UseRequestModifier(func(req *http.Request) (*http.Request, error) {
+		return req, nil
+	})
+	chain.Exec(handler).Handle(ctx, original)
+	if gotReq != original || gotCtx != ctx {
+		t.Error("Request or context changed when modifier returned same request.")
+	}
+
+	replacement := original.WithContext(context.WithValue(context.Background(), key{}, "modifier"))
+	chain.UseRequestModifier(func(req *http.Request) (*http.Request, error) {
+		return replacement, nil
+	})
+	chain.Exec(handler).Handle(ctx, original)
+	if gotReq != replacement || gotCtx.Value(key{}) != "modifier" {
+		t.Errorf("Request or its context not replaced. Got context value: %v", gotCtx.Value(key{}))
+	}
+
+	gotReq = nil
+	myErr := errors.New("custom error")
+	modifier := m.RequestModifier(func(req *http.Request) (*http.Request, error) {
+		return nil, myErr
+	})
+	if _, err := modifier.Exec(handler).Handle(ctx, original); err != myErr || gotReq != nil {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", myErr, err)
+	}
+}
+
+func TestResponseModifier(t *testing.T) {
+	chain := m.NewChain()
+	var seen *http.Response
+	chain.UseResponse(func(resp *http.Response, err error) error {
+		seen = resp
+		return nil
+	})
+	replaced := &http.Response{StatusCode: http.StatusTeapot}
+	chain.UseResponseModifier(func(resp *http.Response, err error) (*http.Response, error) {
+		if err != nil {
+			t.Error("Modifier got error: ", err)
+		}
+		return replaced, nil
+	})
+	handler, _ := createHandler()
+	resp, err := chain.Exec(handler).Handle(nil, nil)
+	if err != nil {
+		t.Error("Handle returned error: ", err)
+	}
+	if resp != replaced || seen != replaced {
+		t.Error("Response not replaced for upstream middleware and caller.")
+	}
+
+	myErr := errors.New("custom error")
+	modifier := m.ResponseModifier(func(resp *http.Response, err error) (*http.Response, error) {
+		return nil, myErr
+	})
+	if resp, err := modifier.Exec(handler).Handle(nil, nil); resp != nil || err != myErr {
+		t.Errorf("Expected error: \"%s\", got: %v, \"%v\"", myErr, resp, err)
+	}
+}
+
 func TestContextProcessor_Exec(t *testing.T) {
 	var processorCalled bool
 	processor := m.ContextProcessor(func(ctx context.Context) context.Context {
@@ -282,6 +616,189 @@ func TestContextProcessor_Exec(t *testing.T) {
 	}
 }
 
+func TestWhen(t *testing.T) {
+	called := new(bool)
+	mw := m.RequestProcessor(func(req *http.Request) error {
+		*called = true
+		return nil
+	})
+	handler, handlerCalled := createHandler()
+	h := m.When(func(req *http.Request) bool { return req.Method != "GET" }, mw).Exec(handler)
+
+	h.Handle(nil, m.EmptyRequest())
+	if *called || !*handlerCalled {
+		t.Error("Middleware executed for request not matching predicate.")
+	}
+	req := m.EmptyRequest()
+	req.Method = "POST"
+	h.Handle(nil, req)
+	if !*called {
+		t.Error("Middleware not executed for request matching predicate.")
+	}
+
+	*called, *handlerCalled = false, false
+	if _, err := h.Handle(nil, nil); err != nil {
+		t.Error("Handle returned error: ", err)
+	}
+	if *called || !*handlerCalled {
+		t.Error("Middleware executed for nil request.")
+	}
+}
+
+func TestShortCircuit(t *testing.T) {
+	var observed int
+	observer := m.ResponseProcessor(func(resp *http.Response, err error) error {
+		if resp != nil {
+			observed = resp.StatusCode
+		}
+		return nil
+	})
+	cached := m.ShortCircuit(func(ctx context.Context, req *http.Request) (*http.Response, bool, error) {
+		if req.Method != "GET" {
+			return nil, false, nil
+		}
+		return &http.Response{StatusCode: http.StatusNotModified}, true, nil
+	})
+	handler, handlerCalled := createHandler()
+	h := m.NewChain(observer, cached).Exec(handler)
+
+	if _, err := h.Handle(nil, m.EmptyRequest()); err != nil {
+		t.Error("Handle returned error: ", err)
+	}
+	if *handlerCalled {
+		t.Error("Handler called for handled request.")
+	}
+	if observed != http.StatusNotModified {
+		t.Errorf("Synthesized response not observed by previous middleware. Got status: %d", observed)
+	}
+	req := m.EmptyRequest()
+	req.Method = "POST"
+	h.Handle(nil, req)
+	if !*handlerCalled {
+		t.Error("Handler not called for request that was not handled.")
+	}
+}
+
+func TestRespond(t *testing.T) {
+	mock := &http.Response{StatusCode: 201, Header: make(http.Header), Body: ioutil.NopCloser(strings.NewReader("mocked"))}
+	handler, handlerCalled := createHandler()
+	h := m.Respond(mock).Exec(handler)
+	for i := 0; i < 2; i++ {
+		resp, err := h.Handle(nil, m.EmptyRequest())
+		if err != nil || resp.StatusCode != 201 || readBody(t, resp) != "mocked" {
+			t.Errorf("Wrong response %d. Got: %v, %v", i+1, resp, err)
+		}
+	}
+	if *handlerCalled {
+		t.Error("Handler called by Respond.")
+	}
+}
+
+// sleepingMiddleware returns middleware that sleeps for provided duration
+// before calling next handler.
+func sleepingMiddleware(d time.Duration) m.Middleware {
+	return m.RequestProcessor(func(req *http.Request) error {
+		time.Sleep(d)
+		return nil
+	})
+}
+
+func TestUseTimer(t *testing.T) {
+	var mu sync.Mutex
+	durations := make(map[int][]time.Duration)
+	names := make(map[int]string)
+	chain := m.NewChain(m.Named("first", sleepingMiddleware(10*time.Millisecond)), sleepingMiddleware(10*time.Millisecond))
+	chain.UseTimer(func(index int, name string, d time.Duration) {
+		mu.Lock()
+		durations[index] = append(durations[index], d)
+		names[index] = name
+		mu.Unlock()
+	})
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		time.Sleep(10 * time.Millisecond)
+		return nil, nil
+	})
+	h := chain.Exec(handler)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.Handle(nil, m.EmptyRequest())
+		}()
+	}
+	wg.Wait()
+
+	if len(durations[0]) != 5 || len(durations[1]) != 5 {
+		t.Fatalf("Wrong number of reported durations: %v", durations)
+	}
+	for i := 0; i < 5; i++ {
+		if durations[0][i] < 30*time.Millisecond || durations[1][i] < 20*time.Millisecond {
+			t.Errorf("Durations do not include next handlers. Got: %s, %s", durations[0][i], durations[1][i])
+		}
+	}
+	if names[0] != "first" || names[1] != "" {
+		t.Errorf("Wrong names reported: %v", names)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	var order []string
+	mw := func(name string) m.Middleware { return orderMiddleware{name, &order} }
+	auth := m.NewChain(mw("base")).ChildChain(mw("auth"))
+	logging := m.NewChain(mw("logging"))
+	merged := auth.Append(logging)
+	auth.Use(mw("late auth"))
+	logging.Use(mw("late logging"))
+
+	if merged.Parent() != nil {
+		t.Error("Merged chain has parent.")
+	}
+	handler, _ := createHandler()
+	merged.Exec(handler).Handle(nil, nil)
+	if strings.Join(order, ", ") != "base, auth, logging" {
+		t.Errorf("Wrong execution order. Got: %v", order)
+	}
+
+	order = nil
+	m.Merge(logging, nil, auth).Exec(handler).Handle(nil, nil)
+	if strings.Join(order, ", ") != "logging, late logging, base, auth, late auth" {
+		t.Errorf("Wrong execution order of merged chains. Got: %v", order)
+	}
+}
+
+func TestChainConcurrentUse(t *testing.T) {
+	chain := m.NewChain()
+	handler := createStatusHandler(200)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			chain.UseRequest(func(req *http.Request) error { return nil })
+			chain.UseFunc(func(next m.Handler) m.Handler { return next })
+		}()
+		go func() {
+			defer wg.Done()
+			chain.Exec(handler).Handle(nil, nil)
+			chain.Middlewares()
+		}()
+	}
+	wg.Wait()
+	if n := len(chain.Middlewares()); n != 20 {
+		t.Errorf("Wrong number of middlewares. Got: %d, expected: 20", n)
+	}
+
+	h := chain.Exec(handler)
+	mw, called := createMiddleware()
+	chain.Use(mw)
+	h.Handle(nil, nil)
+	if *called {
+		t.Error("Middleware added after Exec executed by its handler.")
+	}
+}
+
 func TestCopy(t *testing.T) {
 	processor := m.RequestProcessor(func(req *http.Request) error {
 		return nil
@@ -339,3 +856,83 @@ func createHandler() (handler m.Handler, called *bool) {
 	})
 	return handler, &handlerCalled
 }
+
+type validatingMiddleware struct {
+	m.Middleware
+	err error
+}
+
+func (vm validatingMiddleware) Validate(middlewares []m.Middleware, index int) error {
+	return vm.err
+}
+
+func TestValidate(t *testing.T) {
+	m1, _ := createMiddleware()
+	myErr := errors.New("custom error")
+	chain := m.NewChain(validatingMiddleware{m1, nil})
+	if err := chain.Validate(); err != nil {
+		t.Error("Validate returned error: ", err)
+	}
+	childChain := chain.ChildChain(validatingMiddleware{m1, myErr})
+	if err := childChain.Validate(); err != myErr {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", myErr, err)
+	}
+}
+
+func TestStrictExec(t *testing.T) {
+	m1, _ := createMiddleware()
+	myErr := errors.New("custom error")
+	chain := m.NewChain(validatingMiddleware{m1, myErr})
+	handler, handlerCalled := createHandler()
+
+	if _, err := chain.Exec(handler).Handle(nil, nil); err != nil {
+		t.Error("Handle returned error in non-strict mode: ", err)
+	}
+	*handlerCalled = false
+	chain.SetStrict(true)
+	if _, err := chain.Exec(handler).Handle(nil, nil); err != myErr {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", myErr, err)
+	}
+	if *handlerCalled {
+		t.Error("Handler called for invalid chain in strict mode.")
+	}
+}
+
+func createStatusHandler(code int) m.Handler {
+	return m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		return &http.Response{
+			StatusCode: code,
+			Header:     make(http.Header),
+			Body:       http.NoBody,
+		}, nil
+	})
+}
+
+func benchmarkChain() *m.Chain {
+	noop := m.RequestProcessor(func(req *http.Request) error { return nil })
+	return m.NewChain(noop, noop, noop, noop, noop, noop, noop, noop)
+}
+
+var benchmarkHandler = m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+	return nil, nil
+})
+
+func BenchmarkChainExecHandle(b *testing.B) {
+	chain := benchmarkChain()
+	req := m.EmptyRequest()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		chain.Exec(benchmarkHandler).Handle(nil, req)
+	}
+}
+
+func BenchmarkChainCompiledHandle(b *testing.B) {
+	h := benchmarkChain().Compile(benchmarkHandler)
+	req := m.EmptyRequest()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.Handle(nil, req)
+	}
+}
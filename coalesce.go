@@ -0,0 +1,265 @@
+package cliware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// CoalescingStats are statistics of middleware that coalesces requests, like
+// Coalescer, EqualityCoalescer, Deduper and ResponseCache.
+type CoalescingStats struct {
+	// Requests is number of requests considered for coalescing. For
+	// ResponseCache it includes only requests not served from cache.
+	Requests int64
+	// Coalesced is number of requests that got result of other request
+	// instead of being sent (or were rejected as duplicates).
+	Coalesced int64
+	// InFlight is number of requests with unique key currently in flight,
+	// whose results can be shared.
+	InFlight int64
+}
+
+// HitRatio returns ratio of coalesced requests to all requests, or zero if
+// there were no requests.
+func (s CoalescingStats) HitRatio() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Coalesced) / float64(s.Requests)
+}
+
+// CoalescingReporter is implemented by middlewares that coalesce requests
+// and report statistics about it.
+type CoalescingReporter interface {
+	// CoalescingStats returns current statistics.
+	CoalescingStats() CoalescingStats
+}
+
+// coalescingCounters counts coalesced requests. It is embedded in
+// middlewares that coalesce requests to implement CoalescingReporter.
+type coalescingCounters struct {
+	statsMu sync.Mutex
+	stats   CoalescingStats
+}
+
+// CoalescingStats is implementation of CoalescingReporter interface.
+func (cc *coalescingCounters) CoalescingStats() CoalescingStats {
+	cc.statsMu.Lock()
+	defer cc.statsMu.Unlock()
+	return cc.stats
+}
+
+// countCoalesced records request that got result of other request.
+func (cc *coalescingCounters) countCoalesced() {
+	cc.statsMu.Lock()
+	cc.stats.Requests++
+	cc.stats.Coalesced++
+	cc.statsMu.Unlock()
+}
+
+// countSent records request that is sent and returns function that must be
+// called when it completes.
+func (cc *coalescingCounters) countSent() func() {
+	cc.statsMu.Lock()
+	cc.stats.Requests++
+	cc.stats.InFlight++
+	cc.statsMu.Unlock()
+	return func() {
+		cc.statsMu.Lock()
+		cc.stats.InFlight--
+		cc.statsMu.Unlock()
+	}
+}
+
+// Coalescer is middleware that coalesces concurrent requests with the same
+// key into single call of next handler. It is created using Coalesce or
+// CoalesceIdempotent function.
+type Coalescer struct {
+	// CopyBody makes every coalesced request receive own copy of response
+	// body. By default bodies of all responses read from single shared
+	// buffer, using separate reader for each response. That is safe for
+	// concurrent use, since shared buffer is only read, and uses memory for
+	// single body regardless of number of coalesced requests, but buffer is
+	// kept in memory until all responses are released. Copying makes memory
+	// use grow with number of coalesced requests, but allows every body to
+	// be released independently.
+	CopyBody bool
+
+	coalescingCounters
+	key func(*http.Request) string
+
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+type coalescedCall struct {
+	done chan struct{}
+	resp *bufferedResponse
+	err  error
+}
+
+// Coalesce returns middleware that sends only one of concurrent requests with
+// the same key and shares its result with all of them. Each request gets own
+// copy of response, so response bodies are buffered. Requests sent after
+// shared call finished are sent again.
+//
+// Key of request is obtained using keyFn. Requests with empty key proceed
+// independently. If keyFn is nil, key consists of method, URL and hash of
+// request body. Shared call uses context of request that started it, so
+// if that context is canceled, all coalesced requests fail.
+func Coalesce(keyFn func(*http.Request) string) *Coalescer {
+	if keyFn == nil {
+		keyFn = contentKey
+	}
+	return &Coalescer{
+		key:   keyFn,
+		calls: make(map[string]*coalescedCall),
+	}
+}
+
+// CoalesceIdempotent returns Coalescer that coalesces concurrent requests
+// sharing the same Idempotency-Key header, since they represent the same
+// logical operation. This prevents duplicate side effects when request is
+// retried with the same key while first one is still in flight. Requests
+// without the header proceed independently.
+func CoalesceIdempotent() *Coalescer {
+	return Coalesce(idempotencyKey)
+}
+
+// Exec is implementation of Middleware interface.
+func (c *Coalescer) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		key := c.key(req)
+		if key == "" {
+			return next.Handle(ctx, req)
+		}
+
+		c.mu.Lock()
+		if call, ok := c.calls[key]; ok {
+			c.mu.Unlock()
+			c.countCoalesced()
+			select {
+			case <-call.done:
+			case <-ensureContext(ctx).Done():
+				return nil, ctx.Err()
+			}
+			if call.err != nil || call.resp == nil {
+				return nil, call.err
+			}
+			return c.response(call.resp), nil
+		}
+		call := &coalescedCall{done: make(chan struct{})}
+		c.calls[key] = call
+		c.mu.Unlock()
+
+		defer c.countSent()()
+		defer func() {
+			c.mu.Lock()
+			delete(c.calls, key)
+			c.mu.Unlock()
+			close(call.done)
+		}()
+		resp, call.err = next.Handle(ctx, req)
+		if call.err != nil || resp == nil {
+			return resp, call.err
+		}
+		call.resp, call.err = newBufferedResponse(resp)
+		if call.err != nil {
+			return nil, call.err
+		}
+		return c.response(call.resp), nil
+	})
+}
+
+// response returns copy of shared response for single coalesced request.
+func (c *Coalescer) response(resp *bufferedResponse) *http.Response {
+	if c.CopyBody {
+		return resp.responseCopy()
+	}
+	return resp.response()
+}
+
+// idempotencyKey returns value of Idempotency-Key header of request.
+func idempotencyKey(req *http.Request) string {
+	if req == nil {
+		return ""
+	}
+	return req.Header.Get("Idempotency-Key")
+}
+
+// EqualityCoalescer is middleware that coalesces concurrent requests that
+// are equal. It is created using DedupeBy function.
+type EqualityCoalescer struct {
+	coalescingCounters
+	equal func(a, b *http.Request) bool
+
+	mu    sync.Mutex
+	calls []*equalCall
+}
+
+type equalCall struct {
+	req  *http.Request
+	call *coalescedCall
+}
+
+// DedupeBy returns middleware that coalesces concurrent requests that are
+// equal according to provided function, similarly to Coalesce. It is meant
+// for requests that differ textually but are semantically identical (e.g.
+// have reordered query parameters), where key can not be derived easily.
+//
+// Every new request is compared with every request in flight, so cost of
+// each request grows linearly with number of requests in flight, in contrast
+// to constant cost of key lookup done by Coalesce. Prefer Coalesce when
+// canonical key can be computed.
+func DedupeBy(equal func(a, b *http.Request) bool) *EqualityCoalescer {
+	return &EqualityCoalescer{equal: equal}
+}
+
+// Exec is implementation of Middleware interface.
+func (ec *EqualityCoalescer) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		ec.mu.Lock()
+		for _, f := range ec.calls {
+			if !ec.equal(f.req, req) {
+				continue
+			}
+			ec.mu.Unlock()
+			ec.countCoalesced()
+			select {
+			case <-f.call.done:
+			case <-ensureContext(ctx).Done():
+				return nil, ctx.Err()
+			}
+			if f.call.err != nil || f.call.resp == nil {
+				return nil, f.call.err
+			}
+			return f.call.resp.response(), nil
+		}
+		f := &equalCall{req: req, call: &coalescedCall{done: make(chan struct{})}}
+		ec.calls = append(ec.calls, f)
+		ec.mu.Unlock()
+
+		defer ec.countSent()()
+		defer func() {
+			ec.mu.Lock()
+			for i, other := range ec.calls {
+				if other == f {
+					ec.calls = append(ec.calls[:i], ec.calls[i+1:]...)
+					break
+				}
+			}
+			ec.mu.Unlock()
+			close(f.call.done)
+		}()
+		resp, err = next.Handle(ctx, req)
+		if err != nil || resp == nil {
+			f.call.err = err
+			return resp, err
+		}
+		if f.call.resp, f.call.err = newBufferedResponse(resp); f.call.err != nil {
+			return nil, f.call.err
+		}
+		return f.call.resp.response(), nil
+	})
+}
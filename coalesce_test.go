@@ -0,0 +1,270 @@
+package cliware_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	m "go.delic.rs/cliware"
+)
+
+// createBlockingHandler returns handler that counts calls and blocks until
+// release is closed.
+func createBlockingHandler(release chan struct{}, err error) (handler m.Handler, calls *int32) {
+	calls = new(int32)
+	handler = m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(calls, 1)
+		<-release
+		if err != nil {
+			return nil, err
+		}
+		return &http.Response{
+			StatusCode: 201,
+			Header:     http.Header{"X-Id": {"1"}},
+			Body:       ioutil.NopCloser(strings.NewReader("created")),
+		}, nil
+	})
+	return handler, calls
+}
+
+// waitForCall waits until handler is called and gives other goroutines time
+// to join the call.
+func waitForCall(calls *int32) {
+	for atomic.LoadInt32(calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+}
+
+func runConcurrently(n int, h m.Handler, newReq func(i int) *http.Request) (resps []*http.Response, errs []error) {
+	resps = make([]*http.Response, n)
+	errs = make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resps[i], errs[i] = h.Handle(nil, newReq(i))
+		}(i)
+	}
+	wg.Wait()
+	return resps, errs
+}
+
+func TestCoalesceIdempotent(t *testing.T) {
+	release := make(chan struct{})
+	handler, calls := createBlockingHandler(release, nil)
+	h := m.CoalesceIdempotent().Exec(handler)
+
+	done := make(chan struct{})
+	var resps []*http.Response
+	var errs []error
+	go func() {
+		resps, errs = runConcurrently(5, h, func(i int) *http.Request {
+			req := m.EmptyRequest()
+			req.Header.Set("Idempotency-Key", "key")
+			return req
+		})
+		close(done)
+	}()
+	waitForCall(calls)
+	close(release)
+	<-done
+
+	if *calls != 1 {
+		t.Errorf("Wrong number of downstream calls. Got: %d, expected: 1", *calls)
+	}
+	for i, resp := range resps {
+		if errs[i] != nil {
+			t.Fatal("Handle returned error: ", errs[i])
+		}
+		if got := readBody(t, resp); got != "created" || resp.StatusCode != 201 {
+			t.Errorf("Wrong shared response. Got: %d %q", resp.StatusCode, got)
+		}
+	}
+	resps[0].Header.Set("X-Id", "changed")
+	if resps[1].Header.Get("X-Id") != "1" {
+		t.Error("Coalesced requests share response header.")
+	}
+}
+
+func TestCoalesceWithoutKey(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	handler, calls := createBlockingHandler(release, nil)
+	h := m.CoalesceIdempotent().Exec(handler)
+	_, errs := runConcurrently(5, h, func(i int) *http.Request {
+		return m.EmptyRequest()
+	})
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+	}
+	if *calls != 5 {
+		t.Errorf("Requests without key coalesced. Got %d calls, expected: 5", *calls)
+	}
+}
+
+func TestCoalesceSharesError(t *testing.T) {
+	expected := errors.New("downstream error")
+	release := make(chan struct{})
+	handler, calls := createBlockingHandler(release, expected)
+	h := m.Coalesce(func(req *http.Request) string { return "same" }).Exec(handler)
+
+	done := make(chan struct{})
+	var errs []error
+	go func() {
+		_, errs = runConcurrently(3, h, func(i int) *http.Request { return m.EmptyRequest() })
+		close(done)
+	}()
+	waitForCall(calls)
+	close(release)
+	<-done
+	for _, err := range errs {
+		if err != expected {
+			t.Errorf("Wrong error. Got: %v, expected: %v", err, expected)
+		}
+	}
+}
+
+func TestDedupeBy(t *testing.T) {
+	release := make(chan struct{})
+	handler, calls := createBlockingHandler(release, nil)
+	sameQuery := func(a, b *http.Request) bool {
+		return a.URL.Path == b.URL.Path && reflect.DeepEqual(a.URL.Query(), b.URL.Query())
+	}
+	h := m.DedupeBy(sameQuery).Exec(handler)
+
+	urls := []string{"http://localhost/a?x=1&y=2", "http://localhost/a?y=2&x=1", "http://localhost/b?x=1&y=2"}
+	done := make(chan struct{})
+	var resps []*http.Response
+	var errs []error
+	go func() {
+		resps, errs = runConcurrently(6, h, func(i int) *http.Request {
+			req, _ := http.NewRequest("GET", urls[i%len(urls)], nil)
+			return req
+		})
+		close(done)
+	}()
+	waitForCall(calls)
+	close(release)
+	<-done
+
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Errorf("Wrong number of downstream calls. Got: %d, expected: 2", n)
+	}
+	for i, resp := range resps {
+		if errs[i] != nil {
+			t.Fatal("Handle returned error: ", errs[i])
+		}
+		if got := readBody(t, resp); got != "created" {
+			t.Errorf("Wrong body. Got: %q, expected: \"created\"", got)
+		}
+	}
+}
+
+func TestCoalesceCopyBody(t *testing.T) {
+	release := make(chan struct{})
+	handler, calls := createBlockingHandler(release, nil)
+	coalescer := m.Coalesce(func(req *http.Request) string { return "same" })
+	coalescer.CopyBody = true
+	h := coalescer.Exec(handler)
+
+	done := make(chan struct{})
+	var resps []*http.Response
+	go func() {
+		resps, _ = runConcurrently(3, h, func(i int) *http.Request { return m.EmptyRequest() })
+		close(done)
+	}()
+	waitForCall(calls)
+	close(release)
+	<-done
+	for _, resp := range resps {
+		if got := readBody(t, resp); got != "created" {
+			t.Errorf("Wrong copied body. Got: %q, expected: \"created\"", got)
+		}
+	}
+}
+
+func benchmarkCoalesce(b *testing.B, copyBody bool) {
+	const subscribers = 100
+	body := strings.Repeat("x", 64<<10)
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		time.Sleep(time.Millisecond)
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+	})
+	coalescer := m.CoalesceIdempotent()
+	coalescer.CopyBody = copyBody
+	h := coalescer.Exec(handler)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		for j := 0; j < subscribers; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req := m.EmptyRequest()
+				req.Header.Set("Idempotency-Key", "key")
+				resp, err := h.Handle(nil, req)
+				if err == nil {
+					resp.Body.Close()
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+func BenchmarkCoalesceSharedBody(b *testing.B) {
+	benchmarkCoalesce(b, false)
+}
+
+func BenchmarkCoalesceCopyBody(b *testing.B) {
+	benchmarkCoalesce(b, true)
+}
+
+func TestCoalescingStats(t *testing.T) {
+	release := make(chan struct{})
+	handler, calls := createBlockingHandler(release, nil)
+	coalescer := m.Coalesce(nil)
+	h := coalescer.Exec(handler)
+
+	done := make(chan struct{})
+	go func() {
+		runConcurrently(5, h, func(i int) *http.Request { return m.EmptyRequest() })
+		close(done)
+	}()
+	waitForCall(calls)
+	if stats := coalescer.CoalescingStats(); stats.InFlight != 1 {
+		t.Errorf("Wrong number of requests in flight. Got: %d, expected: 1", stats.InFlight)
+	}
+	close(release)
+	<-done
+
+	expected := m.CoalescingStats{Requests: 5, Coalesced: 4, InFlight: 0}
+	if stats := coalescer.CoalescingStats(); stats != expected {
+		t.Errorf("Wrong stats. Got: %+v, expected: %+v", stats, expected)
+	}
+	if ratio := coalescer.CoalescingStats().HitRatio(); ratio != 0.8 {
+		t.Errorf("Wrong hit ratio. Got: %g, expected: 0.8", ratio)
+	}
+	if (m.CoalescingStats{}).HitRatio() != 0 {
+		t.Error("Hit ratio without requests is not zero.")
+	}
+
+	reporters := []m.CoalescingReporter{coalescer, m.DedupeBy(nil), m.DedupeWindow(time.Second, nil), m.Cache(nil, time.Second)}
+	for _, reporter := range reporters[1:] {
+		if stats := reporter.CoalescingStats(); stats != (m.CoalescingStats{}) {
+			t.Errorf("Stats of unused middleware not empty: %+v", stats)
+		}
+	}
+}
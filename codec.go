@@ -0,0 +1,239 @@
+package cliware
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// DecodeFunc is function that decodes data read from reader into value v.
+type DecodeFunc func(r io.Reader, v interface{}) error
+
+// ResponseCodec is registry of response body decoders, keyed by media type.
+// It is safe for concurrent use.
+type ResponseCodec struct {
+	mu       sync.RWMutex
+	decoders map[string]DecodeFunc
+}
+
+// NewResponseCodec creates response codec with registered decoders for JSON
+// (application/json) and XML (application/xml and text/xml).
+func NewResponseCodec() *ResponseCodec {
+	decodeJSON := func(r io.Reader, v interface{}) error {
+		return json.NewDecoder(r).Decode(v)
+	}
+	decodeXML := func(r io.Reader, v interface{}) error {
+		return xml.NewDecoder(r).Decode(v)
+	}
+	return &ResponseCodec{
+		decoders: map[string]DecodeFunc{
+			"application/json": decodeJSON,
+			"application/xml":  decodeXML,
+			"text/xml":         decodeXML,
+		},
+	}
+}
+
+// WithCodec registers decoder for provided content type, replacing existing
+// one if any. Parameters of content type (e.g. charset) are ignored. Same
+// codec is returned, so calls can be chained.
+func (rc *ResponseCodec) WithCodec(contentType string, decode DecodeFunc) *ResponseCodec {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.decoders[normalizeMediaType(contentType)] = decode
+	return rc
+}
+
+// decoder returns decoder for provided content type. If there is no decoder
+// for exact media type, decoder for structured syntax suffix is tried (e.g.
+// application/json for application/problem+json).
+func (rc *ResponseCodec) decoder(contentType string) (DecodeFunc, bool) {
+	mediaType := normalizeMediaType(contentType)
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	if decode, ok := rc.decoders[mediaType]; ok {
+		return decode, true
+	}
+	if i := strings.LastIndex(mediaType, "+"); i >= 0 {
+		decode, ok := rc.decoders["application/"+mediaType[i+1:]]
+		return decode, ok
+	}
+	return nil, false
+}
+
+// DecodeResponse returns middleware that decodes body of successful (2xx)
+// response into target, using decoder registered for response Content-Type.
+// Body is buffered, so it can be read again after decoding. If no decoder is
+// registered for response content type, error is returned. Since responses
+// pass through middlewares in reverse order, decoding middleware must be
+// placed before Decompress, so that it decodes decompressed body, which is
+// checked by chain validation (see Validate).
+func (rc *ResponseCodec) DecodeResponse(target interface{}) Middleware {
+	return responseDecoder{rc.decodeResponse(target)}
+}
+
+// responseDecoder is middleware returned by DecodeResponse.
+type responseDecoder struct {
+	ResponseProcessor
+}
+
+// Validate is implementation of Validator interface. It reports error if
+// Decompress is before this middleware, so it would decode compressed body.
+func (rd responseDecoder) Validate(middlewares []Middleware, index int) error {
+	for _, m := range middlewares[:index] {
+		if _, ok := m.(*Decompressor); ok {
+			return errors.New("cliware: DecodeResponse decodes compressed body when placed after Decompress")
+		}
+	}
+	return nil
+}
+
+// decodeResponse returns processor that decodes response into target.
+func (rc *ResponseCodec) decodeResponse(target interface{}) ResponseProcessor {
+	return ResponseProcessor(func(resp *http.Response, err error) error {
+		if err != nil || resp == nil || resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return nil
+		}
+		contentType := resp.Header.Get("Content-Type")
+		decode, ok := rc.decoder(contentType)
+		if !ok {
+			return fmt.Errorf("cliware: no decoder registered for content type %q", contentType)
+		}
+		data, err := bufferResponseBody(resp)
+		if err != nil {
+			return err
+		}
+		return decode(bytes.NewReader(data), target)
+	})
+}
+
+// DefaultResponseCodec is response codec used by WithCodec and
+// DecodeResponse functions.
+var DefaultResponseCodec = NewResponseCodec()
+
+// WithCodec registers decoder for content type in DefaultResponseCodec.
+func WithCodec(contentType string, decode DecodeFunc) *ResponseCodec {
+	return DefaultResponseCodec.WithCodec(contentType, decode)
+}
+
+// DecodeResponse returns middleware that decodes response body into target
+// using DefaultResponseCodec.
+func DecodeResponse(target interface{}) Middleware {
+	return DefaultResponseCodec.DecodeResponse(target)
+}
+
+// normalizeMediaType returns lower case media type without parameters.
+func normalizeMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mediaType
+}
+
+// EncodeFunc is function that encodes value v and writes it to writer.
+type EncodeFunc func(w io.Writer, v interface{}) error
+
+// RequestCodec is registry of request body encoders, keyed by media type.
+// It is safe for concurrent use.
+type RequestCodec struct {
+	mu       sync.RWMutex
+	encoders map[string]EncodeFunc
+}
+
+// NewRequestCodec creates request codec with registered encoders for JSON
+// (application/json), XML (application/xml and text/xml) and forms
+// (application/x-www-form-urlencoded). Form encoder accepts url.Values,
+// map[string][]string and map[string]string values.
+func NewRequestCodec() *RequestCodec {
+	encodeJSON := func(w io.Writer, v interface{}) error {
+		return json.NewEncoder(w).Encode(v)
+	}
+	encodeXML := func(w io.Writer, v interface{}) error {
+		return xml.NewEncoder(w).Encode(v)
+	}
+	return &RequestCodec{
+		encoders: map[string]EncodeFunc{
+			"application/json":                  encodeJSON,
+			"application/xml":                   encodeXML,
+			"text/xml":                          encodeXML,
+			"application/x-www-form-urlencoded": encodeForm,
+		},
+	}
+}
+
+// WithCodec registers encoder for provided content type, replacing existing
+// one if any. Parameters of content type (e.g. charset) are ignored. Same
+// codec is returned, so calls can be chained.
+func (rc *RequestCodec) WithCodec(contentType string, encode EncodeFunc) *RequestCodec {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.encoders[normalizeMediaType(contentType)] = encode
+	return rc
+}
+
+// EncodeRequest returns middleware that encodes v using encoder registered
+// for provided content type and sets result as request body. Content-Type
+// header is set to provided content type and GetBody is set, so request can
+// be sent multiple times. If there is no encoder for content type or encoding
+// fails, error is returned and chain execution is stopped.
+func (rc *RequestCodec) EncodeRequest(v interface{}, contentType string) Middleware {
+	return RequestProcessor(func(req *http.Request) error {
+		rc.mu.RLock()
+		encode, ok := rc.encoders[normalizeMediaType(contentType)]
+		rc.mu.RUnlock()
+		if !ok {
+			return fmt.Errorf("cliware: no encoder registered for content type %q", contentType)
+		}
+		var buf bytes.Buffer
+		if err := encode(&buf, v); err != nil {
+			return err
+		}
+		setRequestBody(req, buf.Bytes())
+		req.Header.Set("Content-Type", contentType)
+		return nil
+	})
+}
+
+// DefaultRequestCodec is request codec used by WithRequestCodec and
+// EncodeRequest functions.
+var DefaultRequestCodec = NewRequestCodec()
+
+// WithRequestCodec registers encoder for content type in DefaultRequestCodec.
+func WithRequestCodec(contentType string, encode EncodeFunc) *RequestCodec {
+	return DefaultRequestCodec.WithCodec(contentType, encode)
+}
+
+// EncodeRequest returns middleware that encodes v as request body using
+// DefaultRequestCodec.
+func EncodeRequest(v interface{}, contentType string) Middleware {
+	return DefaultRequestCodec.EncodeRequest(v, contentType)
+}
+
+// encodeForm is EncodeFunc for URL encoded forms.
+func encodeForm(w io.Writer, v interface{}) error {
+	var values url.Values
+	switch form := v.(type) {
+	case url.Values:
+		values = form
+	case map[string][]string:
+		values = url.Values(form)
+	case map[string]string:
+		values = make(url.Values, len(form))
+		for key, value := range form {
+			values.Set(key, value)
+		}
+	default:
+		return fmt.Errorf("cliware: can not encode %T as form", v)
+	}
+	_, err := io.WriteString(w, values.Encode())
+	return err
+}
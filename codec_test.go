@@ -0,0 +1,154 @@
+package cliware_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func createBodyHandler(contentType, body string) m.Handler {
+	return m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		resp = &http.Response{
+			StatusCode: 200,
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+		}
+		resp.Header.Set("Content-Type", contentType)
+		return resp, nil
+	})
+}
+
+func TestDecodeResponseJSON(t *testing.T) {
+	var target struct {
+		Name string `json:"name"`
+	}
+	handler := createBodyHandler("application/problem+json; charset=utf-8", `{"name": "cliware"}`)
+	resp, err := m.DecodeResponse(&target).Exec(handler).Handle(nil, nil)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if target.Name != "cliware" {
+		t.Errorf("Wrong decoded value. Got: %q, expected: cliware", target.Name)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != `{"name": "cliware"}` {
+		t.Errorf("Body not readable after decoding. Got: %q", body)
+	}
+}
+
+func TestDecodeResponseXML(t *testing.T) {
+	var target struct {
+		Name string `xml:"name"`
+	}
+	handler := createBodyHandler("text/xml", `<root><name>cliware</name></root>`)
+	if _, err := m.DecodeResponse(&target).Exec(handler).Handle(nil, nil); err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if target.Name != "cliware" {
+		t.Errorf("Wrong decoded value. Got: %q, expected: cliware", target.Name)
+	}
+}
+
+func TestDecodeResponseCustomCodec(t *testing.T) {
+	codec := m.NewResponseCodec().WithCodec("text/plain", func(r io.Reader, v interface{}) error {
+		data, err := ioutil.ReadAll(r)
+		*v.(*string) = strings.ToUpper(string(data))
+		return err
+	})
+	var target string
+	handler := createBodyHandler("text/plain", "cliware")
+	if _, err := codec.DecodeResponse(&target).Exec(handler).Handle(nil, nil); err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if target != "CLIWARE" {
+		t.Errorf("Wrong decoded value. Got: %q, expected: CLIWARE", target)
+	}
+}
+
+func TestDecodeResponseUnregistered(t *testing.T) {
+	var target string
+	handler := createBodyHandler("application/msgpack", "data")
+	if _, err := m.NewResponseCodec().DecodeResponse(&target).Exec(handler).Handle(nil, nil); err == nil {
+		t.Error("Expected error for unregistered content type.")
+	}
+}
+
+func TestDecodeResponseSkipsErrors(t *testing.T) {
+	myErr := errors.New("custom error")
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		return nil, myErr
+	})
+	var target string
+	if _, err := m.DecodeResponse(&target).Exec(handler).Handle(nil, nil); err != myErr {
+		t.Errorf("Expected error: \"%s\", got: \"%s\"", myErr, err)
+	}
+}
+
+func TestDecodeResponseValidate(t *testing.T) {
+	var target string
+	if err := m.NewChain(m.DecodeResponse(&target), m.Decompress()).Validate(); err != nil {
+		t.Error("Validate returned error: ", err)
+	}
+	if err := m.NewChain(m.Decompress(), m.Named("decode", m.DecodeResponse(&target))).Validate(); err == nil {
+		t.Error("Expected validation error for DecodeResponse after Decompress.")
+	}
+}
+
+func TestEncodeRequest(t *testing.T) {
+	for _, data := range []struct {
+		value       interface{}
+		contentType string
+		body        string
+	}{
+		{map[string]string{"name": "cliware"}, "application/json", "{\"name\":\"cliware\"}\n"},
+		{map[string]string{"name": "cliware"}, "application/x-www-form-urlencoded", "name=cliware"},
+		{struct {
+			XMLName struct{} `xml:"root"`
+			Name    string   `xml:"name"`
+		}{Name: "cliware"}, "application/xml; charset=utf-8", "<root><name>cliware</name></root>"},
+	} {
+		req := m.EmptyRequest()
+		handler, _ := createHandler()
+		if _, err := m.EncodeRequest(data.value, data.contentType).Exec(handler).Handle(nil, req); err != nil {
+			t.Errorf("Handle returned error for %s: %s", data.contentType, err)
+			continue
+		}
+		if req.Header.Get("Content-Type") != data.contentType {
+			t.Errorf("Wrong Content-Type. Got: %s, expected: %s", req.Header.Get("Content-Type"), data.contentType)
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		if string(body) != data.body {
+			t.Errorf("Wrong body. Got: %q, expected: %q", body, data.body)
+		}
+		if req.ContentLength != int64(len(data.body)) {
+			t.Errorf("Wrong ContentLength. Got: %d, expected: %d", req.ContentLength, len(data.body))
+		}
+		rewound, _ := req.GetBody()
+		body, _ = ioutil.ReadAll(rewound)
+		if string(body) != data.body {
+			t.Errorf("Wrong body from GetBody. Got: %q, expected: %q", body, data.body)
+		}
+	}
+}
+
+func TestEncodeRequestError(t *testing.T) {
+	handler, handlerCalled := createHandler()
+	codecs := []m.Middleware{
+		m.NewRequestCodec().EncodeRequest("data", "application/msgpack"),
+		m.EncodeRequest(42, "application/x-www-form-urlencoded"),
+	}
+	for _, codec := range codecs {
+		if _, err := codec.Exec(handler).Handle(nil, m.EmptyRequest()); err == nil {
+			t.Error("Expected encoding error.")
+		}
+	}
+	if *handlerCalled {
+		t.Error("Handler called even when encoding failed.")
+	}
+}
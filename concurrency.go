@@ -0,0 +1,223 @@
+package cliware
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ConcurrencyLimiter is middleware that adaptively limits number of
+// concurrent requests. It is created using AdaptiveConcurrency function.
+type ConcurrencyLimiter struct {
+	// MinLimit is lowest limit limiter can set. Defaults to 1.
+	MinLimit int
+	// MaxLimit is highest limit limiter can set. Defaults to 1000.
+	MaxLimit int
+	// PerHost makes limiter keep separate limit for each host. It must be
+	// set before limiter is used.
+	PerHost bool
+
+	mu     sync.Mutex
+	shared *concurrencyState
+	hosts  map[string]*concurrencyState
+}
+
+// concurrencyState is limit and latency statistics of single limited group
+// of requests. It is guarded by mutex of ConcurrencyLimiter.
+type concurrencyState struct {
+	limit    float64
+	inflight int
+	samples  int
+	longRTT  float64
+	released chan struct{}
+}
+
+const (
+	initialConcurrencyLimit = 20
+	// concurrencyTolerance is ratio by which latency may exceed long term
+	// average before limit is decreased.
+	concurrencyTolerance = 1.5
+	// concurrencySmoothing is weight of newly computed limit.
+	concurrencySmoothing = 0.2
+	// concurrencyWindow is number of samples long term latency is averaged
+	// over.
+	concurrencyWindow = 100
+)
+
+// AdaptiveConcurrency returns middleware that limits number of concurrent
+// requests, adjusting the limit based on observed latency, similarly to
+// gradient limit of Netflix concurrency-limits library. Limit is increased
+// while latency stays close to its long term average and decreased when it
+// rises above it, which is a sign of server or network being overloaded, so
+// optimal concurrency is found without manual tuning. Latency is measured
+// until response is returned by next handler, while request holds its slot
+// until its response body is closed. Requests over the limit wait for free
+// slot, and if their context is done while waiting, its error is returned.
+// Requests that fail with error do not affect the limit.
+//
+// Limit starts at 20, or MinLimit or MaxLimit if 20 is out of their range,
+// and is shared by all requests going through returned middleware, unless
+// PerHost is set. Requests without URL share the same limit even then.
+func AdaptiveConcurrency() *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		MinLimit: 1,
+		MaxLimit: 1000,
+		shared:   newConcurrencyState(),
+		hosts:    make(map[string]*concurrencyState),
+	}
+}
+
+func newConcurrencyState() *concurrencyState {
+	return &concurrencyState{limit: initialConcurrencyLimit, released: make(chan struct{})}
+}
+
+// Limit returns current limit shared by all requests. If PerHost is set,
+// it is not used, see HostLimit.
+func (cl *ConcurrencyLimiter) Limit() int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return int(cl.clampInitial(cl.shared).limit)
+}
+
+// HostLimit returns current limit for requests to provided host, or initial
+// limit if no request was sent to it yet. If PerHost is not set, it is the
+// same as Limit.
+func (cl *ConcurrencyLimiter) HostLimit(host string) int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return int(cl.state(host).limit)
+}
+
+// state returns state for requests to host. Mutex must be held.
+func (cl *ConcurrencyLimiter) state(host string) *concurrencyState {
+	if !cl.PerHost {
+		return cl.clampInitial(cl.shared)
+	}
+	state, ok := cl.hosts[host]
+	if !ok {
+		state = newConcurrencyState()
+		cl.hosts[host] = state
+	}
+	return cl.clampInitial(state)
+}
+
+// requestState returns state for request. Requests without URL use shared
+// state. Mutex must be held.
+func (cl *ConcurrencyLimiter) requestState(req *http.Request) *concurrencyState {
+	if req == nil || req.URL == nil {
+		return cl.clampInitial(cl.shared)
+	}
+	return cl.state(req.URL.Host)
+}
+
+// clampInitial keeps initial limit of state, which has not been adjusted yet,
+// between MinLimit and MaxLimit, since they can be changed after limiter is
+// created. Mutex must be held.
+func (cl *ConcurrencyLimiter) clampInitial(state *concurrencyState) *concurrencyState {
+	if state.samples > 0 {
+		return state
+	}
+	min, max := float64(cl.MinLimit), float64(cl.MaxLimit)
+	if min < 1 {
+		min = 1
+	}
+	state.limit = initialConcurrencyLimit
+	if state.limit < min {
+		state.limit = min
+	}
+	if max >= min && state.limit > max {
+		state.limit = max
+	}
+	return state
+}
+
+// Exec is implementation of Middleware interface.
+func (cl *ConcurrencyLimiter) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		ctx = ensureContext(ctx)
+		cl.mu.Lock()
+		state := cl.requestState(req)
+		for float64(state.inflight) >= math.Floor(state.limit) {
+			released := state.released
+			cl.mu.Unlock()
+			select {
+			case <-released:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			cl.mu.Lock()
+		}
+		state.inflight++
+		inflight := state.inflight
+		cl.mu.Unlock()
+
+		start := time.Now()
+		resp, err = next.Handle(ctx, req)
+		rtt := time.Since(start)
+
+		var once sync.Once
+		release := func() {
+			once.Do(func() {
+				cl.mu.Lock()
+				state.inflight--
+				close(state.released)
+				state.released = make(chan struct{})
+				cl.mu.Unlock()
+			})
+		}
+		if err != nil {
+			release()
+			return resp, err
+		}
+		cl.mu.Lock()
+		state.update(rtt.Seconds(), inflight, float64(cl.MinLimit), float64(cl.MaxLimit))
+		cl.mu.Unlock()
+		cancelOnClose(resp, release)
+		return resp, err
+	})
+}
+
+// update adjusts limit based on latency of request that was sent while
+// inflight requests were in flight.
+func (cs *concurrencyState) update(rtt float64, inflight int, min, max float64) {
+	if rtt <= 0 {
+		rtt = 1e-9
+	}
+	cs.samples++
+	window := float64(cs.samples)
+	if window > concurrencyWindow {
+		window = concurrencyWindow
+	}
+	if cs.longRTT == 0 {
+		cs.longRTT = rtt
+	} else {
+		cs.longRTT += (rtt - cs.longRTT) / window
+	}
+
+	gradient := concurrencyTolerance * cs.longRTT / rtt
+	if gradient > 1 {
+		// Limit is not increased while there is no demand for it, otherwise
+		// it would grow indefinitely for lightly loaded client.
+		if float64(inflight) < cs.limit/2 {
+			return
+		}
+		gradient = 1
+	}
+	if gradient < 0.5 {
+		gradient = 0.5
+	}
+	limit := cs.limit*gradient + math.Sqrt(cs.limit)
+	limit = cs.limit*(1-concurrencySmoothing) + limit*concurrencySmoothing
+	if min < 1 {
+		min = 1
+	}
+	if limit < min {
+		limit = min
+	}
+	if max >= min && limit > max {
+		limit = max
+	}
+	cs.limit = limit
+}
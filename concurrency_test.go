@@ -0,0 +1,124 @@
+package cliware_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	m "go.delic.rs/cliware"
+)
+
+// createLatencyHandler returns handler that responds after delay stored in
+// provided variable, and tracks maximal number of concurrent calls.
+func createLatencyHandler(delay *int64, maxInflight *int32) m.Handler {
+	var inflight int32
+	return m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		n := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		for {
+			current := atomic.LoadInt32(maxInflight)
+			if n <= current || atomic.CompareAndSwapInt32(maxInflight, current, n) {
+				break
+			}
+		}
+		time.Sleep(time.Duration(atomic.LoadInt64(delay)))
+		return &http.Response{StatusCode: 200}, nil
+	})
+}
+
+func sendConcurrently(h m.Handler, workers, requests int) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < requests; j++ {
+				req, _ := http.NewRequest("GET", "http://localhost/", nil)
+				h.Handle(nil, req)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestAdaptiveConcurrency(t *testing.T) {
+	delay := int64(time.Millisecond)
+	var maxInflight int32
+	limiter := m.AdaptiveConcurrency()
+	h := limiter.Exec(createLatencyHandler(&delay, &maxInflight))
+
+	sendConcurrently(h, 40, 10)
+	grown := limiter.Limit()
+	if grown <= 20 {
+		t.Errorf("Limit not increased under stable latency. Got: %d", grown)
+	}
+	if int(maxInflight) > grown {
+		t.Errorf("Concurrency exceeded limit. Got: %d, limit: %d", maxInflight, grown)
+	}
+
+	atomic.StoreInt64(&delay, int64(20*time.Millisecond))
+	sendConcurrently(h, 40, 2)
+	if shrunk := limiter.Limit(); shrunk >= grown {
+		t.Errorf("Limit not decreased when latency rose. Got: %d, before: %d", shrunk, grown)
+	}
+}
+
+func TestAdaptiveConcurrencyPerHost(t *testing.T) {
+	delay := int64(time.Millisecond)
+	var maxInflight int32
+	limiter := m.AdaptiveConcurrency()
+	limiter.PerHost = true
+	limiter.MaxLimit = 25
+	h := limiter.Exec(createLatencyHandler(&delay, &maxInflight))
+	// Latency jitter on busy machine can slow growth of limit, so requests
+	// are sent until it reaches maximum, a few times at most.
+	for i := 0; i < 5 && limiter.HostLimit("localhost") < 25; i++ {
+		sendConcurrently(h, 40, 10)
+	}
+	if got := limiter.HostLimit("localhost"); got != 25 {
+		t.Errorf("Wrong host limit. Got: %d, expected: 25", got)
+	}
+	if got := limiter.HostLimit("other"); got != 20 {
+		t.Errorf("Wrong limit of unused host. Got: %d, expected: 20", got)
+	}
+}
+
+func TestAdaptiveConcurrencyInitialLimit(t *testing.T) {
+	limiter := m.AdaptiveConcurrency()
+	limiter.PerHost = true
+	limiter.MaxLimit = 5
+	if got := limiter.HostLimit("localhost"); got != 5 {
+		t.Errorf("Initial limit not capped. Got: %d, expected: 5", got)
+	}
+	limiter.MinLimit, limiter.MaxLimit = 50, 100
+	if got := limiter.Limit(); got != 50 {
+		t.Errorf("Initial limit not raised. Got: %d, expected: 50", got)
+	}
+
+	handler, called := createHandler()
+	if _, err := limiter.Exec(handler).Handle(nil, &http.Request{Method: "GET"}); err != nil || !*called {
+		t.Errorf("Request without URL not passed to handler. Error: %v", err)
+	}
+}
+
+func TestAdaptiveConcurrencyWaitCancelled(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	handler, calls := createBlockingHandler(release, nil)
+	h := m.AdaptiveConcurrency().Exec(handler)
+	// Fill all slots of initial limit.
+	for i := 0; i < 20; i++ {
+		go h.Handle(nil, m.EmptyRequest())
+	}
+	for atomic.LoadInt32(calls) < 20 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := h.Handle(ctx, m.EmptyRequest()); err != context.DeadlineExceeded {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", context.DeadlineExceeded, err)
+	}
+}
@@ -0,0 +1,52 @@
+package cliware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrPreconditionFailed is error returned by PreconditionFailed middleware
+// when server responds with 412 Precondition Failed status.
+var ErrPreconditionFailed = errors.New("cliware: precondition failed")
+
+// IfMatch returns middleware that sets If-Match header to provided version.
+// Version is quoted if it is not already a valid entity tag (quoted, weak or
+// "*").
+func IfMatch(version string) Middleware {
+	return RequestProcessor(func(req *http.Request) error {
+		req.Header.Set("If-Match", entityTag(version))
+		return nil
+	})
+}
+
+// IfNoneMatch returns middleware that sets If-None-Match header to provided
+// version. Version is quoted if it is not already a valid entity tag (quoted,
+// weak or "*").
+func IfNoneMatch(version string) Middleware {
+	return RequestProcessor(func(req *http.Request) error {
+		req.Header.Set("If-None-Match", entityTag(version))
+		return nil
+	})
+}
+
+// PreconditionFailed returns middleware that converts 412 Precondition Failed
+// response to ErrPreconditionFailed error, so callers can detect concurrent
+// modification conflicts. Response is still returned along with error, but
+// its body is drained and closed.
+func PreconditionFailed() Middleware {
+	return ResponseProcessor(func(resp *http.Response, err error) error {
+		if err == nil && resp != nil && resp.StatusCode == http.StatusPreconditionFailed {
+			return ErrPreconditionFailed
+		}
+		return nil
+	})
+}
+
+// entityTag returns version formatted as entity tag.
+func entityTag(version string) string {
+	if version == "*" || strings.HasPrefix(version, "W/") || strings.HasPrefix(version, "\"") {
+		return version
+	}
+	return "\"" + version + "\""
+}
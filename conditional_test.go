@@ -0,0 +1,52 @@
+package cliware_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestIfMatch(t *testing.T) {
+	for version, expected := range map[string]string{
+		"v1":     `"v1"`,
+		`"v1"`:   `"v1"`,
+		`W/"v1"`: `W/"v1"`,
+		"*":      "*",
+	} {
+		req := m.EmptyRequest()
+		handler, _ := createHandler()
+		m.IfMatch(version).Exec(handler).Handle(nil, req)
+		if got := req.Header.Get("If-Match"); got != expected {
+			t.Errorf("Wrong If-Match header. Got: %s, expected: %s", got, expected)
+		}
+	}
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	req := m.EmptyRequest()
+	handler, _ := createHandler()
+	m.IfNoneMatch("v1").Exec(handler).Handle(nil, req)
+	if got := req.Header.Get("If-None-Match"); got != `"v1"` {
+		t.Errorf("Wrong If-None-Match header. Got: %s, expected: \"v1\"", got)
+	}
+}
+
+func TestPreconditionFailed(t *testing.T) {
+	for code, expected := range map[int]error{
+		http.StatusOK:                 nil,
+		http.StatusPreconditionFailed: m.ErrPreconditionFailed,
+	} {
+		handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			return &http.Response{StatusCode: code}, nil
+		})
+		resp, err := m.PreconditionFailed().Exec(handler).Handle(nil, nil)
+		if err != expected {
+			t.Errorf("Wrong error for status %d. Got: %v, expected: %v", code, err, expected)
+		}
+		if resp == nil {
+			t.Error("Response not returned.")
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package cliware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ContentTypeError is error returned when content type of response is not
+// one of the types request accepts.
+type ContentTypeError struct {
+	// Accept is value of Accept header of request.
+	Accept string
+	// ContentType is value of Content-Type header of response.
+	ContentType string
+}
+
+// Error is implementation of error interface.
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("cliware: response content type %q does not match accepted types %q", e.ContentType, e.Accept)
+}
+
+// RequireContentTypeMatch returns middleware that checks if content type of
+// response satisfies Accept header of request and returns *ContentTypeError
+// if it does not. This catches servers that respond with, e.g., HTML error
+// page when JSON was requested.
+//
+// Check is performed only if request has Accept header with specific media
+// types, if Accept allows any type (*/*) nothing is checked. Responses without
+// content (204, 304 or zero Content-Length) are not checked either. Media type
+// parameters (e.g. charset) are ignored unless Accept header requires them.
+func RequireContentTypeMatch() Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			var accept string
+			if req != nil {
+				accept = strings.Join(req.Header[http.CanonicalHeaderKey("Accept")], ", ")
+			}
+			resp, err = next.Handle(ctx, req)
+			if err != nil || resp == nil || accept == "" {
+				return resp, err
+			}
+			if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified || resp.ContentLength == 0 {
+				return resp, err
+			}
+			ranges := parseAccept(accept)
+			for _, r := range ranges {
+				if r.mediaType == "*/*" {
+					return resp, err
+				}
+			}
+			contentType := resp.Header.Get("Content-Type")
+			if !acceptsContentType(ranges, contentType) {
+				return resp, &ContentTypeError{Accept: accept, ContentType: contentType}
+			}
+			return resp, err
+		})
+	})
+}
+
+// MediaPreference is media type that request accepts, with its preference.
+type MediaPreference struct {
+	// MediaType is media type or media range, optionally with parameters,
+	// e.g. "application/json", "text/*" or "text/plain; charset=utf-8".
+	MediaType string
+	// Quality is relative preference of media type, between 0 and 1, sent
+	// as q-value. Values outside of range (0, 1], including zero, mean 1,
+	// i.e. the most preferred type, since types that are not acceptable do
+	// not have to be listed.
+	Quality float64
+}
+
+// NegotiateContent returns middleware that sets Accept header of requests
+// to media types listed in prefs, with their q-values, e.g.:
+//
+//	application/json, application/xml;q=0.5, */*;q=0.1
+//
+// and checks that server honored negotiation: if response has content type
+// that does not match any of listed types, or has 406 Not Acceptable status,
+// *ContentTypeError is returned along with response. Media types are
+// compared like in RequireContentTypeMatch: ranges (e.g. text/*) match all
+// their subtypes, and parameters are compared only if preference lists
+// them. Responses without content (204, 304 or zero Content-Length) are not
+// checked. If any of media types can not be parsed, every request fails
+// with error describing it.
+func NegotiateContent(prefs []MediaPreference) Middleware {
+	values := make([]string, 0, len(prefs))
+	for _, pref := range prefs {
+		mediaType, params, err := mime.ParseMediaType(pref.MediaType)
+		if err != nil {
+			err = fmt.Errorf("cliware: invalid media type %q: %s", pref.MediaType, err)
+			return RequestProcessor(func(req *http.Request) error {
+				return err
+			})
+		}
+		delete(params, "q")
+		value := mime.FormatMediaType(mediaType, params)
+		if pref.Quality > 0 && pref.Quality < 1 {
+			value += ";q=" + strconv.FormatFloat(pref.Quality, 'f', -1, 64)
+		}
+		values = append(values, value)
+	}
+	accept := strings.Join(values, ", ")
+	ranges := parseAccept(accept)
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			if len(ranges) > 0 {
+				req.Header.Set("Accept", accept)
+			}
+			resp, err = next.Handle(ctx, req)
+			if err != nil || resp == nil || len(ranges) == 0 {
+				return resp, err
+			}
+			contentType := resp.Header.Get("Content-Type")
+			if resp.StatusCode == http.StatusNotAcceptable {
+				return resp, &ContentTypeError{Accept: accept, ContentType: contentType}
+			}
+			if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified || resp.ContentLength == 0 {
+				return resp, err
+			}
+			if !acceptsContentType(ranges, contentType) {
+				return resp, &ContentTypeError{Accept: accept, ContentType: contentType}
+			}
+			return resp, err
+		})
+	})
+}
+
+// ContentSniffer is middleware that corrects content type of responses by
+// sniffing their body. It is created using SniffContentType function.
+type ContentSniffer struct {
+	// Always makes sniffer override content type of every response with
+	// body, instead of only ones with missing or generic
+	// (application/octet-stream) content type.
+	Always bool
+}
+
+// SniffContentType returns middleware that, for responses without
+// Content-Type header or with generic application/octet-stream one, detects
+// content type from first 512 bytes of body using http.DetectContentType and
+// sets it as Content-Type header. This helps decoders that depend on content
+// type when server does not set it correctly. Sniffed bytes are put back, so
+// body can be read whole and unchanged.
+func SniffContentType() *ContentSniffer {
+	return &ContentSniffer{}
+}
+
+// Exec is implementation of Middleware interface.
+func (cs *ContentSniffer) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		resp, err = next.Handle(ctx, req)
+		if err != nil || resp == nil || resp.Body == nil {
+			return resp, err
+		}
+		if !cs.Always {
+			contentType := normalizeMediaType(resp.Header.Get("Content-Type"))
+			if contentType != "" && contentType != "application/octet-stream" {
+				return resp, err
+			}
+		}
+		prefix := make([]byte, 512)
+		n, readErr := io.ReadFull(resp.Body, prefix)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			resp.Body.Close()
+			return nil, readErr
+		}
+		prefix = prefix[:n]
+		resp.Body = &sniffedBody{Reader: io.MultiReader(bytes.NewReader(prefix), resp.Body), body: resp.Body}
+		if n > 0 {
+			if resp.Header == nil {
+				resp.Header = make(http.Header)
+			}
+			resp.Header.Set("Content-Type", http.DetectContentType(prefix))
+		}
+		return resp, nil
+	})
+}
+
+// sniffedBody reads sniffed bytes followed by rest of original body, and
+// closes original body.
+type sniffedBody struct {
+	io.Reader
+	body io.Closer
+}
+
+// Close is implementation of io.Closer interface.
+func (sb *sniffedBody) Close() error {
+	return sb.body.Close()
+}
+
+// mediaRange is single media range from Accept header.
+type mediaRange struct {
+	mediaType string
+	params    map[string]string
+	q         float64
+}
+
+// parseAccept parses value of Accept header into media ranges. Ranges that
+// can not be parsed and ranges with quality 0 (i.e. not acceptable) are
+// omitted.
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qValue, ok := params["q"]; ok {
+			delete(params, "q")
+			parsed, err := strconv.ParseFloat(qValue, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= 0 {
+			continue
+		}
+		ranges = append(ranges, mediaRange{mediaType: mediaType, params: params, q: q})
+	}
+	return ranges
+}
+
+// matches returns true if provided media type and its parameters are
+// within media range.
+func (r mediaRange) matches(mediaType string, params map[string]string) bool {
+	if r.mediaType != "*/*" && r.mediaType != mediaType {
+		prefix := strings.TrimSuffix(r.mediaType, "*")
+		if !strings.HasSuffix(r.mediaType, "/*") || !strings.HasPrefix(mediaType, prefix) {
+			return false
+		}
+	}
+	for name, value := range r.params {
+		if !strings.EqualFold(params[name], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// acceptsContentType returns true if provided content type matches at least
+// one of media ranges.
+func acceptsContentType(ranges []mediaRange, contentType string) bool {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, r := range ranges {
+		if r.matches(mediaType, params) {
+			return true
+		}
+	}
+	return false
+}
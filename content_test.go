@@ -0,0 +1,146 @@
+package cliware_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func createContentTypeHandler(contentType string) m.Handler {
+	return m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		resp = &http.Response{StatusCode: 200, Header: make(http.Header), ContentLength: -1}
+		resp.Header.Set("Content-Type", contentType)
+		return resp, nil
+	})
+}
+
+func TestRequireContentTypeMatch(t *testing.T) {
+	for _, data := range []struct {
+		accept      string
+		contentType string
+		match       bool
+	}{
+		{"", "text/html", true},
+		{"*/*", "text/html", true},
+		{"application/json", "application/json", true},
+		{"application/json", "application/json; charset=utf-8", true},
+		{"application/json", "text/html; charset=utf-8", false},
+		{"application/json", "", false},
+		{"text/html, application/json;q=0.5", "application/json", true},
+		{"application/json;q=0, text/html", "application/json", false},
+		{"application/*", "application/xml", true},
+		{"application/*", "text/plain", false},
+		{"text/plain; charset=utf-8", "text/plain; charset=latin1", false},
+		{"text/plain; charset=utf-8", "text/plain; charset=UTF-8", true},
+	} {
+		req := m.EmptyRequest()
+		if data.accept != "" {
+			req.Header.Set("Accept", data.accept)
+		}
+		_, err := m.RequireContentTypeMatch().Exec(createContentTypeHandler(data.contentType)).Handle(nil, req)
+		if data.match && err != nil {
+			t.Errorf("Accept %q, Content-Type %q: unexpected error: %s", data.accept, data.contentType, err)
+		}
+		if !data.match {
+			ctErr, ok := err.(*m.ContentTypeError)
+			if !ok {
+				t.Errorf("Accept %q, Content-Type %q: expected *ContentTypeError, got: %v", data.accept, data.contentType, err)
+				continue
+			}
+			if ctErr.Accept != data.accept || ctErr.ContentType != data.contentType {
+				t.Errorf("Wrong data in error: %s", ctErr)
+			}
+		}
+	}
+}
+
+func TestNegotiateContent(t *testing.T) {
+	prefs := []m.MediaPreference{
+		{MediaType: "application/json"},
+		{MediaType: "application/xml", Quality: 0.5},
+		{MediaType: "text/plain; charset=utf-8", Quality: 0.25},
+	}
+	expected := "application/json, application/xml;q=0.5, text/plain; charset=utf-8;q=0.25"
+	for _, data := range []struct {
+		contentType string
+		match       bool
+	}{
+		{"application/json; charset=utf-8", true},
+		{"application/xml", true},
+		{"text/plain; charset=UTF-8", true},
+		{"text/plain; charset=latin1", false},
+		{"text/html", false},
+	} {
+		req := m.EmptyRequest()
+		_, err := m.NegotiateContent(prefs).Exec(createContentTypeHandler(data.contentType)).Handle(nil, req)
+		if got := req.Header.Get("Accept"); got != expected {
+			t.Errorf("Wrong Accept header. Got: %q, expected: %q", got, expected)
+		}
+		if _, ok := err.(*m.ContentTypeError); ok == data.match || (data.match && err != nil) {
+			t.Errorf("Content-Type %q: wrong result: %v", data.contentType, err)
+		}
+	}
+
+	notAcceptable := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusNotAcceptable, Header: make(http.Header), ContentLength: 0}, nil
+	})
+	if _, err := m.NegotiateContent(prefs).Exec(notAcceptable).Handle(nil, m.EmptyRequest()); err == nil {
+		t.Error("Expected error for 406 Not Acceptable response.")
+	}
+	invalid := []m.MediaPreference{{MediaType: "application/json;"}, {MediaType: "not a type"}}
+	if _, err := m.NegotiateContent(invalid).Exec(notAcceptable).Handle(nil, m.EmptyRequest()); err == nil {
+		t.Error("Expected error for invalid media type.")
+	}
+}
+
+func TestRequireContentTypeMatchNoContent(t *testing.T) {
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		return &http.Response{StatusCode: http.StatusNoContent, Header: make(http.Header)}, nil
+	})
+	req := m.EmptyRequest()
+	req.Header.Set("Accept", "application/json")
+	_, err := m.RequireContentTypeMatch().Exec(handler).Handle(nil, req)
+	if err != nil {
+		t.Error("Handle returned error: ", err)
+	}
+}
+
+func TestSniffContentType(t *testing.T) {
+	html := "<!DOCTYPE html><html><body>" + strings.Repeat("text ", 200) + "</body></html>"
+	for _, data := range []struct {
+		contentType string
+		always      bool
+		expected    string
+	}{
+		{"", false, "text/html; charset=utf-8"},
+		{"application/octet-stream", false, "text/html; charset=utf-8"},
+		{"application/json", false, "application/json"},
+		{"application/json", true, "text/html; charset=utf-8"},
+	} {
+		sniffer := m.SniffContentType()
+		sniffer.Always = data.always
+		resp, err := sniffer.Exec(createBodyHandler(data.contentType, html)).Handle(nil, m.EmptyRequest())
+		if err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+		if got := resp.Header.Get("Content-Type"); got != data.expected {
+			t.Errorf("Wrong content type for %q (always: %t). Got: %s, expected: %s", data.contentType, data.always, got, data.expected)
+		}
+		if got := readBody(t, resp); got != html {
+			t.Errorf("Body changed by sniffing. Got %d bytes, expected %d.", len(got), len(html))
+		}
+	}
+}
+
+func TestSniffContentTypeEmptyBody(t *testing.T) {
+	resp, err := m.SniffContentType().Exec(createBodyHandler("", "")).Handle(nil, m.EmptyRequest())
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "" {
+		t.Errorf("Content type set for empty body: %s", got)
+	}
+}
@@ -0,0 +1,100 @@
+package cliware
+
+import (
+	"context"
+	"net/http"
+)
+
+// DefaultCorrelationHeaders are headers used by CorrelationContext and
+// WithCorrelation when no headers are provided.
+var DefaultCorrelationHeaders = []string{"X-Request-ID", "X-Correlation-ID", "traceparent"}
+
+type correlationKey struct{}
+
+// WithCorrelation returns copy of provided context that carries values of
+// correlation headers found in inbound request (e.g. request being handled by
+// server that makes outgoing calls). Headers missing from inbound request are
+// skipped. If headers is empty, DefaultCorrelationHeaders are used.
+func WithCorrelation(ctx context.Context, inbound *http.Request, headers []string) context.Context {
+	ctx = ensureContext(ctx)
+	if inbound == nil {
+		return ctx
+	}
+	return withCorrelationValues(ctx, inbound.Header, headers)
+}
+
+// CorrelationValue returns value of correlation header carried by context, or
+// empty string if context does not carry it.
+func CorrelationValue(ctx context.Context, header string) string {
+	return correlationValues(ctx)[http.CanonicalHeaderKey(header)]
+}
+
+// RequestID returns value of X-Request-ID correlation header carried by
+// context.
+func RequestID(ctx context.Context) string {
+	return CorrelationValue(ctx, "X-Request-ID")
+}
+
+// CorrelationID returns value of X-Correlation-ID correlation header carried
+// by context.
+func CorrelationID(ctx context.Context) string {
+	return CorrelationValue(ctx, "X-Correlation-ID")
+}
+
+// TraceParent returns value of traceparent correlation header carried by
+// context.
+func TraceParent(ctx context.Context) string {
+	return CorrelationValue(ctx, "traceparent")
+}
+
+// CorrelationContext returns middleware that propagates correlation headers.
+// Values carried by context (see WithCorrelation) are set on outbound request
+// unless it already has them, and values already present on outbound request
+// are stored in context passed to next handler, so they are available to
+// middlewares after it. Missing headers are skipped. If headers is empty,
+// DefaultCorrelationHeaders are used.
+func CorrelationContext(headers []string) Middleware {
+	if len(headers) == 0 {
+		headers = DefaultCorrelationHeaders
+	}
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			ctx = ensureContext(ctx)
+			values := correlationValues(ctx)
+			for _, header := range headers {
+				if req.Header.Get(header) != "" {
+					continue
+				}
+				if value := values[http.CanonicalHeaderKey(header)]; value != "" {
+					req.Header.Set(header, value)
+				}
+			}
+			return next.Handle(withCorrelationValues(ctx, req.Header, headers), req)
+		})
+	})
+}
+
+func withCorrelationValues(ctx context.Context, h http.Header, headers []string) context.Context {
+	if len(headers) == 0 {
+		headers = DefaultCorrelationHeaders
+	}
+	existing := correlationValues(ctx)
+	values := make(map[string]string, len(existing)+len(headers))
+	for k, v := range existing {
+		values[k] = v
+	}
+	for _, header := range headers {
+		if value := h.Get(header); value != "" {
+			values[http.CanonicalHeaderKey(header)] = value
+		}
+	}
+	return context.WithValue(ctx, correlationKey{}, values)
+}
+
+func correlationValues(ctx context.Context) map[string]string {
+	if ctx == nil {
+		return nil
+	}
+	values, _ := ctx.Value(correlationKey{}).(map[string]string)
+	return values
+}
@@ -0,0 +1,53 @@
+package cliware_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestCorrelationContext(t *testing.T) {
+	inbound := m.EmptyRequest()
+	inbound.Header.Set("X-Request-ID", "req-1")
+	inbound.Header.Set("Traceparent", "00-trace-span-01")
+	ctx := m.WithCorrelation(context.Background(), inbound, nil)
+
+	var requestID, correlationID, traceParent string
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		requestID = m.RequestID(ctx)
+		correlationID = m.CorrelationID(ctx)
+		traceParent = m.TraceParent(ctx)
+		return nil, nil
+	})
+	req := m.EmptyRequest()
+	req.Header.Set("X-Correlation-ID", "corr-1")
+	m.CorrelationContext(nil).Exec(handler).Handle(ctx, req)
+
+	if req.Header.Get("X-Request-ID") != "req-1" || req.Header.Get("traceparent") != "00-trace-span-01" {
+		t.Errorf("Correlation headers not propagated: %v", req.Header)
+	}
+	if requestID != "req-1" || correlationID != "corr-1" || traceParent != "00-trace-span-01" {
+		t.Errorf("Wrong correlation values. Got: %q, %q, %q", requestID, correlationID, traceParent)
+	}
+}
+
+func TestCorrelationContextKeepsOutboundValues(t *testing.T) {
+	inbound := m.EmptyRequest()
+	inbound.Header.Set("X-Custom-ID", "inbound")
+	ctx := m.WithCorrelation(nil, inbound, []string{"X-Custom-ID"})
+
+	req := m.EmptyRequest()
+	req.Header.Set("X-Custom-ID", "outbound")
+	m.CorrelationContext([]string{"X-Custom-ID"}).Exec(createStatusHandler(200)).Handle(ctx, req)
+	if got := req.Header.Get("X-Custom-ID"); got != "outbound" {
+		t.Errorf("Outbound value overwritten. Got: %s", got)
+	}
+	if got := m.CorrelationValue(ctx, "x-custom-id"); got != "inbound" {
+		t.Errorf("Wrong correlation value. Got: %s, expected: inbound", got)
+	}
+	if m.RequestID(nil) != "" {
+		t.Error("Nil context should not carry correlation values.")
+	}
+}
@@ -0,0 +1,80 @@
+package cliware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CurlWriter is middleware that writes curl commands equivalent to requests.
+// It is created using AsCurl function.
+type CurlWriter struct {
+	// Rules describe sensitive headers and body fields that are masked in
+	// written commands. Defaults to rules set by Redact middleware before
+	// it, or DefaultRedactRules.
+	Rules *RedactRules
+
+	mu   sync.Mutex
+	sink io.Writer
+}
+
+// AsCurl returns middleware that, for every request, writes to sink curl
+// command line that sends the same request (method, URL, headers and body),
+// followed by new line, so failing requests can be reproduced from command
+// line. All values are quoted for POSIX shells. Body is passed using
+// --data-binary, so it is sent exactly as it is, and reading it requires
+// buffering it if request does not have GetBody set. Sensitive values are
+// masked according to Rules. Errors from writing to sink are ignored.
+func AsCurl(sink io.Writer) *CurlWriter {
+	return &CurlWriter{sink: sink}
+}
+
+// Exec is implementation of Middleware interface.
+func (cw *CurlWriter) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		body, err := requestBody(req)
+		if err != nil {
+			return nil, err
+		}
+		rules := redactRulesFor(ctx, cw.Rules)
+		command := curlCommand(req, rules.Header(req.Header), rules.Body(req.Header.Get("Content-Type"), body))
+		cw.mu.Lock()
+		io.WriteString(cw.sink, command+"\n")
+		cw.mu.Unlock()
+		return next.Handle(ctx, req)
+	})
+}
+
+// curlCommand returns curl command that sends request with provided header
+// and body.
+func curlCommand(req *http.Request, header http.Header, body []byte) string {
+	var b bytes.Buffer
+	b.WriteString("curl -X " + shellQuote(req.Method) + " " + shellQuote(req.URL.String()))
+	if req.Host != "" && req.Host != req.URL.Host {
+		b.WriteString(" -H " + shellQuote("Host: "+req.Host))
+	}
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			b.WriteString(" -H " + shellQuote(name+": "+value))
+		}
+	}
+	if len(body) > 0 {
+		b.WriteString(" --data-binary " + shellQuote(string(body)))
+	}
+	return b.String()
+}
+
+// shellQuote quotes value using single quotes, so POSIX shell passes it as
+// single argument without interpreting any characters in it.
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,62 @@
+package cliware_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestAsCurl(t *testing.T) {
+	var sink bytes.Buffer
+	req, _ := http.NewRequest("POST", "http://localhost/items?q=a&b=c", strings.NewReader(`{"name":"it's"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	h := m.AsCurl(&sink).Exec(createStatusHandler(200))
+	if _, err := h.Handle(nil, req); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `curl -X 'POST' 'http://localhost/items?q=a&b=c' -H 'Authorization: [REDACTED]' ` +
+		`-H 'Content-Type: application/json' --data-binary '{"name":"it'\''s"}'` + "\n"
+	if sink.String() != expected {
+		t.Errorf("Wrong command written.\nGot:      %s\nExpected: %s", sink.String(), expected)
+	}
+	if body, _ := ioutil.ReadAll(req.Body); string(body) != `{"name":"it's"}` {
+		t.Errorf("Request body consumed. Got: %q", body)
+	}
+}
+
+func TestAsCurlRules(t *testing.T) {
+	var sink bytes.Buffer
+	curl := m.AsCurl(&sink)
+	curl.Rules = &m.RedactRules{JSONPaths: []string{"password"}}
+	req, _ := http.NewRequest("PUT", "http://localhost/", strings.NewReader(`{"password":"hunter2"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Host = "example.com"
+	curl.Exec(createStatusHandler(200)).Handle(nil, req)
+
+	expected := `curl -X 'PUT' 'http://localhost/' -H 'Host: example.com' -H 'Authorization: Bearer secret' ` +
+		`-H 'Content-Type: application/json' --data-binary '{"password":"[REDACTED]"}'` + "\n"
+	if sink.String() != expected {
+		t.Errorf("Wrong command written.\nGot:      %s\nExpected: %s", sink.String(), expected)
+	}
+}
+
+func TestAsCurlRedact(t *testing.T) {
+	var sink bytes.Buffer
+	chain := m.NewChain(m.Redact(m.RedactRules{Headers: []string{"X-Api-Key"}}), m.AsCurl(&sink))
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	req.Header.Set("X-Api-Key", "secret")
+	req.Header.Set("Authorization", "Bearer token")
+	chain.Exec(createStatusHandler(200)).Handle(nil, req)
+
+	expected := `curl -X 'GET' 'http://localhost/' -H 'Authorization: Bearer token' -H 'X-Api-Key: [REDACTED]'` + "\n"
+	if sink.String() != expected {
+		t.Errorf("Rules of Redact not used.\nGot:      %s\nExpected: %s", sink.String(), expected)
+	}
+}
@@ -0,0 +1,284 @@
+package cliware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Decompressor is middleware that decompresses response bodies. It is
+// created using Decompress.
+type Decompressor struct {
+	// MinSize is minimal Content-Length of response for it to be
+	// decompressed. Smaller responses are returned as they are, with
+	// Content-Encoding header intact, which saves setting up decompression
+	// for tiny responses. Zero means that all responses are decompressed.
+	MinSize int64
+	// SkipUnknownSize makes decompressor return responses with unknown
+	// Content-Length (e.g. chunked) as they are when MinSize is set. By
+	// default they are decompressed, since such responses are usually
+	// large streamed ones.
+	SkipUnknownSize bool
+	// DisablePool disables reusing gzip readers between responses. By
+	// default readers are taken from pool shared by all decompressors and
+	// returned to it when response body is closed, which reduces allocations
+	// under high throughput.
+	DisablePool bool
+}
+
+// gzipReaders is pool of gzip readers shared by all decompressors.
+var gzipReaders sync.Pool
+
+// errBodyClosed is returned when decompressed body is read after Close.
+var errBodyClosed = errors.New("cliware: read on closed response body")
+
+// decoders is registry of decoders added using RegisterDecoder.
+var decoders = struct {
+	sync.RWMutex
+	factories map[string]func(io.Reader) (io.ReadCloser, error)
+}{factories: make(map[string]func(io.Reader) (io.ReadCloser, error))}
+
+// RegisterDecoder registers factory of decoders for provided Content-Encoding
+// token (e.g. "br" or "zstd"), which is used by all decompressors. This
+// allows decoding encodings that standard library does not support, using
+// third-party packages, without this package depending on them:
+//
+//	m.RegisterDecoder("zstd", func(r io.Reader) (io.ReadCloser, error) {
+//		d, err := zstd.NewReader(r)
+//		if err != nil {
+//			return nil, err
+//		}
+//		return d.IOReadCloser(), nil
+//	})
+//
+// Tokens are case insensitive. Registered factory takes precedence over
+// built-in gzip and deflate decoding, and nil factory removes registration.
+// Registered encodings are also added to default Accept-Encoding header.
+func RegisterDecoder(encoding string, factory func(io.Reader) (io.ReadCloser, error)) {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	decoders.Lock()
+	defer decoders.Unlock()
+	if factory == nil {
+		delete(decoders.factories, encoding)
+		return
+	}
+	decoders.factories[encoding] = factory
+}
+
+// registeredDecoder returns factory registered for provided encoding.
+func registeredDecoder(encoding string) (func(io.Reader) (io.ReadCloser, error), bool) {
+	decoders.RLock()
+	defer decoders.RUnlock()
+	factory, ok := decoders.factories[encoding]
+	return factory, ok
+}
+
+// acceptEncoding returns default Accept-Encoding header, with built-in and
+// registered encodings.
+func acceptEncoding() string {
+	decoders.RLock()
+	defer decoders.RUnlock()
+	if len(decoders.factories) == 0 {
+		return "gzip, deflate"
+	}
+	encodings := make([]string, 0, len(decoders.factories))
+	for encoding := range decoders.factories {
+		if encoding != "gzip" && encoding != "deflate" {
+			encodings = append(encodings, encoding)
+		}
+	}
+	sort.Strings(encodings)
+	return strings.Join(append([]string{"gzip", "deflate"}, encodings...), ", ")
+}
+
+// Decompress returns middleware that decompresses gzip and deflate encoded
+// response bodies, as well as bodies with encodings registered using
+// RegisterDecoder. If request does not have Accept-Encoding header, it is set
+// to "gzip, deflate", followed by registered encodings. Decompressed
+// responses have Content-Encoding and Content-Length headers removed and
+// Uncompressed set to true.
+//
+// Responses without body (to HEAD requests, with 1xx, 204 or 304 status, or
+// with zero Content-Length) are returned as they are.
+//
+// Multiple encodings listed in Content-Encoding header (e.g. "gzip, br") are
+// decoded in reverse order, in which they were applied. Decoding stops at
+// first unknown encoding, and it and encodings applied before it are kept
+// in Content-Encoding header. Responses whose last applied encoding is
+// unknown are therefore returned as they are.
+//
+// Setting Accept-Encoding header disables transparent decompression
+// http.Transport does on its own, so this middleware is useful when
+// decompression needs to be controlled or when terminal handler does not
+// decompress responses.
+func Decompress() *Decompressor {
+	return &Decompressor{}
+}
+
+// Exec is implementation of Middleware interface.
+func (d *Decompressor) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		if req != nil && req.Header.Get("Accept-Encoding") == "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding())
+		}
+		resp, err = next.Handle(ctx, req)
+		if err != nil || resp == nil || resp.Body == nil || !hasBody(req, resp) {
+			return resp, err
+		}
+		var encodings []string
+		for _, encoding := range strings.Split(resp.Header.Get("Content-Encoding"), ",") {
+			encoding = strings.ToLower(strings.TrimSpace(encoding))
+			if encoding != "" && encoding != "identity" {
+				encodings = append(encodings, encoding)
+			}
+		}
+		if len(encodings) == 0 {
+			return resp, err
+		}
+		if d.MinSize > 0 {
+			if resp.ContentLength >= 0 && resp.ContentLength < d.MinSize {
+				return resp, err
+			}
+			if resp.ContentLength < 0 && d.SkipUnknownSize {
+				return resp, err
+			}
+		}
+
+		body := &decompressedBody{body: resp.Body}
+		var r io.Reader = resp.Body
+		for len(encodings) > 0 {
+			layer, ok, err := d.decoder(encodings[len(encodings)-1], r)
+			if err != nil {
+				body.Close()
+				return nil, err
+			}
+			if !ok {
+				break
+			}
+			body.layers = append(body.layers, layer)
+			r = layer.decoder
+			encodings = encodings[:len(encodings)-1]
+		}
+		if len(body.layers) == 0 {
+			return resp, nil
+		}
+		resp.Body = body
+		if len(encodings) > 0 {
+			resp.Header.Set("Content-Encoding", strings.Join(encodings, ", "))
+		} else {
+			resp.Header.Del("Content-Encoding")
+			resp.Uncompressed = true
+		}
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		return resp, nil
+	})
+}
+
+// hasBody reports whether response to request can have body. Responses
+// without body are not decoded, since there is nothing to decode, even if
+// they carry Content-Encoding of resource (e.g. HEAD or 304 responses).
+func hasBody(req *http.Request, resp *http.Response) bool {
+	switch {
+	case req != nil && req.Method == "HEAD":
+		return false
+	case resp.StatusCode >= 100 && resp.StatusCode < 200:
+		return false
+	case resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified:
+		return false
+	}
+	return resp.ContentLength != 0 && resp.Body != http.NoBody
+}
+
+// decoder returns decoding layer for provided encoding reading from r, or
+// false if encoding is unknown.
+func (d *Decompressor) decoder(encoding string, r io.Reader) (layer decoderLayer, ok bool, err error) {
+	if factory, ok := registeredDecoder(encoding); ok {
+		layer.decoder, err = factory(r)
+		return layer, true, err
+	}
+	switch encoding {
+	case "gzip", "x-gzip":
+		if d.DisablePool {
+			layer.decoder, err = gzip.NewReader(r)
+		} else {
+			layer.decoder, err = pooledGzipReader(r)
+			layer.pooled = err == nil
+		}
+	case "deflate":
+		layer.decoder, err = zlib.NewReader(r)
+	default:
+		return layer, false, nil
+	}
+	return layer, true, err
+}
+
+// pooledGzipReader returns gzip reader from pool reset to read from r, or
+// new one if pool is empty.
+func pooledGzipReader(r io.Reader) (io.ReadCloser, error) {
+	zr, ok := gzipReaders.Get().(*gzip.Reader)
+	if !ok {
+		return gzip.NewReader(r)
+	}
+	if err := zr.Reset(r); err != nil {
+		// Reader is reset again before next use, so it can be reused.
+		gzipReaders.Put(zr)
+		return nil, err
+	}
+	return zr, nil
+}
+
+// decoderLayer is single decoder of decompressed body.
+type decoderLayer struct {
+	decoder io.ReadCloser
+	pooled  bool
+}
+
+// decompressedBody reads from last of decoder layers, each of which reads
+// from one before it, with first one reading from original body. Close
+// closes all decoders and original body. Pooled decoders are returned to
+// pool on first Close and are not used after that.
+type decompressedBody struct {
+	body io.ReadCloser
+
+	mu     sync.Mutex
+	layers []decoderLayer
+	closed bool
+}
+
+// Read is implementation of io.Reader interface.
+func (db *decompressedBody) Read(p []byte) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return 0, errBodyClosed
+	}
+	return db.layers[len(db.layers)-1].decoder.Read(p)
+}
+
+// Close is implementation of io.Closer interface.
+func (db *decompressedBody) Close() error {
+	// Original body is closed first, so that Read blocked on it in other
+	// goroutine returns and releases decoder.
+	err := db.body.Close()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return err
+	}
+	for _, layer := range db.layers {
+		layer.decoder.Close()
+		if layer.pooled {
+			gzipReaders.Put(layer.decoder)
+		}
+	}
+	db.layers = nil
+	db.closed = true
+	return err
+}
@@ -0,0 +1,253 @@
+package cliware_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func compress(encoding, data string) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	if encoding == "gzip" {
+		w = gzip.NewWriter(&buf)
+	} else {
+		w, _ = zlib.NewWriterLevel(&buf, zlib.DefaultCompression)
+	}
+	w.Write([]byte(data))
+	w.Close()
+	return buf.Bytes()
+}
+
+func createEncodedHandler(encoding string, body []byte, contentLength int64) m.Handler {
+	return m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		resp = &http.Response{
+			StatusCode:    200,
+			Header:        make(http.Header),
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			ContentLength: contentLength,
+		}
+		resp.Header.Set("Content-Encoding", encoding)
+		return resp, nil
+	})
+}
+
+func TestDecompress(t *testing.T) {
+	for _, encoding := range []string{"gzip", "deflate"} {
+		body := compress(encoding, "some data")
+		req := m.EmptyRequest()
+		handler := createEncodedHandler(encoding, body, int64(len(body)))
+		resp, err := m.Decompress().Exec(handler).Handle(nil, req)
+		if err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+		if got := readBody(t, resp); got != "some data" {
+			t.Errorf("Wrong %s body. Got: %q, expected: \"some data\"", encoding, got)
+		}
+		if resp.Header.Get("Content-Encoding") != "" || resp.ContentLength != -1 || !resp.Uncompressed {
+			t.Errorf("Response metadata not updated after decompression: %v", resp.Header)
+		}
+		if req.Header.Get("Accept-Encoding") != "gzip, deflate" {
+			t.Errorf("Wrong Accept-Encoding. Got: %s", req.Header.Get("Accept-Encoding"))
+		}
+	}
+}
+
+func TestDecompressUnknownEncoding(t *testing.T) {
+	handler := createEncodedHandler("br", []byte("data"), 4)
+	resp, err := m.Decompress().Exec(handler).Handle(nil, m.EmptyRequest())
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if got := readBody(t, resp); got != "data" || resp.Header.Get("Content-Encoding") != "br" {
+		t.Errorf("Response with unknown encoding changed. Got body: %q", got)
+	}
+}
+
+func TestDecompressNoBody(t *testing.T) {
+	for _, data := range []struct {
+		method string
+		status int
+		length int64
+	}{
+		{"HEAD", 200, 120},
+		{"GET", 304, -1},
+		{"GET", 204, -1},
+		{"GET", 200, 0},
+	} {
+		handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+			resp := &http.Response{StatusCode: data.status, Header: make(http.Header), Body: http.NoBody, ContentLength: data.length}
+			resp.Header.Set("Content-Encoding", "gzip")
+			return resp, nil
+		})
+		req := m.EmptyRequest()
+		req.Method = data.method
+		resp, err := m.Decompress().Exec(handler).Handle(nil, req)
+		if err != nil || resp == nil {
+			t.Fatalf("Response without body failed for %s %d: %v, %v", data.method, data.status, resp, err)
+		}
+		if resp.Header.Get("Content-Encoding") != "gzip" || resp.ContentLength != data.length {
+			t.Errorf("Response without body changed for %s %d: %v", data.method, data.status, resp.Header)
+		}
+	}
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	m.RegisterDecoder("B64", func(r io.Reader) (io.ReadCloser, error) {
+		return ioutil.NopCloser(base64.NewDecoder(base64.StdEncoding, r)), nil
+	})
+	defer m.RegisterDecoder("b64", nil)
+
+	encoded := []byte(base64.StdEncoding.EncodeToString(compress("gzip", "some data")))
+	for _, data := range []struct {
+		encoding     string
+		remaining    string
+		uncompressed bool
+	}{
+		{"gzip, b64", "", true},
+		{"identity, gzip,B64", "", true},
+		{"br, gzip, b64", "br", false},
+	} {
+		req := m.EmptyRequest()
+		handler := createEncodedHandler(data.encoding, encoded, int64(len(encoded)))
+		resp, err := m.Decompress().Exec(handler).Handle(nil, req)
+		if err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+		if got := readBody(t, resp); got != "some data" {
+			t.Errorf("Wrong body for %q. Got: %q, expected: \"some data\"", data.encoding, got)
+		}
+		if encoding := resp.Header.Get("Content-Encoding"); encoding != data.remaining || resp.Uncompressed != data.uncompressed {
+			t.Errorf("Wrong encoding left for %q. Got: %q (uncompressed: %t), expected: %q", data.encoding, encoding, resp.Uncompressed, data.remaining)
+		}
+		if req.Header.Get("Accept-Encoding") != "gzip, deflate, b64" {
+			t.Errorf("Wrong Accept-Encoding. Got: %s", req.Header.Get("Accept-Encoding"))
+		}
+	}
+
+	handler := createEncodedHandler("b64, br", encoded, int64(len(encoded)))
+	resp, err := m.Decompress().Exec(handler).Handle(nil, m.EmptyRequest())
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if got := readBody(t, resp); got != string(encoded) || resp.Header.Get("Content-Encoding") != "b64, br" {
+		t.Errorf("Response with unknown last encoding changed. Got body: %q", got)
+	}
+}
+
+func TestDecompressMinSize(t *testing.T) {
+	small := compress("gzip", "x")
+	random := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(random)
+	large := compress("gzip", string(random))
+	for _, data := range []struct {
+		body        []byte
+		length      int64
+		skipUnknown bool
+		compressed  bool
+	}{
+		{small, int64(len(small)), false, true},
+		{large, int64(len(large)), false, false},
+		{large, -1, false, false},
+		{large, -1, true, true},
+	} {
+		decompressor := m.Decompress()
+		decompressor.MinSize = 100
+		decompressor.SkipUnknownSize = data.skipUnknown
+		resp, err := decompressor.Exec(createEncodedHandler("gzip", data.body, data.length)).Handle(nil, m.EmptyRequest())
+		if err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+		readBody(t, resp)
+		if compressed := resp.Header.Get("Content-Encoding") == "gzip"; compressed != data.compressed {
+			t.Errorf("Wrong decompression for length %d (skip unknown: %t). Compressed: %t, expected: %t",
+				data.length, data.skipUnknown, compressed, data.compressed)
+		}
+	}
+}
+
+func benchmarkDecompressSmall(b *testing.B, minSize int64) {
+	body := compress("gzip", `{"status": "ok"}`)
+	decompressor := m.Decompress()
+	decompressor.MinSize = minSize
+	h := decompressor.Exec(createEncodedHandler("gzip", body, int64(len(body))))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, _ := h.Handle(nil, m.EmptyRequest())
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+func BenchmarkDecompressSmall(b *testing.B) {
+	benchmarkDecompressSmall(b, 0)
+}
+
+func BenchmarkDecompressSmallSkipped(b *testing.B) {
+	benchmarkDecompressSmall(b, 1024)
+}
+
+func TestDecompressPooledReaders(t *testing.T) {
+	decompressor := m.Decompress()
+	for i := 0; i < 10; i++ {
+		expected := strings.Repeat("data", i+1)
+		body := compress("gzip", expected)
+		resp, err := decompressor.Exec(createEncodedHandler("gzip", body, int64(len(body)))).Handle(nil, m.EmptyRequest())
+		if err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+		if got := readBody(t, resp); got != expected {
+			t.Errorf("Wrong body with pooled reader. Got: %q, expected: %q", got, expected)
+		}
+		if err := resp.Body.Close(); err != nil {
+			t.Error("Second Close returned error: ", err)
+		}
+		if _, err := resp.Body.Read(make([]byte, 1)); err == nil {
+			t.Error("Read after Close did not return error.")
+		}
+	}
+}
+
+func TestDecompressInvalidGzip(t *testing.T) {
+	for _, disablePool := range []bool{false, true} {
+		decompressor := m.Decompress()
+		decompressor.DisablePool = disablePool
+		_, err := decompressor.Exec(createEncodedHandler("gzip", []byte("not gzip"), 8)).Handle(nil, m.EmptyRequest())
+		if err == nil {
+			t.Errorf("Expected error for invalid gzip body (pool disabled: %t).", disablePool)
+		}
+	}
+}
+
+func benchmarkDecompressPool(b *testing.B, disablePool bool) {
+	body := compress("gzip", strings.Repeat("some data", 1000))
+	decompressor := m.Decompress()
+	decompressor.DisablePool = disablePool
+	h := decompressor.Exec(createEncodedHandler("gzip", body, int64(len(body))))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, _ := h.Handle(nil, m.EmptyRequest())
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+func BenchmarkDecompressPooled(b *testing.B) {
+	benchmarkDecompressPool(b, false)
+}
+
+func BenchmarkDecompressNotPooled(b *testing.B) {
+	benchmarkDecompressPool(b, true)
+}
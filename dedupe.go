@@ -0,0 +1,151 @@
+package cliware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrDuplicateSuppressed is error returned by Deduper for duplicate requests
+// when it is configured to reject them.
+var ErrDuplicateSuppressed = errors.New("cliware: duplicate request suppressed")
+
+// Deduper is middleware that prevents sending duplicate requests within time
+// window. It is created using DedupeWindow function.
+type Deduper struct {
+	// Reject configures deduper to return ErrDuplicateSuppressed for duplicate
+	// requests, instead of response obtained for first request.
+	Reject bool
+
+	coalescingCounters
+	window time.Duration
+	key    func(*http.Request) string
+
+	mu      sync.Mutex
+	entries map[string]*dedupeEntry
+	sweepAt time.Time
+}
+
+// dedupeEntry is request that is in flight, or response remembered until
+// expires once request finishes.
+type dedupeEntry struct {
+	done    chan struct{}
+	expires time.Time
+	resp    *bufferedResponse
+}
+
+// DedupeWindow returns middleware that remembers successful responses for
+// provided window of time and, instead of sending request with the same key
+// again within window, returns copy of remembered response (or
+// ErrDuplicateSuppressed if Reject is set). This prevents accidental
+// duplicate submissions, like double clicks. In contrast to coalescing of
+// concurrent requests, requests sent one after another are covered too.
+// Duplicates of request that is still in flight wait for its response.
+//
+// Key of request is obtained using keyFn. If keyFn is nil, key consists of
+// method, URL and hash of request body. Failed requests are not remembered,
+// so they can be sent again immediately, and duplicates waiting for failed
+// request are sent instead. Response bodies are buffered to be able to
+// return them multiple times.
+func DedupeWindow(window time.Duration, keyFn func(*http.Request) string) *Deduper {
+	if keyFn == nil {
+		keyFn = contentKey
+	}
+	return &Deduper{
+		window:  window,
+		key:     keyFn,
+		entries: make(map[string]*dedupeEntry),
+	}
+}
+
+// Exec is implementation of Middleware interface.
+func (d *Deduper) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		key := d.key(req)
+		entry, ok := d.lookup(key)
+		for ok {
+			if d.Reject {
+				d.countCoalesced()
+				return nil, ErrDuplicateSuppressed
+			}
+			select {
+			case <-entry.done:
+			case <-ensureContext(ctx).Done():
+				return nil, ctx.Err()
+			}
+			if entry.resp != nil {
+				d.countCoalesced()
+				return entry.resp.response(), nil
+			}
+			entry, ok = d.lookup(key)
+		}
+
+		var buffered *bufferedResponse
+		defer func() {
+			d.mu.Lock()
+			if buffered != nil {
+				entry.resp = buffered
+				entry.expires = time.Now().Add(d.window)
+			} else {
+				delete(d.entries, key)
+			}
+			d.mu.Unlock()
+			close(entry.done)
+		}()
+		done := d.countSent()
+		resp, err = next.Handle(ctx, req)
+		done()
+		if err != nil || resp == nil {
+			return resp, err
+		}
+		if buffered, err = newBufferedResponse(resp); err != nil {
+			return nil, err
+		}
+		return buffered.response(), nil
+	})
+}
+
+// lookup returns entry of request with provided key and true if there is one
+// in flight or remembered. Otherwise, it registers new in-flight entry and
+// returns it and false. Expired entries are removed as they are looked up,
+// and all of them at most once per window.
+func (d *Deduper) lookup(key string) (*dedupeEntry, bool) {
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !now.Before(d.sweepAt) {
+		for k, entry := range d.entries {
+			if d.expired(entry, now) {
+				delete(d.entries, k)
+			}
+		}
+		d.sweepAt = now.Add(d.window)
+	}
+	if entry, ok := d.entries[key]; ok && !d.expired(entry, now) {
+		return entry, true
+	}
+	entry := &dedupeEntry{done: make(chan struct{})}
+	d.entries[key] = entry
+	return entry, false
+}
+
+// expired returns true if response of entry is no longer remembered at
+// provided time. Entries in flight do not expire.
+func (d *Deduper) expired(entry *dedupeEntry, now time.Time) bool {
+	return !entry.expires.IsZero() && !now.Before(entry.expires)
+}
+
+// contentKey returns key of request that consists of request method, URL and
+// SHA-256 hash of request body.
+func contentKey(req *http.Request) string {
+	if req == nil {
+		return ""
+	}
+	body, _ := requestBody(req)
+	hash := sha256.Sum256(body)
+	return req.Method + " " + req.URL.String() + " " + hex.EncodeToString(hash[:])
+}
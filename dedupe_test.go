@@ -0,0 +1,148 @@
+package cliware_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	m "go.delic.rs/cliware"
+)
+
+// createCountingHandler returns handler that responds with number of times
+// it has been called.
+func createCountingHandler() (handler m.Handler, calls *int) {
+	var count int
+	handler = m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		count++
+		return &http.Response{
+			StatusCode: 200,
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(strings.NewReader(fmt.Sprint(count))),
+		}, nil
+	})
+	return handler, &count
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	if resp == nil || resp.Body == nil {
+		t.Fatal("Response without body.")
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal("Failed to read body: ", err)
+	}
+	return string(data)
+}
+
+func TestDedupeWindow(t *testing.T) {
+	handler, calls := createCountingHandler()
+	h := m.DedupeWindow(50*time.Millisecond, nil).Exec(handler)
+
+	send := func(body string) string {
+		req, _ := http.NewRequest("POST", "http://localhost/", strings.NewReader(body))
+		resp, err := h.Handle(nil, req)
+		if err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+		return readBody(t, resp)
+	}
+	if got := send("a"); got != "1" {
+		t.Errorf("Wrong first response. Got: %s, expected: 1", got)
+	}
+	if got := send("a"); got != "1" {
+		t.Errorf("Duplicate not suppressed. Got: %s, expected: 1", got)
+	}
+	if got := send("b"); got != "2" {
+		t.Errorf("Different request suppressed. Got: %s, expected: 2", got)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if got := send("a"); got != "3" {
+		t.Errorf("Request suppressed after window. Got: %s, expected: 3", got)
+	}
+	if *calls != 3 {
+		t.Errorf("Wrong number of handler calls. Got: %d, expected: 3", *calls)
+	}
+}
+
+func TestDedupeWindowReject(t *testing.T) {
+	handler, _ := createCountingHandler()
+	deduper := m.DedupeWindow(time.Minute, func(req *http.Request) string { return "key" })
+	deduper.Reject = true
+	h := deduper.Exec(handler)
+	if _, err := h.Handle(nil, m.EmptyRequest()); err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if _, err := h.Handle(nil, m.EmptyRequest()); err != m.ErrDuplicateSuppressed {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", m.ErrDuplicateSuppressed, err)
+	}
+}
+
+func TestDedupeWindowConcurrent(t *testing.T) {
+	release := make(chan struct{})
+	handler, calls := createBlockingHandler(release, nil)
+	deduper := m.DedupeWindow(time.Minute, func(req *http.Request) string { return "key" })
+	h := deduper.Exec(handler)
+	var resps []*http.Response
+	var errs []error
+	done := make(chan struct{})
+	go func() {
+		resps, errs = runConcurrently(5, h, func(int) *http.Request { return m.EmptyRequest() })
+		close(done)
+	}()
+	waitForCall(calls)
+	close(release)
+	<-done
+	if *calls != 1 {
+		t.Errorf("Concurrent duplicates not suppressed. Got %d calls, expected: 1", *calls)
+	}
+	for i := range resps {
+		if errs[i] != nil {
+			t.Fatalf("Request %d failed: %s", i, errs[i])
+		}
+		if got := readBody(t, resps[i]); got != "created" {
+			t.Errorf("Request %d got wrong body: %q", i, got)
+		}
+	}
+
+	release = make(chan struct{})
+	handler, calls = createBlockingHandler(release, nil)
+	deduper = m.DedupeWindow(time.Minute, func(req *http.Request) string { return "key" })
+	deduper.Reject = true
+	h = deduper.Exec(handler)
+	done = make(chan struct{})
+	go func() {
+		resps, errs = runConcurrently(5, h, func(int) *http.Request { return m.EmptyRequest() })
+		close(done)
+	}()
+	waitForCall(calls)
+	close(release)
+	<-done
+	rejected := 0
+	for _, err := range errs {
+		if err == m.ErrDuplicateSuppressed {
+			rejected++
+		}
+	}
+	if *calls != 1 || rejected != 4 {
+		t.Errorf("Concurrent duplicates not rejected. Got %d calls and %d rejected, expected: 1 and 4", *calls, rejected)
+	}
+}
+
+func TestDedupeWindowStats(t *testing.T) {
+	handler, _ := createCountingHandler()
+	deduper := m.DedupeWindow(time.Minute, nil)
+	h := deduper.Exec(handler)
+	for i := 0; i < 4; i++ {
+		resp, _ := h.Handle(nil, m.EmptyRequest())
+		readBody(t, resp)
+	}
+	expected := m.CoalescingStats{Requests: 4, Coalesced: 3}
+	if stats := deduper.CoalescingStats(); stats != expected {
+		t.Errorf("Wrong stats. Got: %+v, expected: %+v", stats, expected)
+	}
+}
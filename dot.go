@@ -0,0 +1,53 @@
+package cliware
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// DOT returns Graphviz DOT representation of middlewares in chain, including
+// middlewares of parent chains, in order of execution. Each chain is drawn as
+// separate cluster and middlewares are labeled by their name (if they have
+// Name() string method) or by their index in chain otherwise.
+func (c *Chain) DOT() string {
+	// collect chains from the outermost parent, since they are executed first
+	var levels [][]Middleware
+	var current Middleware = c
+	for current != nil {
+		chain, ok := current.(*Chain)
+		if !ok {
+			levels = append([][]Middleware{{current}}, levels...)
+			break
+		}
+		levels = append([][]Middleware{chain.Middlewares()}, levels...)
+		current = chain.parent
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("digraph chain {\n\trankdir=LR;\n")
+	var nodes []string
+	for level, middlewares := range levels {
+		if len(middlewares) == 0 {
+			continue
+		}
+		fmt.Fprintf(&buf, "\tsubgraph cluster_%d {\n\t\tlabel=\"chain %d\";\n", level, level)
+		for i, m := range middlewares {
+			label := middlewareName(m)
+			if label == "" {
+				label = "#" + strconv.Itoa(i)
+			}
+			node := strconv.Quote(fmt.Sprintf("%d.%d", level, i))
+			fmt.Fprintf(&buf, "\t\t%s [label=%s];\n", node, strconv.Quote(label))
+			nodes = append(nodes, node)
+		}
+		buf.WriteString("\t}\n")
+	}
+	buf.WriteString("\t\"handler\" [shape=box];\n")
+	nodes = append(nodes, "\"handler\"")
+	for i := 1; i < len(nodes); i++ {
+		fmt.Fprintf(&buf, "\t%s -> %s;\n", nodes[i-1], nodes[i])
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
@@ -0,0 +1,51 @@
+package cliware_test
+
+import (
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+type namedMiddleware struct {
+	m.Middleware
+	name string
+}
+
+func (nm namedMiddleware) Name() string {
+	return nm.name
+}
+
+func TestDOT(t *testing.T) {
+	m1, _ := createMiddleware()
+	m2, _ := createMiddleware()
+	m3, _ := createMiddleware()
+	chain := m.NewChain(namedMiddleware{m1, "auth"}, m2).ChildChain(m3)
+
+	expected := `digraph chain {
+	rankdir=LR;
+	subgraph cluster_0 {
+		label="chain 0";
+		"0.0" [label="auth"];
+		"0.1" [label="#1"];
+	}
+	subgraph cluster_1 {
+		label="chain 1";
+		"1.0" [label="#0"];
+	}
+	"handler" [shape=box];
+	"0.0" -> "0.1";
+	"0.1" -> "1.0";
+	"1.0" -> "handler";
+}
+`
+	if got := chain.DOT(); got != expected {
+		t.Errorf("Wrong DOT output.\nGot:\n%s\nExpected:\n%s", got, expected)
+	}
+}
+
+func TestDOTEmpty(t *testing.T) {
+	expected := "digraph chain {\n\trankdir=LR;\n\t\"handler\" [shape=box];\n}\n"
+	if got := m.NewChain().DOT(); got != expected {
+		t.Errorf("Wrong DOT output.\nGot:\n%s\nExpected:\n%s", got, expected)
+	}
+}
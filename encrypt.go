@@ -0,0 +1,106 @@
+package cliware
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// EncryptionNonceHeader is name of header that carries base64 encoded nonce
+// of encrypted request or response body.
+const EncryptionNonceHeader = "X-Encryption-Nonce"
+
+// ErrDecryptionFailed is error returned by Encrypt middleware when response
+// body can not be decrypted, either because it is not encrypted, nonce is
+// invalid or authentication of encrypted body fails.
+var ErrDecryptionFailed = errors.New("cliware: response body decryption failed")
+
+// Encrypt returns middleware that encrypts request body and decrypts
+// response body using AES-GCM with provided key, which must be 16, 24 or 32
+// bytes long. It provides payload protection between cooperating client and
+// server that is independent of TLS. Random nonce is generated for each
+// request and sent base64 encoded in EncryptionNonceHeader header. Server is
+// expected to encrypt response body with the same key and own nonce, sent in
+// the same header.
+//
+// Encrypted copy of request is sent, while provided request keeps plain
+// body, so request sent again by middleware before Encrypt (e.g. Retry) is
+// encrypted only once, with new nonce. Requests without body are sent as
+// they are, while responses without body are accepted without nonce. All
+// other responses that can not be decrypted fail with ErrDecryptionFailed.
+// If key is invalid, error is returned for every request.
+func Encrypt(key []byte) Middleware {
+	var aead cipher.AEAD
+	block, keyErr := aes.NewCipher(key)
+	if keyErr == nil {
+		aead, keyErr = cipher.NewGCM(block)
+	}
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			if keyErr != nil {
+				return nil, keyErr
+			}
+			body, err := bufferRequestBody(req)
+			if err != nil {
+				return nil, err
+			}
+			if len(body) > 0 {
+				nonce := make([]byte, aead.NonceSize())
+				if _, err = rand.Read(nonce); err != nil {
+					return nil, err
+				}
+				// Encrypted copy is sent, so that request keeps plain body and
+				// is encrypted again with fresh nonce if it is sent again.
+				encrypted := new(http.Request)
+				*encrypted = *req
+				encrypted.Header = cloneHeader(req.Header)
+				setRequestBody(encrypted, aead.Seal(nil, nonce, body, nil))
+				encrypted.Header.Set(EncryptionNonceHeader, base64.StdEncoding.EncodeToString(nonce))
+				req = encrypted
+			}
+
+			resp, err = next.Handle(ctx, req)
+			if err != nil || resp == nil {
+				return resp, err
+			}
+			if err = decryptResponse(aead, resp); err != nil {
+				return nil, err
+			}
+			return resp, nil
+		})
+	})
+}
+
+// decryptResponse replaces body of response with decrypted one.
+func decryptResponse(aead cipher.AEAD, resp *http.Response) error {
+	data, err := bufferResponseBody(resp)
+	if err != nil {
+		return err
+	}
+	encodedNonce := resp.Header.Get(EncryptionNonceHeader)
+	if len(data) == 0 && encodedNonce == "" {
+		return nil
+	}
+	nonce, err := base64.StdEncoding.DecodeString(encodedNonce)
+	if err != nil || len(nonce) != aead.NonceSize() {
+		return ErrDecryptionFailed
+	}
+	plain, err := aead.Open(nil, nonce, data, nil)
+	if err != nil {
+		return ErrDecryptionFailed
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(plain))
+	resp.ContentLength = int64(len(plain))
+	resp.Header.Del(EncryptionNonceHeader)
+	if resp.Header.Get("Content-Length") != "" {
+		resp.Header.Set("Content-Length", strconv.Itoa(len(plain)))
+	}
+	return nil
+}
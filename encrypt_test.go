@@ -0,0 +1,126 @@
+package cliware_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+var encryptionKey = []byte("0123456789abcdef0123456789abcdef")
+
+// createEncryptingServer returns handler that decrypts request body and
+// responds with response created by respond from decrypted body.
+func createEncryptingServer(t *testing.T, respond func(aead cipher.AEAD, plain []byte) *http.Response) m.Handler {
+	block, _ := aes.NewCipher(encryptionKey)
+	aead, _ := cipher.NewGCM(block)
+	return m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		ciphertext, _ := ioutil.ReadAll(req.Body)
+		nonce, _ := base64.StdEncoding.DecodeString(req.Header.Get(m.EncryptionNonceHeader))
+		plain, err := aead.Open(nil, nonce, ciphertext, nil)
+		if err != nil {
+			t.Fatal("Server could not decrypt request: ", err)
+		}
+		return respond(aead, plain), nil
+	})
+}
+
+func encryptedResponse(aead cipher.AEAD, body []byte, nonce []byte) *http.Response {
+	resp := &http.Response{StatusCode: 200, Header: make(http.Header)}
+	resp.Header.Set(m.EncryptionNonceHeader, base64.StdEncoding.EncodeToString(nonce))
+	resp.Body = ioutil.NopCloser(bytes.NewReader(aead.Seal(nil, nonce, body, nil)))
+	return resp
+}
+
+func TestEncrypt(t *testing.T) {
+	handler := createEncryptingServer(t, func(aead cipher.AEAD, plain []byte) *http.Response {
+		return encryptedResponse(aead, bytes.ToUpper(plain), make([]byte, aead.NonceSize()))
+	})
+	req, _ := http.NewRequest("POST", "http://localhost/", strings.NewReader("secret payload"))
+	resp, err := m.Encrypt(encryptionKey).Exec(handler).Handle(nil, req)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if got := readBody(t, resp); got != "SECRET PAYLOAD" {
+		t.Errorf("Wrong decrypted body. Got: %q, expected: \"SECRET PAYLOAD\"", got)
+	}
+}
+
+func TestEncryptNoncesDiffer(t *testing.T) {
+	var nonces []string
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		nonces = append(nonces, req.Header.Get(m.EncryptionNonceHeader))
+		return &http.Response{StatusCode: 204, Header: make(http.Header), Body: http.NoBody}, nil
+	})
+	h := m.Encrypt(encryptionKey).Exec(handler)
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", "http://localhost/", strings.NewReader("payload"))
+		if _, err := h.Handle(nil, req); err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+	}
+	if nonces[0] == "" || nonces[0] == nonces[1] {
+		t.Errorf("Nonces are not unique: %v", nonces)
+	}
+}
+
+func TestEncryptDecryptionFailure(t *testing.T) {
+	for _, respond := range []func(aead cipher.AEAD, plain []byte) *http.Response{
+		func(aead cipher.AEAD, plain []byte) *http.Response {
+			return &http.Response{StatusCode: 200, Header: make(http.Header), Body: ioutil.NopCloser(strings.NewReader("plain"))}
+		},
+		func(aead cipher.AEAD, plain []byte) *http.Response {
+			resp := encryptedResponse(aead, plain, make([]byte, aead.NonceSize()))
+			resp.Header.Set(m.EncryptionNonceHeader, base64.StdEncoding.EncodeToString([]byte("other nonce!")))
+			return resp
+		},
+		func(aead cipher.AEAD, plain []byte) *http.Response {
+			resp := encryptedResponse(aead, plain, make([]byte, aead.NonceSize()))
+			resp.Header.Set(m.EncryptionNonceHeader, "invalid")
+			return resp
+		},
+	} {
+		req, _ := http.NewRequest("POST", "http://localhost/", strings.NewReader("payload"))
+		_, err := m.Encrypt(encryptionKey).Exec(createEncryptingServer(t, respond)).Handle(nil, req)
+		if err != m.ErrDecryptionFailed {
+			t.Errorf("Expected error: \"%s\", got: \"%v\"", m.ErrDecryptionFailed, err)
+		}
+	}
+}
+
+func TestEncryptInvalidKey(t *testing.T) {
+	handler, called := createHandler()
+	_, err := m.Encrypt([]byte("short")).Exec(handler).Handle(nil, m.EmptyRequest())
+	if err == nil {
+		t.Error("Expected error for invalid key.")
+	}
+	if *called {
+		t.Error("Request sent with invalid key.")
+	}
+}
+
+func TestEncryptRetry(t *testing.T) {
+	var bodies []string
+	server := createEncryptingServer(t, func(aead cipher.AEAD, plain []byte) *http.Response {
+		bodies = append(bodies, string(plain))
+		return &http.Response{StatusCode: 503, Header: make(http.Header), Body: http.NoBody}
+	})
+	req, _ := http.NewRequest("POST", "http://localhost/", strings.NewReader("payload"))
+	chain := m.NewChain(m.Retry(3, nil, nil), m.Encrypt(encryptionKey))
+	if _, err := chain.Exec(server).Handle(nil, req); err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if got := strings.Join(bodies, ", "); got != "payload, payload, payload" {
+		t.Errorf("Retried requests not encrypted once. Server got: %s", got)
+	}
+	if req.Header.Get(m.EncryptionNonceHeader) != "" {
+		t.Error("Nonce header set on original request.")
+	}
+}
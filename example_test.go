@@ -108,7 +108,7 @@ func Example() {
 	// Custom-Header:  whatever
 	// request data
 	// *** After sending request.
-
+	//
 	// Got response:
 	// My shiny server response
 }
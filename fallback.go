@@ -0,0 +1,69 @@
+package cliware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// StatusError is error that describes response with failure status code.
+type StatusError struct {
+	// StatusCode is status code of response.
+	StatusCode int
+}
+
+// Error is implementation of error interface.
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("cliware: response status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Degrader is middleware that produces fallback responses for failed
+// requests. It is created using Fallback function.
+type Degrader struct {
+	// Failed reports whether response or error returned by next handler is
+	// failure that fallback response should be produced for. By default
+	// errors and responses with 5xx status codes are failures.
+	Failed func(resp *http.Response, err error) bool
+
+	fallback func(ctx context.Context, req *http.Request, err error) (*http.Response, error)
+}
+
+// Fallback returns middleware that, when request fails, calls fallback to
+// produce degraded response (e.g. cached or static default) instead of
+// propagating the failure. Fallback gets error returned by next handler or,
+// for failure responses, *StatusError, and whatever it returns is returned
+// to caller. Body of failure response is closed before fallback is called.
+//
+// In contrast to retrying or failing over to other backend, request is not
+// sent again, fallback produces response on its own.
+func Fallback(fallback func(ctx context.Context, req *http.Request, err error) (*http.Response, error)) *Degrader {
+	return &Degrader{Failed: failedRequest, fallback: fallback}
+}
+
+// Exec is implementation of Middleware interface.
+func (d *Degrader) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		resp, err = next.Handle(ctx, req)
+		failed := d.Failed
+		if failed == nil {
+			failed = failedRequest
+		}
+		if !failed(resp, err) {
+			return resp, err
+		}
+		if err == nil {
+			if resp != nil {
+				err = &StatusError{StatusCode: resp.StatusCode}
+			} else {
+				err = &StatusError{}
+			}
+		}
+		drainAndClose(resp)
+		return d.fallback(ensureContext(ctx), req, err)
+	})
+}
+
+// failedRequest reports whether request failed with error or 5xx status.
+func failedRequest(resp *http.Response, err error) bool {
+	return err != nil || (resp != nil && resp.StatusCode >= 500)
+}
@@ -0,0 +1,64 @@
+package cliware_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func staticFallback(received *error) func(ctx context.Context, req *http.Request, err error) (*http.Response, error) {
+	return func(ctx context.Context, req *http.Request, err error) (*http.Response, error) {
+		*received = err
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader("default"))}, nil
+	}
+}
+
+func TestFallback(t *testing.T) {
+	downErr := errors.New("backend down")
+	down := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		return nil, downErr
+	})
+	for _, data := range []struct {
+		handler  m.Handler
+		body     string
+		expected error
+	}{
+		{down, "default", downErr},
+		{createStatusHandler(503), "default", &m.StatusError{StatusCode: 503}},
+		{createStatusHandler(404), "", nil},
+		{createStatusHandler(200), "", nil},
+	} {
+		var received error
+		resp, err := m.Fallback(staticFallback(&received)).Exec(data.handler).Handle(nil, m.EmptyRequest())
+		if err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+		if got := readBody(t, resp); got != data.body {
+			t.Errorf("Wrong body. Got: %q, expected: %q", got, data.body)
+		}
+		if (received == nil) != (data.expected == nil) || (received != nil && received.Error() != data.expected.Error()) {
+			t.Errorf("Wrong error passed to fallback. Got: %v, expected: %v", received, data.expected)
+		}
+	}
+}
+
+func TestFallbackCustomFailure(t *testing.T) {
+	var received error
+	degrader := m.Fallback(staticFallback(&received))
+	degrader.Failed = func(resp *http.Response, err error) bool {
+		return resp != nil && resp.StatusCode == 404
+	}
+	resp, _ := degrader.Exec(createStatusHandler(404)).Handle(nil, m.EmptyRequest())
+	if got := readBody(t, resp); got != "default" {
+		t.Errorf("Fallback not used for configured failure. Got: %q", got)
+	}
+	resp, _ = degrader.Exec(createStatusHandler(500)).Handle(nil, m.EmptyRequest())
+	if resp.StatusCode != 500 {
+		t.Errorf("Fallback used for response that is not configured failure. Got: %d", resp.StatusCode)
+	}
+}
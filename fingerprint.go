@@ -0,0 +1,145 @@
+package cliware
+
+import (
+	"context"
+	"hash/fnv"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// RequestFingerprint is fingerprint of outgoing request, computed by
+// Fingerprint middleware. Equivalent requests, that differ only in values of
+// headers, query and variable parts of path, have equal fingerprints.
+type RequestFingerprint struct {
+	// Method is method of request.
+	Method string
+	// Host is lower case host of request, with port if there is one.
+	Host string
+	// Path is path template of request: path with variable segments
+	// replaced with "{id}".
+	Path string
+	// Headers are sorted canonical names of request headers.
+	Headers []string
+	// Hash is 64-bit FNV-1a hash of all other fields, so fingerprints can be
+	// grouped and compared cheaply.
+	Hash uint64
+}
+
+// Fingerprint returns middleware that computes fingerprint of every request
+// and reports it to sink before passing request to next handler. Reported
+// fingerprints can be fed to monitoring that detects unusual patterns of
+// requests, e.g. previously unseen endpoints or header sets.
+//
+// Fingerprint consists of method, host, path template and names of headers,
+// while header values, query and body are ignored. Path template is made by
+// replacing path segments that look like identifiers with "{id}", so that
+// requests for different objects of same kind are grouped. Segment is
+// considered identifier if it is:
+//   - decimal number, e.g. "/users/42";
+//   - UUID, e.g. "/orders/123e4567-e89b-12d3-a456-426614174000";
+//   - hex string of at least 16 characters, e.g. commit or object hash;
+//   - token of at least 20 letters, digits, '-' and '_', with at least one
+//     digit, e.g. base64 encoded key.
+//
+// Sink is called synchronously, so it should be cheap or hand fingerprint
+// off to other goroutine.
+func Fingerprint(sink func(fp RequestFingerprint)) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			if req != nil {
+				sink(fingerprint(req))
+			}
+			return next.Handle(ctx, req)
+		})
+	})
+}
+
+// fingerprint computes fingerprint of provided request.
+func fingerprint(req *http.Request) RequestFingerprint {
+	fp := RequestFingerprint{Method: req.Method, Host: req.Host, Path: "/"}
+	if req.URL != nil {
+		if fp.Host == "" {
+			fp.Host = req.URL.Host
+		}
+		fp.Path = pathTemplate(req.URL.Path)
+	}
+	fp.Host = strings.ToLower(fp.Host)
+	fp.Headers = make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		fp.Headers = append(fp.Headers, textproto.CanonicalMIMEHeaderKey(name))
+	}
+	sort.Strings(fp.Headers)
+
+	h := fnv.New64a()
+	h.Write([]byte(fp.Method + "\n" + fp.Host + "\n" + fp.Path + "\n"))
+	for _, name := range fp.Headers {
+		h.Write([]byte(name + "\n"))
+	}
+	fp.Hash = h.Sum64()
+	return fp
+}
+
+// pathTemplate returns path with segments that look like identifiers
+// replaced with "{id}".
+func pathTemplate(path string) string {
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if isIdentifier(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// isIdentifier reports whether path segment looks like identifier, according
+// to heuristics described in Fingerprint.
+func isIdentifier(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	digits, hex, token := 0, true, true
+	for _, c := range segment {
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F':
+		case c >= 'g' && c <= 'z' || c >= 'G' && c <= 'Z' || c == '-' || c == '_':
+			hex = false
+		default:
+			hex, token = false, false
+		}
+	}
+	switch {
+	case digits == len(segment):
+		return true
+	case hex && len(segment) >= 16:
+		return true
+	case token && digits > 0 && len(segment) >= 20:
+		return true
+	}
+	return isUUID(segment)
+}
+
+// isUUID reports whether s is UUID in canonical textual form.
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		if i == 8 || i == 13 || i == 18 || i == 23 {
+			if c != '-' {
+				return false
+			}
+			continue
+		}
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
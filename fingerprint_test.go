@@ -0,0 +1,54 @@
+package cliware_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestFingerprint(t *testing.T) {
+	var fps []m.RequestFingerprint
+	handler, _ := createHandler()
+	h := m.Fingerprint(func(fp m.RequestFingerprint) {
+		fps = append(fps, fp)
+	}).Exec(handler)
+
+	urls := []string{
+		"http://API.example.com/users/42/orders/123e4567-e89b-12d3-a456-426614174000?page=1",
+		"http://api.example.com/users/7/orders/00000000-0000-0000-0000-000000000000?page=2",
+		"http://api.example.com/users/me/orders/recent",
+		"http://api.example.com/blobs/3f786850e387550fdab836ed7e6dc881de23001b",
+		"http://api.example.com/keys/Zm9vYmFyMTIzNDU2Nzg5MA",
+	}
+	for i, url := range urls {
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Authorization", "token "+url)
+		if i == 1 {
+			req.Header = http.Header{"authorization": {"other"}, "Accept": {"text/plain"}}
+		}
+		h.Handle(nil, req)
+	}
+
+	expected := m.RequestFingerprint{
+		Method:  "GET",
+		Host:    "api.example.com",
+		Path:    "/users/{id}/orders/{id}",
+		Headers: []string{"Accept", "Authorization"},
+		Hash:    fps[0].Hash,
+	}
+	if !reflect.DeepEqual(fps[0], expected) {
+		t.Errorf("Wrong fingerprint. Got: %+v, expected: %+v", fps[0], expected)
+	}
+	if !reflect.DeepEqual(fps[1], fps[0]) {
+		t.Errorf("Fingerprints of equivalent requests differ: %+v, %+v", fps[1], fps[0])
+	}
+	if fps[2].Path != "/users/me/orders/recent" || fps[2].Hash == fps[0].Hash {
+		t.Errorf("Wrong fingerprint of request without identifiers: %+v", fps[2])
+	}
+	if fps[3].Path != "/blobs/{id}" || fps[4].Path != "/keys/{id}" {
+		t.Errorf("Hash or token segments not replaced. Got: %q, %q", fps[3].Path, fps[4].Path)
+	}
+}
@@ -0,0 +1,239 @@
+package cliware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNoRegions is error returned by GeoRacer when it has no regions to send
+// request to.
+var ErrNoRegions = errors.New("cliware: no regions to send request to")
+
+// RegionHandler is handler that sends requests to single region of globally
+// distributed backend.
+type RegionHandler struct {
+	// Region is name of region.
+	Region string
+	// Handler sends requests to region.
+	Handler Handler
+}
+
+// GeoRacer is middleware that races requests to multiple regions. It is
+// created using GeoRace function.
+type GeoRacer struct {
+	// Selector orders regions from the most to the least preferred for
+	// request. If nil, regions are ordered by observed latency.
+	Selector func(req *http.Request, regions []RegionHandler) []RegionHandler
+
+	regions     []RegionHandler
+	maxParallel int
+
+	mu      sync.Mutex
+	latency map[string]time.Duration
+}
+
+// GeoRace returns middleware that concurrently sends copies of request to
+// maxParallel nearest regions (all of them if maxParallel is not positive)
+// and returns first successful response, i.e. one without error and with
+// status code below 500, cancelling requests to other regions. This reduces
+// latency of requests to globally distributed backends. Requests are sent
+// using handlers of regions, so next handler is not called and GeoRace
+// should be last middleware in chain.
+//
+// Regions are ordered using Selector or, if it is not set, by latency of
+// their successful responses observed so far (exponentially weighted moving
+// average), with regions without observations first, in provided order, so
+// they get measured. If all regions fail, response of the most preferred
+// region that returned one is returned, or error of the most preferred
+// region if none did. Request body is buffered, so it can be sent multiple
+// times. Bodies of discarded responses are drained and closed.
+//
+// Region that served request can be obtained from context prepared using
+// WithRegion.
+func GeoRace(regions []RegionHandler, maxParallel int) *GeoRacer {
+	return &GeoRacer{regions: regions, maxParallel: maxParallel, latency: make(map[string]time.Duration)}
+}
+
+// Latency returns observed latency of region and true, or false if latency
+// of region is not observed yet.
+func (gr *GeoRacer) Latency(region string) (time.Duration, bool) {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+	latency, ok := gr.latency[region]
+	return latency, ok
+}
+
+// regionResult is result of sending request to single region.
+type regionResult struct {
+	index   int
+	resp    *http.Response
+	err     error
+	latency time.Duration
+}
+
+// Exec is implementation of Middleware interface.
+func (gr *GeoRacer) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		ctx = ensureContext(ctx)
+		regions := gr.order(req)
+		if gr.maxParallel > 0 && gr.maxParallel < len(regions) {
+			regions = regions[:gr.maxParallel]
+		}
+		if len(regions) == 0 {
+			return nil, ErrNoRegions
+		}
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			if _, err = bufferRequestBody(req); err != nil {
+				return nil, err
+			}
+		}
+
+		results := make(chan regionResult, len(regions))
+		cancels := make([]context.CancelFunc, len(regions))
+		for i, region := range regions {
+			regionCtx, cancel := context.WithCancel(ctx)
+			cancels[i] = cancel
+			regionReq, err := cloneRequest(regionCtx, req)
+			if err != nil {
+				results <- regionResult{index: i, err: err}
+				continue
+			}
+			go func(i int, handler Handler) {
+				start := time.Now()
+				resp, err := handler.Handle(regionCtx, regionReq)
+				results <- regionResult{index: i, resp: resp, err: err, latency: time.Since(start)}
+			}(i, region.Handler)
+		}
+
+		failed := make([]*regionResult, len(regions))
+		for received := 1; received <= len(regions); received++ {
+			r := <-results
+			if failedRequest(r.resp, r.err) {
+				failed[r.index] = &r
+				continue
+			}
+			gr.observe(regions[r.index].Region, r.latency)
+			for i, cancel := range cancels {
+				if i != r.index {
+					cancel()
+				}
+			}
+			go discardRegionResults(results, len(regions)-received)
+			discardFailedRegions(failed, -1)
+			setRegion(ctx, regions[r.index].Region)
+			cancelOnClose(r.resp, cancels[r.index])
+			return r.resp, r.err
+		}
+
+		best := failed[0]
+		for _, r := range failed {
+			if r.resp != nil {
+				best = r
+				break
+			}
+		}
+		discardFailedRegions(failed, best.index)
+		for i, cancel := range cancels {
+			if i != best.index || best.err != nil {
+				cancel()
+			}
+		}
+		if best.err != nil {
+			return best.resp, best.err
+		}
+		setRegion(ctx, regions[best.index].Region)
+		cancelOnClose(best.resp, cancels[best.index])
+		return best.resp, nil
+	})
+}
+
+// order returns regions ordered for request.
+func (gr *GeoRacer) order(req *http.Request) []RegionHandler {
+	if gr.Selector != nil {
+		return gr.Selector(req, gr.regions)
+	}
+	regions := append([]RegionHandler(nil), gr.regions...)
+	gr.mu.Lock()
+	sort.SliceStable(regions, func(i, j int) bool {
+		return gr.latency[regions[i].Region] < gr.latency[regions[j].Region]
+	})
+	gr.mu.Unlock()
+	return regions
+}
+
+// observe adds latency of successful response to latency of region.
+func (gr *GeoRacer) observe(region string, latency time.Duration) {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+	if previous, ok := gr.latency[region]; ok {
+		latency = time.Duration(0.7*float64(previous) + 0.3*float64(latency))
+	}
+	gr.latency[region] = latency
+}
+
+// discardRegionResults receives remaining results and drains and closes
+// their responses.
+func discardRegionResults(results <-chan regionResult, remaining int) {
+	for i := 0; i < remaining; i++ {
+		r := <-results
+		drainAndClose(r.resp)
+	}
+}
+
+// discardFailedRegions drains and closes responses of failed regions, except
+// one with provided index.
+func discardFailedRegions(failed []*regionResult, keep int) {
+	for i, r := range failed {
+		if r != nil && i != keep {
+			drainAndClose(r.resp)
+		}
+	}
+}
+
+type regionKey struct{}
+
+// regionHolder is stored in context and GeoRacer records region that served
+// request into it.
+type regionHolder struct {
+	mu     sync.Mutex
+	region string
+	set    bool
+}
+
+// WithRegion returns copy of provided context prepared for recording region
+// that served request (see GeoRace). Region can be obtained using
+// RegionFromContext after request completes.
+func WithRegion(ctx context.Context) context.Context {
+	return context.WithValue(ensureContext(ctx), regionKey{}, &regionHolder{})
+}
+
+// RegionFromContext returns region that served request sent with context
+// prepared using WithRegion. If request was not served by any region, false
+// is returned.
+func RegionFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	holder, ok := ctx.Value(regionKey{}).(*regionHolder)
+	if !ok {
+		return "", false
+	}
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+	return holder.region, holder.set
+}
+
+// setRegion records region that served request into holder in context, if
+// there is one.
+func setRegion(ctx context.Context, region string) {
+	if holder, ok := ctx.Value(regionKey{}).(*regionHolder); ok {
+		holder.mu.Lock()
+		holder.region = region
+		holder.set = true
+		holder.mu.Unlock()
+	}
+}
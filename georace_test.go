@@ -0,0 +1,134 @@
+package cliware_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	m "go.delic.rs/cliware"
+)
+
+// regionRecorder records bodies of requests regions received and whether
+// their contexts were cancelled.
+type regionRecorder struct {
+	mu        sync.Mutex
+	bodies    map[string]string
+	cancelled map[string]bool
+}
+
+func newRegionRecorder() *regionRecorder {
+	return &regionRecorder{bodies: make(map[string]string), cancelled: make(map[string]bool)}
+}
+
+// region returns region handler that responds with provided status code
+// (or fails with error if code is 0) after delay.
+func (rr *regionRecorder) region(name string, delay time.Duration, code int) m.RegionHandler {
+	return m.RegionHandler{Region: name, Handler: m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		var body bytes.Buffer
+		if req.Body != nil {
+			bodyBytes := make([]byte, 64)
+			n, _ := req.Body.Read(bodyBytes)
+			body.Write(bodyBytes[:n])
+		}
+		rr.mu.Lock()
+		rr.bodies[name] = body.String()
+		rr.mu.Unlock()
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			rr.mu.Lock()
+			rr.cancelled[name] = true
+			rr.mu.Unlock()
+			return nil, ctx.Err()
+		}
+		if code == 0 {
+			return nil, errors.New(name + " unavailable")
+		}
+		resp := &http.Response{StatusCode: code, Header: make(http.Header), Body: http.NoBody}
+		resp.Header.Set("X-Region", name)
+		return resp, nil
+	})}
+}
+
+func (rr *regionRecorder) wasCancelled(name string) bool {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	return rr.cancelled[name]
+}
+
+func TestGeoRace(t *testing.T) {
+	rr := newRegionRecorder()
+	regions := []m.RegionHandler{
+		rr.region("eu", 10*time.Millisecond, 200),
+		rr.region("us", time.Second, 200),
+		rr.region("ap", 0, 200),
+	}
+	racer := m.GeoRace(regions, 2)
+	req, _ := http.NewRequest("POST", "http://localhost/", strings.NewReader("payload"))
+	ctx := m.WithRegion(context.Background())
+	resp, err := racer.Exec(nil).Handle(ctx, req)
+	if err != nil || resp.Header.Get("X-Region") != "eu" {
+		t.Fatalf("Wrong result. Got: %v, %v", resp, err)
+	}
+	if region, ok := m.RegionFromContext(ctx); !ok || region != "eu" {
+		t.Errorf("Wrong region in context. Got: %q, %t", region, ok)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if !rr.wasCancelled("us") {
+		t.Error("Losing region not cancelled.")
+	}
+	rr.mu.Lock()
+	if rr.bodies["eu"] != "payload" || rr.bodies["us"] != "payload" {
+		t.Errorf("Body not sent to all regions: %v", rr.bodies)
+	}
+	if _, ok := rr.bodies["ap"]; ok {
+		t.Error("Request sent to more than maxParallel regions.")
+	}
+	rr.mu.Unlock()
+	if _, ok := racer.Latency("eu"); !ok {
+		t.Error("Latency of winning region not observed.")
+	}
+
+	// eu is measured now, so unmeasured regions are tried first.
+	resp, _ = racer.Exec(nil).Handle(nil, m.EmptyRequest())
+	if resp.Header.Get("X-Region") != "ap" {
+		t.Errorf("Unmeasured region not preferred. Got: %s", resp.Header.Get("X-Region"))
+	}
+}
+
+func TestGeoRaceAllFail(t *testing.T) {
+	rr := newRegionRecorder()
+	regions := []m.RegionHandler{
+		rr.region("eu", 0, 0),
+		rr.region("us", 10*time.Millisecond, 503),
+	}
+	resp, err := m.GeoRace(regions, 0).Exec(nil).Handle(nil, m.EmptyRequest())
+	if err != nil || resp.StatusCode != 503 {
+		t.Errorf("Response of failed region not returned. Got: %v, %v", resp, err)
+	}
+
+	regions = []m.RegionHandler{rr.region("eu", 0, 0), rr.region("us", 10*time.Millisecond, 0)}
+	if _, err = m.GeoRace(regions, 0).Exec(nil).Handle(nil, m.EmptyRequest()); err == nil || err.Error() != "eu unavailable" {
+		t.Errorf("Error of the most preferred region not returned. Got: %v", err)
+	}
+}
+
+func TestGeoRaceSelector(t *testing.T) {
+	rr := newRegionRecorder()
+	racer := m.GeoRace([]m.RegionHandler{rr.region("eu", 0, 200), rr.region("us", 0, 200)}, 1)
+	racer.Selector = func(req *http.Request, regions []m.RegionHandler) []m.RegionHandler {
+		return []m.RegionHandler{regions[1], regions[0]}
+	}
+	resp, _ := racer.Exec(nil).Handle(nil, m.EmptyRequest())
+	if resp.Header.Get("X-Region") != "us" {
+		t.Errorf("Selector not used. Got region: %s", resp.Header.Get("X-Region"))
+	}
+	if _, err := m.GeoRace(nil, 1).Exec(nil).Handle(nil, m.EmptyRequest()); err != m.ErrNoRegions {
+		t.Errorf("Expected error: \"%s\", got: %v", m.ErrNoRegions, err)
+	}
+}
@@ -0,0 +1,79 @@
+package cliware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Priority returns middleware that sets Priority header, as defined by
+// RFC 9218, to signal urgency of request (0 being the highest and 7 the
+// lowest priority) and whether response can be processed incrementally.
+// If urgency is out of range, every request fails with error describing it.
+func Priority(urgency int, incremental bool) Middleware {
+	if urgency < 0 || urgency > 7 {
+		err := fmt.Errorf("cliware: priority urgency must be in range 0-7, got %d", urgency)
+		return RequestProcessor(func(req *http.Request) error {
+			return err
+		})
+	}
+	value := "u=" + strconv.Itoa(urgency)
+	if incremental {
+		value += ", i"
+	}
+	return RequestProcessor(func(req *http.Request) error {
+		req.Header.Set("Priority", value)
+		return nil
+	})
+}
+
+// SetHeader returns middleware that sets header with provided key to value,
+// replacing any values it already has. Request without headers (e.g. one
+// constructed manually) gets them initialized.
+func SetHeader(key, value string) Middleware {
+	return RequestProcessor(func(req *http.Request) error {
+		if req.Header == nil {
+			req.Header = make(http.Header)
+		}
+		req.Header.Set(key, value)
+		return nil
+	})
+}
+
+// SetHeaders returns middleware that sets all provided headers, as
+// SetHeader does. Headers are copied, so changing map afterwards does not
+// affect middleware.
+func SetHeaders(headers map[string]string) Middleware {
+	headers = copyHeaderMap(headers)
+	return RequestProcessor(func(req *http.Request) error {
+		if req.Header == nil {
+			req.Header = make(http.Header)
+		}
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		return nil
+	})
+}
+
+// AddHeader returns middleware that adds value to header with provided key,
+// keeping values it already has. Request without headers gets them
+// initialized.
+func AddHeader(key, value string) Middleware {
+	return RequestProcessor(func(req *http.Request) error {
+		if req.Header == nil {
+			req.Header = make(http.Header)
+		}
+		req.Header.Add(key, value)
+		return nil
+	})
+}
+
+// copyHeaderMap returns copy of headers.
+func copyHeaderMap(headers map[string]string) map[string]string {
+	copied := make(map[string]string, len(headers))
+	for key, value := range headers {
+		copied[key] = value
+	}
+	return copied
+}
@@ -0,0 +1,89 @@
+package cliware_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestPriority(t *testing.T) {
+	for _, data := range []struct {
+		urgency     int
+		incremental bool
+		expected    string
+	}{
+		{0, false, "u=0"},
+		{3, true, "u=3, i"},
+		{7, false, "u=7"},
+	} {
+		req := m.EmptyRequest()
+		handler, _ := createHandler()
+		if _, err := m.Priority(data.urgency, data.incremental).Exec(handler).Handle(nil, req); err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+		if got := req.Header.Get("Priority"); got != data.expected {
+			t.Errorf("Wrong Priority header. Got: %s, expected: %s", got, data.expected)
+		}
+	}
+}
+
+func TestPriorityInvalidUrgency(t *testing.T) {
+	for _, urgency := range []int{-1, 8} {
+		handler, handlerCalled := createHandler()
+		if _, err := m.Priority(urgency, false).Exec(handler).Handle(nil, m.EmptyRequest()); err == nil {
+			t.Errorf("Expected error for urgency %d.", urgency)
+		}
+		if *handlerCalled {
+			t.Error("Handler called with invalid urgency.")
+		}
+	}
+}
+
+func TestSetHeader(t *testing.T) {
+	headers := map[string]string{"X-Client": "cliware", "Accept": "application/json"}
+	chain := m.NewChain(
+		m.SetHeader("X-Request-Source", "test"),
+		m.SetHeaders(headers),
+		m.AddHeader("X-Tag", "first"),
+		m.AddHeader("X-Tag", "second"),
+	)
+	headers["X-Client"] = "changed"
+	req := m.EmptyRequest()
+	req.Header.Set("X-Request-Source", "old")
+	handler, _ := createHandler()
+	if _, err := chain.Exec(handler).Handle(nil, req); err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	for _, data := range []struct {
+		key      string
+		expected string
+	}{
+		{"X-Request-Source", "test"},
+		{"X-Client", "cliware"},
+		{"Accept", "application/json"},
+		{"X-Tag", "first, second"},
+	} {
+		if got := strings.Join(req.Header[data.key], ", "); got != data.expected {
+			t.Errorf("Wrong %s header. Got: %s, expected: %s", data.key, got, data.expected)
+		}
+	}
+}
+
+func TestSetHeaderNilHeader(t *testing.T) {
+	for _, mw := range []m.Middleware{
+		m.SetHeader("X-Test", "value"),
+		m.SetHeaders(map[string]string{"X-Test": "value"}),
+		m.AddHeader("X-Test", "value"),
+	} {
+		req := &http.Request{Method: "GET"}
+		handler, _ := createHandler()
+		if _, err := mw.Exec(handler).Handle(nil, req); err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+		if got := req.Header.Get("X-Test"); got != "value" {
+			t.Errorf("Header not set on request without headers. Got: %q", got)
+		}
+	}
+}
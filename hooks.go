@@ -0,0 +1,86 @@
+package cliware
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+)
+
+// OnError returns middleware that calls fn whenever next handler returns
+// non-nil error. Error and response are returned unchanged, so it is meant for
+// observing errors (e.g. reporting them to an error tracker) rather than
+// handling them. If placed first in chain, fn is called for errors returned by
+// any middleware in chain, as well as by final handler.
+func OnError(fn func(ctx context.Context, req *http.Request, err error)) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			resp, err = next.Handle(ctx, req)
+			if err != nil && fn != nil {
+				fn(ctx, req, err)
+			}
+			return resp, err
+		})
+	})
+}
+
+// PolicyCheck returns middleware that evaluates policy before request is
+// sent. If policy returns error, request is denied: error is returned and
+// next handler is not called. Policy gets context of request, so it can
+// check access based on user, role or scope information carried by it.
+func PolicyCheck(policy func(ctx context.Context, req *http.Request) error) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			if err = policy(ensureContext(ctx), req); err != nil {
+				return nil, err
+			}
+			return next.Handle(ctx, req)
+		})
+	})
+}
+
+// OnStatus returns middleware that calls fn for responses with provided
+// status code, e.g. for special logging or metrics. Fn can inspect response
+// but not replace it. If fn returns error, it is returned along with
+// response, like with ResponseProcessor. Fn is not called when next handler
+// returns error or no response. Any number of OnStatus middlewares for
+// different codes can be used in the same chain.
+func OnStatus(code int, fn func(ctx context.Context, resp *http.Response) error) Middleware {
+	return OnStatusRange(code, code, fn)
+}
+
+// OnStatusRange returns middleware that calls fn for responses with status
+// code between min and max, inclusive (e.g. 500 and 599 for all server
+// errors). It behaves like OnStatus otherwise.
+func OnStatusRange(min, max int, fn func(ctx context.Context, resp *http.Response) error) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			resp, err = next.Handle(ctx, req)
+			if err != nil || resp == nil || resp.StatusCode < min || resp.StatusCode > max {
+				return resp, err
+			}
+			return resp, fn(ensureContext(ctx), resp)
+		})
+	})
+}
+
+// Finally returns middleware that calls fn once next handler is done, with
+// response and error it returned, for cleanup that must always run, e.g.
+// finishing span, releasing token or logging final status. Fn is called in
+// deferred call, so it is called even if next handler panics, in which case
+// it gets *PanicError describing panic as error, and panic continues after
+// fn returns. Fn can not change response or error, which are returned
+// unchanged. If placed first in chain, fn is called for whole chain.
+func Finally(fn func(ctx context.Context, req *http.Request, resp *http.Response, err error)) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			defer func() {
+				if value := recover(); value != nil {
+					fn(ensureContext(ctx), req, nil, &PanicError{Value: value, Stack: debug.Stack()})
+					panic(value)
+				}
+				fn(ensureContext(ctx), req, resp, err)
+			}()
+			return next.Handle(ctx, req)
+		})
+	})
+}
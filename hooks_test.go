@@ -0,0 +1,165 @@
+package cliware_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestOnError(t *testing.T) {
+	middlewareErr := errors.New("middleware error")
+	handlerErr := errors.New("handler error")
+	failing := m.RequestProcessor(func(req *http.Request) error {
+		if req.Header.Get("Fail") != "" {
+			return middlewareErr
+		}
+		return nil
+	})
+	for _, data := range []struct {
+		fail     bool
+		handler  m.Handler
+		expected error
+	}{
+		{false, createStatusHandler(200), nil},
+		{true, createStatusHandler(200), middlewareErr},
+		{false, m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+			return nil, handlerErr
+		}), handlerErr},
+	} {
+		var reported error
+		var reportedReq *http.Request
+		chain := m.NewChain(m.OnError(func(ctx context.Context, req *http.Request, err error) {
+			reported = err
+			reportedReq = req
+		}), failing)
+		req := m.EmptyRequest()
+		if data.fail {
+			req.Header.Set("Fail", "yes")
+		}
+		_, err := chain.Exec(data.handler).Handle(nil, req)
+		if !errors.Is(err, data.expected) || (err == nil) != (data.expected == nil) {
+			t.Errorf("Error changed. Got: %v, expected: %v", err, data.expected)
+		}
+		if !errors.Is(reported, data.expected) || (reported == nil) != (data.expected == nil) {
+			t.Errorf("Wrong reported error. Got: %v, expected: %v", reported, data.expected)
+		}
+		if data.expected != nil && reportedReq != req {
+			t.Error("Callback did not receive request.")
+		}
+	}
+}
+
+type roleKey struct{}
+
+func TestPolicyCheck(t *testing.T) {
+	errDenied := errors.New("denied")
+	policy := m.PolicyCheck(func(ctx context.Context, req *http.Request) error {
+		if req.Method != "GET" && ctx.Value(roleKey{}) != "admin" {
+			return errDenied
+		}
+		return nil
+	})
+	for _, data := range []struct {
+		method   string
+		role     string
+		expected error
+	}{
+		{"GET", "", nil},
+		{"DELETE", "user", errDenied},
+		{"DELETE", "admin", nil},
+	} {
+		handler, called := createHandler()
+		req := m.EmptyRequest()
+		req.Method = data.method
+		ctx := context.WithValue(context.Background(), roleKey{}, data.role)
+		_, err := policy.Exec(handler).Handle(ctx, req)
+		if err != data.expected {
+			t.Errorf("Wrong error for %s by %q. Got: %v, expected: %v", data.method, data.role, err, data.expected)
+		}
+		if *called != (data.expected == nil) {
+			t.Errorf("Wrong handler call for %s by %q. Called: %t", data.method, data.role, *called)
+		}
+	}
+}
+
+func TestOnStatus(t *testing.T) {
+	var calls []string
+	record := func(name string) func(ctx context.Context, resp *http.Response) error {
+		return func(ctx context.Context, resp *http.Response) error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+	chain := m.NewChain(
+		m.OnStatus(404, record("not found")),
+		m.OnStatusRange(500, 599, record("server error")),
+		m.OnStatusRange(400, 599, record("error")),
+	)
+	for _, code := range []int{200, 404, 503} {
+		chain.Exec(createStatusHandler(code)).Handle(nil, m.EmptyRequest())
+	}
+	// Responses are processed by last middleware in chain first.
+	expected := "error, not found, error, server error"
+	if got := strings.Join(calls, ", "); got != expected {
+		t.Errorf("Wrong status handlers called. Got: %s, expected: %s", got, expected)
+	}
+}
+
+func TestOnStatusError(t *testing.T) {
+	expected := errors.New("status handler error")
+	mw := m.OnStatus(500, func(ctx context.Context, resp *http.Response) error {
+		return expected
+	})
+	resp, err := mw.Exec(createStatusHandler(500)).Handle(nil, m.EmptyRequest())
+	if err != expected || resp == nil {
+		t.Errorf("Wrong result. Got response: %v, error: %v", resp, err)
+	}
+	failing := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		return nil, errors.New("failed")
+	})
+	if _, err := mw.Exec(failing).Handle(nil, m.EmptyRequest()); err == expected {
+		t.Error("Status handler called for failed request.")
+	}
+}
+
+func TestFinally(t *testing.T) {
+	var gotResp *http.Response
+	var gotErr error
+	calls := 0
+	finally := m.Finally(func(ctx context.Context, req *http.Request, resp *http.Response, err error) {
+		calls++
+		gotResp, gotErr = resp, err
+	})
+
+	expected := errors.New("downstream error")
+	failing := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		return nil, expected
+	})
+	if _, err := finally.Exec(failing).Handle(nil, m.EmptyRequest()); err != expected || gotErr != expected {
+		t.Errorf("Expected error: \"%s\", got: \"%v\", fn got: \"%v\"", expected, err, gotErr)
+	}
+
+	resp, _ := finally.Exec(createStatusHandler(204)).Handle(nil, m.EmptyRequest())
+	if gotResp != resp || gotErr != nil {
+		t.Errorf("Fn did not get response. Got: %v, \"%v\"", gotResp, gotErr)
+	}
+
+	func() {
+		defer func() {
+			if value := recover(); value != "boom" {
+				t.Errorf("Panic not propagated. Got: %v", value)
+			}
+		}()
+		finally.Exec(createPanickingHandler("boom")).Handle(nil, m.EmptyRequest())
+	}()
+	if panicErr, ok := gotErr.(*m.PanicError); !ok || panicErr.Value != "boom" || gotResp != nil {
+		t.Errorf("Fn did not get panic error. Got: %v, \"%v\"", gotResp, gotErr)
+	}
+	if calls != 3 {
+		t.Errorf("Wrong number of calls. Got: %d, expected: 3", calls)
+	}
+}
@@ -0,0 +1,136 @@
+package cliware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// NormalizeJSON returns middleware that decodes JSON object from response
+// body, transforms it using provided function and replaces body with encoded
+// result. It is useful for APIs that return inconsistent shapes of the same
+// resource (e.g. renamed fields or missing defaults), so that decoders after
+// it see canonical shape. Only responses with application/json or +json
+// content type are transformed. Bodies that are empty or do not contain JSON
+// object (arrays, scalars) are left unchanged. Numbers are decoded as
+// json.Number, so they are not altered by round trip.
+func NormalizeJSON(transform func(map[string]interface{}) map[string]interface{}) Middleware {
+	return ResponseProcessor(func(resp *http.Response, err error) error {
+		if err != nil || resp == nil || transform == nil || !isJSON(resp.Header.Get("Content-Type")) {
+			return nil
+		}
+		data, err := bufferResponseBody(resp)
+		if err != nil {
+			return err
+		}
+		trimmed := bytes.TrimSpace(data)
+		if len(trimmed) == 0 || trimmed[0] != '{' {
+			return nil
+		}
+		var object map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		decoder.UseNumber()
+		if err := decoder.Decode(&object); err != nil {
+			return err
+		}
+		data, err = json.Marshal(transform(object))
+		if err != nil {
+			return err
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+		resp.ContentLength = int64(len(data))
+		if resp.Header.Get("Content-Length") != "" {
+			resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+		}
+		return nil
+	})
+}
+
+// MissingFieldsError is error returned by RequireJSONFields middleware when
+// required fields are missing from JSON response.
+type MissingFieldsError struct {
+	// Fields are paths of missing fields, in order in which they were
+	// required.
+	Fields []string
+}
+
+// Error is implementation of error interface.
+func (e *MissingFieldsError) Error() string {
+	return "cliware: missing JSON fields: " + strings.Join(e.Fields, ", ")
+}
+
+// RequireJSONFields returns middleware that decodes JSON response body and
+// checks that fields at provided paths exist and are not null, which is
+// lightweight check against incomplete responses that does not require full
+// schema. If some fields are missing, *MissingFieldsError listing them is
+// returned along with response. Paths are dot separated names of nested
+// fields (e.g. "user.address.city"), and segments that are numbers, or are
+// written in brackets, index arrays (e.g. "items.0.id" or "items[0].id").
+//
+// Only responses with application/json or +json content type are checked.
+// Body is buffered, so it can still be read by caller, and body that is not
+// valid JSON results in decoding error.
+func RequireJSONFields(paths ...string) Middleware {
+	split := make([][]string, len(paths))
+	for i, path := range paths {
+		path = strings.Replace(strings.Replace(path, "[", ".", -1), "]", "", -1)
+		split[i] = strings.Split(path, ".")
+	}
+	return ResponseProcessor(func(resp *http.Response, err error) error {
+		if err != nil || resp == nil || !isJSON(resp.Header.Get("Content-Type")) {
+			return nil
+		}
+		data, err := bufferResponseBody(resp)
+		if err != nil {
+			return err
+		}
+		var value interface{}
+		if len(bytes.TrimSpace(data)) > 0 {
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.UseNumber()
+			if err := decoder.Decode(&value); err != nil {
+				return KeepBody(err)
+			}
+		}
+		var missing []string
+		for i, path := range split {
+			if lookupJSON(value, path) == nil {
+				missing = append(missing, paths[i])
+			}
+		}
+		if len(missing) > 0 {
+			return KeepBody(&MissingFieldsError{Fields: missing})
+		}
+		return nil
+	})
+}
+
+// lookupJSON returns value at path in decoded JSON value, or nil if there is
+// none.
+func lookupJSON(value interface{}, path []string) interface{} {
+	for _, segment := range path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[segment]
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil
+			}
+			value = v[index]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+// isJSON reports whether content type is application/json or has +json
+// structured syntax suffix.
+func isJSON(contentType string) bool {
+	mediaType := normalizeMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
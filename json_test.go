@@ -0,0 +1,82 @@
+package cliware_test
+
+import (
+	"strings"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestNormalizeJSON(t *testing.T) {
+	rename := func(object map[string]interface{}) map[string]interface{} {
+		if v, ok := object["user_name"]; ok {
+			object["username"] = v
+			delete(object, "user_name")
+		}
+		if _, ok := object["active"]; !ok {
+			object["active"] = true
+		}
+		return object
+	}
+	for _, data := range []struct {
+		contentType string
+		body        string
+		expected    string
+	}{
+		{"application/json", `{"user_name": "john", "id": 12345678901234567890}`, `{"active":true,"id":12345678901234567890,"username":"john"}`},
+		{"application/problem+json; charset=utf-8", `{"active": false}`, `{"active":false}`},
+		{"application/json", `[{"user_name": "john"}]`, `[{"user_name": "john"}]`},
+		{"application/json", `"scalar"`, `"scalar"`},
+		{"application/json", ``, ``},
+		{"text/plain", `{"user_name": "john"}`, `{"user_name": "john"}`},
+	} {
+		resp, err := m.NormalizeJSON(rename).Exec(createBodyHandler(data.contentType, data.body)).Handle(nil, m.EmptyRequest())
+		if err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+		if got := readBody(t, resp); got != data.expected {
+			t.Errorf("Wrong body for %q. Got: %s, expected: %s", data.body, got, data.expected)
+		}
+	}
+}
+
+func TestNormalizeJSONInvalid(t *testing.T) {
+	identity := func(object map[string]interface{}) map[string]interface{} { return object }
+	_, err := m.NormalizeJSON(identity).Exec(createBodyHandler("application/json", `{"broken`)).Handle(nil, m.EmptyRequest())
+	if err == nil {
+		t.Error("Expected error for invalid JSON object.")
+	}
+}
+
+func TestRequireJSONFields(t *testing.T) {
+	body := `{"id": 1, "user": {"name": "john", "email": null}, "items": [{"id": "a"}]}`
+	require := m.RequireJSONFields("id", "user.name", "user.email", "items[0].id", "items.1.id", "missing.field")
+	resp, err := require.Exec(createBodyHandler("application/json", body)).Handle(nil, m.EmptyRequest())
+	missingErr, ok := err.(*m.MissingFieldsError)
+	if !ok {
+		t.Fatalf("Expected missing fields error, got: %v", err)
+	}
+	if got := strings.Join(missingErr.Fields, ", "); got != "user.email, items.1.id, missing.field" {
+		t.Errorf("Wrong missing fields. Got: %s", got)
+	}
+	if got := readBody(t, resp); got != body {
+		t.Errorf("Body not available after check. Got: %s", got)
+	}
+
+	for _, data := range []struct {
+		contentType string
+		body        string
+		fails       bool
+	}{
+		{"application/json", `{"id": 1, "user": {"name": "john"}}`, false},
+		{"application/vnd.api+json", `{"id": 1}`, true},
+		{"application/json", ``, true},
+		{"application/json", `{"broken`, true},
+		{"text/plain", `{}`, false},
+	} {
+		_, err := m.RequireJSONFields("id", "user.name").Exec(createBodyHandler(data.contentType, data.body)).Handle(nil, m.EmptyRequest())
+		if (err != nil) != data.fails {
+			t.Errorf("Wrong result for %s %q. Got error: %v", data.contentType, data.body, err)
+		}
+	}
+}
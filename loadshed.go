@@ -0,0 +1,140 @@
+package cliware
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrLoadShed is error returned by LoadShedder for requests it rejects.
+var ErrLoadShed = errors.New("cliware: shedding load")
+
+// minShedSamples is number of latency samples LoadShedder needs before it
+// starts shedding requests.
+const minShedSamples = 10
+
+// latencyTracker tracks exponentially weighted moving average of latency.
+// It is safe for concurrent use.
+type latencyTracker struct {
+	mu      sync.Mutex
+	average time.Duration
+	samples int
+}
+
+// observe adds latency to average, with weight of 0.2.
+func (lt *latencyTracker) observe(latency time.Duration) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if lt.samples == 0 {
+		lt.average = latency
+	} else {
+		lt.average = time.Duration(0.8*float64(lt.average) + 0.2*float64(latency))
+	}
+	lt.samples++
+}
+
+// get returns average latency and number of samples it is based on.
+func (lt *latencyTracker) get() (time.Duration, int) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	return lt.average, lt.samples
+}
+
+// LoadShedder is middleware that rejects requests when backend appears
+// overloaded. It is created using LoadShed function.
+type LoadShedder struct {
+	// MaxShedRate is highest fraction of requests that is rejected, even
+	// when latency exceeds budget. Requests that pass keep measuring
+	// latency, so shedding stops once backend recovers. Defaults to 0.9.
+	MaxShedRate float64
+
+	budget  time.Duration
+	latency latencyTracker
+
+	mu     sync.Mutex
+	random *rand.Rand
+}
+
+// LoadShed returns middleware that proactively rejects part of requests
+// with ErrLoadShed, without sending them, when recent latency of requests
+// approaches maxLatencyBudget. This protects overloaded backend from
+// collapse, since rising latency usually means that its queues are growing.
+//
+// Latency of every completed request is added to exponentially weighted
+// moving average (new latency has weight of 0.2). Once at least 10
+// requests completed, requests are rejected at random with probability
+// (shed rate) that grows linearly from 0, when average latency is at half of
+// budget, to MaxShedRate, when it reaches budget:
+//
+//	rate = MaxShedRate * (average - budget/2) / (budget/2)
+//
+// Unlike circuit breaker, which stops all requests after failures and
+// probes backend before resuming, load shedder reacts to latency before
+// requests start failing, and rejects only a fraction of requests, which
+// grows and shrinks gradually with load. Current shed rate and decision can
+// be obtained using ShedRate and Shedding.
+func LoadShed(maxLatencyBudget time.Duration) *LoadShedder {
+	return &LoadShedder{
+		MaxShedRate: 0.9,
+		budget:      maxLatencyBudget,
+		random:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Source sets source of random values used for deciding which requests are
+// rejected. Fixed source makes decisions reproducible.
+func (ls *LoadShedder) Source(src rand.Source) *LoadShedder {
+	ls.mu.Lock()
+	ls.random = rand.New(src)
+	ls.mu.Unlock()
+	return ls
+}
+
+// Latency returns current average latency of requests.
+func (ls *LoadShedder) Latency() time.Duration {
+	average, _ := ls.latency.get()
+	return average
+}
+
+// ShedRate returns fraction of requests that is currently rejected.
+func (ls *LoadShedder) ShedRate() float64 {
+	average, samples := ls.latency.get()
+	if samples < minShedSamples || ls.budget <= 0 {
+		return 0
+	}
+	half := ls.budget / 2
+	if average <= half {
+		return 0
+	}
+	rate := float64(average-half) / float64(half)
+	if rate > 1 {
+		rate = 1
+	}
+	return rate * ls.MaxShedRate
+}
+
+// Shedding returns true if load shedder currently rejects requests.
+func (ls *LoadShedder) Shedding() bool {
+	return ls.ShedRate() > 0
+}
+
+// Exec is implementation of Middleware interface.
+func (ls *LoadShedder) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		if rate := ls.ShedRate(); rate > 0 {
+			ls.mu.Lock()
+			shed := ls.random.Float64() < rate
+			ls.mu.Unlock()
+			if shed {
+				return nil, ErrLoadShed
+			}
+		}
+		start := time.Now()
+		resp, err = next.Handle(ctx, req)
+		ls.latency.observe(time.Since(start))
+		return resp, err
+	})
+}
@@ -0,0 +1,65 @@
+package cliware_test
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestLoadShed(t *testing.T) {
+	delay := int64(time.Millisecond)
+	var maxInflight int32
+	shedder := m.LoadShed(20 * time.Millisecond).Source(rand.NewSource(1))
+	h := shedder.Exec(createLatencyHandler(&delay, &maxInflight))
+	send := func(n int) (shed int) {
+		for i := 0; i < n; i++ {
+			if _, err := h.Handle(nil, m.EmptyRequest()); err == m.ErrLoadShed {
+				shed++
+			}
+		}
+		return shed
+	}
+
+	if shed := send(20); shed != 0 || shedder.Shedding() {
+		t.Errorf("Requests shed under low latency: %d (rate: %g)", shed, shedder.ShedRate())
+	}
+
+	atomic.StoreInt64(&delay, int64(25*time.Millisecond))
+	send(15)
+	if rate := shedder.ShedRate(); rate < 0.5 || rate > 0.9 || !shedder.Shedding() {
+		t.Errorf("Wrong shed rate when latency exceeds budget. Got: %g, latency: %s", rate, shedder.Latency())
+	}
+	if shed := send(50); shed < 25 || shed == 50 {
+		t.Errorf("Wrong number of shed requests. Got: %d of 50", shed)
+	}
+
+	// Requests that pass measure latency, so shedding stops once it drops.
+	atomic.StoreInt64(&delay, 0)
+	send(200)
+	if shedder.Shedding() {
+		t.Errorf("Shedding did not stop after latency dropped. Latency: %s", shedder.Latency())
+	}
+}
+
+func TestLoadShedBeforeSamples(t *testing.T) {
+	slow := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		time.Sleep(5 * time.Millisecond)
+		return nil, nil
+	})
+	shedder := m.LoadShed(time.Millisecond)
+	h := shedder.Exec(slow)
+	for i := 0; i < 9; i++ {
+		if _, err := h.Handle(nil, m.EmptyRequest()); err != nil {
+			t.Fatal("Request shed before enough samples: ", err)
+		}
+	}
+	h.Handle(nil, m.EmptyRequest())
+	if rate := shedder.ShedRate(); rate != 0.9 {
+		t.Errorf("Wrong shed rate. Got: %g, expected: 0.9", rate)
+	}
+}
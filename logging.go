@@ -0,0 +1,189 @@
+package cliware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Logger is destination of log entries. It is satisfied by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// defaultLogBodySize is default MaxBodySize of ErrorLogger.
+const defaultLogBodySize = 4096
+
+// ErrorLogger is middleware that logs details of failed requests. It is
+// created using LogOnErrorContext.
+type ErrorLogger struct {
+	// Failed reports whether request failed and should be logged. By default
+	// requests that returned error or response with 5xx status are logged.
+	Failed func(resp *http.Response, err error) bool
+	// MaxBodySize is maximal number of bytes of request and response body
+	// that are included in log entry. Zero means 4096 bytes, and negative
+	// value disables logging of bodies.
+	MaxBodySize int
+	// Rules describe values masked in logged headers and bodies. Defaults to
+	// rules set by Redact middleware before it, or DefaultRedactRules.
+	Rules *RedactRules
+
+	logger Logger
+}
+
+// LogOnErrorContext returns middleware that logs request and response only
+// when request fails, so that failures come with full context needed to
+// debug them, while successful requests are not logged at all.
+//
+// Log entry contains method, URL and headers of request, error or status and
+// headers of response, time request took, and the first MaxBodySize bytes of
+// both bodies. Request body is captured as next handler reads it, so only the
+// part of it that was actually sent is logged. Captured bytes are discarded
+// as soon as request succeeds, and response body is peeked at only for
+// failed requests; peeked bytes are put back, so body is returned unchanged.
+// Values matched by Rules are masked.
+func LogOnErrorContext(logger Logger) *ErrorLogger {
+	return &ErrorLogger{logger: logger}
+}
+
+// Exec is implementation of Middleware interface.
+func (el *ErrorLogger) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		var captured *capturedBody
+		if req != nil && req.Body != nil && req.Body != http.NoBody && el.maxBodySize() > 0 {
+			captured = &capturedBody{ReadCloser: req.Body, limit: el.maxBodySize()}
+			req.Body = captured
+		}
+		start := time.Now()
+		resp, err = next.Handle(ctx, req)
+		failed := el.Failed
+		if failed == nil {
+			failed = failedRequest
+		}
+		if !failed(resp, err) {
+			return resp, err
+		}
+		var responseBody []byte
+		if resp != nil && resp.Body != nil && el.maxBodySize() > 0 {
+			var peekErr error
+			if responseBody, peekErr = peekResponseBody(resp, el.maxBodySize()); peekErr != nil && err == nil {
+				err = peekErr
+			}
+		}
+		el.logger.Printf("%s", el.entry(ctx, req, captured.bytes(), resp, responseBody, err, time.Since(start)))
+		return resp, err
+	})
+}
+
+func (el *ErrorLogger) maxBodySize() int {
+	if el.MaxBodySize == 0 {
+		return defaultLogBodySize
+	}
+	return el.MaxBodySize
+}
+
+// entry returns log entry for failed request.
+func (el *ErrorLogger) entry(ctx context.Context, req *http.Request, requestBody []byte, resp *http.Response, responseBody []byte, err error, took time.Duration) string {
+	rules := redactRulesFor(ctx, el.Rules)
+	var buf bytes.Buffer
+	outcome := "no response"
+	if err != nil {
+		outcome = err.Error()
+	} else if resp != nil {
+		outcome = resp.Status
+		if outcome == "" {
+			outcome = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+		}
+	}
+	if req != nil && req.URL != nil {
+		fmt.Fprintf(&buf, "cliware: request %s %s failed: %s (took %s)\n", req.Method, req.URL, outcome, took)
+		writeLogHeader(&buf, "> ", rules.Header(req.Header))
+		writeLogBody(&buf, "> ", rules.Body(req.Header.Get("Content-Type"), requestBody), req.ContentLength)
+	} else {
+		fmt.Fprintf(&buf, "cliware: request failed: %s (took %s)\n", outcome, took)
+	}
+	if resp != nil {
+		fmt.Fprintf(&buf, "< %d %s\n", resp.StatusCode, http.StatusText(resp.StatusCode))
+		writeLogHeader(&buf, "< ", rules.Header(resp.Header))
+		writeLogBody(&buf, "< ", rules.Body(resp.Header.Get("Content-Type"), responseBody), resp.ContentLength)
+	}
+	return buf.String()
+}
+
+// writeLogHeader writes header to buf, one value per line in order of names.
+func writeLogHeader(buf *bytes.Buffer, prefix string, h http.Header) {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range h[name] {
+			fmt.Fprintf(buf, "%s%s: %s\n", prefix, name, value)
+		}
+	}
+}
+
+// writeLogBody writes body to buf, noting if it is only part of body of
+// provided length.
+func writeLogBody(buf *bytes.Buffer, prefix string, body []byte, length int64) {
+	if len(body) == 0 {
+		return
+	}
+	fmt.Fprintf(buf, "%s\n%s%s\n", prefix, prefix, body)
+	if length < 0 || int64(len(body)) < length {
+		fmt.Fprintf(buf, "%s... (truncated)\n", prefix)
+	}
+}
+
+// peekResponseBody reads up to limit bytes of response body and puts them
+// back, so that body can still be read whole.
+func peekResponseBody(resp *http.Response, limit int) ([]byte, error) {
+	prefix := make([]byte, limit)
+	n, err := io.ReadFull(resp.Body, prefix)
+	prefix = prefix[:n]
+	resp.Body = &sniffedBody{Reader: io.MultiReader(bytes.NewReader(prefix), resp.Body), body: resp.Body}
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return prefix, err
+	}
+	return prefix, nil
+}
+
+// capturedBody is request body that keeps copy of up to limit bytes read
+// from it.
+type capturedBody struct {
+	io.ReadCloser
+	limit int
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// Read is implementation of io.Reader interface.
+func (cb *capturedBody) Read(p []byte) (int, error) {
+	n, err := cb.ReadCloser.Read(p)
+	cb.mu.Lock()
+	if remaining := cb.limit - len(cb.buf); remaining > 0 && n > 0 {
+		if n < remaining {
+			remaining = n
+		}
+		cb.buf = append(cb.buf, p[:remaining]...)
+	}
+	cb.mu.Unlock()
+	return n, err
+}
+
+// bytes returns captured bytes.
+func (cb *capturedBody) bytes() []byte {
+	if cb == nil {
+		return nil
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.buf
+}
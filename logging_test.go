@@ -0,0 +1,127 @@
+package cliware_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+type recordingLogger struct {
+	entries []string
+}
+
+func (rl *recordingLogger) Printf(format string, v ...interface{}) {
+	rl.entries = append(rl.entries, fmt.Sprintf(format, v...))
+}
+
+// createEchoHandler returns handler that reads whole request body and
+// responds with provided status and body.
+func createEchoHandler(code int, body string) m.Handler {
+	return m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		if req.Body != nil {
+			ioutil.ReadAll(req.Body)
+		}
+		return &http.Response{
+			StatusCode:    code,
+			Header:        http.Header{"Content-Type": {"text/plain"}},
+			Body:          ioutil.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+		}, nil
+	})
+}
+
+func TestLogOnErrorContext(t *testing.T) {
+	logger := &recordingLogger{}
+	newRequest := func() *http.Request {
+		req, _ := http.NewRequest("POST", "http://example.com/users", strings.NewReader("request data"))
+		req.Header.Set("Authorization", "Bearer secret")
+		return req
+	}
+
+	mw := m.LogOnErrorContext(logger)
+	resp, _ := mw.Exec(createEchoHandler(200, "ok")).Handle(nil, newRequest())
+	if got := readBody(t, resp); got != "ok" || len(logger.entries) != 0 {
+		t.Fatalf("Successful request logged. Got body: %q, entries: %q", got, logger.entries)
+	}
+
+	resp, _ = mw.Exec(createEchoHandler(503, "overloaded")).Handle(nil, newRequest())
+	if got := readBody(t, resp); got != "overloaded" {
+		t.Errorf("Body of failed response changed. Got: %q", got)
+	}
+	if len(logger.entries) != 1 {
+		t.Fatalf("Wrong number of entries. Got: %d, expected: 1", len(logger.entries))
+	}
+	entry := logger.entries[0]
+	for _, expected := range []string{
+		"cliware: request POST http://example.com/users failed: 503 Service Unavailable",
+		"> Authorization: [REDACTED]",
+		"> request data",
+		"< 503 Service Unavailable",
+		"< Content-Type: text/plain",
+		"< overloaded",
+	} {
+		if !strings.Contains(entry, expected) {
+			t.Errorf("Entry does not contain %q. Got:\n%s", expected, entry)
+		}
+	}
+	if strings.Contains(entry, "secret") || strings.Contains(entry, "truncated") {
+		t.Errorf("Entry contains secret or is truncated. Got:\n%s", entry)
+	}
+
+	expected := errors.New("connection refused")
+	failing := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		return nil, expected
+	})
+	if _, err := mw.Exec(failing).Handle(nil, newRequest()); err != expected {
+		t.Errorf("Error changed. Got: %v", err)
+	}
+	if len(logger.entries) != 2 || !strings.Contains(logger.entries[1], "failed: connection refused") {
+		t.Errorf("Error not logged. Got: %q", logger.entries)
+	}
+}
+
+func TestLogOnErrorContextConfig(t *testing.T) {
+	logger := &recordingLogger{}
+	mw := m.LogOnErrorContext(logger)
+	mw.MaxBodySize = 4
+	mw.Failed = func(resp *http.Response, err error) bool {
+		return err != nil || resp.StatusCode >= 400
+	}
+
+	resp, _ := mw.Exec(createEchoHandler(404, "not found")).Handle(nil, m.EmptyRequest())
+	if got := readBody(t, resp); got != "not found" {
+		t.Errorf("Body of failed response changed. Got: %q", got)
+	}
+	if len(logger.entries) != 1 {
+		t.Fatalf("Configured failure status not logged. Got: %q", logger.entries)
+	}
+	if entry := logger.entries[0]; !strings.Contains(entry, "< not \n< ... (truncated)") {
+		t.Errorf("Body not truncated. Got:\n%s", entry)
+	}
+
+	mw.MaxBodySize = -1
+	mw.Exec(createEchoHandler(404, "not found")).Handle(nil, m.EmptyRequest())
+	if entry := logger.entries[1]; strings.Contains(entry, "not found") {
+		t.Errorf("Body logged when disabled. Got:\n%s", entry)
+	}
+}
+
+func TestLogOnErrorContextRedact(t *testing.T) {
+	logger := &recordingLogger{}
+	chain := m.NewChain(m.Redact(m.RedactRules{Headers: []string{"X-Api-Key"}}), m.LogOnErrorContext(logger))
+	req := m.EmptyRequest()
+	req.Header.Set("X-Api-Key", "secret")
+	chain.Exec(createEchoHandler(500, "failed")).Handle(nil, req)
+	if len(logger.entries) != 1 {
+		t.Fatalf("Wrong number of entries. Got: %d, expected: 1", len(logger.entries))
+	}
+	if entry := logger.entries[0]; strings.Contains(entry, "secret") || !strings.Contains(entry, "> X-Api-Key: [REDACTED]") {
+		t.Errorf("Rules of Redact not used. Got:\n%s", entry)
+	}
+}
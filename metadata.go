@@ -0,0 +1,125 @@
+package cliware
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// valueKey wraps keys of values stored using WithValue, so they can not
+// collide with keys of other packages, even if they are of built-in type
+// (e.g. string).
+type valueKey struct {
+	key interface{}
+}
+
+// WithValue returns copy of provided context that carries value under
+// provided key, like context.WithValue, but key is wrapped in unexported
+// type of this package, so it does not collide with keys used by other
+// packages, even if it is a plain string. Key must be comparable. Value can
+// be obtained using FromContext.
+func WithValue(ctx context.Context, key, value interface{}) context.Context {
+	return context.WithValue(ensureContext(ctx), valueKey{key}, value)
+}
+
+// FromContext returns value stored under provided key using WithValue.
+func FromContext(ctx context.Context, key interface{}) (interface{}, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	value := ctx.Value(valueKey{key})
+	return value, value != nil
+}
+
+// DetachContext returns context that carries all values of provided one
+// (e.g. trace IDs or Metadata), but is never cancelled and has no deadline.
+// Middlewares that do work in background goroutines, which must outlive
+// request they were started by, should use it instead of request context, so
+// that work is not cancelled when request completes, and instead of
+// context.Background, so that it is still attributed to request.
+func DetachContext(ctx context.Context) context.Context {
+	return detachedContext{ensureContext(ctx)}
+}
+
+// detachedContext is context that takes values from parent, but not its
+// cancellation and deadline.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (dc detachedContext) Value(key interface{}) interface{} {
+	return dc.parent.Value(key)
+}
+
+type metadataKey struct{}
+
+// Metadata is set of values that middlewares annotate request with. It is
+// carried by context (see WithMetadata), and since all middlewares and the
+// caller share the same Metadata, values set by any of them can be read by
+// others, including middlewares before in chain and caller, after request
+// completes. It is safe for concurrent use.
+type Metadata struct {
+	mu     sync.RWMutex
+	values map[string]interface{}
+}
+
+// Set sets value of key.
+func (md *Metadata) Set(key string, value interface{}) {
+	md.mu.Lock()
+	md.values[key] = value
+	md.mu.Unlock()
+}
+
+// Get returns value of key and true, or false if key is not set.
+func (md *Metadata) Get(key string) (interface{}, bool) {
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+	value, ok := md.values[key]
+	return value, ok
+}
+
+// WithMetadata returns copy of provided context that carries new empty
+// Metadata. If context already carries Metadata, it is returned unchanged, so
+// existing values stay visible to whoever prepared it. It can be used as
+// ContextProcessor to prepare metadata at the beginning of chain:
+//
+//	chain.Use(m.ContextProcessor(m.WithMetadata))
+func WithMetadata(ctx context.Context) context.Context {
+	ctx = ensureContext(ctx)
+	if _, ok := MetadataFromContext(ctx); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, metadataKey{}, &Metadata{values: make(map[string]interface{})})
+}
+
+// MetadataFromContext returns Metadata carried by context.
+func MetadataFromContext(ctx context.Context) (*Metadata, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	md, ok := ctx.Value(metadataKey{}).(*Metadata)
+	return md, ok
+}
+
+// SetMeta sets value of key in Metadata carried by context and returns true,
+// or returns false if context does not carry Metadata.
+func SetMeta(ctx context.Context, key string, value interface{}) bool {
+	md, ok := MetadataFromContext(ctx)
+	if ok {
+		md.Set(key, value)
+	}
+	return ok
+}
+
+// GetMeta returns value of key in Metadata carried by context.
+func GetMeta(ctx context.Context, key string) (interface{}, bool) {
+	md, ok := MetadataFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	return md.Get(key)
+}
@@ -0,0 +1,84 @@
+package cliware_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestWithValue(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "user", "other package")
+	ctx = m.WithValue(ctx, "user", "cliware")
+	if value, ok := m.FromContext(ctx, "user"); !ok || value != "cliware" {
+		t.Errorf("Wrong value. Got: %v, %t", value, ok)
+	}
+	if ctx.Value("user") != "other package" {
+		t.Error("Value collides with key of other package.")
+	}
+	if _, ok := m.FromContext(ctx, "missing"); ok {
+		t.Error("Value found for missing key.")
+	}
+	if _, ok := m.FromContext(nil, "user"); ok {
+		t.Error("Value found in nil context.")
+	}
+}
+
+func TestDetachContext(t *testing.T) {
+	parent, cancel := context.WithTimeout(m.WithValue(nil, "trace", "abc"), time.Hour)
+	detached := m.DetachContext(parent)
+	cancel()
+	if parent.Err() == nil {
+		t.Fatal("Parent context not cancelled.")
+	}
+	if value, ok := m.FromContext(detached, "trace"); !ok || value != "abc" {
+		t.Errorf("Value not propagated. Got: %v, %t", value, ok)
+	}
+	if detached.Err() != nil || detached.Done() != nil {
+		t.Errorf("Cancellation propagated. Got error: %v", detached.Err())
+	}
+	if _, ok := detached.Deadline(); ok {
+		t.Error("Deadline propagated.")
+	}
+	derived, cancelDerived := context.WithCancel(detached)
+	cancelDerived()
+	if derived.Err() == nil {
+		t.Error("Context derived from detached one not cancellable.")
+	}
+	if m.DetachContext(nil).Err() != nil {
+		t.Error("Detached nil context has error.")
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	var seen interface{}
+	late := m.MiddlewareFunc(func(next m.Handler) m.Handler {
+		return m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+			seen, _ = m.GetMeta(ctx, "upstream")
+			m.SetMeta(ctx, "status", 200)
+			return next.Handle(ctx, req)
+		})
+	})
+	early := m.MiddlewareFunc(func(next m.Handler) m.Handler {
+		return m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+			m.SetMeta(ctx, "upstream", "eu")
+			return next.Handle(ctx, req)
+		})
+	})
+	handler, _ := createHandler()
+	chain := m.NewChain(m.ContextProcessor(m.WithMetadata), early, late)
+
+	ctx := m.WithMetadata(context.Background())
+	chain.Exec(handler).Handle(ctx, nil)
+	if seen != "eu" {
+		t.Errorf("Value set by early middleware not seen by late one. Got: %v", seen)
+	}
+	if status, ok := m.GetMeta(ctx, "status"); !ok || status != 200 {
+		t.Errorf("Value set by middleware not visible to caller. Got: %v, %t", status, ok)
+	}
+	if m.SetMeta(context.Background(), "key", "value") {
+		t.Error("Value set in context without metadata.")
+	}
+}
@@ -0,0 +1,169 @@
+package cliware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// DiffResult describes differences between responses of primary and
+// candidate handler to the same request.
+type DiffResult struct {
+	// Request is copy of mirrored request.
+	Request *http.Request
+	// Primary and Candidate are responses of primary and candidate handler,
+	// with buffered bodies. They are nil if handler returned error.
+	Primary, Candidate *http.Response
+	// PrimaryErr and CandidateErr are errors returned by handlers.
+	PrimaryErr, CandidateErr error
+	// Differences are human readable descriptions of found differences.
+	Differences []string
+}
+
+// Mirror is middleware that mirrors requests to candidate handler and
+// reports differences between responses. It is created using DiffMirror
+// function.
+type Mirror struct {
+	// IgnoreHeaders are names of headers that are not compared. Defaults to
+	// Date.
+	IgnoreHeaders []string
+	// NormalizeBody, if set, is applied to both bodies before they are
+	// compared (e.g. to remove timestamps or generated IDs).
+	NormalizeBody func([]byte) []byte
+
+	candidate Handler
+	report    func(DiffResult)
+}
+
+// DiffMirror returns middleware that sends every request to next handler
+// and, asynchronously, copy of it to candidate handler, compares their
+// responses (status code, headers and body) and calls report if they
+// differ. This validates that candidate backend behaves like production one
+// on real traffic. Result of next handler is returned unchanged, regardless
+// of candidate results. Request bodies and both response bodies are
+// buffered. Candidate is called with context detached from request context
+// (see DetachContext), so it carries its values, but is not affected by its
+// cancellation.
+func DiffMirror(candidate Handler, report func(DiffResult)) *Mirror {
+	return &Mirror{IgnoreHeaders: []string{"Date"}, candidate: candidate, report: report}
+}
+
+// Exec is implementation of Middleware interface.
+func (mr *Mirror) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		body, err := bufferRequestBody(req)
+		if err != nil {
+			return nil, err
+		}
+		mirrored := req.WithContext(DetachContext(ctx))
+		mirrored.Header = cloneHeader(req.Header)
+		mirroredURL := *req.URL
+		mirrored.URL = &mirroredURL
+		if req.Body != nil && req.Body != http.NoBody {
+			setRequestBody(mirrored, body)
+		}
+		primary := make(chan *DiffResult, 1)
+		go mr.compare(mirrored, primary)
+
+		resp, err = next.Handle(ctx, req)
+		result := &DiffResult{PrimaryErr: err}
+		if err == nil && resp != nil {
+			buffered, bufferErr := newBufferedResponse(resp)
+			if bufferErr != nil {
+				primary <- nil
+				return nil, bufferErr
+			}
+			result.Primary = buffered.response()
+			resp = buffered.response()
+		}
+		primary <- result
+		return resp, err
+	})
+}
+
+// compare sends request to candidate and compares its response with primary
+// one once it is available.
+func (mr *Mirror) compare(req *http.Request, primary <-chan *DiffResult) {
+	candidate, candidateErr := mr.candidate.Handle(req.Context(), req)
+	var candidateBody []byte
+	if candidateErr == nil && candidate != nil {
+		buffered, err := newBufferedResponse(candidate)
+		if err != nil {
+			candidateErr = err
+			candidate = nil
+		} else {
+			candidate = buffered.response()
+			candidateBody = buffered.body
+		}
+	}
+	result := <-primary
+	if result == nil {
+		return
+	}
+	result.Request = req
+	result.Candidate = candidate
+	result.CandidateErr = candidateErr
+	result.Differences = mr.diff(result.Primary, result.PrimaryErr, candidate, candidateBody, candidateErr)
+	if len(result.Differences) > 0 && mr.report != nil {
+		mr.report(*result)
+	}
+}
+
+// diff returns descriptions of differences between responses.
+func (mr *Mirror) diff(primary *http.Response, primaryErr error, candidate *http.Response, candidateBody []byte, candidateErr error) []string {
+	if primaryErr != nil || candidateErr != nil {
+		if (primaryErr == nil) != (candidateErr == nil) {
+			return []string{fmt.Sprintf("error: %v != %v", primaryErr, candidateErr)}
+		}
+		return nil
+	}
+	if primary == nil || candidate == nil {
+		if primary != candidate {
+			return []string{"response: one of responses is nil"}
+		}
+		return nil
+	}
+
+	var differences []string
+	if primary.StatusCode != candidate.StatusCode {
+		differences = append(differences, fmt.Sprintf("status: %d != %d", primary.StatusCode, candidate.StatusCode))
+	}
+	ignored := make(map[string]bool, len(mr.IgnoreHeaders))
+	for _, name := range mr.IgnoreHeaders {
+		ignored[http.CanonicalHeaderKey(name)] = true
+	}
+	names := make(map[string]bool)
+	for name := range primary.Header {
+		names[name] = true
+	}
+	for name := range candidate.Header {
+		names[name] = true
+	}
+	var sorted []string
+	for name := range names {
+		if !ignored[name] {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		p := strings.Join(primary.Header[name], ", ")
+		c := strings.Join(candidate.Header[name], ", ")
+		if p != c {
+			differences = append(differences, fmt.Sprintf("header %s: %q != %q", name, p, c))
+		}
+	}
+
+	primaryBody, _ := bufferResponseBody(primary)
+	if mr.NormalizeBody != nil {
+		primaryBody = mr.NormalizeBody(primaryBody)
+		candidateBody = mr.NormalizeBody(candidateBody)
+	}
+	if !bytes.Equal(primaryBody, candidateBody) {
+		differences = append(differences, "body differs")
+	}
+	return differences
+}
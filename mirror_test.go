@@ -0,0 +1,128 @@
+package cliware_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	m "go.delic.rs/cliware"
+)
+
+func createMirrorHandler(status int, body string, headers ...string) m.Handler {
+	return m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		reqBody, _ := ioutil.ReadAll(req.Body)
+		resp := &http.Response{
+			StatusCode: status,
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(strings.NewReader(body + string(reqBody))),
+		}
+		for i := 0; i+1 < len(headers); i += 2 {
+			resp.Header.Set(headers[i], headers[i+1])
+		}
+		return resp, nil
+	})
+}
+
+// mirror sends request with body through mirror to primary handler.
+func mirror(t *testing.T, mr *m.Mirror, primary m.Handler) *http.Response {
+	req, _ := http.NewRequest("POST", "http://localhost/", strings.NewReader(" payload"))
+	resp, err := mr.Exec(primary).Handle(nil, req)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	return resp
+}
+
+func TestDiffMirror(t *testing.T) {
+	reports := make(chan m.DiffResult, 1)
+	candidate := createMirrorHandler(500, "candidate", "Date", "tomorrow", "X-Version", "2")
+	mr := m.DiffMirror(candidate, func(result m.DiffResult) { reports <- result })
+	resp := mirror(t, mr, createMirrorHandler(200, "primary", "Date", "today", "X-Version", "1"))
+	if got := readBody(t, resp); got != "primary payload" {
+		t.Errorf("Wrong primary response. Got: %q", got)
+	}
+
+	select {
+	case result := <-reports:
+		expected := []string{"status: 200 != 500", `header X-Version: "1" != "2"`, "body differs"}
+		if strings.Join(result.Differences, "; ") != strings.Join(expected, "; ") {
+			t.Errorf("Wrong differences. Got: %q, expected: %q", result.Differences, expected)
+		}
+		if got := readBody(t, result.Candidate); got != "candidate payload" {
+			t.Errorf("Candidate did not receive request body. Got: %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Differences not reported.")
+	}
+}
+
+func TestDiffMirrorNormalized(t *testing.T) {
+	reports := make(chan m.DiffResult, 1)
+	candidate := createMirrorHandler(200, "at 2020-01-02", "Date", "tomorrow")
+	mr := m.DiffMirror(candidate, func(result m.DiffResult) { reports <- result })
+	timestamp := regexp.MustCompile(`\d{4}-\d{2}-\d{2}`)
+	mr.NormalizeBody = func(body []byte) []byte {
+		return timestamp.ReplaceAll(body, []byte("DATE"))
+	}
+	resp := mirror(t, mr, createMirrorHandler(200, "at 2020-01-01", "Date", "today"))
+	readBody(t, resp)
+	select {
+	case result := <-reports:
+		t.Errorf("Equal responses reported: %q", result.Differences)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDiffMirrorCandidateError(t *testing.T) {
+	reports := make(chan m.DiffResult, 1)
+	candidate := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	})
+	mr := m.DiffMirror(candidate, func(result m.DiffResult) { reports <- result })
+	resp := mirror(t, mr, createMirrorHandler(200, "primary"))
+	readBody(t, resp)
+	select {
+	case result := <-reports:
+		if result.CandidateErr == nil || len(result.Differences) != 1 {
+			t.Errorf("Wrong report of candidate error: %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Candidate error not reported.")
+	}
+}
+
+func TestDiffMirrorDetachedContext(t *testing.T) {
+	type traceKey struct{}
+	seen := make(chan error, 1)
+	candidate := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		time.Sleep(10 * time.Millisecond)
+		if ctx.Value(traceKey{}) != "abc" {
+			seen <- errors.New("value not propagated")
+		} else {
+			seen <- ctx.Err()
+		}
+		return nil, errors.New("candidate")
+	})
+	mr := m.DiffMirror(candidate, nil)
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), traceKey{}, "abc"))
+	req, _ := http.NewRequest("POST", "http://localhost/", strings.NewReader(" payload"))
+	resp, err := mr.Exec(createMirrorHandler(200, "primary")).Handle(ctx, req)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	readBody(t, resp)
+	cancel()
+	select {
+	case err := <-seen:
+		if err != nil {
+			t.Errorf("Wrong candidate context: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Candidate not called.")
+	}
+}
@@ -0,0 +1,124 @@
+package cliware
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Mutation is change that MutateReplay applies to requests with some
+// probability. Available mutations are created using DropHeader,
+// ChangeMethod, CorruptBody and TruncateBody, and custom ones can be created
+// by setting Apply.
+type Mutation struct {
+	// Probability is probability, between 0 and 1, that mutation is applied
+	// to request.
+	Probability float64
+	// Apply changes request. Random values should be taken from provided
+	// random number generator, so mutations are reproducible.
+	Apply func(req *http.Request, random *rand.Rand) error
+}
+
+// DropHeader returns mutation that removes header from request.
+func DropHeader(name string, probability float64) Mutation {
+	return Mutation{Probability: probability, Apply: func(req *http.Request, random *rand.Rand) error {
+		req.Header.Del(name)
+		return nil
+	}}
+}
+
+// ChangeMethod returns mutation that changes method of request.
+func ChangeMethod(method string, probability float64) Mutation {
+	return Mutation{Probability: probability, Apply: func(req *http.Request, random *rand.Rand) error {
+		req.Method = method
+		return nil
+	}}
+}
+
+// CorruptBody returns mutation that replaces random byte of request body
+// with random value.
+func CorruptBody(probability float64) Mutation {
+	return Mutation{Probability: probability, Apply: func(req *http.Request, random *rand.Rand) error {
+		body, err := requestBody(req)
+		if err != nil || len(body) == 0 {
+			return err
+		}
+		corrupted := append([]byte(nil), body...)
+		corrupted[random.Intn(len(corrupted))] = byte(random.Intn(256))
+		setRequestBody(req, corrupted)
+		return nil
+	}}
+}
+
+// TruncateBody returns mutation that cuts request body at random position,
+// while leaving Content-Length header of original length, if it is set.
+func TruncateBody(probability float64) Mutation {
+	return Mutation{Probability: probability, Apply: func(req *http.Request, random *rand.Rand) error {
+		body, err := requestBody(req)
+		if err != nil || len(body) == 0 {
+			return err
+		}
+		setRequestBody(req, body[:random.Intn(len(body))])
+		return nil
+	}}
+}
+
+// Mutator is middleware that randomly mutates requests. It is created using
+// MutateReplay function.
+type Mutator struct {
+	// Enabled enables mutating of requests. Mutator does nothing until it is
+	// set, so it is not enabled by accident outside of test environment.
+	Enabled bool
+
+	mutations []Mutation
+
+	mu     sync.Mutex
+	random *rand.Rand
+}
+
+// MutateReplay returns middleware that applies provided mutations to
+// requests, each with its probability, in order to test how servers handle
+// malformed input (chaos or negative testing). It is intended for test
+// environments only and does nothing unless Enabled is set. Random values
+// are taken from source provided using Source method or from source seeded
+// with current time. If mutation fails, its error is returned without
+// sending request.
+func MutateReplay(mutations []Mutation) *Mutator {
+	return &Mutator{
+		mutations: mutations,
+		random:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Source sets source of random values used for deciding which mutations are
+// applied and by mutations themselves. Fixed source makes mutations
+// reproducible.
+func (mt *Mutator) Source(src rand.Source) *Mutator {
+	mt.mu.Lock()
+	mt.random = rand.New(src)
+	mt.mu.Unlock()
+	return mt
+}
+
+// Exec is implementation of Middleware interface.
+func (mt *Mutator) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		if !mt.Enabled {
+			return next.Handle(ctx, req)
+		}
+		mt.mu.Lock()
+		for _, mutation := range mt.mutations {
+			if mt.random.Float64() >= mutation.Probability {
+				continue
+			}
+			if err = mutation.Apply(req, mt.random); err != nil {
+				mt.mu.Unlock()
+				return nil, err
+			}
+		}
+		mt.mu.Unlock()
+		return next.Handle(ctx, req)
+	})
+}
@@ -0,0 +1,76 @@
+package cliware_test
+
+import (
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+type receivedRequest struct {
+	method, auth, body string
+}
+
+func createRecordingHandler(received *[]receivedRequest) m.Handler {
+	return m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		body, _ := ioutil.ReadAll(req.Body)
+		*received = append(*received, receivedRequest{req.Method, req.Header.Get("Authorization"), string(body)})
+		return nil, nil
+	})
+}
+
+func newMutatedRequest() *http.Request {
+	req, _ := http.NewRequest("POST", "http://localhost/", strings.NewReader("payload"))
+	req.Header.Set("Authorization", "token")
+	return req
+}
+
+func TestMutateReplay(t *testing.T) {
+	var received []receivedRequest
+	mutator := m.MutateReplay([]m.Mutation{
+		m.DropHeader("Authorization", 1),
+		m.ChangeMethod("PATCH", 1),
+		m.CorruptBody(1),
+	}).Source(rand.NewSource(1))
+	mutator.Enabled = true
+	mutator.Exec(createRecordingHandler(&received)).Handle(nil, newMutatedRequest())
+
+	r := received[0]
+	if r.method != "PATCH" || r.auth != "" || r.body == "payload" || len(r.body) != len("payload") {
+		t.Errorf("Mutations not applied: %+v", r)
+	}
+}
+
+func TestMutateReplayProbability(t *testing.T) {
+	var received []receivedRequest
+	mutator := m.MutateReplay([]m.Mutation{m.TruncateBody(0.5), m.ChangeMethod("GET", 0)}).Source(rand.NewSource(1))
+	mutator.Enabled = true
+	h := mutator.Exec(createRecordingHandler(&received))
+	for i := 0; i < 1000; i++ {
+		h.Handle(nil, newMutatedRequest())
+	}
+	truncated := 0
+	for _, r := range received {
+		if r.method != "POST" {
+			t.Fatal("Mutation with zero probability applied.")
+		}
+		if len(r.body) < len("payload") {
+			truncated++
+		}
+	}
+	if truncated < 400 || truncated > 600 {
+		t.Errorf("Wrong number of mutated requests. Got: %d, expected about 500", truncated)
+	}
+}
+
+func TestMutateReplayDisabled(t *testing.T) {
+	var received []receivedRequest
+	m.MutateReplay([]m.Mutation{m.DropHeader("Authorization", 1)}).Exec(createRecordingHandler(&received)).Handle(nil, newMutatedRequest())
+	if received[0].auth != "token" {
+		t.Error("Request mutated by disabled mutator.")
+	}
+}
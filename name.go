@@ -0,0 +1,144 @@
+package cliware
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+// namer is implemented by middlewares that have a name. Name is used when
+// describing chain.
+type namer interface {
+	Name() string
+}
+
+// middlewareName returns name of middleware or empty string if middleware
+// does not have it.
+func middlewareName(m Middleware) string {
+	if n, ok := m.(namer); ok {
+		return n.Name()
+	}
+	return ""
+}
+
+// NamedError is error returned by middleware wrapped using Named, which
+// carries name of middleware that returned it.
+type NamedError struct {
+	// Name is name of middleware.
+	Name string
+	// Err is error returned by middleware.
+	Err error
+}
+
+// Error is implementation of error interface.
+func (e *NamedError) Error() string {
+	return "cliware: " + e.Name + ": " + e.Err.Error()
+}
+
+// Unwrap returns error returned by middleware.
+func (e *NamedError) Unwrap() error {
+	return e.Err
+}
+
+// named is middleware with a name.
+type named struct {
+	name string
+	mw   Middleware
+}
+
+// namedKey is context key under which named middleware stores errors
+// returned to it by next handler.
+type namedKey struct {
+	m *named
+}
+
+// passedErrors are errors returned by next handler during single call of
+// named middleware. Middleware can call next handler multiple times, even
+// concurrently, so all errors are kept.
+type passedErrors struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+// add records error returned by next handler.
+func (pe *passedErrors) add(err error) {
+	if err == nil {
+		return
+	}
+	pe.mu.Lock()
+	pe.errs = append(pe.errs, err)
+	pe.mu.Unlock()
+}
+
+// contains returns true if err was returned by next handler.
+func (pe *passedErrors) contains(err error) bool {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	for _, passed := range pe.errs {
+		if sameError(err, passed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Named returns middleware that executes provided middleware and gives it a
+// name, which is returned by Names method of chain and used in DOT. Errors
+// produced by middleware are returned as *NamedError carrying the name, so
+// it is known which middleware in large chain failed. Errors returned by
+// handlers after middleware that it passes on without change are returned
+// as they are, so they are attributed to middleware that produced them.
+func Named(name string, mw Middleware) Middleware {
+	return &named{name: name, mw: mw}
+}
+
+// Name returns name of middleware.
+func (n *named) Name() string {
+	return n.name
+}
+
+// Unwrap is implementation of Wrapper interface.
+func (n *named) Unwrap() Middleware {
+	return n.mw
+}
+
+// Exec is implementation of Middleware interface.
+func (n *named) Exec(next Handler) Handler {
+	handler := n.mw.Exec(HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		resp, err = next.Handle(ctx, req)
+		if passed, ok := ctx.Value(namedKey{n}).(*passedErrors); ok {
+			passed.add(err)
+		}
+		return resp, err
+	}))
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		passed := &passedErrors{}
+		resp, err = handler.Handle(context.WithValue(ensureContext(ctx), namedKey{n}, passed), req)
+		if err != nil && !passed.contains(err) {
+			err = &NamedError{Name: n.name, Err: err}
+		}
+		return resp, err
+	})
+}
+
+// sameError returns true if errors are equal. Errors of types that are not
+// comparable are never equal.
+func sameError(a, b error) bool {
+	if b == nil || !reflect.TypeOf(b).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// Names returns names of all middlewares that chain executes, in the same
+// order as AllMiddlewares. Empty string is returned for middlewares without
+// name (see Named).
+func (c *Chain) Names() []string {
+	middlewares := c.AllMiddlewares()
+	names := make([]string, len(middlewares))
+	for i, m := range middlewares {
+		names[i] = middlewareName(m)
+	}
+	return names
+}
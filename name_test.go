@@ -0,0 +1,84 @@
+package cliware_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func createFailingMiddleware(err error) m.Middleware {
+	return m.RequestProcessor(func(req *http.Request) error {
+		return err
+	})
+}
+
+func TestNamed(t *testing.T) {
+	authErr := errors.New("no credentials")
+	m1, _ := createMiddleware()
+	chain := m.NewChain(m.Named("logging", m1), m.Named("auth", createFailingMiddleware(authErr)))
+	handler, _ := createHandler()
+	_, err := chain.Exec(handler).Handle(nil, m.EmptyRequest())
+	var namedErr *m.NamedError
+	if !errors.As(err, &namedErr) {
+		t.Fatalf("Expected *NamedError, got: %v", err)
+	}
+	if namedErr.Name != "auth" || !errors.Is(err, authErr) {
+		t.Errorf("Wrong middleware attributed. Got: %+v", namedErr)
+	}
+	if namedErr.Error() != "cliware: auth: no credentials" {
+		t.Errorf("Wrong error message: %s", err)
+	}
+}
+
+func TestNamedPassesErrors(t *testing.T) {
+	handlerErr := errors.New("connection refused")
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		return nil, handlerErr
+	})
+	m1, _ := createMiddleware()
+	_, err := m.Named("passing", m1).Exec(handler).Handle(nil, m.EmptyRequest())
+	if err != handlerErr {
+		t.Errorf("Error of handler attributed to middleware: %v", err)
+	}
+}
+
+func TestNamedPassesConcurrentErrors(t *testing.T) {
+	errs := []error{errors.New("first failed"), errors.New("second failed")}
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		return nil, errs[req.ContentLength]
+	})
+	hedging := m.MiddlewareFunc(func(next m.Handler) m.Handler {
+		return m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+			results := make(chan error, len(errs))
+			for i := range errs {
+				go func(i int) {
+					attempt := m.EmptyRequest()
+					attempt.ContentLength = int64(i)
+					_, err := next.Handle(ctx, attempt)
+					results <- err
+				}(i)
+			}
+			err := <-results
+			<-results
+			return nil, err
+		})
+	})
+	_, err := m.Named("hedging", hedging).Exec(handler).Handle(nil, m.EmptyRequest())
+	if err != errs[0] && err != errs[1] {
+		t.Errorf("Error of concurrent handler attributed to middleware: %v", err)
+	}
+}
+
+func TestChainNames(t *testing.T) {
+	m1, _ := createMiddleware()
+	m2, _ := createMiddleware()
+	chain := m.NewChain(m.Named("auth", m1), m2).ChildChain(m.Named("retry", m1))
+	expected := []string{"auth", "", "retry"}
+	if names := chain.Names(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Wrong names. Got: %q, expected: %q", names, expected)
+	}
+}
@@ -0,0 +1,88 @@
+package cliware
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Spec is interface for validating responses against OpenAPI specification.
+// It is implemented by adapters for OpenAPI libraries, so cliware does not
+// depend on any of them.
+type Spec interface {
+	// ValidateResponse validates status, headers and body of response
+	// against definition of responses of operation with provided ID. Body
+	// contains buffered response body. Returned error should describe all
+	// violations.
+	ValidateResponse(operationID string, resp *http.Response, body []byte) error
+}
+
+// RequestSpec is interface for validating requests against OpenAPI
+// specification.
+type RequestSpec interface {
+	// ValidateRequest validates request against definition of operation
+	// with provided ID. Body contains buffered request body.
+	ValidateRequest(operationID string, req *http.Request, body []byte) error
+}
+
+// OpenAPIError is error returned when request or response does not match
+// OpenAPI operation.
+type OpenAPIError struct {
+	// OperationID is ID of operation validation was performed against.
+	OperationID string
+	// Request is true if request was validated and false for response.
+	Request bool
+	// Err is error returned by Spec.
+	Err error
+}
+
+// Error is implementation of error interface.
+func (e *OpenAPIError) Error() string {
+	subject := "response"
+	if e.Request {
+		subject = "request"
+	}
+	return fmt.Sprintf("cliware: %s does not match OpenAPI operation %q: %s", subject, e.OperationID, e.Err)
+}
+
+// Unwrap returns error returned by Spec.
+func (e *OpenAPIError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateOpenAPI returns middleware that validates responses against
+// operation with provided ID using spec and returns *OpenAPIError if response
+// does not match operation definition. This is intended for catching API
+// contract drift, e.g. during tests. Response body is buffered, so it can be
+// read by caller after validation.
+func ValidateOpenAPI(spec Spec, operationID string) Middleware {
+	return ResponseProcessor(func(resp *http.Response, err error) error {
+		if err != nil || resp == nil {
+			return nil
+		}
+		body, err := bufferResponseBody(resp)
+		if err != nil {
+			return err
+		}
+		if err = spec.ValidateResponse(operationID, resp, body); err != nil {
+			return KeepBody(&OpenAPIError{OperationID: operationID, Err: err})
+		}
+		return nil
+	})
+}
+
+// ValidateOpenAPIRequest returns middleware that validates requests against
+// operation with provided ID using spec, before they are sent. If request
+// does not match operation definition, *OpenAPIError is returned and request
+// is not sent.
+func ValidateOpenAPIRequest(spec RequestSpec, operationID string) Middleware {
+	return RequestProcessor(func(req *http.Request) error {
+		body, err := requestBody(req)
+		if err != nil {
+			return err
+		}
+		if err = spec.ValidateRequest(operationID, req, body); err != nil {
+			return &OpenAPIError{OperationID: operationID, Request: true, Err: err}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,81 @@
+package cliware_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+type testSpec struct {
+	operationID string
+	body        string
+}
+
+func (ts *testSpec) ValidateResponse(operationID string, resp *http.Response, body []byte) error {
+	ts.operationID = operationID
+	ts.body = string(body)
+	if resp.StatusCode != 200 {
+		return errors.New("unexpected status")
+	}
+	return nil
+}
+
+func (ts *testSpec) ValidateRequest(operationID string, req *http.Request, body []byte) error {
+	ts.operationID = operationID
+	ts.body = string(body)
+	if req.Method != "POST" {
+		return errors.New("unexpected method")
+	}
+	return nil
+}
+
+func TestValidateOpenAPI(t *testing.T) {
+	spec := &testSpec{}
+	resp, err := m.ValidateOpenAPI(spec, "getUser").Exec(createBodyHandler("application/json", "{}")).Handle(nil, nil)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if spec.operationID != "getUser" || spec.body != "{}" {
+		t.Errorf("Wrong data validated. Operation: %s, body: %s", spec.operationID, spec.body)
+	}
+	if body, _ := ioutil.ReadAll(resp.Body); string(body) != "{}" {
+		t.Errorf("Body not readable after validation. Got: %q", body)
+	}
+}
+
+func TestValidateOpenAPIViolation(t *testing.T) {
+	handler := createStatusHandler(500)
+	_, err := m.ValidateOpenAPI(&testSpec{}, "getUser").Exec(handler).Handle(nil, nil)
+	apiErr, ok := err.(*m.OpenAPIError)
+	if !ok {
+		t.Fatalf("Expected *OpenAPIError, got: %v", err)
+	}
+	if apiErr.OperationID != "getUser" || apiErr.Request || apiErr.Err.Error() != "unexpected status" {
+		t.Errorf("Wrong error: %s", apiErr)
+	}
+}
+
+func TestValidateOpenAPIRequest(t *testing.T) {
+	spec := &testSpec{}
+	handler, handlerCalled := createHandler()
+	req, _ := http.NewRequest("POST", "http://localhost", strings.NewReader("payload"))
+	if _, err := m.ValidateOpenAPIRequest(spec, "createUser").Exec(handler).Handle(nil, req); err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if spec.body != "payload" {
+		t.Errorf("Wrong body validated. Got: %s", spec.body)
+	}
+
+	*handlerCalled = false
+	_, err := m.ValidateOpenAPIRequest(spec, "createUser").Exec(handler).Handle(nil, m.EmptyRequest())
+	if apiErr, ok := err.(*m.OpenAPIError); !ok || !apiErr.Request {
+		t.Errorf("Expected request *OpenAPIError, got: %v", err)
+	}
+	if *handlerCalled {
+		t.Error("Invalid request was sent.")
+	}
+}
@@ -0,0 +1,66 @@
+package cliware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// PartialResponseError is error returned by PartialResponseGuard in place of
+// response that was returned together with error.
+type PartialResponseError struct {
+	// Response is partial response. Its body is already closed, so only its
+	// status and headers can be inspected.
+	Response *http.Response
+	// Err is error returned along with response.
+	Err error
+}
+
+// Error is implementation of error interface.
+func (e *PartialResponseError) Error() string {
+	return fmt.Sprintf("cliware: partial response with status %d: %s", e.Response.StatusCode, e.Err)
+}
+
+// Unwrap returns error returned along with response.
+func (e *PartialResponseError) Unwrap() error {
+	return e.Err
+}
+
+// PartialResponseGuard is middleware that normalizes partial responses. It
+// is created using HandlePartialResponse function.
+type PartialResponseGuard struct {
+	// WrapResponse makes guard return *PartialResponseError that carries
+	// both partial response and error. By default partial response is
+	// discarded and only error is returned.
+	WrapResponse bool
+}
+
+// HandlePartialResponse returns middleware that enforces contract that next
+// handler returns either response or error, but never both. Handlers may
+// return both, e.g. when body of response fails mid-transfer, which
+// previous middlewares often do not expect: they either ignore error, or
+// return without closing body of response. When next handler returns both,
+// guard closes body of response and returns only error, or
+// *PartialResponseError wrapping both if WrapResponse is set.
+//
+// Guard should be placed right before terminal handler, or before
+// middlewares that can produce partial responses, so all middlewares before
+// it can rely on the contract.
+func HandlePartialResponse() *PartialResponseGuard {
+	return &PartialResponseGuard{}
+}
+
+// Exec is implementation of Middleware interface.
+func (pg *PartialResponseGuard) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		resp, err = next.Handle(ctx, req)
+		if resp == nil || err == nil {
+			return resp, err
+		}
+		drainAndClose(resp)
+		if pg.WrapResponse {
+			return nil, &PartialResponseError{Response: resp, Err: err}
+		}
+		return nil, err
+	})
+}
@@ -0,0 +1,40 @@
+package cliware_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestHandlePartialResponse(t *testing.T) {
+	expected := errors.New("unexpected EOF")
+	body := &trackedBody{Reader: strings.NewReader("partial")}
+	partial := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Body: body}, expected
+	})
+
+	resp, err := m.HandlePartialResponse().Exec(partial).Handle(nil, m.EmptyRequest())
+	if resp != nil || err != expected {
+		t.Errorf("Expected only error: \"%s\", got: %v, \"%v\"", expected, resp, err)
+	}
+	if !body.isClosed() {
+		t.Error("Body of partial response not closed.")
+	}
+
+	guard := m.HandlePartialResponse()
+	guard.WrapResponse = true
+	resp, err = guard.Exec(partial).Handle(nil, m.EmptyRequest())
+	partialErr, ok := err.(*m.PartialResponseError)
+	if resp != nil || !ok || partialErr.Err != expected || partialErr.Response.StatusCode != 200 {
+		t.Errorf("Expected partial response error, got: %v, \"%v\"", resp, err)
+	}
+
+	handler, _ := createHandler()
+	if _, err := guard.Exec(handler).Handle(nil, nil); err != nil {
+		t.Error("Handle returned error: ", err)
+	}
+}
@@ -0,0 +1,61 @@
+package cliware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Path returns middleware that sets request URL path by replacing {name}
+// placeholders in template with values from params. Values are escaped as
+// single path segment, so value containing "/" does not introduce new
+// segments. Parts of template outside of placeholders are used as they are.
+// If template contains placeholder without value in params, error is
+// returned.
+//
+// Example:
+//
+//	Path("/users/{id}/posts/{post}", map[string]string{"id": "42", "post": "a/b"})
+//
+// sets path to "/users/42/posts/a%2Fb".
+func Path(template string, params map[string]string) Middleware {
+	return RequestProcessor(func(req *http.Request) error {
+		escaped, err := expandPath(template, params)
+		if err != nil {
+			return err
+		}
+		path, err := url.PathUnescape(escaped)
+		if err != nil {
+			return err
+		}
+		req.URL.Path = path
+		req.URL.RawPath = escaped
+		return nil
+	})
+}
+
+// expandPath replaces placeholders in template with escaped values of params.
+func expandPath(template string, params map[string]string) (string, error) {
+	var result []string
+	rest := template
+	for {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			result = append(result, rest)
+			break
+		}
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("cliware: unclosed placeholder in path template %q", template)
+		}
+		name := rest[start+1 : start+end]
+		value, ok := params[name]
+		if !ok {
+			return "", fmt.Errorf("cliware: missing value for path parameter %q", name)
+		}
+		result = append(result, rest[:start], url.PathEscape(value))
+		rest = rest[start+end+1:]
+	}
+	return strings.Join(result, ""), nil
+}
@@ -0,0 +1,38 @@
+package cliware_test
+
+import (
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestPath(t *testing.T) {
+	req := m.EmptyRequest()
+	req.URL.Scheme = "http"
+	req.URL.Host = "localhost"
+	handler, _ := createHandler()
+	params := map[string]string{"id": "42", "post": "a/b c"}
+	_, err := m.Path("/users/{id}/posts/{post}", params).Exec(handler).Handle(nil, req)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if req.URL.Path != "/users/42/posts/a/b c" {
+		t.Errorf("Wrong path. Got: %s, expected: /users/42/posts/a/b c", req.URL.Path)
+	}
+	if got := req.URL.String(); got != "http://localhost/users/42/posts/a%2Fb%20c" {
+		t.Errorf("Wrong URL. Got: %s, expected: http://localhost/users/42/posts/a%%2Fb%%20c", got)
+	}
+}
+
+func TestPathMissingParam(t *testing.T) {
+	handler, handlerCalled := createHandler()
+	for _, template := range []string{"/users/{id}", "/users/{id"} {
+		_, err := m.Path(template, map[string]string{}).Exec(handler).Handle(nil, m.EmptyRequest())
+		if err == nil {
+			t.Errorf("Expected error for template %s.", template)
+		}
+	}
+	if *handlerCalled {
+		t.Error("Handler called even when path could not be built.")
+	}
+}
@@ -0,0 +1,94 @@
+package cliware
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"sync"
+)
+
+// ResourceUsage holds resources used while request was processed.
+type ResourceUsage struct {
+	// Mallocs is number of heap objects allocated.
+	Mallocs uint64
+	// Bytes is number of heap bytes allocated.
+	Bytes uint64
+	// Goroutines is difference between number of goroutines after and
+	// before request was processed. Positive value may point to leak.
+	Goroutines int
+}
+
+type resourceUsageKey struct{}
+
+// resourceUsageHolder is stored in context and ResourceProfile records
+// usage into it.
+type resourceUsageHolder struct {
+	mu       sync.Mutex
+	usage    ResourceUsage
+	recorded bool
+}
+
+// WithResourceUsage returns copy of context into which ResourceProfile
+// middleware will record resource usage. Use ResourceUsageFromContext on
+// returned context to read recorded usage after request is done.
+func WithResourceUsage(ctx context.Context) context.Context {
+	return context.WithValue(ensureContext(ctx), resourceUsageKey{}, &resourceUsageHolder{})
+}
+
+// ResourceUsageFromContext returns resource usage recorded by ResourceProfile
+// middleware into context created by WithResourceUsage. If nothing was
+// recorded, false is returned.
+func ResourceUsageFromContext(ctx context.Context) (ResourceUsage, bool) {
+	if ctx == nil {
+		return ResourceUsage{}, false
+	}
+	holder, ok := ctx.Value(resourceUsageKey{}).(*resourceUsageHolder)
+	if !ok {
+		return ResourceUsage{}, false
+	}
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+	return holder.usage, holder.recorded
+}
+
+// ResourceProfile returns middleware that measures allocations and number of
+// goroutines before and after calling next handler and records differences
+// into context created by WithResourceUsage. This helps finding middlewares
+// and handlers that allocate heavily or leak goroutines.
+//
+// Measuring requires runtime.ReadMemStats, which stops the world, so it is
+// done only when enabled is true (intended for debug mode) and context carries
+// place to record usage to. Counters are process wide, so measurements are
+// accurate only when requests are not sent concurrently.
+func ResourceProfile(enabled bool) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		if !enabled {
+			return next
+		}
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			var holder *resourceUsageHolder
+			if ctx != nil {
+				holder, _ = ctx.Value(resourceUsageKey{}).(*resourceUsageHolder)
+			}
+			if holder == nil {
+				return next.Handle(ctx, req)
+			}
+
+			var before, after runtime.MemStats
+			goroutines := runtime.NumGoroutine()
+			runtime.ReadMemStats(&before)
+			resp, err = next.Handle(ctx, req)
+			runtime.ReadMemStats(&after)
+
+			holder.mu.Lock()
+			holder.usage = ResourceUsage{
+				Mallocs:    after.Mallocs - before.Mallocs,
+				Bytes:      after.TotalAlloc - before.TotalAlloc,
+				Goroutines: runtime.NumGoroutine() - goroutines,
+			}
+			holder.recorded = true
+			holder.mu.Unlock()
+			return resp, err
+		})
+	})
+}
@@ -0,0 +1,51 @@
+package cliware_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestResourceProfile(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	var sink [][]byte
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		for i := 0; i < 10; i++ {
+			sink = append(sink, make([]byte, 1024))
+			go func() { <-release }()
+		}
+		return nil, nil
+	})
+	ctx := m.WithResourceUsage(context.Background())
+	m.ResourceProfile(true).Exec(handler).Handle(ctx, nil)
+
+	usage, ok := m.ResourceUsageFromContext(ctx)
+	if !ok {
+		t.Fatal("Resource usage not recorded.")
+	}
+	if usage.Bytes < 10*1024 {
+		t.Errorf("Wrong number of allocated bytes. Got: %d, expected at least: %d", usage.Bytes, 10*1024)
+	}
+	if usage.Mallocs < 10 {
+		t.Errorf("Wrong number of allocations. Got: %d, expected at least: 10", usage.Mallocs)
+	}
+	// other goroutines (e.g. idle connections) might exit in the meantime
+	if usage.Goroutines < 5 {
+		t.Errorf("Wrong goroutine delta. Got: %d, expected around: 10", usage.Goroutines)
+	}
+}
+
+func TestResourceProfileDisabled(t *testing.T) {
+	handler, handlerCalled := createHandler()
+	ctx := m.WithResourceUsage(context.Background())
+	m.ResourceProfile(false).Exec(handler).Handle(ctx, nil)
+	if !*handlerCalled {
+		t.Error("Handler not called.")
+	}
+	if _, ok := m.ResourceUsageFromContext(ctx); ok {
+		t.Error("Resource usage recorded while profiling is disabled.")
+	}
+}
@@ -0,0 +1,75 @@
+package cliware
+
+import (
+	"context"
+	"net/http"
+)
+
+// Race returns handler that concurrently sends copies of request to all
+// provided handlers and returns first successful response, i.e. one without
+// error and with status code below 500, which reduces latency of reads from
+// replicated backends. Every handler gets own copy of request, with body
+// buffered if request does not have GetBody set, and context derived from
+// context of request, which is cancelled once other handler wins, or, for
+// winner, once its response body is closed. Bodies of responses of losing
+// handlers are drained and closed, including ones that arrive after winner.
+//
+// If all handlers fail, result of handler that completed last is returned.
+// Race should only be used for idempotent requests, since they are sent
+// multiple times. If there are no handlers, ErrNoBackends is returned.
+func Race(handlers ...Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		if len(handlers) == 0 {
+			return nil, ErrNoBackends
+		}
+		ctx = ensureContext(ctx)
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			if _, err = bufferRequestBody(req); err != nil {
+				return nil, err
+			}
+		}
+
+		results := make(chan regionResult, len(handlers))
+		cancels := make([]context.CancelFunc, len(handlers))
+		for i, handler := range handlers {
+			raceCtx, cancel := context.WithCancel(ctx)
+			cancels[i] = cancel
+			raceReq, err := cloneRequest(raceCtx, req)
+			if err != nil {
+				results <- regionResult{index: i, err: err}
+				continue
+			}
+			go func(i int, handler Handler) {
+				resp, err := handler.Handle(raceCtx, raceReq)
+				results <- regionResult{index: i, resp: resp, err: err}
+			}(i, handler)
+		}
+
+		var last regionResult
+		for received := 1; received <= len(handlers); received++ {
+			r := <-results
+			if failedRequest(r.resp, r.err) {
+				if received < len(handlers) {
+					drainAndClose(r.resp)
+					cancels[r.index]()
+				}
+				last = r
+				continue
+			}
+			for i, cancel := range cancels {
+				if i != r.index {
+					cancel()
+				}
+			}
+			go discardRegionResults(results, len(handlers)-received)
+			cancelOnClose(r.resp, cancels[r.index])
+			return r.resp, r.err
+		}
+		if last.err != nil {
+			cancels[last.index]()
+			return last.resp, last.err
+		}
+		cancelOnClose(last.resp, cancels[last.index])
+		return last.resp, nil
+	})
+}
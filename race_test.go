@@ -0,0 +1,89 @@
+package cliware_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	m "go.delic.rs/cliware"
+)
+
+// createRaceHandler returns handler that responds with provided status code
+// or error after delay, unless its context is done before that, and records
+// bodies of requests and responses it returned.
+func createRaceHandler(delay time.Duration, code int, err error, requests *[]string, responses *[]*trackedBody, mu *sync.Mutex) m.Handler {
+	return m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		data, _ := ioutil.ReadAll(req.Body)
+		mu.Lock()
+		*requests = append(*requests, string(data))
+		mu.Unlock()
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return nil, err
+		}
+		body := &trackedBody{Reader: strings.NewReader(http.StatusText(code))}
+		mu.Lock()
+		*responses = append(*responses, body)
+		mu.Unlock()
+		return &http.Response{StatusCode: code, Body: body}, nil
+	})
+}
+
+func TestRace(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	var responses []*trackedBody
+	h := m.Race(
+		createRaceHandler(time.Millisecond, 503, nil, &requests, &responses, &mu),
+		createRaceHandler(10*time.Millisecond, 200, nil, &requests, &responses, &mu),
+		createRaceHandler(time.Second, 200, nil, &requests, &responses, &mu),
+	)
+	req, _ := http.NewRequest("POST", "http://localhost/", strings.NewReader("payload"))
+	start := time.Now()
+	resp, err := h.Handle(nil, req)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Slow handler not cancelled. Elapsed: %s", elapsed)
+	}
+	if resp.StatusCode != 200 || readBody(t, resp) != "OK" {
+		t.Errorf("Wrong winning response. Got: %d", resp.StatusCode)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(requests, ",") != "payload,payload,payload" {
+		t.Errorf("Handlers did not get own copy of body: %q", requests)
+	}
+	if len(responses) != 2 || !responses[0].isClosed() {
+		t.Errorf("Losing response not closed. Got %d responses.", len(responses))
+	}
+}
+
+func TestRaceAllFail(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	var responses []*trackedBody
+	expected := errors.New("replica down")
+	h := m.Race(
+		createRaceHandler(time.Millisecond, 503, nil, &requests, &responses, &mu),
+		createRaceHandler(10*time.Millisecond, 0, expected, &requests, &responses, &mu),
+	)
+	if _, err := h.Handle(nil, m.EmptyRequest()); err != expected {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", expected, err)
+	}
+	if _, err := m.Race().Handle(nil, m.EmptyRequest()); err != m.ErrNoBackends {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", m.ErrNoBackends, err)
+	}
+}
@@ -0,0 +1,50 @@
+package cliware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// PanicError is error returned by Recover middleware when panic is recovered.
+type PanicError struct {
+	// Value is value panic was called with.
+	Value interface{}
+	// Stack is stack trace of goroutine that panicked, as returned by
+	// debug.Stack.
+	Stack []byte
+}
+
+// Error is implementation of error interface.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("cliware: recovered from panic: %v\n%s", e.Value, e.Stack)
+}
+
+// Recover returns middleware that recovers from panics in next handler
+// (i.e. in any of middlewares after it or in final handler) and returns
+// *PanicError with recovered value and stack trace as error, so panic can
+// be handled as any other error instead of crashing the program. It should
+// be placed at the beginning of chain.
+func Recover() Middleware {
+	return RecoverWith(func(value interface{}) error {
+		return &PanicError{Value: value, Stack: debug.Stack()}
+	})
+}
+
+// RecoverWith returns middleware that recovers from panics in next handler,
+// same as Recover, but converts recovered value to error using provided
+// function. Function is called in deferred call, so stack trace can still
+// be obtained using debug.Stack.
+func RecoverWith(convert func(value interface{}) error) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			defer func() {
+				if value := recover(); value != nil {
+					resp, err = nil, convert(value)
+				}
+			}()
+			return next.Handle(ctx, req)
+		})
+	})
+}
@@ -0,0 +1,59 @@
+package cliware_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func createPanickingHandler(value interface{}) m.Handler {
+	return m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		panic(value)
+	})
+}
+
+func TestRecover(t *testing.T) {
+	resp, err := m.Recover().Exec(createPanickingHandler("boom")).Handle(nil, m.EmptyRequest())
+	if resp != nil {
+		t.Error("Expected no response.")
+	}
+	panicErr, ok := err.(*m.PanicError)
+	if !ok {
+		t.Fatalf("Expected *PanicError, got: %v", err)
+	}
+	if panicErr.Value != "boom" {
+		t.Errorf("Wrong recovered value. Got: %v", panicErr.Value)
+	}
+	if !strings.Contains(string(panicErr.Stack), "createPanickingHandler") {
+		t.Errorf("Stack trace does not contain panicking function:\n%s", panicErr.Stack)
+	}
+	if !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Error message does not contain recovered value: %s", err)
+	}
+}
+
+func TestRecoverWith(t *testing.T) {
+	expectedErr := errors.New("converted")
+	var recovered interface{}
+	mw := m.RecoverWith(func(value interface{}) error {
+		recovered = value
+		return expectedErr
+	})
+	if _, err := mw.Exec(createPanickingHandler(42)).Handle(nil, m.EmptyRequest()); err != expectedErr {
+		t.Errorf("Expected error: \"%s\", got: %v", expectedErr, err)
+	}
+	if recovered != 42 {
+		t.Errorf("Wrong recovered value. Got: %v", recovered)
+	}
+}
+
+func TestRecoverNoPanic(t *testing.T) {
+	resp, err := m.Recover().Exec(createStatusHandler(200)).Handle(nil, m.EmptyRequest())
+	if err != nil || resp == nil || resp.StatusCode != 200 {
+		t.Errorf("Response changed without panic. Got: %v, %v", resp, err)
+	}
+}
@@ -0,0 +1,170 @@
+package cliware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// RedactRules describe which parts of requests and responses are sensitive
+// and must be masked before they are logged or recorded.
+type RedactRules struct {
+	// Headers are names of headers whose values are masked.
+	Headers []string
+	// JSONPaths are dot separated paths of fields in JSON bodies whose values
+	// are masked (e.g. "user.password"). Arrays are traversed, so path
+	// applies to every element of array on the way.
+	JSONPaths []string
+	// Placeholder is value sensitive values are replaced with. Defaults to
+	// "[REDACTED]".
+	Placeholder string
+}
+
+// DefaultRedactRules mask headers that usually carry credentials.
+var DefaultRedactRules = RedactRules{
+	Headers: []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"},
+}
+
+func (r RedactRules) placeholder() string {
+	if r.Placeholder == "" {
+		return "[REDACTED]"
+	}
+	return r.Placeholder
+}
+
+// Header returns copy of header with sensitive values masked.
+func (r RedactRules) Header(h http.Header) http.Header {
+	redacted := cloneHeader(h)
+	for _, name := range r.Headers {
+		name = http.CanonicalHeaderKey(name)
+		if values, ok := redacted[name]; ok {
+			for i := range values {
+				values[i] = r.placeholder()
+			}
+		}
+	}
+	return redacted
+}
+
+// Body returns copy of body with sensitive JSON fields masked. Bodies that
+// are not JSON (by content type), or that can not be decoded, are returned
+// unchanged.
+func (r RedactRules) Body(contentType string, body []byte) []byte {
+	if len(r.JSONPaths) == 0 || len(body) == 0 || !isJSON(contentType) {
+		return body
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return body
+	}
+	for _, path := range r.JSONPaths {
+		value = redactPath(value, strings.Split(path, "."), r.placeholder())
+	}
+	redacted, err := json.Marshal(value)
+	if err != nil {
+		return body
+	}
+	return redacted
+}
+
+// Request returns copy of request with sensitive headers and body fields
+// masked. Body of original request is left intact.
+func (r RedactRules) Request(req *http.Request) (*http.Request, error) {
+	body, err := requestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	redacted := req.WithContext(req.Context())
+	redacted.Header = r.Header(req.Header)
+	if req.Body != nil && req.Body != http.NoBody {
+		setRequestBody(redacted, r.Body(req.Header.Get("Content-Type"), body))
+	}
+	return redacted, nil
+}
+
+// Response returns copy of response with sensitive headers and body fields
+// masked. Body of original response is buffered, so it can still be read.
+func (r RedactRules) Response(resp *http.Response) (*http.Response, error) {
+	body, err := bufferResponseBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	redacted := *resp
+	redacted.Header = r.Header(resp.Header)
+	redacted.Trailer = r.Header(resp.Trailer)
+	if resp.Body != nil {
+		data := r.Body(resp.Header.Get("Content-Type"), body)
+		redacted.Body = ioutil.NopCloser(bytes.NewReader(data))
+		if resp.ContentLength >= 0 {
+			redacted.ContentLength = int64(len(data))
+		}
+	}
+	return &redacted, nil
+}
+
+type redactRulesKey struct{}
+
+// Redact returns middleware that stores redaction rules in context, so that
+// logging and recording middlewares after it can obtain redacted copies of
+// requests and responses (see RedactRulesFromContext). Requests and
+// responses themselves are not changed, so handlers still see real values.
+// Built-in recorders (AsCurl and LogOnErrorContext) use these rules, unless
+// they are configured with own rules.
+func Redact(rules RedactRules) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			return next.Handle(context.WithValue(ensureContext(ctx), redactRulesKey{}, rules), req)
+		})
+	})
+}
+
+// RedactRulesFromContext returns redaction rules stored in context by Redact
+// middleware. If there are none, DefaultRedactRules are returned and ok is
+// false.
+func RedactRulesFromContext(ctx context.Context) (rules RedactRules, ok bool) {
+	if ctx != nil {
+		if rules, ok = ctx.Value(redactRulesKey{}).(RedactRules); ok {
+			return rules, true
+		}
+	}
+	return DefaultRedactRules, false
+}
+
+// redactRulesFor returns rules, if they are not nil, or rules from context
+// (see RedactRulesFromContext).
+func redactRulesFor(ctx context.Context, rules *RedactRules) RedactRules {
+	if rules != nil {
+		return *rules
+	}
+	fromContext, _ := RedactRulesFromContext(ctx)
+	return fromContext
+}
+
+// redactPath replaces value at path with placeholder.
+func redactPath(value interface{}, path []string, placeholder string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(path) == 0 {
+			return value
+		}
+		field, ok := v[path[0]]
+		if !ok {
+			return value
+		}
+		if len(path) == 1 {
+			v[path[0]] = placeholder
+		} else {
+			v[path[0]] = redactPath(field, path[1:], placeholder)
+		}
+	case []interface{}:
+		for i, element := range v {
+			v[i] = redactPath(element, path, placeholder)
+		}
+	}
+	return value
+}
@@ -0,0 +1,82 @@
+package cliware_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+var testRedactRules = m.RedactRules{
+	Headers:   []string{"authorization", "Cookie"},
+	JSONPaths: []string{"password", "items.token", "user.secret"},
+}
+
+func TestRedactRequest(t *testing.T) {
+	var logged string
+	var handled string
+	logger := m.MiddlewareFunc(func(next m.Handler) m.Handler {
+		return m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+			rules, _ := m.RedactRulesFromContext(ctx)
+			redacted, err := rules.Request(req)
+			if err != nil {
+				return nil, err
+			}
+			body, _ := ioutil.ReadAll(redacted.Body)
+			logged = redacted.Header.Get("Authorization") + " " + redacted.Header.Get("Accept") + " " + string(body)
+			return next.Handle(ctx, req)
+		})
+	})
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		body, _ := ioutil.ReadAll(req.Body)
+		handled = req.Header.Get("Authorization") + " " + string(body)
+		return nil, nil
+	})
+
+	body := `{"name":"john","password":"hunter2","items":[{"token":"a"},{"token":"b"}],"user":{"secret":1}}`
+	req, _ := http.NewRequest("POST", "http://localhost/", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	m.NewChain(m.Redact(testRedactRules), logger).Exec(handler).Handle(nil, req)
+
+	expected := `[REDACTED] application/json {"items":[{"token":"[REDACTED]"},{"token":"[REDACTED]"}],"name":"john","password":"[REDACTED]","user":{"secret":"[REDACTED]"}}`
+	if logged != expected {
+		t.Errorf("Wrong redacted request.\nGot:      %s\nExpected: %s", logged, expected)
+	}
+	if handled != "Bearer token "+body {
+		t.Errorf("Real request changed by redaction. Got: %s", handled)
+	}
+}
+
+func TestRedactResponse(t *testing.T) {
+	rules := testRedactRules
+	rules.Placeholder = "***"
+	resp, _ := createBodyHandler("application/json", `{"password":"x"}`).Handle(nil, m.EmptyRequest())
+	resp.Header.Set("Cookie", "session=1")
+	redacted, err := rules.Response(resp)
+	if err != nil {
+		t.Fatal("Response returned error: ", err)
+	}
+	if got := readBody(t, redacted); got != `{"password":"***"}` || redacted.Header.Get("Cookie") != "***" {
+		t.Errorf("Wrong redacted response. Got: %s %v", got, redacted.Header)
+	}
+	if got := readBody(t, resp); got != `{"password":"x"}` || resp.Header.Get("Cookie") != "session=1" {
+		t.Errorf("Real response changed by redaction. Got: %s %v", got, resp.Header)
+	}
+}
+
+func TestRedactNonJSONBody(t *testing.T) {
+	if got := testRedactRules.Body("text/plain", []byte("password=x")); string(got) != "password=x" {
+		t.Errorf("Non-JSON body changed. Got: %s", got)
+	}
+	if got := testRedactRules.Body("application/json", []byte("{broken")); string(got) != "{broken" {
+		t.Errorf("Invalid JSON body changed. Got: %s", got)
+	}
+	if _, ok := m.RedactRulesFromContext(nil); ok {
+		t.Error("Rules found in nil context.")
+	}
+}
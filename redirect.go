@@ -0,0 +1,201 @@
+package cliware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// FollowRedirects returns middleware that follows redirect responses by
+// sending request to location server redirected to, at most max times.
+// It is useful when terminal handler does not follow redirects on its own
+// (e.g. it uses http.RoundTripper directly).
+//
+// For 301, 302 and 303 responses to request with method other than GET or
+// HEAD, redirected request is sent as GET without body, same as browsers do.
+// For 307 and 308 method and body are preserved, so body is buffered before
+// first request is sent. Authorization and Cookie headers are removed when
+// redirect points to different host.
+//
+// If DetectRedirectLoops middleware is placed before this one, every redirect
+// is checked for loops too.
+func FollowRedirects(max int) Middleware {
+	return &redirectFollower{max: max}
+}
+
+// redirectFollower is middleware returned by FollowRedirects.
+type redirectFollower struct {
+	max int
+}
+
+// Exec is implementation of Middleware interface.
+func (rf *redirectFollower) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		ctx = ensureContext(ctx)
+		if _, err = requestBody(req); err != nil {
+			return nil, err
+		}
+		for redirects := 0; ; redirects++ {
+			resp, err = next.Handle(ctx, req)
+			if err != nil || resp == nil || !isRedirect(resp.StatusCode) {
+				return resp, err
+			}
+			location := resp.Header.Get("Location")
+			if location == "" {
+				return resp, err
+			}
+			if redirects >= rf.max {
+				drainAndClose(resp)
+				return nil, fmt.Errorf("cliware: stopped after %d redirects", rf.max)
+			}
+			target, parseErr := req.URL.Parse(location)
+			if parseErr != nil {
+				drainAndClose(resp)
+				return nil, fmt.Errorf("cliware: failed to parse redirect location %q: %s", location, parseErr)
+			}
+			if log, ok := ctx.Value(redirectLogKey{}).(*redirectLog); ok {
+				if err = log.visit(req.URL, target); err != nil {
+					drainAndClose(resp)
+					return nil, err
+				}
+			}
+			drainAndClose(resp)
+			req, err = redirectRequest(req, resp.StatusCode, target)
+			if err != nil {
+				return nil, err
+			}
+		}
+	})
+}
+
+// isRedirect returns true if status code is one of redirect status codes
+// FollowRedirects follows.
+func isRedirect(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// redirectRequest creates request that should be sent to follow redirect.
+func redirectRequest(req *http.Request, code int, target *url.URL) (*http.Request, error) {
+	redirected := req.WithContext(req.Context())
+	redirected.URL = target
+	redirected.Host = ""
+	redirected.Header = make(http.Header, len(req.Header))
+	for name, values := range req.Header {
+		redirected.Header[name] = append([]string(nil), values...)
+	}
+	if target.Host != req.URL.Host {
+		redirected.Header.Del("Authorization")
+		redirected.Header.Del("Cookie")
+	}
+
+	preserveMethod := code == http.StatusTemporaryRedirect || code == http.StatusPermanentRedirect
+	if !preserveMethod && req.Method != "GET" && req.Method != "HEAD" {
+		redirected.Method = "GET"
+		redirected.Body = nil
+		redirected.GetBody = nil
+		redirected.ContentLength = 0
+		redirected.Header.Del("Content-Type")
+		redirected.Header.Del("Content-Length")
+		return redirected, nil
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		redirected.Body = body
+	}
+	return redirected, nil
+}
+
+// RedirectLoopError is error returned when redirect loop is detected.
+type RedirectLoopError struct {
+	// URLs contains redirects that form loop, in order of visiting.
+	URLs []string
+}
+
+// Error is implementation of error interface.
+func (e *RedirectLoopError) Error() string {
+	return "cliware: redirect loop detected: " + strings.Join(e.URLs, " -> ")
+}
+
+// DetectRedirectLoops returns middleware that detects redirect loops while
+// FollowRedirects middleware follows redirects. It returns
+// *RedirectLoopError if same URL is visited twice while following redirects
+// of single request, or if more than maxPerHost redirects point to the same
+// host (zero maxPerHost disables this check). This catches loops that are too
+// long to be stopped by redirect count alone.
+//
+// It tracks visited URLs in context, so it has to be placed before
+// FollowRedirects middleware in chain.
+func DetectRedirectLoops(maxPerHost int) Middleware {
+	return &redirectLoopDetector{maxPerHost: maxPerHost}
+}
+
+// redirectLoopDetector is middleware returned by DetectRedirectLoops.
+type redirectLoopDetector struct {
+	maxPerHost int
+}
+
+// Exec is implementation of Middleware interface.
+func (rld *redirectLoopDetector) Exec(next Handler) Handler {
+	return ContextProcessor(func(ctx context.Context) context.Context {
+		return context.WithValue(ensureContext(ctx), redirectLogKey{}, &redirectLog{
+			maxPerHost: rld.maxPerHost,
+			perHost:    make(map[string]int),
+		})
+	}).Exec(next)
+}
+
+// Validate is implementation of Validator interface. It reports error if
+// there is no FollowRedirects middleware after this one.
+func (rld *redirectLoopDetector) Validate(middlewares []Middleware, index int) error {
+	for _, m := range middlewares[index+1:] {
+		if _, ok := m.(*redirectFollower); ok {
+			return nil
+		}
+	}
+	return errors.New("cliware: DetectRedirectLoops has no effect without FollowRedirects after it")
+}
+
+type redirectLogKey struct{}
+
+// redirectLog holds URLs visited while following redirects of single request.
+type redirectLog struct {
+	mu         sync.Mutex
+	maxPerHost int
+	visited    []string
+	perHost    map[string]int
+}
+
+// visit records redirect from one URL to another and returns error if loop
+// is detected.
+func (rl *redirectLog) visit(from, to *url.URL) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if len(rl.visited) == 0 {
+		rl.visited = append(rl.visited, from.String())
+	}
+	target := to.String()
+	for i, visited := range rl.visited {
+		if visited == target {
+			cycle := append(append([]string(nil), rl.visited[i:]...), target)
+			return &RedirectLoopError{URLs: cycle}
+		}
+	}
+	rl.visited = append(rl.visited, target)
+	rl.perHost[to.Host]++
+	if rl.maxPerHost > 0 && rl.perHost[to.Host] > rl.maxPerHost {
+		return &RedirectLoopError{URLs: append([]string(nil), rl.visited...)}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package cliware_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+// transportHandler sends requests using default transport, which does not
+// follow redirects.
+var transportHandler = m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+	return http.DefaultTransport.RoundTrip(req.WithContext(ctx))
+})
+
+func createRedirectServer(redirects map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target, ok := redirects[r.URL.Path]; ok {
+			http.Redirect(w, r, target, http.StatusFound)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(r.Method + " " + r.URL.Path + " " + string(body)))
+	}))
+}
+
+func TestFollowRedirects(t *testing.T) {
+	server := createRedirectServer(map[string]string{"/a": "/b", "/b": "/c"})
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", server.URL+"/a", strings.NewReader("data"))
+	resp, err := m.FollowRedirects(5).Exec(transportHandler).Handle(context.Background(), req)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "GET /c " {
+		t.Errorf("Wrong response. Got: %q, expected: \"GET /c \"", body)
+	}
+}
+
+func TestFollowRedirectsPreservesBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/a" {
+			http.Redirect(w, r, "/b", http.StatusTemporaryRedirect)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(r.Method + " " + string(body)))
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", server.URL+"/a", ioutil.NopCloser(strings.NewReader("data")))
+	resp, err := m.FollowRedirects(5).Exec(transportHandler).Handle(context.Background(), req)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "POST data" {
+		t.Errorf("Wrong response. Got: %q, expected: \"POST data\"", body)
+	}
+}
+
+func TestFollowRedirectsMax(t *testing.T) {
+	server := createRedirectServer(map[string]string{"/a": "/b", "/b": "/c"})
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/a", nil)
+	_, err := m.FollowRedirects(1).Exec(transportHandler).Handle(context.Background(), req)
+	if err == nil {
+		t.Error("Expected error when max redirects is exceeded.")
+	}
+}
+
+func TestDetectRedirectLoops(t *testing.T) {
+	server := createRedirectServer(map[string]string{"/a": "/b", "/b": "/c", "/c": "/b"})
+	defer server.Close()
+
+	chain := m.NewChain(m.DetectRedirectLoops(0), m.FollowRedirects(100))
+	req, _ := http.NewRequest("GET", server.URL+"/a", nil)
+	_, err := chain.Exec(transportHandler).Handle(context.Background(), req)
+	loopErr, ok := err.(*m.RedirectLoopError)
+	if !ok {
+		t.Fatalf("Expected *RedirectLoopError, got: %v", err)
+	}
+	expected := []string{server.URL + "/b", server.URL + "/c", server.URL + "/b"}
+	if strings.Join(loopErr.URLs, " ") != strings.Join(expected, " ") {
+		t.Errorf("Wrong loop reported. Got: %v, expected: %v", loopErr.URLs, expected)
+	}
+}
+
+func TestDetectRedirectLoopsPerHost(t *testing.T) {
+	server := createRedirectServer(map[string]string{"/a": "/b", "/b": "/c", "/c": "/d"})
+	defer server.Close()
+
+	chain := m.NewChain(m.DetectRedirectLoops(2), m.FollowRedirects(100))
+	req, _ := http.NewRequest("GET", server.URL+"/a", nil)
+	_, err := chain.Exec(transportHandler).Handle(context.Background(), req)
+	if _, ok := err.(*m.RedirectLoopError); !ok {
+		t.Errorf("Expected *RedirectLoopError, got: %v", err)
+	}
+}
+
+func TestDetectRedirectLoopsValidate(t *testing.T) {
+	if err := m.NewChain(m.DetectRedirectLoops(0), m.FollowRedirects(10)).Validate(); err != nil {
+		t.Error("Validate returned error: ", err)
+	}
+	if err := m.NewChain(m.FollowRedirects(10), m.DetectRedirectLoops(0)).Validate(); err == nil {
+		t.Error("Expected validation error when FollowRedirects is missing.")
+	}
+}
+
+func TestDetectRedirectLoopsValidateWrapped(t *testing.T) {
+	always := func(req *http.Request) bool { return true }
+	for _, follower := range []m.Middleware{
+		m.Named("follow", m.FollowRedirects(5)),
+		m.When(always, m.FollowRedirects(5)),
+		m.NewChain(m.Named("follow", m.FollowRedirects(5))),
+	} {
+		chain := m.NewChain(m.DetectRedirectLoops(0), follower)
+		chain.SetStrict(true)
+		if err := chain.Validate(); err != nil {
+			t.Error("Validate returned error for wrapped FollowRedirects: ", err)
+		}
+	}
+	if err := m.NewChain(m.Named("loops", m.DetectRedirectLoops(0))).Validate(); err == nil {
+		t.Error("Expected validation error for named DetectRedirectLoops without FollowRedirects.")
+	}
+}
@@ -0,0 +1,198 @@
+package cliware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Retry returns middleware that sends request up to attempts times, for as
+// long as retryable returns true for response and error of previous attempt.
+// Before every retry it waits for delay returned by backoff (no delay if
+// backoff is nil), and if context is done while waiting, context error is
+// returned. If retryable is nil, requests that fail with error or with 5xx
+// status code are retried. Every attempt is marked with its number (see
+// WithAttempt).
+//
+// Request body is rewound before every retry using GetBody. If request has
+// body but GetBody is not set, body is buffered first. Body of response that
+// is retried is drained and closed, so connection can be reused, while last
+// response is returned as it is. If attempt returns ErrInsufficientDeadline
+// (see RespectDeadline), or error wrapping it, retrying stops and result of
+// previous attempt is returned. Number of retries can be limited using
+// RetryBudget.
+func Retry(attempts int, backoff Backoff, retryable func(*http.Response, error) bool) Middleware {
+	if retryable == nil {
+		retryable = failedRequest
+	}
+	return &retrier{attempts: attempts, backoff: backoff, retryable: retryable}
+}
+
+// retrier is middleware returned by Retry.
+type retrier struct {
+	attempts  int
+	backoff   Backoff
+	retryable func(*http.Response, error) bool
+}
+
+// Exec is implementation of Middleware interface.
+func (r *retrier) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		ctx = ensureContext(ctx)
+		if req != nil && req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			if _, err = bufferRequestBody(req); err != nil {
+				return nil, err
+			}
+		}
+		for attempt := 1; ; attempt++ {
+			if attempt > 1 {
+				if err := rewindForRetry(ctx, req, r.backoff, attempt); err != nil {
+					drainAndClose(resp)
+					return nil, err
+				}
+			}
+			attemptResp, attemptErr := next.Handle(WithAttempt(ctx, attempt), req)
+			if attempt > 1 {
+				if errors.Is(attemptErr, ErrInsufficientDeadline) {
+					return resp, err
+				}
+				drainAndClose(resp)
+			}
+			resp, err = attemptResp, attemptErr
+			if attempt >= r.attempts || !r.retryable(resp, err) {
+				return resp, err
+			}
+			if budget, ok := ctx.Value(retryBudgetKey{}).(*RetryBucket); ok && !budget.take(req) {
+				return resp, err
+			}
+		}
+	})
+}
+
+// rewindForRetry waits for backoff delay of provided attempt and rewinds
+// request body.
+func rewindForRetry(ctx context.Context, req *http.Request, backoff Backoff, attempt int) error {
+	var delay time.Duration
+	if backoff != nil {
+		delay = backoff(attempt)
+	}
+	if err := sleep(ctx, delay); err != nil {
+		return err
+	}
+	if req == nil || req.GetBody == nil {
+		return nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+	req.Body = body
+	return nil
+}
+
+type retryBudgetKey struct{}
+
+// RetryBucket is middleware that limits number of retries using token
+// bucket. It is created using RetryBudget function.
+type RetryBucket struct {
+	// PerHost makes bucket keep separate tokens for each host. It must be
+	// set before bucket is used.
+	PerHost bool
+
+	max  int
+	cost int
+
+	mu     sync.Mutex
+	shared *int
+	hosts  map[string]*int
+}
+
+// RetryBudget returns middleware that limits retries made by Retry
+// middleware after it, similarly to adaptive retry mode of AWS SDK, so
+// retries do not amplify load of server during outage. Budget is token
+// bucket that starts full, with maxTokens tokens. Every retry takes
+// costPerRetry tokens and every request that succeeds (i.e. does not fail
+// with error or 5xx status code) puts one token back, up to maxTokens. When
+// there are not enough tokens for retry, Retry returns result of last
+// attempt instead of retrying, which caps ratio of retries to successful
+// requests.
+//
+// Tokens are shared by all requests going through returned middleware,
+// unless PerHost is set. Requests without URL share the same tokens even
+// then.
+func RetryBudget(maxTokens, costPerRetry int) *RetryBucket {
+	shared := maxTokens
+	return &RetryBucket{
+		max:    maxTokens,
+		cost:   costPerRetry,
+		shared: &shared,
+		hosts:  make(map[string]*int),
+	}
+}
+
+// Tokens returns current number of tokens shared by all hosts. It should only
+// be used if PerHost is not set.
+func (rb *RetryBucket) Tokens() int {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return *rb.shared
+}
+
+// HostTokens returns current number of tokens of provided host. It should
+// only be used if PerHost is set.
+func (rb *RetryBucket) HostTokens(host string) int {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return *rb.tokens(host)
+}
+
+// Exec is implementation of Middleware interface.
+func (rb *RetryBucket) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		resp, err = next.Handle(context.WithValue(ensureContext(ctx), retryBudgetKey{}, rb), req)
+		if !failedRequest(resp, err) {
+			rb.mu.Lock()
+			if tokens := rb.bucket(req); *tokens < rb.max {
+				*tokens++
+			}
+			rb.mu.Unlock()
+		}
+		return resp, err
+	})
+}
+
+// take takes tokens for retry of request and returns true if there were
+// enough of them.
+func (rb *RetryBucket) take(req *http.Request) bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	tokens := rb.bucket(req)
+	if *tokens < rb.cost {
+		return false
+	}
+	*tokens -= rb.cost
+	return true
+}
+
+// bucket returns tokens for provided request. It must be called with lock
+// held.
+func (rb *RetryBucket) bucket(req *http.Request) *int {
+	if !rb.PerHost || req == nil || req.URL == nil {
+		return rb.shared
+	}
+	return rb.tokens(req.URL.Host)
+}
+
+// tokens returns tokens of provided host, creating full bucket for it if it
+// does not exist. It must be called with lock held.
+func (rb *RetryBucket) tokens(host string) *int {
+	tokens, ok := rb.hosts[host]
+	if !ok {
+		max := rb.max
+		tokens = &max
+		rb.hosts[host] = tokens
+	}
+	return tokens
+}
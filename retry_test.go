@@ -0,0 +1,215 @@
+package cliware_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	m "go.delic.rs/cliware"
+)
+
+// trackedBody is response body that records if it is closed.
+type trackedBody struct {
+	io.Reader
+	closed int32
+}
+
+func (tb *trackedBody) Close() error {
+	atomic.StoreInt32(&tb.closed, 1)
+	return nil
+}
+
+func (tb *trackedBody) isClosed() bool {
+	return atomic.LoadInt32(&tb.closed) == 1
+}
+
+// retryAttempt records request received by handler on single attempt.
+type retryAttempt struct {
+	number int
+	body   string
+}
+
+// createFlakyHandler returns handler that responds with provided status
+// codes, one per call, and records received attempts and response bodies.
+func createFlakyHandler(codes ...int) (m.Handler, *[]retryAttempt, *[]*trackedBody) {
+	var attempts []retryAttempt
+	var bodies []*trackedBody
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		var body []byte
+		if req.Body != nil {
+			body, _ = ioutil.ReadAll(req.Body)
+		}
+		attempts = append(attempts, retryAttempt{m.Attempt(ctx), string(body)})
+		code := codes[len(attempts)-1]
+		if code == 0 {
+			return nil, errors.New("connection reset")
+		}
+		tracked := &trackedBody{Reader: strings.NewReader("response")}
+		bodies = append(bodies, tracked)
+		return &http.Response{StatusCode: code, Body: tracked}, nil
+	})
+	return handler, &attempts, &bodies
+}
+
+func newBodyRequest(body string) *http.Request {
+	req, _ := http.NewRequest("POST", "http://localhost/", ioutil.NopCloser(strings.NewReader(body)))
+	return req
+}
+
+func TestRetry(t *testing.T) {
+	handler, attempts, bodies := createFlakyHandler(503, 0, 200)
+	resp, err := m.Retry(5, nil, nil).Exec(handler).Handle(nil, newBodyRequest("payload"))
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatalf("Wrong result. Got: %v, %v", resp, err)
+	}
+	expected := []retryAttempt{{1, "payload"}, {2, "payload"}, {3, "payload"}}
+	if len(*attempts) != len(expected) {
+		t.Fatalf("Wrong number of attempts. Got: %d, expected: %d", len(*attempts), len(expected))
+	}
+	for i, attempt := range *attempts {
+		if attempt != expected[i] {
+			t.Errorf("Wrong attempt %d. Got: %+v, expected: %+v", i+1, attempt, expected[i])
+		}
+	}
+	if !(*bodies)[0].isClosed() {
+		t.Error("Body of retried response not closed.")
+	}
+	if (*bodies)[1].isClosed() {
+		t.Error("Body of returned response closed.")
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	handler, attempts, _ := createFlakyHandler(500, 502, 503, 200)
+	var delays []int
+	backoff := func(attempt int) time.Duration {
+		delays = append(delays, attempt)
+		return time.Millisecond
+	}
+	resp, _ := m.Retry(3, backoff, nil).Exec(handler).Handle(nil, m.EmptyRequest())
+	if len(*attempts) != 3 || resp.StatusCode != 503 {
+		t.Errorf("Wrong result. Attempts: %d, status: %d", len(*attempts), resp.StatusCode)
+	}
+	if len(delays) != 2 || delays[0] != 2 || delays[1] != 3 {
+		t.Errorf("Backoff called for wrong attempts: %v", delays)
+	}
+}
+
+func TestRetryNotRetryable(t *testing.T) {
+	handler, attempts, _ := createFlakyHandler(500, 200)
+	never := func(resp *http.Response, err error) bool { return false }
+	resp, _ := m.Retry(3, nil, never).Exec(handler).Handle(nil, m.EmptyRequest())
+	if len(*attempts) != 1 || resp.StatusCode != 500 {
+		t.Errorf("Request retried. Attempts: %d, status: %d", len(*attempts), resp.StatusCode)
+	}
+}
+
+func TestRetryCancelledBackoff(t *testing.T) {
+	handler, attempts, bodies := createFlakyHandler(500, 200)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	backoff := func(int) time.Duration { return time.Minute }
+	resp, err := m.Retry(3, backoff, nil).Exec(handler).Handle(ctx, m.EmptyRequest())
+	if resp != nil || err != context.DeadlineExceeded {
+		t.Errorf("Expected error: \"%s\", got: %v, %v", context.DeadlineExceeded, resp, err)
+	}
+	if len(*attempts) != 1 || !(*bodies)[0].isClosed() {
+		t.Error("Request retried or response body not closed after cancellation.")
+	}
+}
+
+func TestRetryInsufficientDeadline(t *testing.T) {
+	var calls int
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		calls++
+		if m.Attempt(ctx) > 1 {
+			return nil, m.ErrInsufficientDeadline
+		}
+		return nil, errors.New("first failure")
+	})
+	_, err := m.Retry(5, nil, nil).Exec(handler).Handle(nil, m.EmptyRequest())
+	if err == nil || err.Error() != "first failure" {
+		t.Errorf("Expected error of first attempt, got: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("Retrying not stopped. Handler calls: %d, expected: 2", calls)
+	}
+}
+
+func TestRetryInsufficientDeadlineNamed(t *testing.T) {
+	var calls int
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		calls++
+		time.Sleep(100 * time.Millisecond)
+		return &http.Response{StatusCode: 503, Header: make(http.Header), Body: http.NoBody}, nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	chain := m.NewChain(m.Retry(3, nil, nil), m.Named("deadline", m.RespectDeadline()))
+	resp, err := chain.Exec(handler).Handle(ctx, m.EmptyRequest())
+	if err != nil || resp == nil || resp.StatusCode != 503 {
+		t.Errorf("Expected response of first attempt, got: %v, %v", resp, err)
+	}
+	if calls != 1 {
+		t.Errorf("Retrying not stopped. Handler calls: %d, expected: 1", calls)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	var calls int
+	failing := true
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		calls++
+		if failing {
+			return &http.Response{StatusCode: 503, Body: http.NoBody}, nil
+		}
+		return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+	})
+	budget := m.RetryBudget(10, 5)
+	h := m.NewChain(budget, m.Retry(5, nil, nil)).Exec(handler)
+
+	h.Handle(nil, m.EmptyRequest())
+	if calls != 3 || budget.Tokens() != 0 {
+		t.Errorf("Retries not limited. Handler calls: %d, tokens: %d", calls, budget.Tokens())
+	}
+	h.Handle(nil, m.EmptyRequest())
+	if calls != 4 {
+		t.Errorf("Request retried with empty budget. Handler calls: %d, expected: 4", calls)
+	}
+
+	failing = false
+	for i := 0; i < 20; i++ {
+		h.Handle(nil, m.EmptyRequest())
+	}
+	if budget.Tokens() != 10 {
+		t.Errorf("Tokens not refilled up to max. Got: %d, expected: 10", budget.Tokens())
+	}
+}
+
+func TestRetryBudgetPerHost(t *testing.T) {
+	handler, _, _ := createFlakyHandler(500, 500, 500, 500, 500, 500)
+	budget := m.RetryBudget(4, 2)
+	budget.PerHost = true
+	h := m.NewChain(budget, m.Retry(3, nil, nil)).Exec(handler)
+	req, _ := http.NewRequest("GET", "http://first.example.com/", nil)
+	h.Handle(nil, req)
+	if budget.HostTokens("first.example.com") != 0 || budget.HostTokens("second.example.com") != 4 {
+		t.Errorf("Tokens not tracked per host. First: %d, second: %d",
+			budget.HostTokens("first.example.com"), budget.HostTokens("second.example.com"))
+	}
+}
+
+func TestRetryBudgetPerHostWithoutURL(t *testing.T) {
+	handler, called := createHandler()
+	budget := m.RetryBudget(4, 2)
+	budget.PerHost = true
+	if _, err := budget.Exec(handler).Handle(nil, &http.Request{Method: "GET"}); err != nil || !*called {
+		t.Errorf("Request without URL not passed to handler. Error: %v", err)
+	}
+}
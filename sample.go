@@ -0,0 +1,117 @@
+package cliware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// SizeDimension selects which size SampleBySize compares to threshold.
+type SizeDimension int
+
+const (
+	// RequestSize samples by size of request body.
+	RequestSize SizeDimension = iota
+	// ResponseSize samples by size of response body.
+	ResponseSize
+	// AnySize samples by size of either request or response body.
+	AnySize
+)
+
+type sizeSampleKey struct{}
+
+// sizeSample is sampling state of single request.
+type sizeSample struct {
+	minBytes      int64
+	dimension     SizeDimension
+	requestSize   int64
+	responseBytes int64
+}
+
+// SampleBySize returns middleware that marks requests for sampling by
+// observability middlewares after it, based on size of request or response
+// body (selected by dimension). Marked are only requests whose size is at
+// least minBytes, on the theory that large transfers are the interesting
+// ones. This is orthogonal to sampling based on rate.
+//
+// Middlewares that record requests should check SampledBySize before
+// recording. Size of response is taken from Content-Length and, if it is not
+// known, counted while body is read.
+func SampleBySize(minBytes int64, dimension SizeDimension) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			sample := &sizeSample{minBytes: minBytes, dimension: dimension}
+			if req != nil {
+				sample.requestSize = req.ContentLength
+				if sample.requestSize <= 0 {
+					body, err := requestBody(req)
+					if err != nil {
+						return nil, err
+					}
+					sample.requestSize = int64(len(body))
+				}
+			}
+			ctx = context.WithValue(ensureContext(ctx), sizeSampleKey{}, sample)
+			resp, err = next.Handle(ctx, req)
+			if err != nil || resp == nil || resp.Body == nil || dimension == RequestSize {
+				return resp, err
+			}
+			if resp.ContentLength < 0 {
+				resp.Body = &countingBody{body: resp.Body, sample: sample}
+			}
+			return resp, err
+		})
+	})
+}
+
+// SampledBySize reports whether request sent with provided context, and its
+// response (if any), should be sampled according to SampleBySize middleware
+// before it. If context does not carry sampling state, true is returned, so
+// everything is recorded when sampling is not used. For response of unknown
+// length, bytes read so far are used, so it should be checked once body is
+// read (e.g. when it is closed).
+func SampledBySize(ctx context.Context, resp *http.Response) bool {
+	if ctx == nil {
+		return true
+	}
+	sample, ok := ctx.Value(sizeSampleKey{}).(*sizeSample)
+	if !ok {
+		return true
+	}
+	requestSampled := sample.requestSize >= sample.minBytes
+	responseSampled := false
+	if resp != nil {
+		size := resp.ContentLength
+		if size < 0 {
+			size = atomic.LoadInt64(&sample.responseBytes)
+		}
+		responseSampled = size >= sample.minBytes
+	}
+	switch sample.dimension {
+	case RequestSize:
+		return requestSampled
+	case ResponseSize:
+		return responseSampled
+	default:
+		return requestSampled || responseSampled
+	}
+}
+
+// countingBody counts bytes read from response body of unknown length.
+type countingBody struct {
+	body   io.ReadCloser
+	sample *sizeSample
+}
+
+// Read is implementation of io.Reader interface.
+func (cb *countingBody) Read(p []byte) (int, error) {
+	n, err := cb.body.Read(p)
+	atomic.AddInt64(&cb.sample.responseBytes, int64(n))
+	return n, err
+}
+
+// Close is implementation of io.Closer interface.
+func (cb *countingBody) Close() error {
+	return cb.body.Close()
+}
@@ -0,0 +1,90 @@
+package cliware_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+// createSampleRecorder returns middleware that records result of
+// SampledBySize when response body is closed.
+func createSampleRecorder(sampled *bool) m.Middleware {
+	return m.MiddlewareFunc(func(next m.Handler) m.Handler {
+		return m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+			resp, err := next.Handle(ctx, req)
+			if err != nil {
+				return resp, err
+			}
+			resp.Body = &closeHook{ReadCloser: resp.Body, onClose: func() {
+				*sampled = m.SampledBySize(ctx, resp)
+			}}
+			return resp, err
+		})
+	})
+}
+
+type closeHook struct {
+	io.ReadCloser
+	onClose func()
+}
+
+func (ch *closeHook) Close() error {
+	err := ch.ReadCloser.Close()
+	ch.onClose()
+	return err
+}
+
+func createSizedHandler(size int, contentLength int64) m.Handler {
+	return m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode:    200,
+			Header:        make(http.Header),
+			Body:          ioutil.NopCloser(strings.NewReader(strings.Repeat("x", size))),
+			ContentLength: contentLength,
+		}, nil
+	})
+}
+
+func TestSampleBySize(t *testing.T) {
+	for _, data := range []struct {
+		dimension     m.SizeDimension
+		requestSize   int
+		responseSize  int
+		contentLength int64
+		expected      bool
+	}{
+		{m.ResponseSize, 0, 2000, 2000, true},
+		{m.ResponseSize, 0, 10, 10, false},
+		{m.ResponseSize, 0, 2000, -1, true},
+		{m.ResponseSize, 0, 10, -1, false},
+		{m.ResponseSize, 2000, 10, 10, false},
+		{m.RequestSize, 2000, 10, 10, true},
+		{m.RequestSize, 10, 2000, 2000, false},
+		{m.AnySize, 10, 2000, -1, true},
+		{m.AnySize, 2000, 10, 10, true},
+		{m.AnySize, 10, 10, 10, false},
+	} {
+		var sampled bool
+		chain := m.NewChain(m.SampleBySize(1000, data.dimension), createSampleRecorder(&sampled))
+		req, _ := http.NewRequest("POST", "http://localhost/", strings.NewReader(strings.Repeat("x", data.requestSize)))
+		resp, err := chain.Exec(createSizedHandler(data.responseSize, data.contentLength)).Handle(nil, req)
+		if err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+		readBody(t, resp)
+		if sampled != data.expected {
+			t.Errorf("Wrong sampling for %+v. Got: %t", data, sampled)
+		}
+	}
+}
+
+func TestSampledBySizeWithoutSampling(t *testing.T) {
+	if !m.SampledBySize(context.Background(), nil) || !m.SampledBySize(nil, nil) {
+		t.Error("Requests should be sampled when SampleBySize is not used.")
+	}
+}
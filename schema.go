@@ -0,0 +1,71 @@
+package cliware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// SchemaVersionError is error returned when schema version of response does
+// not match expected one.
+type SchemaVersionError struct {
+	// Header is name of header version is read from.
+	Header string
+	// Expected is version client expects.
+	Expected string
+	// Actual is version of response. It is empty if header is missing.
+	Actual string
+}
+
+// Error is implementation of error interface.
+func (e *SchemaVersionError) Error() string {
+	if e.Actual == "" {
+		return fmt.Sprintf("cliware: response has no %s header, expected schema version %q", e.Header, e.Expected)
+	}
+	return fmt.Sprintf("cliware: response schema version %q does not match expected version %q", e.Actual, e.Expected)
+}
+
+// SchemaChecker is middleware that checks schema version of responses. It is
+// created using CheckSchemaVersion function.
+type SchemaChecker struct {
+	// Warn, if set, is called for mismatched versions instead of returning
+	// error, so mismatch can be logged without failing requests.
+	Warn func(ctx context.Context, resp *http.Response, err *SchemaVersionError)
+	// AllowMissing makes checker accept responses without version header.
+	AllowMissing bool
+
+	expected string
+	header   string
+}
+
+// CheckSchemaVersion returns middleware that compares value of provided
+// header of responses with version of schema client was built against and
+// returns *SchemaVersionError, along with response, if they differ. This
+// gives early warning about incompatible changes deployed to server. Values
+// are compared exactly, after surrounding whitespace is trimmed. Responses
+// without header are treated as mismatched, unless AllowMissing is set. If
+// Warn is set, it is called instead of returning error.
+func CheckSchemaVersion(expected string, header string) *SchemaChecker {
+	return &SchemaChecker{expected: expected, header: header}
+}
+
+// Exec is implementation of Middleware interface.
+func (sc *SchemaChecker) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		resp, err = next.Handle(ctx, req)
+		if err != nil || resp == nil {
+			return resp, err
+		}
+		actual := strings.TrimSpace(resp.Header.Get(sc.header))
+		if actual == sc.expected || (actual == "" && sc.AllowMissing) {
+			return resp, err
+		}
+		mismatch := &SchemaVersionError{Header: sc.header, Expected: sc.expected, Actual: actual}
+		if sc.Warn != nil {
+			sc.Warn(ctx, resp, mismatch)
+			return resp, err
+		}
+		return resp, mismatch
+	})
+}
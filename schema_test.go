@@ -0,0 +1,58 @@
+package cliware_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func createVersionHandler(version string) m.Handler {
+	return m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		resp := &http.Response{StatusCode: 200, Header: make(http.Header)}
+		if version != "" {
+			resp.Header.Set("X-Schema-Version", version)
+		}
+		return resp, nil
+	})
+}
+
+func TestCheckSchemaVersion(t *testing.T) {
+	checker := m.CheckSchemaVersion("2024-01", "X-Schema-Version")
+	if _, err := checker.Exec(createVersionHandler(" 2024-01")).Handle(nil, m.EmptyRequest()); err != nil {
+		t.Errorf("Matching version rejected: %s", err)
+	}
+
+	resp, err := checker.Exec(createVersionHandler("2025-06")).Handle(nil, m.EmptyRequest())
+	versionErr, ok := err.(*m.SchemaVersionError)
+	if !ok {
+		t.Fatalf("Expected *SchemaVersionError, got: %v", err)
+	}
+	if versionErr.Actual != "2025-06" || versionErr.Expected != "2024-01" || resp == nil {
+		t.Errorf("Wrong mismatch reported: %+v, response: %v", versionErr, resp)
+	}
+
+	if _, err = checker.Exec(createVersionHandler("")).Handle(nil, m.EmptyRequest()); err == nil {
+		t.Error("Response without version accepted.")
+	}
+	checker.AllowMissing = true
+	if _, err = checker.Exec(createVersionHandler("")).Handle(nil, m.EmptyRequest()); err != nil {
+		t.Errorf("Response without version rejected: %s", err)
+	}
+}
+
+func TestCheckSchemaVersionWarn(t *testing.T) {
+	var warnings []*m.SchemaVersionError
+	checker := m.CheckSchemaVersion("v1", "X-Schema-Version")
+	checker.Warn = func(ctx context.Context, resp *http.Response, err *m.SchemaVersionError) {
+		warnings = append(warnings, err)
+	}
+	h := checker.Exec(createVersionHandler("v2"))
+	if _, err := h.Handle(nil, m.EmptyRequest()); err != nil {
+		t.Errorf("Error returned in warning mode: %s", err)
+	}
+	if len(warnings) != 1 || warnings[0].Actual != "v2" {
+		t.Errorf("Wrong warnings: %v", warnings)
+	}
+}
@@ -0,0 +1,121 @@
+package cliware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SecretStore is store of secrets, like Vault, environment variables or
+// files, that FromSecretStore reads secrets from.
+type SecretStore interface {
+	// Get returns value of secret with provided name.
+	Get(ctx context.Context, name string) (string, error)
+}
+
+// SecretStoreFunc is adapter that allows ordinary function to be used as
+// SecretStore.
+type SecretStoreFunc func(ctx context.Context, name string) (string, error)
+
+// Get is implementation of SecretStore interface.
+func (f SecretStoreFunc) Get(ctx context.Context, name string) (string, error) {
+	return f(ctx, name)
+}
+
+// EnvSecretStore returns SecretStore that reads secrets from environment
+// variables with provided prefix followed by name of secret. Error is
+// returned for variables that are not set.
+func EnvSecretStore(prefix string) SecretStore {
+	return SecretStoreFunc(func(ctx context.Context, name string) (string, error) {
+		value, ok := os.LookupEnv(prefix + name)
+		if !ok {
+			return "", fmt.Errorf("cliware: environment variable %q is not set", prefix+name)
+		}
+		return value, nil
+	})
+}
+
+// SecretInjector is middleware that sets headers of requests to secrets read
+// from SecretStore. It is created using FromSecretStore function.
+type SecretInjector struct {
+	// TTL is duration for which secrets read from store are cached. Defaults
+	// to 5 minutes. If zero, store is read for every request.
+	TTL time.Duration
+
+	store   SecretStore
+	headers []string
+	secrets map[string]string
+
+	mu    sync.Mutex
+	cache map[string]cachedSecret
+}
+
+// cachedSecret is secret value cached until it expires.
+type cachedSecret struct {
+	value   string
+	expires time.Time
+}
+
+// FromSecretStore returns middleware that sets headers of requests to values
+// of secrets read from store, according to mappings of header names to names
+// of secrets, so secrets do not have to be hard-coded or passed around.
+// Headers are overwritten if they are already set. If reading any of secrets
+// fails, its error is returned and request is not sent. Secrets are cached
+// for TTL, so store is not read for every request.
+func FromSecretStore(store SecretStore, mappings map[string]string) *SecretInjector {
+	si := &SecretInjector{
+		TTL:     5 * time.Minute,
+		store:   store,
+		secrets: make(map[string]string, len(mappings)),
+		cache:   make(map[string]cachedSecret),
+	}
+	for header, secret := range mappings {
+		si.headers = append(si.headers, header)
+		si.secrets[header] = secret
+	}
+	sort.Strings(si.headers)
+	return si
+}
+
+// Exec is implementation of Middleware interface.
+func (si *SecretInjector) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		ctx = ensureContext(ctx)
+		for _, header := range si.headers {
+			value, err := si.secret(ctx, si.secrets[header])
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set(header, value)
+		}
+		return next.Handle(ctx, req)
+	})
+}
+
+// secret returns value of secret with provided name, from cache if it is not
+// expired, or from store otherwise.
+func (si *SecretInjector) secret(ctx context.Context, name string) (string, error) {
+	now := time.Now()
+	if si.TTL > 0 {
+		si.mu.Lock()
+		cached, ok := si.cache[name]
+		si.mu.Unlock()
+		if ok && now.Before(cached.expires) {
+			return cached.value, nil
+		}
+	}
+	value, err := si.store.Get(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if si.TTL > 0 {
+		si.mu.Lock()
+		si.cache[name] = cachedSecret{value: value, expires: now.Add(si.TTL)}
+		si.mu.Unlock()
+	}
+	return value, nil
+}
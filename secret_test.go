@@ -0,0 +1,95 @@
+package cliware_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	m "go.delic.rs/cliware"
+)
+
+// countingStore returns store that returns secrets from provided map and
+// counts how many times it is read.
+func countingStore(secrets map[string]string) (m.SecretStore, *int) {
+	var reads int
+	return m.SecretStoreFunc(func(ctx context.Context, name string) (string, error) {
+		reads++
+		value, ok := secrets[name]
+		if !ok {
+			return "", errors.New("secret not found")
+		}
+		return value, nil
+	}), &reads
+}
+
+func createHeaderHandler(headers *http.Header) m.Handler {
+	return m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		*headers = req.Header
+		return nil, nil
+	})
+}
+
+func TestFromSecretStore(t *testing.T) {
+	store, reads := countingStore(map[string]string{"api-token": "Bearer secret", "api-key": "key"})
+	var headers http.Header
+	injector := m.FromSecretStore(store, map[string]string{"Authorization": "api-token", "X-Api-Key": "api-key"})
+	h := injector.Exec(createHeaderHandler(&headers))
+	for i := 0; i < 3; i++ {
+		if _, err := h.Handle(nil, m.EmptyRequest()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if headers.Get("Authorization") != "Bearer secret" || headers.Get("X-Api-Key") != "key" {
+		t.Errorf("Secrets not set as headers. Got: %v", headers)
+	}
+	if *reads != 2 {
+		t.Errorf("Secrets not cached. Store reads: %d, expected: 2", *reads)
+	}
+
+	injector.TTL = 0
+	h.Handle(nil, m.EmptyRequest())
+	h.Handle(nil, m.EmptyRequest())
+	if *reads != 6 {
+		t.Errorf("Store not read without caching. Store reads: %d, expected: 6", *reads)
+	}
+}
+
+func TestFromSecretStoreExpiration(t *testing.T) {
+	store, reads := countingStore(map[string]string{"token": "secret"})
+	injector := m.FromSecretStore(store, map[string]string{"Authorization": "token"})
+	injector.TTL = 20 * time.Millisecond
+	h := injector.Exec(createStatusHandler(200))
+	h.Handle(nil, m.EmptyRequest())
+	time.Sleep(30 * time.Millisecond)
+	h.Handle(nil, m.EmptyRequest())
+	if *reads != 2 {
+		t.Errorf("Expired secret not read again. Store reads: %d, expected: 2", *reads)
+	}
+}
+
+func TestFromSecretStoreError(t *testing.T) {
+	store, _ := countingStore(nil)
+	handler, called := createHandler()
+	_, err := m.FromSecretStore(store, map[string]string{"Authorization": "missing"}).Exec(handler).Handle(nil, m.EmptyRequest())
+	if err == nil {
+		t.Error("Expected error for missing secret.")
+	}
+	if *called {
+		t.Error("Request sent without secret.")
+	}
+}
+
+func TestEnvSecretStore(t *testing.T) {
+	os.Setenv("CLIWARE_TEST_TOKEN", "from-env")
+	defer os.Unsetenv("CLIWARE_TEST_TOKEN")
+	store := m.EnvSecretStore("CLIWARE_TEST_")
+	if value, err := store.Get(context.Background(), "TOKEN"); err != nil || value != "from-env" {
+		t.Errorf("Wrong secret. Got: %q, %v", value, err)
+	}
+	if _, err := store.Get(context.Background(), "MISSING"); err == nil {
+		t.Error("Expected error for variable that is not set.")
+	}
+}
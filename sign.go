@@ -0,0 +1,505 @@
+package cliware
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signer is interface for signing HTTP requests.
+type Signer interface {
+	// Sign signs provided request, usually by setting header(s) with
+	// signature. Request body is buffered before Sign is called, so
+	// implementation can read it using req.GetBody without consuming it.
+	Sign(ctx context.Context, req *http.Request) error
+}
+
+// SignerFunc is function variant of Signer interface.
+type SignerFunc func(ctx context.Context, req *http.Request) error
+
+// Sign is implementation of Signer interface.
+func (sf SignerFunc) Sign(ctx context.Context, req *http.Request) error {
+	return sf(ctx, req)
+}
+
+// Sign returns middleware that signs every request using provided signer.
+// Request body is buffered before signer is invoked, so signer can read it.
+// If signer returns error, chain execution is stopped and error is returned.
+func Sign(s Signer) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			if _, err = requestBody(req); err != nil {
+				return nil, err
+			}
+			if err = s.Sign(ctx, req); err != nil {
+				return nil, err
+			}
+			return next.Handle(ctx, req)
+		})
+	})
+}
+
+// HMACSigner is Signer that sets HMAC of request method, URI and body as
+// hex encoded value of a header. Signed message is method, request URI and
+// body, separated by new lines.
+type HMACSigner struct {
+	// Key is secret key used for HMAC.
+	Key []byte
+	// Hash is hash function used for HMAC. If nil, SHA-256 is used.
+	Hash func() hash.Hash
+	// Header is name of header signature is set to. If empty,
+	// "X-Signature" is used.
+	Header string
+}
+
+// Sign is implementation of Signer interface.
+func (s *HMACSigner) Sign(ctx context.Context, req *http.Request) error {
+	body, err := requestBody(req)
+	if err != nil {
+		return err
+	}
+	hashFunc := s.Hash
+	if hashFunc == nil {
+		hashFunc = sha256.New
+	}
+	header := s.Header
+	if header == "" {
+		header = "X-Signature"
+	}
+	mac := hmac.New(hashFunc, s.Key)
+	mac.Write([]byte(req.Method + "\n" + req.URL.RequestURI() + "\n"))
+	mac.Write(body)
+	req.Header.Set(header, hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+// SigV4Signer is Signer that implements AWS Signature Version 4 using
+// Authorization header. Signed headers are Host and all X-Amz-* and
+// Content-Type headers present on request.
+type SigV4Signer struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Region       string
+	Service      string
+	// Now returns current time. If nil, time.Now is used.
+	Now func() time.Time
+}
+
+// Sign is implementation of Signer interface.
+func (s *SigV4Signer) Sign(ctx context.Context, req *http.Request) error {
+	body, err := requestBody(req)
+	if err != nil {
+		return err
+	}
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	t := now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" {
+			trimmed := make([]string, len(values))
+			for i, v := range values {
+				trimmed[i] = strings.Join(strings.Fields(v), " ")
+			}
+			headers[lower] = strings.Join(trimmed, ",")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders string
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/" + s.Service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, s.Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature,
+	))
+	return nil
+}
+
+// OAuth1Signer is Signer that implements OAuth 1.0a HMAC-SHA1 signature
+// method using Authorization header. Query parameters and, for form encoded
+// requests, body parameters are included in signature.
+type OAuth1Signer struct {
+	ConsumerKey    string
+	ConsumerSecret string
+	Token          string
+	TokenSecret    string
+	// Now returns current time. If nil, time.Now is used.
+	Now func() time.Time
+	// Nonce returns unique nonce for request. If nil, random nonce is used.
+	Nonce func() string
+}
+
+// Sign is implementation of Signer interface.
+func (s *OAuth1Signer) Sign(ctx context.Context, req *http.Request) error {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	nonce := s.Nonce
+	if nonce == nil {
+		nonce = randomNonce
+	}
+	oauthParams := map[string]string{
+		"oauth_consumer_key":     s.ConsumerKey,
+		"oauth_nonce":            nonce(),
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(now().Unix(), 10),
+		"oauth_version":          "1.0",
+	}
+	if s.Token != "" {
+		oauthParams["oauth_token"] = s.Token
+	}
+
+	params := req.URL.Query()
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+		body, err := requestBody(req)
+		if err != nil {
+			return err
+		}
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return err
+		}
+		for k, v := range form {
+			params[k] = append(params[k], v...)
+		}
+	}
+	for k, v := range oauthParams {
+		params.Set(k, v)
+	}
+
+	baseURL := strings.ToLower(req.URL.Scheme) + "://" + strings.ToLower(req.URL.Host) + req.URL.EscapedPath()
+	base := strings.ToUpper(req.Method) + "&" + percentEncode(baseURL) + "&" + percentEncode(canonicalQuery(params))
+	key := percentEncode(s.ConsumerSecret) + "&" + percentEncode(s.TokenSecret)
+	mac := hmac.New(sha1.New, []byte(key))
+	mac.Write([]byte(base))
+	oauthParams["oauth_signature"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	names := make([]string, 0, len(oauthParams))
+	for name := range oauthParams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = percentEncode(name) + "=\"" + percentEncode(oauthParams[name]) + "\""
+	}
+	req.Header.Set("Authorization", "OAuth "+strings.Join(parts, ", "))
+	return nil
+}
+
+// canonicalQuery returns query string with keys and values sorted and
+// encoded using percentEncode.
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), values[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, percentEncode(k)+"="+percentEncode(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// percentEncode encodes string as defined by RFC 3986, leaving only
+// unreserved characters unescaped.
+func percentEncode(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func randomNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// CacheSignatures returns Signer that signs request using provided signer
+// only once and reuses obtained signature when the same request is signed
+// again (e.g. when it is retried), for up to ttl. This saves recomputation
+// and keeps signature consistent across retries, which matters for signatures
+// that include timestamp or nonce.
+//
+// Signatures are cached in context of request, set up using
+// WithSignatureCache once for every logical request, before middleware that
+// sends it multiple times:
+//
+//	chain := m.NewChain(
+//		m.ContextProcessor(m.WithSignatureCache),
+//		m.Retry(3, nil, nil),
+//		m.Sign(m.CacheSignatures(signer, time.Minute)),
+//	)
+//
+// Cache is released together with context, and requests whose context does
+// not carry it are signed every time. Headers set or changed by signer on
+// first signing are remembered and set again on subsequent ones. Cached
+// signature is not used if request was changed between attempts (method,
+// URL, headers not set by signer or body), it is signed again instead. This
+// means that idempotency key (or any other header) that stays the same across
+// retries keeps cached signature valid, while header that changes on every
+// attempt causes request to be signed on every attempt.
+func CacheSignatures(s Signer, ttl time.Duration) Signer {
+	return &signatureCache{signer: s, ttl: ttl}
+}
+
+type signatureCacheKey struct{}
+
+// signatures holds signatures of single logical request, by cache that
+// created them.
+type signatures struct {
+	mu      sync.Mutex
+	entries map[*signatureCache]*signatureEntry
+}
+
+// WithSignatureCache returns context that caches signatures created by
+// signers returned by CacheSignatures for request sent with it, including
+// all its retries. If context already has signature cache, it is returned
+// as it is. It can be used as ContextProcessor.
+func WithSignatureCache(ctx context.Context) context.Context {
+	ctx = ensureContext(ctx)
+	if _, ok := ctx.Value(signatureCacheKey{}).(*signatures); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, signatureCacheKey{}, &signatures{entries: make(map[*signatureCache]*signatureEntry)})
+}
+
+// signatureCache is Signer returned by CacheSignatures.
+type signatureCache struct {
+	signer Signer
+	ttl    time.Duration
+}
+
+type signatureEntry struct {
+	fingerprint string
+	headers     http.Header
+	expires     time.Time
+}
+
+// Sign is implementation of Signer interface.
+func (sc *signatureCache) Sign(ctx context.Context, req *http.Request) error {
+	var cache *signatures
+	if ctx != nil {
+		cache, _ = ctx.Value(signatureCacheKey{}).(*signatures)
+	}
+	if cache == nil {
+		return sc.signer.Sign(ctx, req)
+	}
+	now := time.Now()
+	cache.mu.Lock()
+	entry := cache.entries[sc]
+	cache.mu.Unlock()
+
+	if entry != nil && now.Before(entry.expires) {
+		fingerprint, err := requestFingerprint(req, entry.headers)
+		if err != nil {
+			return err
+		}
+		if fingerprint == entry.fingerprint {
+			for name, values := range entry.headers {
+				req.Header[name] = append([]string(nil), values...)
+			}
+			return nil
+		}
+	}
+
+	before := cloneHeader(req.Header)
+	if err := sc.signer.Sign(ctx, req); err != nil {
+		return err
+	}
+	signed := make(http.Header)
+	for name, values := range req.Header {
+		if strings.Join(values, "\n") != strings.Join(before[name], "\n") {
+			signed[name] = append([]string(nil), values...)
+		}
+	}
+	fingerprint, err := requestFingerprint(req, signed)
+	if err != nil {
+		return err
+	}
+	cache.mu.Lock()
+	cache.entries[sc] = &signatureEntry{fingerprint: fingerprint, headers: signed, expires: now.Add(sc.ttl)}
+	cache.mu.Unlock()
+	return nil
+}
+
+// requestFingerprint returns hash of request method, URL, headers (except
+// excluded ones) and body.
+func requestFingerprint(req *http.Request, exclude http.Header) (string, error) {
+	body, err := requestBody(req)
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		if _, excluded := exclude[name]; !excluded {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", req.Method, req.URL)
+	for _, name := range names {
+		fmt.Fprintf(h, "%s:%q\n", name, req.Header[name])
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// RotatingSigner is Signer and middleware that signs requests using HMAC with
+// keys that are rotated. It is created using RotatingSign function.
+type RotatingSigner struct {
+	// Refresh is interval after which current key is fetched again.
+	// Defaults to 1 minute. If zero, key is fetched for every request.
+	Refresh time.Duration
+	// KeyIDHeader is name of header ID of key is set to. Defaults to
+	// "X-Key-Id".
+	KeyIDHeader string
+	// Hash is hash function used for HMAC. If nil, SHA-256 is used.
+	Hash func() hash.Hash
+	// Header is name of header signature is set to. If empty,
+	// "X-Signature" is used.
+	Header string
+
+	provider func(ctx context.Context) (keyID string, key []byte, err error)
+
+	mu      sync.Mutex
+	keyID   string
+	key     []byte
+	fetched time.Time
+}
+
+// RotatingSign returns middleware that signs requests like HMACSigner, using
+// current key obtained from provider, and sets ID of key to KeyIDHeader, so
+// server can select key to verify signature with. This allows keys to be
+// rotated without redeploying client. Key is cached and fetched from provider
+// again once Refresh passes, so provider is not called for every request. If
+// provider returns error, it is returned and request is not sent.
+//
+// Returned value is also Signer, so it can be wrapped by CacheSignatures to
+// keep signatures of retried requests:
+//
+//	chain.Use(m.Sign(m.CacheSignatures(m.RotatingSign(provider), time.Minute)))
+//
+// Key ID header is set by signer, so it is cached along with signature and
+// retries keep matching pair of key ID and signature even if key is rotated
+// in the meantime. Server must therefore accept recently rotated keys for at
+// least TTL of signature cache.
+func RotatingSign(provider func(ctx context.Context) (keyID string, key []byte, err error)) *RotatingSigner {
+	return &RotatingSigner{Refresh: time.Minute, KeyIDHeader: "X-Key-Id", provider: provider}
+}
+
+// Exec is implementation of Middleware interface.
+func (rs *RotatingSigner) Exec(next Handler) Handler {
+	return Sign(rs).Exec(next)
+}
+
+// Sign is implementation of Signer interface.
+func (rs *RotatingSigner) Sign(ctx context.Context, req *http.Request) error {
+	keyID, key, err := rs.currentKey(ctx)
+	if err != nil {
+		return err
+	}
+	signer := &HMACSigner{Key: key, Hash: rs.Hash, Header: rs.Header}
+	if err = signer.Sign(ctx, req); err != nil {
+		return err
+	}
+	req.Header.Set(rs.KeyIDHeader, keyID)
+	return nil
+}
+
+// currentKey returns cached key, fetching it from provider if it is older
+// than refresh interval.
+func (rs *RotatingSigner) currentKey(ctx context.Context) (string, []byte, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.key != nil && rs.Refresh > 0 && time.Since(rs.fetched) < rs.Refresh {
+		return rs.keyID, rs.key, nil
+	}
+	keyID, key, err := rs.provider(ensureContext(ctx))
+	if err != nil {
+		return "", nil, err
+	}
+	rs.keyID, rs.key, rs.fetched = keyID, key, time.Now()
+	return keyID, key, nil
+}
@@ -0,0 +1,228 @@
+package cliware_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestSignBuffersBody(t *testing.T) {
+	var signedBody string
+	signer := m.SignerFunc(func(ctx context.Context, req *http.Request) error {
+		body, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		data, _ := ioutil.ReadAll(body)
+		signedBody = string(data)
+		return nil
+	})
+	var sentBody string
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		data, _ := ioutil.ReadAll(req.Body)
+		sentBody = string(data)
+		return nil, nil
+	})
+	req, _ := http.NewRequest("POST", "http://localhost", ioutil.NopCloser(strings.NewReader("payload")))
+	_, err := m.Sign(signer).Exec(handler).Handle(nil, req)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if signedBody != "payload" {
+		t.Errorf("Signer got wrong body. Got: %s, expected: payload", signedBody)
+	}
+	if sentBody != "payload" {
+		t.Errorf("Handler got wrong body. Got: %s, expected: payload", sentBody)
+	}
+}
+
+func TestSignError(t *testing.T) {
+	myErr := errors.New("custom error")
+	signer := m.SignerFunc(func(ctx context.Context, req *http.Request) error {
+		return myErr
+	})
+	handler, handlerCalled := createHandler()
+	_, err := m.Sign(signer).Exec(handler).Handle(nil, m.EmptyRequest())
+	if err != myErr {
+		t.Errorf("Expected error: \"%s\", got: \"%s\"", myErr, err)
+	}
+	if *handlerCalled {
+		t.Error("Handler called even when signer returned error.")
+	}
+}
+
+func TestHMACSigner(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://localhost/path?a=b", strings.NewReader("payload"))
+	signer := &m.HMACSigner{Key: []byte("secret")}
+	if err := signer.Sign(nil, req); err != nil {
+		t.Fatal("Sign returned error: ", err)
+	}
+	// printf "POST\n/path?a=b\npayload" | openssl dgst -sha256 -hmac secret
+	expected := "2ee849b9ed4dac08c91dfed8deb5fb66dd2ccfba53de03a352d98f5bae9ec6af"
+	if got := req.Header.Get("X-Signature"); got != expected {
+		t.Errorf("Wrong signature. Got: %s, expected: %s", got, expected)
+	}
+}
+
+func TestSigV4Signer(t *testing.T) {
+	// get-vanilla test case from AWS Signature Version 4 test suite
+	req, _ := http.NewRequest("GET", "http://example.amazonaws.com/", nil)
+	signer := &m.SigV4Signer{
+		AccessKey: "AKIDEXAMPLE",
+		SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:    "us-east-1",
+		Service:   "service",
+		Now: func() time.Time {
+			return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+		},
+	}
+	if err := signer.Sign(nil, req); err != nil {
+		t.Fatal("Sign returned error: ", err)
+	}
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("Wrong authorization header.\nGot:      %s\nexpected: %s", got, expected)
+	}
+}
+
+func TestOAuth1Signer(t *testing.T) {
+	// example from Twitter's "Creating a signature" documentation
+	body := "status=Hello%20Ladies%20%2B%20Gentlemen%2C%20a%20signed%20OAuth%20request%21"
+	req, _ := http.NewRequest("POST", "https://api.twitter.com/1.1/statuses/update.json?include_entities=true", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	signer := &m.OAuth1Signer{
+		ConsumerKey:    "xvz1evFS4wEEPTGEFPHBog",
+		ConsumerSecret: "kAcSOqF21Fu85e7zjz7ZN2U4ZRhfV3WpwPAoE3Z7kBw",
+		Token:          "370773112-GmHxMAgYyLbNEtIKZeRNFsMKPR9EyMZeS9weJAEb",
+		TokenSecret:    "LswwdoUaIvS8ltyTt5jkRh4J50vUPVVHtR2YPi5kE",
+		Now: func() time.Time {
+			return time.Unix(1318622958, 0)
+		},
+		Nonce: func() string {
+			return "kYjzVBB8Y0ZFabxSWbWovY3uYSQ2pTgmZeNu2VS4cg"
+		},
+	}
+	handler, _ := createHandler()
+	if _, err := m.Sign(signer).Exec(handler).Handle(nil, req); err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	expected := `oauth_signature="hCtSmYh%2BiHYCEqBWrE7C7hYmtUk%3D"`
+	if got := req.Header.Get("Authorization"); !strings.Contains(got, expected) {
+		t.Errorf("Wrong authorization header. Got: %s, expected it to contain: %s", got, expected)
+	}
+}
+
+func TestCacheSignatures(t *testing.T) {
+	var signed int
+	signer := m.CacheSignatures(m.SignerFunc(func(ctx context.Context, req *http.Request) error {
+		signed++
+		req.Header.Set("X-Signature", fmt.Sprint(signed))
+		return nil
+	}), time.Minute)
+	var signatures []string
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		signatures = append(signatures, req.Header.Get("X-Signature"))
+		return nil, nil
+	})
+	h := m.Sign(signer).Exec(handler)
+
+	ctx := m.WithSignatureCache(nil)
+	req, _ := http.NewRequest("POST", "http://localhost", strings.NewReader("payload"))
+	h.Handle(ctx, req)
+	h.Handle(ctx, req)
+	req.Header.Set("X-Custom", "changed")
+	h.Handle(ctx, req)
+	other, _ := http.NewRequest("POST", "http://localhost", strings.NewReader("payload"))
+	h.Handle(m.WithSignatureCache(nil), other)
+	h.Handle(nil, other)
+	h.Handle(nil, other)
+
+	expected := []string{"1", "1", "2", "3", "4", "5"}
+	if strings.Join(signatures, ",") != strings.Join(expected, ",") {
+		t.Errorf("Wrong signatures sent. Got: %v, expected: %v", signatures, expected)
+	}
+}
+
+func TestCacheSignaturesRetry(t *testing.T) {
+	var signed int
+	signer := m.CacheSignatures(m.SignerFunc(func(ctx context.Context, req *http.Request) error {
+		signed++
+		req.Header.Set("X-Signature", fmt.Sprint(signed))
+		return nil
+	}), time.Minute)
+	var signatures []string
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		signatures = append(signatures, req.Header.Get("X-Signature"))
+		return &http.Response{StatusCode: 503, Header: make(http.Header), Body: http.NoBody}, nil
+	})
+	chain := m.NewChain(m.ContextProcessor(m.WithSignatureCache), m.Retry(3, nil, nil), m.Sign(signer))
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", "http://localhost", strings.NewReader("payload"))
+		chain.Exec(handler).Handle(nil, req)
+	}
+
+	expected := []string{"1", "1", "1", "2", "2", "2"}
+	if strings.Join(signatures, ",") != strings.Join(expected, ",") {
+		t.Errorf("Wrong signatures sent. Got: %v, expected: %v", signatures, expected)
+	}
+}
+
+func TestRotatingSign(t *testing.T) {
+	var fetches int
+	keys := []string{"first", "second"}
+	signer := m.RotatingSign(func(ctx context.Context) (string, []byte, error) {
+		keyID := keys[fetches%len(keys)]
+		fetches++
+		return keyID, []byte("secret-" + keyID), nil
+	})
+	signer.Refresh = 30 * time.Millisecond
+	var headers http.Header
+	h := signer.Exec(createHeaderHandler(&headers))
+
+	sign := func() (string, string) {
+		req, _ := http.NewRequest("POST", "http://localhost/path?a=b", strings.NewReader("payload"))
+		if _, err := h.Handle(nil, req); err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+		return headers.Get("X-Key-Id"), headers.Get("X-Signature")
+	}
+	firstID, firstSignature := sign()
+	cachedID, cachedSignature := sign()
+	if firstID != "first" || cachedID != "first" || cachedSignature != firstSignature || fetches != 1 {
+		t.Errorf("Key not cached. Key IDs: %s, %s, fetches: %d", firstID, cachedID, fetches)
+	}
+	expected := &m.HMACSigner{Key: []byte("secret-first")}
+	req, _ := http.NewRequest("POST", "http://localhost/path?a=b", strings.NewReader("payload"))
+	expected.Sign(nil, req)
+	if firstSignature != req.Header.Get("X-Signature") {
+		t.Error("Request not signed with current key.")
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if rotatedID, rotatedSignature := sign(); rotatedID != "second" || rotatedSignature == firstSignature {
+		t.Errorf("Key not rotated. Got key ID: %s", rotatedID)
+	}
+}
+
+func TestRotatingSignError(t *testing.T) {
+	providerErr := errors.New("key store unavailable")
+	handler, called := createHandler()
+	h := m.RotatingSign(func(ctx context.Context) (string, []byte, error) {
+		return "", nil, providerErr
+	}).Exec(handler)
+	if _, err := h.Handle(nil, m.EmptyRequest()); err != providerErr {
+		t.Errorf("Expected error: \"%s\", got: %v", providerErr, err)
+	}
+	if *called {
+		t.Error("Request sent without signature.")
+	}
+}
@@ -0,0 +1,154 @@
+package cliware
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolver is interface for looking up DNS SRV records. It is implemented by
+// *net.Resolver.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error)
+}
+
+// ErrNoSRVTargets is error returned by Failover when SRV lookup did not
+// return any targets.
+var ErrNoSRVTargets = errors.New("cliware: no SRV targets found")
+
+// Failover is middleware that sends requests to targets of DNS SRV records
+// and fails over to next target on error. It is created using SRVFailover
+// function.
+type Failover struct {
+	// TTL is duration for which looked up SRV records are cached. Defaults
+	// to 30 seconds.
+	TTL time.Duration
+
+	service  string
+	resolver Resolver
+
+	mu      sync.Mutex
+	addrs   []*net.SRV
+	expires time.Time
+}
+
+// SRVFailover returns middleware that looks up SRV records of service (e.g.
+// "_api._tcp.example.com") using resolver and sends request to their targets
+// ordered by priority and, within the same priority, randomly by weight. Host
+// of request URL is replaced with target host and port. If sending request to
+// target fails with error, next target is tried and body of request is
+// rewound. Responses are returned as they are, regardless of status code.
+// Last error is returned if all targets fail. If resolver is nil,
+// net.DefaultResolver is used.
+//
+// Looked up records are cached for TTL. If lookup fails while records are
+// cached, expired records are used. Every request sent to target is marked
+// with attempt number (see WithAttempt).
+func SRVFailover(service string, resolver Resolver) *Failover {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &Failover{TTL: 30 * time.Second, service: service, resolver: resolver}
+}
+
+// Exec is implementation of Middleware interface.
+func (f *Failover) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		ctx = ensureContext(ctx)
+		addrs, err := f.lookup(ctx)
+		if err != nil {
+			return nil, err
+		}
+		body, err := bufferRequestBody(req)
+		if err != nil {
+			return nil, err
+		}
+		for i, addr := range orderSRV(addrs) {
+			if i > 0 {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				if req.Body != nil && req.Body != http.NoBody {
+					setRequestBody(req, body)
+				}
+			}
+			req.URL.Host = net.JoinHostPort(strings.TrimSuffix(addr.Target, "."), strconv.Itoa(int(addr.Port)))
+			resp, err = next.Handle(WithAttempt(ctx, i+1), req)
+			if err == nil {
+				return resp, nil
+			}
+		}
+		return nil, err
+	})
+}
+
+// lookup returns cached SRV records, looking them up if cache expired.
+func (f *Failover) lookup(ctx context.Context) ([]*net.SRV, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.addrs != nil && time.Now().Before(f.expires) {
+		return f.addrs, nil
+	}
+	_, addrs, err := f.resolver.LookupSRV(ctx, "", "", f.service)
+	if err == nil && len(addrs) == 0 {
+		err = ErrNoSRVTargets
+	}
+	if err != nil {
+		if f.addrs != nil {
+			return f.addrs, nil
+		}
+		return nil, err
+	}
+	ttl := f.TTL
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	f.addrs = addrs
+	f.expires = time.Now().Add(ttl)
+	return addrs, nil
+}
+
+// orderSRV returns copy of records ordered by priority and, within records
+// with the same priority, randomly with probability proportional to weight,
+// as described in RFC 2782.
+func orderSRV(addrs []*net.SRV) []*net.SRV {
+	sorted := append([]*net.SRV(nil), addrs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+	for start := 0; start < len(sorted); {
+		end := start + 1
+		for end < len(sorted) && sorted[end].Priority == sorted[start].Priority {
+			end++
+		}
+		shuffleByWeight(sorted[start:end])
+		start = end
+	}
+	return sorted
+}
+
+// shuffleByWeight orders records randomly with probability proportional to
+// weight. Records with zero weight have small chance of being picked first.
+func shuffleByWeight(addrs []*net.SRV) {
+	for i := range addrs {
+		total := 0
+		for _, addr := range addrs[i:] {
+			total += int(addr.Weight) + 1
+		}
+		pick := rand.Intn(total)
+		for j := i; j < len(addrs); j++ {
+			pick -= int(addrs[j].Weight) + 1
+			if pick < 0 {
+				addrs[i], addrs[j] = addrs[j], addrs[i]
+				break
+			}
+		}
+	}
+}
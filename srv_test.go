@@ -0,0 +1,102 @@
+package cliware_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+type testResolver struct {
+	lookups int
+	addrs   []*net.SRV
+	err     error
+}
+
+func (r *testResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.lookups++
+	return name, r.addrs, r.err
+}
+
+func TestSRVFailover(t *testing.T) {
+	resolver := &testResolver{addrs: []*net.SRV{
+		{Target: "backup.example.com.", Port: 8080, Priority: 20},
+		{Target: "primary.example.com.", Port: 80, Priority: 10},
+		{Target: "secondary.example.com.", Port: 8443, Priority: 15},
+	}}
+	var hosts, bodies, attempts []string
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		if req.Body != nil {
+			body, _ := ioutil.ReadAll(req.Body)
+			bodies = append(bodies, string(body))
+		}
+		hosts = append(hosts, req.URL.Host)
+		attempts = append(attempts, string(rune('0'+m.Attempt(ctx))))
+		if req.URL.Host != "backup.example.com:8080" {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: 200}, nil
+	})
+	h := m.SRVFailover("_api._tcp.example.com", resolver).Exec(handler)
+
+	req, _ := http.NewRequest("POST", "http://example.com/", strings.NewReader("payload"))
+	resp, err := h.Handle(nil, req)
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatal("Handle returned error: ", err)
+	}
+	expected := "primary.example.com:80 secondary.example.com:8443 backup.example.com:8080"
+	if got := strings.Join(hosts, " "); got != expected {
+		t.Errorf("Wrong failover order. Got: %s, expected: %s", got, expected)
+	}
+	if got := strings.Join(bodies, " "); got != "payload payload payload" {
+		t.Errorf("Body not rewound between attempts. Got: %s", got)
+	}
+	if got := strings.Join(attempts, " "); got != "1 2 3" {
+		t.Errorf("Wrong attempt numbers. Got: %s", got)
+	}
+
+	req, _ = http.NewRequest("GET", "http://example.com/", nil)
+	h.Handle(nil, req)
+	if resolver.lookups != 1 {
+		t.Errorf("SRV records not cached. Lookups: %d", resolver.lookups)
+	}
+}
+
+func TestSRVFailoverAllFail(t *testing.T) {
+	expected := errors.New("connection refused")
+	resolver := &testResolver{addrs: []*net.SRV{{Target: "a.", Port: 1}, {Target: "b.", Port: 2}}}
+	var calls int
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		calls++
+		return nil, expected
+	})
+	_, err := m.SRVFailover("_api._tcp.example.com", resolver).Exec(handler).Handle(nil, m.EmptyRequest())
+	if err != expected || calls != 2 {
+		t.Errorf("Wrong result. Got error: %v, calls: %d", err, calls)
+	}
+
+	_, err = m.SRVFailover("_api._tcp.example.com", &testResolver{}).Exec(handler).Handle(nil, m.EmptyRequest())
+	if err != m.ErrNoSRVTargets {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", m.ErrNoSRVTargets, err)
+	}
+}
+
+func TestSRVFailoverCancelled(t *testing.T) {
+	resolver := &testResolver{addrs: []*net.SRV{{Target: "a.", Port: 1}, {Target: "b.", Port: 2}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		calls++
+		cancel()
+		return nil, ctx.Err()
+	})
+	_, err := m.SRVFailover("_api._tcp.example.com", resolver).Exec(handler).Handle(ctx, m.EmptyRequest())
+	if err != context.Canceled || calls != 1 {
+		t.Errorf("Failover continued after cancellation. Got error: %v, calls: %d", err, calls)
+	}
+}
@@ -0,0 +1,184 @@
+package cliware
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrNoBackends is error returned by StickyBalancer when it has no backends
+// to send request to.
+var ErrNoBackends = errors.New("cliware: no backends to send request to")
+
+// stickyReplicas is number of points each backend has on hash ring.
+const stickyReplicas = 100
+
+// Backend is handler that sends requests to single backend of load balanced
+// service.
+type Backend struct {
+	// Name identifies backend, e.g. its address. Names must be unique, since
+	// position of backend on hash ring is derived from it.
+	Name string
+	// Handler sends requests to backend.
+	Handler Handler
+}
+
+// ringPoint is point on hash ring owned by backend with provided index.
+type ringPoint struct {
+	hash    uint64
+	backend int
+}
+
+// StickyBalancer is middleware that balances requests across backends,
+// keeping requests of the same session on the same backend. It is created
+// using StickySession function.
+type StickyBalancer struct {
+	keyFn func(*http.Request) string
+	next  uint32
+
+	mu       sync.RWMutex
+	backends []Backend
+	ring     []ringPoint
+}
+
+// StickySession returns middleware that sends requests to one of provided
+// backends, so that requests with the same session key, returned by keyFn,
+// are consistently sent to the same backend. This maintains session affinity
+// to stateful backends in client-side load balancing. Requests are sent using
+// handlers of backends, so next handler is not called and StickySession
+// should be last middleware in chain. Requests with empty session key are
+// spread across backends in round-robin order.
+//
+// Keys are mapped to backends using consistent hashing: every backend owns
+// many points on hash ring, and key belongs to backend owning the first point
+// after hash of key. When backends change (see SetBackends), only keys of
+// removed backends and a proportional share of keys taken over by added ones
+// move to other backends, while others keep their backend.
+//
+// If preferred backend fails (returns error or response with 5xx status),
+// request is sent to next backend on ring, until one succeeds or all fail,
+// in which case result of the last one is returned. Request body is
+// buffered, so it can be sent multiple times, and bodies of failed responses
+// are drained and closed. Backend that key maps to can be obtained using
+// Backend method.
+func StickySession(keyFn func(*http.Request) string, backends []Backend) *StickyBalancer {
+	sb := &StickyBalancer{keyFn: keyFn}
+	sb.SetBackends(backends)
+	return sb
+}
+
+// SetBackends replaces backends of balancer. It is safe to call while
+// requests are being sent.
+func (sb *StickyBalancer) SetBackends(backends []Backend) {
+	backends = append([]Backend(nil), backends...)
+	ring := make([]ringPoint, 0, len(backends)*stickyReplicas)
+	for i, backend := range backends {
+		for replica := 0; replica < stickyReplicas; replica++ {
+			ring = append(ring, ringPoint{hash: hashKey(backend.Name + "#" + strconv.Itoa(replica)), backend: i})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	sb.mu.Lock()
+	sb.backends = backends
+	sb.ring = ring
+	sb.mu.Unlock()
+}
+
+// Backend returns name of backend that requests with provided session key
+// are sent to, as long as it does not fail, or empty string if there are no
+// backends.
+func (sb *StickyBalancer) Backend(key string) string {
+	backends := sb.candidates(key)
+	if len(backends) == 0 {
+		return ""
+	}
+	return backends[0].Name
+}
+
+// candidates returns all backends in order in which request with provided
+// key is sent to them.
+func (sb *StickyBalancer) candidates(key string) []Backend {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	if len(sb.backends) == 0 {
+		return nil
+	}
+	candidates := make([]Backend, 0, len(sb.backends))
+	if key == "" {
+		start := int(atomic.AddUint32(&sb.next, 1)-1) % len(sb.backends)
+		for i := range sb.backends {
+			candidates = append(candidates, sb.backends[(start+i)%len(sb.backends)])
+		}
+		return candidates
+	}
+	hash := hashKey(key)
+	start := sort.Search(len(sb.ring), func(i int) bool { return sb.ring[i].hash >= hash })
+	seen := make([]bool, len(sb.backends))
+	for i := 0; i < len(sb.ring) && len(candidates) < len(sb.backends); i++ {
+		point := sb.ring[(start+i)%len(sb.ring)]
+		if !seen[point.backend] {
+			seen[point.backend] = true
+			candidates = append(candidates, sb.backends[point.backend])
+		}
+	}
+	return candidates
+}
+
+// Exec is implementation of Middleware interface.
+func (sb *StickyBalancer) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		ctx = ensureContext(ctx)
+		var key string
+		if sb.keyFn != nil {
+			key = sb.keyFn(req)
+		}
+		backends := sb.candidates(key)
+		if len(backends) == 0 {
+			return nil, ErrNoBackends
+		}
+		if len(backends) > 1 && req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			if _, err = bufferRequestBody(req); err != nil {
+				return nil, err
+			}
+		}
+		for i, backend := range backends {
+			if i > 0 {
+				drainAndClose(resp)
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+			}
+			backendReq := req
+			if len(backends) > 1 {
+				if backendReq, err = cloneRequest(ctx, req); err != nil {
+					return nil, err
+				}
+			}
+			resp, err = backend.Handler.Handle(ctx, backendReq)
+			if !failedRequest(resp, err) {
+				break
+			}
+		}
+		return resp, err
+	})
+}
+
+// hashKey returns 64-bit FNV-1a hash of key, with bits mixed using
+// finalizer of MurmurHash3, so that similar keys (e.g. points of the same
+// backend) are spread evenly over ring.
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
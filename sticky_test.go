@@ -0,0 +1,128 @@
+package cliware_test
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+// createBackends returns backends with provided names, that record names of
+// backends that were sent requests to in served. Backends listed in failing
+// respond with status 503.
+func createBackends(served *[]string, failing map[string]bool, names ...string) []m.Backend {
+	backends := make([]m.Backend, len(names))
+	for i, name := range names {
+		name := name
+		backends[i] = m.Backend{Name: name, Handler: m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+			*served = append(*served, name)
+			if failing[name] {
+				return &http.Response{StatusCode: 503, Body: http.NoBody}, nil
+			}
+			return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+		})}
+	}
+	return backends
+}
+
+func sessionKey(req *http.Request) string {
+	return req.Header.Get("Session")
+}
+
+func newSessionRequest(session string) *http.Request {
+	req := m.EmptyRequest()
+	req.Header.Set("Session", session)
+	return req
+}
+
+func TestStickySession(t *testing.T) {
+	var served []string
+	balancer := m.StickySession(sessionKey, createBackends(&served, nil, "a", "b", "c"))
+	h := balancer.Exec(nil)
+
+	for i := 0; i < 20; i++ {
+		session := "session-" + strconv.Itoa(i)
+		for j := 0; j < 3; j++ {
+			served = nil
+			if _, err := h.Handle(nil, newSessionRequest(session)); err != nil {
+				t.Fatal("Handle returned error: ", err)
+			}
+			if len(served) != 1 || served[0] != balancer.Backend(session) {
+				t.Errorf("Session %s not sent to its backend %s. Served by: %v", session, balancer.Backend(session), served)
+			}
+		}
+	}
+
+	served = nil
+	for i := 0; i < 3; i++ {
+		h.Handle(nil, m.EmptyRequest())
+	}
+	if len(served) != 3 || served[0] == served[1] || served[1] == served[2] || served[0] == served[2] {
+		t.Errorf("Requests without session not spread across backends: %v", served)
+	}
+}
+
+func TestStickySessionFailover(t *testing.T) {
+	var served []string
+	names := []string{"a", "b", "c"}
+	balancer := m.StickySession(sessionKey, createBackends(&served, nil, names...))
+	preferred := balancer.Backend("user")
+	balancer.SetBackends(createBackends(&served, map[string]bool{preferred: true}, names...))
+
+	resp, err := balancer.Exec(nil).Handle(nil, newSessionRequest("user"))
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if resp.StatusCode != 200 || len(served) != 2 || served[0] != preferred {
+		t.Errorf("Request not failed over from %s. Served by: %v, status: %d", preferred, served, resp.StatusCode)
+	}
+
+	served = nil
+	balancer.SetBackends(createBackends(&served, map[string]bool{"a": true, "b": true, "c": true}, names...))
+	resp, _ = balancer.Exec(nil).Handle(nil, newSessionRequest("user"))
+	if resp.StatusCode != 503 || len(served) != 3 {
+		t.Errorf("Wrong result when all backends fail. Served by: %v, status: %d", served, resp.StatusCode)
+	}
+
+	balancer.SetBackends(nil)
+	if _, err := balancer.Exec(nil).Handle(nil, newSessionRequest("user")); err != m.ErrNoBackends {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", m.ErrNoBackends, err)
+	}
+	if backend := balancer.Backend("user"); backend != "" {
+		t.Errorf("Backend returned without backends: %s", backend)
+	}
+}
+
+func TestStickySessionBackendChange(t *testing.T) {
+	var served []string
+	balancer := m.StickySession(sessionKey, createBackends(&served, nil, "a", "b", "c", "d"))
+	const keys = 1000
+	before := make([]string, keys)
+	for i := range before {
+		before[i] = balancer.Backend(strconv.Itoa(i))
+	}
+
+	balancer.SetBackends(createBackends(&served, nil, "a", "b", "c", "d", "e"))
+	moved := 0
+	for i, backend := range before {
+		if after := balancer.Backend(strconv.Itoa(i)); after != backend {
+			moved++
+			if after != "e" {
+				t.Fatalf("Key %d moved from %s to existing backend %s.", i, backend, after)
+			}
+		}
+	}
+	// Added backend should take over about fifth of keys.
+	if moved == 0 || moved > keys/3 {
+		t.Errorf("Wrong number of keys moved to added backend: %d of %d", moved, keys)
+	}
+
+	balancer.SetBackends(createBackends(&served, nil, "a", "c", "d", "e"))
+	for i := 0; i < keys; i++ {
+		if backend := balancer.Backend(strconv.Itoa(i)); backend == "b" {
+			t.Fatalf("Key %d mapped to removed backend.", i)
+		}
+	}
+}
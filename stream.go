@@ -0,0 +1,54 @@
+package cliware
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// StreamChunks returns middleware that reads response body in chunks of
+// chunkSize bytes (last chunk may be smaller) and calls onChunk for each of
+// them, allowing processing of large responses without buffering them.
+// Only single buffer of chunkSize bytes is allocated and it is reused for
+// all chunks, so onChunk must not retain provided slice after it returns.
+// If chunkSize is not positive, 32KB is used.
+//
+// Reading stops when onChunk returns error or when context is cancelled and
+// that error is returned. Body is consumed and closed, so returned response
+// has empty body.
+func StreamChunks(chunkSize int, onChunk func(ctx context.Context, chunk []byte) error) Middleware {
+	if chunkSize <= 0 {
+		chunkSize = 32 << 10
+	}
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			ctx = ensureContext(ctx)
+			resp, err = next.Handle(ctx, req)
+			if err != nil || resp == nil || resp.Body == nil {
+				return resp, err
+			}
+			body := resp.Body
+			defer body.Close()
+			resp.Body = http.NoBody
+
+			buf := make([]byte, chunkSize)
+			for {
+				if err = ctx.Err(); err != nil {
+					return resp, err
+				}
+				n, readErr := io.ReadFull(body, buf)
+				if n > 0 {
+					if err = onChunk(ctx, buf[:n]); err != nil {
+						return resp, err
+					}
+				}
+				if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+					return resp, nil
+				}
+				if readErr != nil {
+					return resp, readErr
+				}
+			}
+		})
+	})
+}
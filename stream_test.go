@@ -0,0 +1,67 @@
+package cliware_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestStreamChunks(t *testing.T) {
+	var chunks []string
+	middleware := m.StreamChunks(4, func(ctx context.Context, chunk []byte) error {
+		chunks = append(chunks, string(chunk))
+		return nil
+	})
+	resp, err := middleware.Exec(createBodyHandler("text/plain", "0123456789")).Handle(nil, nil)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	expected := []string{"0123", "4567", "89"}
+	if len(chunks) != len(expected) {
+		t.Fatalf("Wrong chunks. Got: %v, expected: %v", chunks, expected)
+	}
+	for i := range expected {
+		if chunks[i] != expected[i] {
+			t.Errorf("Wrong chunk %d. Got: %s, expected: %s", i, chunks[i], expected[i])
+		}
+	}
+	if body, _ := ioutil.ReadAll(resp.Body); len(body) != 0 {
+		t.Errorf("Body not consumed. Got: %s", body)
+	}
+}
+
+func TestStreamChunksCallbackError(t *testing.T) {
+	myErr := errors.New("custom error")
+	var calls int
+	middleware := m.StreamChunks(2, func(ctx context.Context, chunk []byte) error {
+		calls++
+		return myErr
+	})
+	_, err := middleware.Exec(createBodyHandler("text/plain", "0123456789")).Handle(nil, nil)
+	if err != myErr {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", myErr, err)
+	}
+	if calls != 1 {
+		t.Errorf("Reading not stopped after error. Callback called %d times.", calls)
+	}
+}
+
+func TestStreamChunksCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int
+	middleware := m.StreamChunks(2, func(ctx context.Context, chunk []byte) error {
+		calls++
+		cancel()
+		return nil
+	})
+	_, err := middleware.Exec(createBodyHandler("text/plain", "0123456789")).Handle(ctx, nil)
+	if err != context.Canceled {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", context.Canceled, err)
+	}
+	if calls != 1 {
+		t.Errorf("Reading not stopped after cancel. Callback called %d times.", calls)
+	}
+}
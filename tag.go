@@ -0,0 +1,60 @@
+package cliware
+
+import (
+	"context"
+	"net/http"
+)
+
+type tagsKey struct{}
+
+// WithTags returns copy of provided context that carries provided tags in
+// addition to tags already carried by ctx. Tags that are already present are
+// not added again.
+func WithTags(ctx context.Context, tags ...string) context.Context {
+	ctx = ensureContext(ctx)
+	existing := Tags(ctx)
+	merged := make([]string, len(existing), len(existing)+len(tags))
+	copy(merged, existing)
+	for _, tag := range tags {
+		if !containsTag(merged, tag) {
+			merged = append(merged, tag)
+		}
+	}
+	return context.WithValue(ctx, tagsKey{}, merged)
+}
+
+// Tags returns tags carried by context, in order they were added. Returned
+// slice must not be modified.
+func Tags(ctx context.Context) []string {
+	if ctx == nil {
+		return nil
+	}
+	tags, _ := ctx.Value(tagsKey{}).([]string)
+	return tags
+}
+
+// HasTag reports whether context carries provided tag.
+func HasTag(ctx context.Context, tag string) bool {
+	return containsTag(Tags(ctx), tag)
+}
+
+// Tag returns middleware that attaches provided tags to context passed to
+// next handler. Tags are used to classify requests, so that middlewares after
+// it (e.g. rate limiters or balancers) can make decisions based on request
+// class using HasTag. Tags accumulate across multiple Tag middlewares.
+func Tag(tags ...string) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			return next.Handle(WithTags(ctx, tags...), req)
+		})
+	})
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
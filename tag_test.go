@@ -0,0 +1,42 @@
+package cliware_test
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestTag(t *testing.T) {
+	var tags []string
+	var batch, other bool
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		tags = m.Tags(ctx)
+		batch = m.HasTag(ctx, "batch")
+		other = m.HasTag(ctx, "other")
+		return nil, nil
+	})
+	chain := m.NewChain(m.Tag("batch", "low"), m.Tag("low", "internal"))
+	chain.Exec(handler).Handle(nil, m.EmptyRequest())
+
+	if expected := []string{"batch", "low", "internal"}; !reflect.DeepEqual(tags, expected) {
+		t.Errorf("Wrong tags. Got: %v, expected: %v", tags, expected)
+	}
+	if !batch || other {
+		t.Errorf("Wrong HasTag results. Got batch: %t, other: %t", batch, other)
+	}
+}
+
+func TestTagDoesNotShareSlices(t *testing.T) {
+	base := m.WithTags(context.Background(), "a", "b")
+	first := m.WithTags(base, "c")
+	second := m.WithTags(base, "d")
+	if m.HasTag(first, "d") || m.HasTag(second, "c") || m.HasTag(base, "c") {
+		t.Errorf("Tags leaked between contexts: %v, %v, %v", m.Tags(base), m.Tags(first), m.Tags(second))
+	}
+	if m.Tags(nil) != nil || m.HasTag(nil, "a") {
+		t.Error("Nil context should not carry tags.")
+	}
+}
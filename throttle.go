@@ -0,0 +1,280 @@
+package cliware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CoordinatedBackoff returns middleware that, when server responds with
+// 429 Too Many Requests or 503 Service Unavailable and Retry-After header,
+// pauses all requests to the same host until Retry-After period elapses.
+// This prevents concurrent requests from hammering a server that already
+// asked client to slow down. Response with Retry-After header is returned to
+// caller as is, only subsequent requests are paused. If context of paused
+// request is done while waiting, its error is returned.
+//
+// State is shared by all requests going through returned middleware, so same
+// instance should be used for all requests that need to be coordinated.
+// Requests without URL are passed through unchanged.
+func CoordinatedBackoff() Middleware {
+	var mu sync.Mutex
+	pausedUntil := make(map[string]time.Time)
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			if req == nil || req.URL == nil {
+				return next.Handle(ctx, req)
+			}
+			ctx = ensureContext(ctx)
+			host := req.URL.Host
+
+			mu.Lock()
+			until, paused := pausedUntil[host]
+			if paused && !time.Now().Before(until) {
+				delete(pausedUntil, host)
+			}
+			mu.Unlock()
+			if err = sleep(ctx, time.Until(until)); err != nil {
+				return nil, err
+			}
+
+			resp, err = next.Handle(ctx, req)
+			if err != nil || resp == nil {
+				return resp, err
+			}
+			if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+				return resp, err
+			}
+			if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				resume := time.Now().Add(delay)
+				mu.Lock()
+				if resume.After(pausedUntil[host]) {
+					pausedUntil[host] = resume
+				}
+				mu.Unlock()
+			}
+			return resp, err
+		})
+	})
+}
+
+// parseRetryAfter parses value of Retry-After header, which is either number
+// of seconds or HTTP date, and returns duration to wait.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	delay := time.Until(date)
+	if delay < 0 {
+		delay = 0
+	}
+	return delay, true
+}
+
+// sleep waits for provided duration or until context is done, in which case
+// context error is returned.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tokenBucket is token bucket rate limiter. Tokens are reserved when
+// requested, so request for more tokens than available (or more than bucket
+// can even hold) waits proportionally longer.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates full token bucket that is refilled with rate tokens
+// per second and holds at most burst tokens.
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait blocks until n tokens are available or context is done, in which
+// case reserved tokens are returned to bucket and context error is returned.
+func (tb *tokenBucket) wait(ctx context.Context, n float64) error {
+	tb.mu.Lock()
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+	tb.tokens -= n
+	var delay time.Duration
+	if tb.tokens < 0 {
+		delay = time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+	}
+	tb.mu.Unlock()
+
+	if err := sleep(ctx, delay); err != nil {
+		tb.mu.Lock()
+		tb.tokens += n
+		tb.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// CostBasedRateLimit returns middleware that limits rate of requests using
+// token bucket that is refilled with ratePerSec tokens per second and holds
+// at most burst tokens, where every request consumes costFn(req) tokens
+// instead of one. This models weighted API quotas, where e.g. bulk request
+// costs more than simple GET. Request waits until enough tokens are
+// available, or until its context is done, in which case tokens it reserved
+// are returned and context error is returned. Requests with cost of zero or
+// less are not limited. Request that costs more than burst is sent once
+// bucket would have been refilled for it, leaving bucket in debt.
+//
+// Bucket is shared by all requests going through returned middleware. If
+// ratePerSec is not positive, every request fails with error describing it.
+func CostBasedRateLimit(costFn func(*http.Request) int, ratePerSec, burst int) Middleware {
+	if ratePerSec <= 0 {
+		err := fmt.Errorf("cliware: rate limit must be positive, got %d", ratePerSec)
+		return RequestProcessor(func(req *http.Request) error {
+			return err
+		})
+	}
+	bucket := newTokenBucket(float64(ratePerSec), float64(burst))
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			if cost := costFn(req); cost > 0 {
+				if err = bucket.wait(ensureContext(ctx), float64(cost)); err != nil {
+					return nil, err
+				}
+			}
+			return next.Handle(ctx, req)
+		})
+	})
+}
+
+// RateLimitHeaderPacing returns middleware that paces requests according to
+// rate limit advertised by server in X-RateLimit-Remaining and
+// X-RateLimit-Reset response headers. Remaining requests are spread evenly
+// until reset time, so that quota is not exhausted before it is reset, and
+// when no requests remain, requests wait until reset time. This avoids
+// 429 Too Many Requests responses instead of reacting to them. Reset header
+// can be either number of seconds until reset or Unix time of reset. If
+// context of paced request is done while waiting, its error is returned.
+//
+// State is kept per host and shared by all requests going through returned
+// middleware. Responses without these headers, or with malformed ones,
+// disable pacing for the host until next response that has them. Requests
+// without URL are passed through unchanged.
+func RateLimitHeaderPacing() Middleware {
+	var mu sync.Mutex
+	limits := make(map[string]*rateLimit)
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			if req == nil || req.URL == nil {
+				return next.Handle(ctx, req)
+			}
+			ctx = ensureContext(ctx)
+			host := req.URL.Host
+
+			now := time.Now()
+			var send time.Time
+			mu.Lock()
+			if limit, ok := limits[host]; ok {
+				if now.Before(limit.reset) {
+					send = limit.reserve(now)
+				} else {
+					delete(limits, host)
+				}
+			}
+			mu.Unlock()
+			if err = sleep(ctx, send.Sub(now)); err != nil {
+				return nil, err
+			}
+
+			resp, err = next.Handle(ctx, req)
+			if err != nil || resp == nil {
+				return resp, err
+			}
+			remaining, reset, ok := parseRateLimit(resp.Header)
+			mu.Lock()
+			if ok {
+				limit, exists := limits[host]
+				if !exists {
+					limit = &rateLimit{}
+					limits[host] = limit
+				}
+				limit.remaining = remaining
+				limit.reset = reset
+			} else {
+				delete(limits, host)
+			}
+			mu.Unlock()
+			return resp, err
+		})
+	})
+}
+
+// rateLimit is rate limit state of single host advertised by server.
+type rateLimit struct {
+	remaining int64
+	reset     time.Time
+	next      time.Time
+}
+
+// reserve reserves one of remaining requests and returns time when it can be
+// sent. If no requests remain, it can be sent at reset time.
+func (rl *rateLimit) reserve(now time.Time) time.Time {
+	if rl.remaining <= 0 {
+		return rl.reset
+	}
+	send := now
+	if rl.next.After(send) {
+		send = rl.next
+	}
+	rl.next = send.Add(rl.reset.Sub(send) / time.Duration(rl.remaining))
+	rl.remaining--
+	return send
+}
+
+// parseRateLimit parses X-RateLimit-Remaining and X-RateLimit-Reset headers.
+// Values of reset header larger than 10^9 are treated as Unix time, smaller
+// ones as number of seconds until reset.
+func parseRateLimit(h http.Header) (remaining int64, reset time.Time, ok bool) {
+	remaining, err := strconv.ParseInt(strings.TrimSpace(h.Get("X-RateLimit-Remaining")), 10, 64)
+	if err != nil || remaining < 0 {
+		return 0, time.Time{}, false
+	}
+	value, err := strconv.ParseInt(strings.TrimSpace(h.Get("X-RateLimit-Reset")), 10, 64)
+	if err != nil || value < 0 {
+		return 0, time.Time{}, false
+	}
+	if value > 1e9 {
+		return remaining, time.Unix(value, 0), true
+	}
+	return remaining, time.Now().Add(time.Duration(value) * time.Second), true
+}
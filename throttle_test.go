@@ -0,0 +1,204 @@
+package cliware_test
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestCoordinatedBackoff(t *testing.T) {
+	var calls int
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		calls++
+		resp = &http.Response{StatusCode: 200, Header: make(http.Header)}
+		if req.URL.Host == "throttled" {
+			resp.StatusCode = http.StatusTooManyRequests
+			resp.Header.Set("Retry-After", "10")
+		}
+		return resp, nil
+	})
+	h := m.CoordinatedBackoff().Exec(handler)
+
+	send := func(ctx context.Context, host string) error {
+		req, _ := http.NewRequest("GET", "http://"+host+"/", nil)
+		_, err := h.Handle(ctx, req)
+		return err
+	}
+	if err := send(context.Background(), "throttled"); err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := send(ctx, "throttled"); err != context.DeadlineExceeded {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", context.DeadlineExceeded, err)
+	}
+	if calls != 1 {
+		t.Errorf("Request sent while host is paused. Handler calls: %d", calls)
+	}
+
+	start := time.Now()
+	if err := send(context.Background(), "other"); err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("Request to other host was paused.")
+	}
+}
+
+func TestCoordinatedBackoffResumes(t *testing.T) {
+	var calls int
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		calls++
+		resp = &http.Response{StatusCode: http.StatusServiceUnavailable, Header: make(http.Header)}
+		if calls == 1 {
+			resp.Header.Set("Retry-After", "1")
+		}
+		return resp, nil
+	})
+	h := m.CoordinatedBackoff().Exec(handler)
+	h.Handle(nil, m.EmptyRequest())
+	start := time.Now()
+	h.Handle(nil, m.EmptyRequest())
+	if waited := time.Since(start); waited < 900*time.Millisecond {
+		t.Errorf("Request not paused. Waited: %s, expected around: 1s", waited)
+	}
+}
+
+// createRateLimitHandler returns handler that responds with provided rate
+// limit headers, repeating last ones once they are used up.
+func TestCoordinatedBackoffNilRequest(t *testing.T) {
+	handler, called := createHandler()
+	if _, err := m.CoordinatedBackoff().Exec(handler).Handle(nil, nil); err != nil || !*called {
+		t.Errorf("Nil request not passed to handler. Error: %v", err)
+	}
+	if _, err := m.CoordinatedBackoff().Exec(handler).Handle(nil, &http.Request{Method: "GET"}); err != nil {
+		t.Error("Handle returned error for request without URL: ", err)
+	}
+}
+
+func createRateLimitHandler(headers ...[2]string) m.Handler {
+	var calls int
+	return m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		resp = &http.Response{StatusCode: 200, Header: make(http.Header)}
+		if len(headers) > 0 {
+			i := calls
+			if i >= len(headers) {
+				i = len(headers) - 1
+			}
+			resp.Header.Set("X-RateLimit-Remaining", headers[i][0])
+			resp.Header.Set("X-RateLimit-Reset", headers[i][1])
+		}
+		calls++
+		return resp, nil
+	})
+}
+
+func TestRateLimitHeaderPacingExhausted(t *testing.T) {
+	h := m.RateLimitHeaderPacing().Exec(createRateLimitHandler([2]string{"0", "1"}))
+	req, _ := http.NewRequest("GET", "http://limited/", nil)
+	if _, err := h.Handle(nil, req); err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := h.Handle(ctx, req); err != context.DeadlineExceeded {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", context.DeadlineExceeded, err)
+	}
+
+	start := time.Now()
+	if _, err := h.Handle(nil, req); err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Errorf("Request not delayed until reset. Elapsed: %s", elapsed)
+	}
+}
+
+func TestRateLimitHeaderPacingSpreads(t *testing.T) {
+	reset := strconv.FormatInt(time.Now().Add(time.Second).Unix()+1, 10)
+	h := m.RateLimitHeaderPacing().Exec(createRateLimitHandler([2]string{"4", reset}))
+	req, _ := http.NewRequest("GET", "http://limited/", nil)
+	h.Handle(nil, req)
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if _, err := h.Handle(nil, req); err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+	}
+	// First request is sent immediately, second one after quarter of time
+	// remaining until reset.
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Requests not spread until reset. Elapsed: %s", elapsed)
+	}
+}
+
+func TestRateLimitHeaderPacingMalformed(t *testing.T) {
+	h := m.RateLimitHeaderPacing().Exec(createRateLimitHandler([2]string{"0", "soon"}, [2]string{"-1", "10"}))
+	req, _ := http.NewRequest("GET", "http://limited/", nil)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := h.Handle(nil, req); err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("Requests paced using malformed headers.")
+	}
+}
+
+func TestRateLimitHeaderPacingNilRequest(t *testing.T) {
+	handler, called := createHandler()
+	if _, err := m.RateLimitHeaderPacing().Exec(handler).Handle(nil, nil); err != nil || !*called {
+		t.Errorf("Nil request not passed to handler. Error: %v", err)
+	}
+	if _, err := m.RateLimitHeaderPacing().Exec(handler).Handle(nil, &http.Request{Method: "GET"}); err != nil {
+		t.Error("Handle returned error for request without URL: ", err)
+	}
+}
+
+func TestCostBasedRateLimit(t *testing.T) {
+	cost := func(req *http.Request) int {
+		n, _ := strconv.Atoi(req.Header.Get("Cost"))
+		return n
+	}
+	handler, _ := createHandler()
+	h := m.CostBasedRateLimit(cost, 100, 10).Exec(handler)
+	send := func(ctx context.Context, n string) (time.Duration, error) {
+		req := m.EmptyRequest()
+		req.Header.Set("Cost", n)
+		start := time.Now()
+		_, err := h.Handle(ctx, req)
+		return time.Since(start), err
+	}
+
+	// Burst is consumed immediately, so following requests wait until bucket
+	// is refilled for their cost.
+	if elapsed, _ := send(nil, "10"); elapsed > 20*time.Millisecond {
+		t.Errorf("Request within burst delayed. Elapsed: %s", elapsed)
+	}
+	cheap, _ := send(nil, "2")
+	expensive, _ := send(nil, "10")
+	if cheap < 10*time.Millisecond || expensive < 80*time.Millisecond || expensive < 3*cheap {
+		t.Errorf("Requests not delayed proportionally to cost. Cheap: %s, expensive: %s", cheap, expensive)
+	}
+	if elapsed, _ := send(nil, "0"); elapsed > 20*time.Millisecond {
+		t.Errorf("Free request delayed. Elapsed: %s", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := send(ctx, "50"); err != context.DeadlineExceeded {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", context.DeadlineExceeded, err)
+	}
+
+	if _, err := m.CostBasedRateLimit(cost, 0, 10).Exec(handler).Handle(nil, m.EmptyRequest()); err == nil {
+		t.Error("Expected error for invalid rate.")
+	}
+}
@@ -0,0 +1,531 @@
+package cliware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrIdleTimeout is error returned by IdleTimeout middleware when no data is
+// received for configured period of time.
+var ErrIdleTimeout = errors.New("cliware: idle timeout exceeded")
+
+// Timeout returns middleware that limits time request has to complete to
+// provided duration, by passing context with deadline to next handler. If
+// context is already done, its error is returned without calling next
+// handler. Derived context is always cancelled - immediately when handler
+// returns error, no response body or panics, and when response body is
+// closed otherwise, so body can still be read after handler returns. Nil
+// context is treated as context.Background.
+func Timeout(d time.Duration) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			ctx = ensureContext(ctx)
+			if err = ctx.Err(); err != nil {
+				return nil, err
+			}
+			ctx, cancel := context.WithTimeout(ctx, d)
+			onClose := false
+			defer func() {
+				if !onClose {
+					cancel()
+				}
+			}()
+			resp, err = next.Handle(ctx, req)
+			if err == nil && resp != nil && resp.Body != nil {
+				cancelOnClose(resp, cancel)
+				onClose = true
+			}
+			return resp, err
+		})
+	})
+}
+
+// AdaptiveTimeout returns middleware that sets timeout for each attempt of
+// sending request, increasing it with every new attempt. First attempt gets
+// base timeout and every next one gets timeout of previous attempt multiplied
+// by factor, up to max. If max is zero, timeout is not capped. Attempt number
+// is read from context (see WithAttempt), so this middleware should be placed
+// after middleware that retries requests.
+//
+// Timeout is applied by deriving context with deadline, which means it can
+// only shorten the time request has, never extend it. If overall budget for
+// request is set as deadline on context before retrying, no attempt will run
+// past it, regardless of computed per-attempt timeout.
+//
+// Context of each attempt is cancelled when attempt completes - immediately
+// if error is returned or when response body is closed otherwise.
+func AdaptiveTimeout(base time.Duration, factor float64, max time.Duration) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			timeout := base
+			for i := 1; i < Attempt(ctx); i++ {
+				timeout = time.Duration(float64(timeout) * factor)
+				if max > 0 && timeout >= max {
+					break
+				}
+			}
+			if max > 0 && timeout > max {
+				timeout = max
+			}
+
+			ctx, cancel := context.WithTimeout(ensureContext(ctx), timeout)
+			resp, err = next.Handle(ctx, req)
+			if err != nil {
+				cancel()
+				return resp, err
+			}
+			cancelOnClose(resp, cancel)
+			return resp, err
+		})
+	})
+}
+
+// IdleTimeout returns middleware that cancels request if no data is received
+// for provided duration. In contrast to fixed timeout, slow response that
+// keeps receiving data is not cancelled, only stalled one is, which makes it
+// suitable for long downloads and streams. Watchdog is started when request
+// is sent and reset when response is received and on every Read of response
+// body that returns data. When it fires, context of request is cancelled and
+// ErrIdleTimeout is returned, either by handler or by Read of response body.
+// Watchdog is stopped and context cancelled when response body is closed.
+func IdleTimeout(d time.Duration) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			ctx, cancel := context.WithCancel(ensureContext(ctx))
+			body := &idleBody{cancel: cancel, timeout: d}
+			body.timer = time.AfterFunc(d, func() {
+				atomic.StoreInt32(&body.expired, 1)
+				cancel()
+			})
+			resp, err = next.Handle(ctx, req)
+			if err != nil {
+				body.timer.Stop()
+				cancel()
+				if atomic.LoadInt32(&body.expired) == 1 {
+					return resp, ErrIdleTimeout
+				}
+				return resp, err
+			}
+			if resp == nil || resp.Body == nil {
+				body.timer.Stop()
+				cancel()
+				return resp, err
+			}
+			body.timer.Reset(d)
+			body.body = resp.Body
+			resp.Body = body
+			return resp, err
+		})
+	})
+}
+
+// idleBody is response body that resets idle timer on every Read that
+// returns data.
+type idleBody struct {
+	body    io.ReadCloser
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	timeout time.Duration
+	expired int32
+}
+
+// Read is implementation of io.Reader interface.
+func (ib *idleBody) Read(p []byte) (int, error) {
+	n, err := ib.body.Read(p)
+	if atomic.LoadInt32(&ib.expired) == 1 {
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		return n, ErrIdleTimeout
+	}
+	if n > 0 {
+		ib.timer.Reset(ib.timeout)
+	}
+	return n, err
+}
+
+// Close is implementation of io.Closer interface.
+func (ib *idleBody) Close() error {
+	ib.timer.Stop()
+	err := ib.body.Close()
+	ib.cancel()
+	return err
+}
+
+// SizeTimeout is middleware that sets timeout of request based on size of
+// its body. It is created using TimeoutBySize function.
+type SizeTimeout struct {
+	// Max is maximal timeout. If zero, timeout is not capped.
+	Max time.Duration
+
+	base  time.Duration
+	perMB time.Duration
+}
+
+// TimeoutBySize returns middleware that sets timeout of request to base
+// plus perMB for every megabyte (2^20 bytes) of request body, so large
+// uploads get more time than small requests. Size of body is taken from
+// ContentLength. Bodies of unknown size are not read, base timeout is used
+// for them instead. Requests without URL are passed through unchanged.
+//
+// Timeout is applied by deriving context with deadline, which is cancelled
+// immediately if error is returned or when response body is closed
+// otherwise.
+func TimeoutBySize(base, perMB time.Duration) *SizeTimeout {
+	return &SizeTimeout{base: base, perMB: perMB}
+}
+
+// Exec is implementation of Middleware interface.
+func (st *SizeTimeout) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		if req == nil || req.URL == nil {
+			return next.Handle(ctx, req)
+		}
+		var size int64
+		if req.ContentLength > 0 {
+			size = req.ContentLength
+		}
+		timeout := st.base + time.Duration(float64(st.perMB)*float64(size)/(1<<20))
+		if st.Max > 0 && timeout > st.Max {
+			timeout = st.Max
+		}
+
+		ctx, cancel := context.WithTimeout(ensureContext(ctx), timeout)
+		resp, err = next.Handle(ctx, req)
+		if err != nil {
+			cancel()
+			return resp, err
+		}
+		cancelOnClose(resp, cancel)
+		return resp, err
+	})
+}
+
+// ErrInsufficientDeadline is error returned by RespectDeadline middleware
+// when attempt is not started because it would not complete before
+// deadline of context. Middlewares that retry requests should treat it as
+// signal to stop retrying and return error of previous attempt.
+var ErrInsufficientDeadline = errors.New("cliware: insufficient time until deadline for another attempt")
+
+// RespectDeadline returns middleware that prevents starting retry attempts
+// that can not complete before deadline of context. It keeps estimate of
+// attempt duration, as exponentially weighted moving average of durations of
+// previous attempts (new duration has weight of 0.3), and before every retry
+// attempt (attempt number larger than 1, see Attempt) checks remaining time
+// until deadline. If less time remains than estimated duration,
+// ErrInsufficientDeadline is returned without calling next handler, so
+// remaining time is not wasted on attempt that would most likely time out.
+// First attempts are always started, and so are all attempts until first
+// duration is observed or if context has no deadline.
+//
+// Estimate is shared by all requests going through returned middleware, so
+// it should be used for requests with similar latency (e.g. to the same
+// API). It should be placed after middleware that retries requests (Retry
+// or SRVFailover), which is checked by chain validation (see Validate).
+func RespectDeadline() Middleware {
+	return &deadlineGuard{}
+}
+
+// deadlineGuard is middleware returned by RespectDeadline.
+type deadlineGuard struct {
+	mu       sync.Mutex
+	estimate time.Duration
+}
+
+// Exec is implementation of Middleware interface.
+func (dg *deadlineGuard) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		ctx = ensureContext(ctx)
+		if deadline, ok := ctx.Deadline(); ok && Attempt(ctx) > 1 {
+			dg.mu.Lock()
+			expected := dg.estimate
+			dg.mu.Unlock()
+			if expected > 0 && time.Until(deadline) < expected {
+				return nil, ErrInsufficientDeadline
+			}
+		}
+
+		start := time.Now()
+		resp, err = next.Handle(ctx, req)
+		duration := time.Since(start)
+		dg.mu.Lock()
+		if dg.estimate == 0 {
+			dg.estimate = duration
+		} else {
+			dg.estimate = time.Duration(0.7*float64(dg.estimate) + 0.3*float64(duration))
+		}
+		dg.mu.Unlock()
+		return resp, err
+	})
+}
+
+// Validate is implementation of Validator interface. It reports error if
+// there is no middleware that retries requests before this one.
+func (dg *deadlineGuard) Validate(middlewares []Middleware, index int) error {
+	for _, m := range middlewares[:index] {
+		switch m.(type) {
+		case *retrier, *Failover:
+			return nil
+		}
+	}
+	return errors.New("cliware: RespectDeadline has no effect without Retry or SRVFailover before it")
+}
+
+// LatencyTimeout is middleware that sets timeout of requests based on their
+// observed latency. It is created using PercentileTimeout function.
+type LatencyTimeout struct {
+	// Min is lowest timeout that can be set. If zero, timeout has no floor.
+	Min time.Duration
+	// Max is highest timeout that can be set. It is also used for requests
+	// to hosts without enough observed latencies for estimate. If zero,
+	// timeout has no ceiling and requests without estimate have no timeout.
+	Max time.Duration
+
+	percentile float64
+	multiplier float64
+	err        error
+
+	mu    sync.Mutex
+	hosts map[string]*quantileEstimator
+}
+
+// PercentileTimeout returns middleware that sets timeout of every request to
+// provided percentile (between 0 and 1, e.g. 0.99) of latencies of previous
+// requests to the same host multiplied by multiplier, limited by Min and Max.
+// This tunes timeouts to actual behaviour of servers, making them tighter
+// for servers that prove to be fast and looser for slow ones. Percentiles
+// are estimated using P² algorithm, which does not store observations, so
+// memory used for every host is constant. Estimate is available once five
+// latencies are observed for host, until then Max is used.
+//
+// Latency is measured until response is returned by next handler. Requests
+// that fail with error other than exceeded timeout are not observed, while
+// ones that time out are observed with their full timeout, so estimate grows
+// if server gets slower (multiplier larger than 1 is needed for that).
+// Requests without URL are passed to next handler without timeout. If
+// percentile is out of range, every request fails with error describing it.
+//
+// Timeout is applied by deriving context with deadline, which is cancelled
+// immediately if error is returned or when response body is closed
+// otherwise.
+func PercentileTimeout(percentile float64, multiplier float64) *LatencyTimeout {
+	lt := &LatencyTimeout{
+		percentile: percentile,
+		multiplier: multiplier,
+		hosts:      make(map[string]*quantileEstimator),
+	}
+	if percentile <= 0 || percentile >= 1 {
+		lt.err = fmt.Errorf("cliware: percentile must be between 0 and 1, got %g", percentile)
+	}
+	return lt
+}
+
+// Timeout returns timeout currently set for requests to provided host.
+// Zero is returned if there is no timeout.
+func (lt *LatencyTimeout) Timeout(host string) time.Duration {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	var timeout time.Duration
+	if estimator, ok := lt.hosts[host]; ok && estimator.ready() {
+		timeout = time.Duration(estimator.value() * lt.multiplier)
+	} else {
+		return lt.Max
+	}
+	if timeout < lt.Min {
+		timeout = lt.Min
+	}
+	if lt.Max > 0 && timeout > lt.Max {
+		timeout = lt.Max
+	}
+	return timeout
+}
+
+// Exec is implementation of Middleware interface.
+func (lt *LatencyTimeout) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		if lt.err != nil {
+			return nil, lt.err
+		}
+		if req == nil || req.URL == nil {
+			return next.Handle(ctx, req)
+		}
+		host := req.URL.Host
+		timeout := lt.Timeout(host)
+		ctx = ensureContext(ctx)
+		cancel := context.CancelFunc(func() {})
+		if timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
+
+		start := time.Now()
+		resp, err = next.Handle(ctx, req)
+		latency := time.Since(start)
+		if err != nil {
+			timedOut := timeout > 0 && ctx.Err() == context.DeadlineExceeded
+			cancel()
+			if !timedOut {
+				return resp, err
+			}
+			latency = timeout
+		} else {
+			cancelOnClose(resp, cancel)
+		}
+
+		lt.mu.Lock()
+		estimator, ok := lt.hosts[host]
+		if !ok {
+			estimator = newQuantileEstimator(lt.percentile)
+			lt.hosts[host] = estimator
+		}
+		estimator.add(float64(latency))
+		lt.mu.Unlock()
+		return resp, err
+	})
+}
+
+// quantileEstimator is streaming estimator of single quantile using P²
+// algorithm (Jain and Chlamtac, 1985). It tracks five markers: minimum,
+// maximum, estimated quantile and two estimates halfway to it, and adjusts
+// their heights using piecewise-parabolic interpolation as observations come.
+type quantileEstimator struct {
+	p         float64
+	count     int
+	heights   [5]float64
+	positions [5]float64
+	desired   [5]float64
+	increment [5]float64
+}
+
+// newQuantileEstimator returns estimator of quantile p.
+func newQuantileEstimator(p float64) *quantileEstimator {
+	return &quantileEstimator{
+		p:         p,
+		positions: [5]float64{0, 1, 2, 3, 4},
+		desired:   [5]float64{0, 2 * p, 4 * p, 2 + 2*p, 4},
+		increment: [5]float64{0, p / 2, p, (1 + p) / 2, 1},
+	}
+}
+
+// ready returns true if enough observations are added for estimate.
+func (qe *quantileEstimator) ready() bool {
+	return qe.count >= len(qe.heights)
+}
+
+// value returns estimated quantile. It must not be called before estimator
+// is ready.
+func (qe *quantileEstimator) value() float64 {
+	return qe.heights[2]
+}
+
+// add adds new observation to estimator.
+func (qe *quantileEstimator) add(x float64) {
+	if qe.count < len(qe.heights) {
+		qe.heights[qe.count] = x
+		qe.count++
+		if qe.count == len(qe.heights) {
+			sort.Float64s(qe.heights[:])
+		}
+		return
+	}
+	qe.count++
+
+	var k int
+	switch {
+	case x < qe.heights[0]:
+		qe.heights[0] = x
+		k = 0
+	case x >= qe.heights[4]:
+		qe.heights[4] = x
+		k = 3
+	default:
+		for k = 0; x >= qe.heights[k+1]; k++ {
+		}
+	}
+	for i := k + 1; i < 5; i++ {
+		qe.positions[i]++
+	}
+	for i := range qe.desired {
+		qe.desired[i] += qe.increment[i]
+	}
+
+	for i := 1; i < 4; i++ {
+		d := qe.desired[i] - qe.positions[i]
+		if (d >= 1 && qe.positions[i+1]-qe.positions[i] > 1) || (d <= -1 && qe.positions[i-1]-qe.positions[i] < -1) {
+			sign := 1
+			if d < 0 {
+				sign = -1
+			}
+			height := qe.parabolic(i, float64(sign))
+			if height <= qe.heights[i-1] || height >= qe.heights[i+1] {
+				height = qe.linear(i, sign)
+			}
+			qe.heights[i] = height
+			qe.positions[i] += float64(sign)
+		}
+	}
+}
+
+// parabolic returns height of marker i moved by d using piecewise-parabolic
+// formula.
+func (qe *quantileEstimator) parabolic(i int, d float64) float64 {
+	q, n := qe.heights, qe.positions
+	return q[i] + d/(n[i+1]-n[i-1])*((n[i]-n[i-1]+d)*(q[i+1]-q[i])/(n[i+1]-n[i])+
+		(n[i+1]-n[i]-d)*(q[i]-q[i-1])/(n[i]-n[i-1]))
+}
+
+// linear returns height of marker i moved by d using linear formula.
+func (qe *quantileEstimator) linear(i int, d int) float64 {
+	q, n := qe.heights, qe.positions
+	return q[i] + float64(d)*(q[i+d]-q[i])/(n[i+d]-n[i])
+}
+
+// EnforceDeadline returns middleware that returns control to caller when
+// context of request is done, even if next handler ignores context (e.g.
+// custom http.RoundTripper that does not support cancellation). Next handler
+// is called in new goroutine and, if context is done before it returns,
+// context error is returned immediately. Timeout middleware and deadlines
+// set by caller, which rely on handlers respecting context, can be enforced
+// this way.
+//
+// Abandoned handler keeps running in its goroutine, holding any resources
+// (connections, memory) it uses until it returns on its own, after which
+// body of response it returns is closed. Handlers that respect context do
+// not need this middleware and release resources as soon as context is
+// done, so it should only be used for handlers that do not.
+func EnforceDeadline() Middleware {
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			ctx = ensureContext(ctx)
+			if err = ctx.Err(); err != nil {
+				return nil, err
+			}
+			results := make(chan result, 1)
+			go func() {
+				resp, err := next.Handle(ctx, req)
+				results <- result{resp, err}
+			}()
+			select {
+			case r := <-results:
+				return r.resp, r.err
+			case <-ctx.Done():
+				go func() {
+					if r := <-results; r.resp != nil && r.resp.Body != nil {
+						r.resp.Body.Close()
+					}
+				}()
+				return nil, ctx.Err()
+			}
+		})
+	})
+}
@@ -0,0 +1,440 @@
+package cliware_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	m "go.delic.rs/cliware"
+)
+
+// retryingMiddleware calls next handler provided number of times, marking
+// each call with attempt number.
+func retryingMiddleware(attempts int) m.Middleware {
+	return m.MiddlewareFunc(func(next m.Handler) m.Handler {
+		return m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			for i := 1; i <= attempts; i++ {
+				resp, err = next.Handle(m.WithAttempt(ctx, i), req)
+			}
+			return resp, err
+		})
+	})
+}
+
+func TestTimeout(t *testing.T) {
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	start := time.Now()
+	_, err := m.Timeout(20*time.Millisecond).Exec(handler).Handle(nil, m.EmptyRequest())
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected error: \"%s\", got: %v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Request not aborted on timeout. Took: %s", elapsed)
+	}
+}
+
+func TestTimeoutCancels(t *testing.T) {
+	var handlerCtx context.Context
+	var handlerErr error
+	var body io.ReadCloser
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		handlerCtx = ctx
+		if body == nil {
+			return nil, handlerErr
+		}
+		return &http.Response{Body: body}, nil
+	})
+	h := m.Timeout(time.Minute).Exec(handler)
+
+	handlerErr = errors.New("failure")
+	h.Handle(context.Background(), m.EmptyRequest())
+	if handlerCtx.Err() == nil {
+		t.Error("Context not cancelled after handler returned error.")
+	}
+
+	body = ioutil.NopCloser(strings.NewReader("data"))
+	resp, _ := h.Handle(context.Background(), m.EmptyRequest())
+	if handlerCtx.Err() != nil {
+		t.Error("Context cancelled before body is closed.")
+	}
+	resp.Body.Close()
+	if handlerCtx.Err() == nil {
+		t.Error("Context not cancelled after body is closed.")
+	}
+
+	panicking := m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		handlerCtx = ctx
+		panic("boom")
+	})
+	m.NewChain(m.Recover(), m.Timeout(time.Minute)).Exec(panicking).Handle(nil, m.EmptyRequest())
+	if handlerCtx.Err() == nil {
+		t.Error("Context not cancelled after handler panicked.")
+	}
+}
+
+func TestTimeoutExpiredParent(t *testing.T) {
+	handler, called := createHandler()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := m.Timeout(time.Minute).Exec(handler).Handle(ctx, m.EmptyRequest())
+	if err != context.Canceled {
+		t.Errorf("Expected error: \"%s\", got: %v", context.Canceled, err)
+	}
+	if *called {
+		t.Error("Handler called with expired context.")
+	}
+}
+
+func TestEnforceDeadline(t *testing.T) {
+	release := make(chan struct{})
+	body := &trackedBody{Reader: strings.NewReader("late")}
+	closed := make(chan struct{})
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		// handler ignores context
+		<-release
+		defer close(closed)
+		return &http.Response{StatusCode: 200, Body: body}, nil
+	})
+	h := m.NewChain(m.Timeout(20*time.Millisecond), m.EnforceDeadline()).Exec(handler)
+	start := time.Now()
+	resp, err := h.Handle(nil, m.EmptyRequest())
+	if resp != nil || err != context.DeadlineExceeded {
+		t.Errorf("Expected error: \"%s\", got: %v, %v", context.DeadlineExceeded, resp, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Control not returned at deadline. Took: %s", elapsed)
+	}
+
+	close(release)
+	<-closed
+	time.Sleep(10 * time.Millisecond)
+	if !body.isClosed() {
+		t.Error("Body of abandoned response not closed.")
+	}
+}
+
+func TestEnforceDeadlineInTime(t *testing.T) {
+	resp, err := m.EnforceDeadline().Exec(createStatusHandler(201)).Handle(nil, m.EmptyRequest())
+	if err != nil || resp.StatusCode != 201 {
+		t.Errorf("Wrong result. Got: %v, %v", resp, err)
+	}
+}
+
+func TestAdaptiveTimeoutIncreasingDeadlines(t *testing.T) {
+	var timeouts []time.Duration
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			t.Fatal("Deadline not set on context.")
+		}
+		timeouts = append(timeouts, deadline.Sub(time.Now()))
+		return nil, nil
+	})
+	chain := m.NewChain(retryingMiddleware(4), m.AdaptiveTimeout(100*time.Millisecond, 2, 500*time.Millisecond))
+	chain.Exec(handler).Handle(nil, nil)
+
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 500 * time.Millisecond}
+	if len(timeouts) != len(expected) {
+		t.Fatalf("Wrong number of attempts. Got: %d, expected: %d", len(timeouts), len(expected))
+	}
+	for i, timeout := range timeouts {
+		if timeout > expected[i] || timeout < expected[i]-50*time.Millisecond {
+			t.Errorf("Wrong timeout for attempt %d. Got: %s, expected: %s", i+1, timeout, expected[i])
+		}
+	}
+}
+
+func TestAdaptiveTimeoutRespectsParentDeadline(t *testing.T) {
+	var timeout time.Duration
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		deadline, _ := ctx.Deadline()
+		timeout = deadline.Sub(time.Now())
+		return nil, nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	m.AdaptiveTimeout(time.Second, 2, 0).Exec(handler).Handle(ctx, nil)
+	if timeout > 50*time.Millisecond {
+		t.Errorf("Parent deadline not respected. Got timeout: %s", timeout)
+	}
+}
+
+func TestAdaptiveTimeoutCancelsOnBodyClose(t *testing.T) {
+	var attemptCtx context.Context
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		attemptCtx = ctx
+		return &http.Response{Body: ioutil.NopCloser(strings.NewReader("data"))}, nil
+	})
+	resp, err := m.AdaptiveTimeout(time.Minute, 2, 0).Exec(handler).Handle(nil, nil)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if attemptCtx.Err() != nil {
+		t.Error("Context cancelled before body is closed.")
+	}
+	resp.Body.Close()
+	if attemptCtx.Err() == nil {
+		t.Error("Context not cancelled after body is closed.")
+	}
+}
+
+// createTricklingServer returns server that writes chunks of data, sleeping
+// for provided delays before each of them.
+func createTricklingServer(delays ...time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.(http.Flusher).Flush()
+		for _, delay := range delays {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+			w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+		}
+	}))
+}
+
+func TestIdleTimeoutProgressing(t *testing.T) {
+	server := createTricklingServer(30*time.Millisecond, 30*time.Millisecond, 30*time.Millisecond, 30*time.Millisecond)
+	defer server.Close()
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := m.IdleTimeout(100*time.Millisecond).Exec(transportHandler).Handle(nil, req)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal("Progressing response failed: ", err)
+	}
+	if len(body) != 20 {
+		t.Errorf("Wrong body length. Got: %d, expected: 20", len(body))
+	}
+}
+
+func TestIdleTimeoutStalled(t *testing.T) {
+	server := createTricklingServer(10*time.Millisecond, time.Second)
+	defer server.Close()
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := m.IdleTimeout(100*time.Millisecond).Exec(transportHandler).Handle(nil, req)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	defer resp.Body.Close()
+	start := time.Now()
+	_, err = ioutil.ReadAll(resp.Body)
+	if err != m.ErrIdleTimeout {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", m.ErrIdleTimeout, err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("Stalled response was not cancelled in time.")
+	}
+}
+
+func TestIdleTimeoutBeforeResponse(t *testing.T) {
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	_, err := m.IdleTimeout(20*time.Millisecond).Exec(handler).Handle(nil, m.EmptyRequest())
+	if err != m.ErrIdleTimeout {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", m.ErrIdleTimeout, err)
+	}
+}
+
+func TestTimeoutBySize(t *testing.T) {
+	for _, data := range []struct {
+		body     io.Reader
+		max      time.Duration
+		expected time.Duration
+	}{
+		{nil, 0, time.Second},
+		{bytes.NewReader(make([]byte, 2<<20)), 0, 21 * time.Second},
+		{bytes.NewReader(make([]byte, 512<<10)), 0, 6 * time.Second},
+		{bytes.NewReader(make([]byte, 10<<20)), 30 * time.Second, 30 * time.Second},
+		{ioutil.NopCloser(bytes.NewReader(make([]byte, 2<<20))), 0, time.Second},
+	} {
+		var timeout time.Duration
+		handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+			deadline, _ := ctx.Deadline()
+			timeout = time.Until(deadline)
+			return nil, nil
+		})
+		req, _ := http.NewRequest("POST", "http://localhost/", data.body)
+		st := m.TimeoutBySize(time.Second, 10*time.Second)
+		st.Max = data.max
+		if _, err := st.Exec(handler).Handle(nil, req); err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+		if timeout > data.expected || timeout < data.expected-time.Second {
+			t.Errorf("Wrong timeout. Got: %s, expected: %s", timeout, data.expected)
+		}
+	}
+}
+
+func TestTimeoutBySizeUnknownLength(t *testing.T) {
+	var timeout time.Duration
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		deadline, _ := ctx.Deadline()
+		timeout = time.Until(deadline)
+		return nil, nil
+	})
+	req, _ := http.NewRequest("POST", "http://localhost/", bytes.NewReader(make([]byte, 2<<20)))
+	req.ContentLength = -1
+	if _, err := m.TimeoutBySize(time.Second, 10*time.Second).Exec(handler).Handle(nil, req); err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if timeout > time.Second {
+		t.Errorf("Base timeout not used for unknown length. Got: %s", timeout)
+	}
+
+	passing, called := createHandler()
+	if _, err := m.TimeoutBySize(time.Second, 10*time.Second).Exec(passing).Handle(nil, nil); err != nil || !*called {
+		t.Errorf("Nil request not passed to handler. Error: %v", err)
+	}
+}
+
+func TestRespectDeadline(t *testing.T) {
+	var calls int
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		calls++
+		time.Sleep(30 * time.Millisecond)
+		return nil, errors.New("temporary failure")
+	})
+	h := m.RespectDeadline().Exec(handler)
+	ctx, cancel := context.WithTimeout(context.Background(), 80*time.Millisecond)
+	defer cancel()
+
+	var errs []error
+	for attempt := 1; attempt <= 4; attempt++ {
+		_, err := h.Handle(m.WithAttempt(ctx, attempt), m.EmptyRequest())
+		errs = append(errs, err)
+	}
+	// Two attempts fit into deadline, third one would not complete in time.
+	if calls != 2 {
+		t.Errorf("Wrong number of started attempts. Got: %d, expected: 2", calls)
+	}
+	if errs[2] != m.ErrInsufficientDeadline || errs[3] != m.ErrInsufficientDeadline {
+		t.Errorf("Expected error: \"%s\", got: %v", m.ErrInsufficientDeadline, errs)
+	}
+
+	// First attempts and requests without deadline are always sent.
+	h.Handle(ctx, m.EmptyRequest())
+	h.Handle(m.WithAttempt(context.Background(), 5), m.EmptyRequest())
+	if calls != 4 {
+		t.Errorf("Attempts not started. Handler calls: %d, expected: 4", calls)
+	}
+}
+
+func TestRespectDeadlineValidate(t *testing.T) {
+	for _, data := range []struct {
+		chain *m.Chain
+		valid bool
+	}{
+		{m.NewChain(m.Retry(3, nil, nil), m.RespectDeadline()), true},
+		{m.NewChain(m.Named("retry", m.Retry(3, nil, nil)), m.Named("deadline", m.RespectDeadline())), true},
+		{m.NewChain(m.SRVFailover("_api._tcp.example.com", nil), m.RespectDeadline()), true},
+		{m.NewChain(m.RespectDeadline(), m.Retry(3, nil, nil)), false},
+		{m.NewChain(m.RespectDeadline()), false},
+	} {
+		if err := data.chain.Validate(); (err == nil) != data.valid {
+			t.Errorf("Wrong validation of %v. Got error: %v", data.chain.Names(), err)
+		}
+	}
+}
+
+func TestPercentileTimeout(t *testing.T) {
+	var deadlines []time.Duration
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		if deadline, ok := ctx.Deadline(); ok {
+			deadlines = append(deadlines, time.Until(deadline))
+		}
+		time.Sleep(10 * time.Millisecond)
+		return &http.Response{StatusCode: 200}, nil
+	})
+	lt := m.PercentileTimeout(0.5, 3)
+	lt.Max = time.Second
+	h := lt.Exec(handler)
+	for i := 0; i < 20; i++ {
+		h.Handle(nil, m.EmptyRequest())
+	}
+
+	if deadlines[0] <= 900*time.Millisecond {
+		t.Errorf("Ceiling not used before latencies are observed. Got: %s", deadlines[0])
+	}
+	timeout := lt.Timeout("")
+	if timeout < 30*time.Millisecond || timeout > 100*time.Millisecond {
+		t.Errorf("Wrong timeout computed. Got: %s, expected about 30ms", timeout)
+	}
+	if last := deadlines[len(deadlines)-1]; last > 100*time.Millisecond {
+		t.Errorf("Computed timeout not applied. Got: %s", last)
+	}
+	if lt.Timeout("other.example.com") != time.Second {
+		t.Error("Latencies not tracked per host.")
+	}
+
+	lt.Min = time.Second
+	if lt.Timeout("") != time.Second {
+		t.Errorf("Floor not applied. Got: %s", lt.Timeout(""))
+	}
+}
+
+func TestPercentileTimeoutExceeded(t *testing.T) {
+	var slow int32 = 1
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		if atomic.LoadInt32(&slow) == 0 {
+			return &http.Response{StatusCode: 200}, nil
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	lt := m.PercentileTimeout(0.9, 2)
+	lt.Min = time.Millisecond
+	lt.Max = 50 * time.Millisecond
+	h := lt.Exec(handler)
+	atomic.StoreInt32(&slow, 0)
+	for i := 0; i < 10; i++ {
+		h.Handle(nil, m.EmptyRequest())
+	}
+	atomic.StoreInt32(&slow, 1)
+	for i := 0; i < 10; i++ {
+		if _, err := h.Handle(nil, m.EmptyRequest()); err != context.DeadlineExceeded {
+			t.Fatalf("Expected error: \"%s\", got: %v", context.DeadlineExceeded, err)
+		}
+	}
+	// Timed out requests are observed with their timeout, so estimate grows
+	// above the floor fast requests settled at.
+	if timeout := lt.Timeout(""); timeout <= 2*time.Millisecond {
+		t.Errorf("Timed out requests not observed. Timeout: %s", timeout)
+	}
+}
+
+func TestPercentileTimeoutInvalid(t *testing.T) {
+	h := m.PercentileTimeout(1.5, 2).Exec(createStatusHandler(200))
+	if _, err := h.Handle(nil, m.EmptyRequest()); err == nil {
+		t.Error("Expected error for invalid percentile.")
+	}
+}
+
+func TestPercentileTimeoutNilRequest(t *testing.T) {
+	handler, called := createHandler()
+	if _, err := m.PercentileTimeout(0.99, 2).Exec(handler).Handle(nil, nil); err != nil || !*called {
+		t.Errorf("Nil request not passed to handler. Error: %v", err)
+	}
+	if _, err := m.PercentileTimeout(0.99, 2).Exec(handler).Handle(nil, &http.Request{Method: "GET"}); err != nil {
+		t.Error("Handle returned error for request without URL: ", err)
+	}
+}
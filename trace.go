@@ -0,0 +1,166 @@
+package cliware
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"sync"
+	"time"
+)
+
+// EarlyHints returns middleware that calls onHint with headers of every
+// 103 Early Hints response server sends before final response. This allows
+// client to act on hints (e.g. Link preload headers) before final response
+// arrives.
+//
+// Standard library HTTP client does not return informational responses, it
+// only reports them using httptrace, so this middleware installs
+// httptrace.ClientTrace into context passed to next handler. Hints are
+// reported only if terminal handler sends request with that context (e.g.
+// using req.WithContext(ctx)), as Handler documentation requires.
+func EarlyHints(onHint func(http.Header)) Middleware {
+	return ContextProcessor(func(ctx context.Context) context.Context {
+		return httptrace.WithClientTrace(ensureContext(ctx), &httptrace.ClientTrace{
+			Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+				if code == 103 {
+					onHint(http.Header(header))
+				}
+				return nil
+			},
+		})
+	})
+}
+
+// RequestTimings holds durations of phases of sending request. Durations of
+// phases that did not happen (e.g. DNS lookup and connecting, when idle
+// connection was reused) are zero.
+type RequestTimings struct {
+	// DNS is duration of DNS lookup.
+	DNS time.Duration
+	// Connect is duration of establishing TCP connection.
+	Connect time.Duration
+	// TLSHandshake is duration of TLS handshake.
+	TLSHandshake time.Duration
+	// TimeToFirstByte is duration from start of sending request until first
+	// byte of response was received.
+	TimeToFirstByte time.Duration
+	// Reused reports whether previously used connection was reused.
+	Reused bool
+}
+
+type requestTimingsKey struct{}
+
+// requestTimingsHolder is stored in context and PhaseTimings records
+// timings into it.
+type requestTimingsHolder struct {
+	mu       sync.Mutex
+	timings  RequestTimings
+	recorded bool
+}
+
+// WithRequestTimings returns copy of context into which PhaseTimings
+// middleware will record timings. Use RequestTimingsFromContext on returned
+// context to read recorded timings after request is done.
+func WithRequestTimings(ctx context.Context) context.Context {
+	return context.WithValue(ensureContext(ctx), requestTimingsKey{}, &requestTimingsHolder{})
+}
+
+// RequestTimingsFromContext returns timings recorded by PhaseTimings
+// middleware into context created by WithRequestTimings. If nothing was
+// recorded, false is returned.
+func RequestTimingsFromContext(ctx context.Context) (RequestTimings, bool) {
+	if ctx == nil {
+		return RequestTimings{}, false
+	}
+	holder, ok := ctx.Value(requestTimingsKey{}).(*requestTimingsHolder)
+	if !ok {
+		return RequestTimings{}, false
+	}
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+	return holder.timings, holder.recorded
+}
+
+// PhaseTimings returns middleware that measures durations of DNS lookup,
+// connecting, TLS handshake and time to first byte of response, and records
+// them into context created by WithRequestTimings. This breaks latency of
+// request down into phases, which shows where time is spent. If request is
+// sent multiple times (e.g. retried by middleware before this one), timings
+// of last attempt are recorded.
+//
+// Phases are measured using httptrace.ClientTrace installed into context
+// passed to next handler, so they are measured only if terminal handler
+// sends request with that context (e.g. using req.WithContext(ctx)), as
+// Handler documentation requires.
+func PhaseTimings() Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			var holder *requestTimingsHolder
+			if ctx != nil {
+				holder, _ = ctx.Value(requestTimingsKey{}).(*requestTimingsHolder)
+			}
+			if holder == nil {
+				return next.Handle(ctx, req)
+			}
+
+			var mu sync.Mutex
+			var timings RequestTimings
+			var dnsStart, connectStart, tlsStart time.Time
+			start := time.Now()
+			trace := &httptrace.ClientTrace{
+				DNSStart: func(httptrace.DNSStartInfo) {
+					mu.Lock()
+					dnsStart = time.Now()
+					mu.Unlock()
+				},
+				DNSDone: func(httptrace.DNSDoneInfo) {
+					mu.Lock()
+					timings.DNS = time.Since(dnsStart)
+					mu.Unlock()
+				},
+				ConnectStart: func(network, addr string) {
+					mu.Lock()
+					connectStart = time.Now()
+					mu.Unlock()
+				},
+				ConnectDone: func(network, addr string, err error) {
+					mu.Lock()
+					timings.Connect = time.Since(connectStart)
+					mu.Unlock()
+				},
+				TLSHandshakeStart: func() {
+					mu.Lock()
+					tlsStart = time.Now()
+					mu.Unlock()
+				},
+				TLSHandshakeDone: func(tls.ConnectionState, error) {
+					mu.Lock()
+					timings.TLSHandshake = time.Since(tlsStart)
+					mu.Unlock()
+				},
+				GotConn: func(info httptrace.GotConnInfo) {
+					mu.Lock()
+					timings.Reused = info.Reused
+					mu.Unlock()
+				},
+				GotFirstResponseByte: func() {
+					mu.Lock()
+					timings.TimeToFirstByte = time.Since(start)
+					mu.Unlock()
+				},
+			}
+			resp, err = next.Handle(httptrace.WithClientTrace(ctx, trace), req)
+
+			mu.Lock()
+			recorded := timings
+			mu.Unlock()
+			holder.mu.Lock()
+			holder.timings = recorded
+			holder.recorded = true
+			holder.mu.Unlock()
+			return resp, err
+		})
+	})
+}
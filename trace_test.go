@@ -0,0 +1,86 @@
+package cliware_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestEarlyHints(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(103)
+		w.WriteHeader(200)
+	}))
+	defer server.Close()
+
+	var hints []string
+	middleware := m.EarlyHints(func(header http.Header) {
+		hints = append(hints, header.Get("Link"))
+	})
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		return http.DefaultClient.Do(req.WithContext(ctx))
+	})
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := middleware.Exec(handler).Handle(context.Background(), req)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Wrong final status code. Got: %d, expected: 200", resp.StatusCode)
+	}
+	if len(hints) != 1 || hints[0] != "</style.css>; rel=preload; as=style" {
+		t.Errorf("Wrong early hints received: %v", hints)
+	}
+}
+
+func TestPhaseTimings(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer server.Close()
+	client := server.Client()
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		return client.Do(req.WithContext(ctx))
+	})
+	h := m.PhaseTimings().Exec(handler)
+
+	send := func() m.RequestTimings {
+		ctx := m.WithRequestTimings(context.Background())
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		resp, err := h.Handle(ctx, req)
+		if err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+		resp.Body.Close()
+		timings, ok := m.RequestTimingsFromContext(ctx)
+		if !ok {
+			t.Fatal("Timings not recorded.")
+		}
+		return timings
+	}
+	first := send()
+	if first.Reused || first.Connect <= 0 || first.TLSHandshake <= 0 || first.TimeToFirstByte < 10*time.Millisecond {
+		t.Errorf("Wrong timings of first request: %+v", first)
+	}
+	second := send()
+	if !second.Reused || second.Connect != 0 || second.TLSHandshake != 0 || second.TimeToFirstByte < 10*time.Millisecond {
+		t.Errorf("Wrong timings of request on reused connection: %+v", second)
+	}
+}
+
+func TestPhaseTimingsWithoutHolder(t *testing.T) {
+	handler, called := createHandler()
+	m.PhaseTimings().Exec(handler).Handle(context.Background(), m.EmptyRequest())
+	if !*called {
+		t.Error("Handler not called.")
+	}
+	if _, ok := m.RequestTimingsFromContext(context.Background()); ok {
+		t.Error("Timings found in context without holder.")
+	}
+}
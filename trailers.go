@@ -0,0 +1,55 @@
+package cliware
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// ReadTrailers returns middleware that calls onTrailers with trailers of
+// response (resp.Trailer) once its body is read until end. This supports
+// protocols that send status in trailers (e.g. gRPC), since error returned
+// by onTrailers is returned by Read of response body instead of io.EOF.
+//
+// Trailers are only available after whole body is read, so callback is
+// called from Read that reaches end of body and is not called at all if body
+// is closed before that. Callers that do not otherwise need body must read
+// it until end for trailers to be checked.
+func ReadTrailers(onTrailers func(http.Header) error) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			resp, err = next.Handle(ctx, req)
+			if err != nil || resp == nil || resp.Body == nil {
+				return resp, err
+			}
+			resp.Body = &trailerBody{ReadCloser: resp.Body, resp: resp, onTrailers: onTrailers}
+			return resp, err
+		})
+	})
+}
+
+// trailerBody calls onTrailers when underlying body returns io.EOF.
+type trailerBody struct {
+	io.ReadCloser
+	resp       *http.Response
+	onTrailers func(http.Header) error
+	done       bool
+	err        error
+}
+
+// Read is implementation of io.Reader interface.
+func (tb *trailerBody) Read(p []byte) (int, error) {
+	if tb.done {
+		return 0, tb.err
+	}
+	n, err := tb.ReadCloser.Read(p)
+	if err == io.EOF {
+		tb.done = true
+		tb.err = io.EOF
+		if trailerErr := tb.onTrailers(tb.resp.Trailer); trailerErr != nil {
+			tb.err = trailerErr
+		}
+		return n, tb.err
+	}
+	return n, err
+}
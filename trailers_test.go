@@ -0,0 +1,66 @@
+package cliware_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+func createTrailerServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte("payload"))
+		w.Header().Set("Grpc-Status", r.URL.Query().Get("status"))
+	}))
+}
+
+func TestReadTrailers(t *testing.T) {
+	server := createTrailerServer()
+	defer server.Close()
+	errStatus := errors.New("non-zero status")
+	mw := m.ReadTrailers(func(trailer http.Header) error {
+		if trailer.Get("Grpc-Status") != "0" {
+			return errStatus
+		}
+		return nil
+	})
+	for status, expected := range map[string]error{"0": nil, "13": errStatus} {
+		req, _ := http.NewRequest("GET", server.URL+"?status="+status, nil)
+		resp, err := mw.Exec(transportHandler).Handle(context.Background(), req)
+		if err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != expected {
+			t.Errorf("Wrong error for status %s. Got: %v, expected: %v", status, err, expected)
+		}
+		if string(body) != "payload" {
+			t.Errorf("Wrong body. Got: %q", body)
+		}
+	}
+}
+
+func TestReadTrailersNotReadBody(t *testing.T) {
+	server := createTrailerServer()
+	defer server.Close()
+	called := false
+	mw := m.ReadTrailers(func(trailer http.Header) error {
+		called = true
+		return nil
+	})
+	req, _ := http.NewRequest("GET", server.URL+"?status=0", nil)
+	resp, err := mw.Exec(transportHandler).Handle(context.Background(), req)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	resp.Body.Close()
+	if called {
+		t.Error("Trailers callback called before body was read.")
+	}
+}
@@ -0,0 +1,136 @@
+package cliware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+type transportKey struct{}
+
+// SelectTransport returns middleware that selects transport for request
+// using selector and stores it in context passed to next handler. If
+// selector is nil or returns nil, fallback is used, and if fallback is nil,
+// http.DefaultTransport is used. This allows, e.g., sending some requests
+// through proxy and others directly within single chain.
+//
+// Selected transport is only used by terminal handler that reads it from
+// context (see TransportFromContext), like one returned by
+// SelectedTransport, so chain should be executed with such handler. To use
+// it from http.Client, wrap executed chain using AsRoundTripper:
+//
+//	client := &http.Client{Transport: m.AsRoundTripper(chain.Exec(m.SelectedTransport()))}
+func SelectTransport(selector func(*http.Request) http.RoundTripper, fallback http.RoundTripper) Middleware {
+	if fallback == nil {
+		fallback = http.DefaultTransport
+	}
+	return MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+			var transport http.RoundTripper
+			if selector != nil {
+				transport = selector(req)
+			}
+			if transport == nil {
+				transport = fallback
+			}
+			return next.Handle(context.WithValue(ensureContext(ctx), transportKey{}, transport), req)
+		})
+	})
+}
+
+// TransportFromContext returns transport stored in context by
+// SelectTransport middleware.
+func TransportFromContext(ctx context.Context) (http.RoundTripper, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	transport, ok := ctx.Value(transportKey{}).(http.RoundTripper)
+	return transport, ok
+}
+
+// SelectedTransport returns terminal handler that sends requests using
+// transport stored in context by SelectTransport, or using
+// http.DefaultTransport if there is none. Request is sent with context
+// passed to handler.
+func SelectedTransport() Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		ctx = ensureContext(ctx)
+		transport, ok := TransportFromContext(ctx)
+		if !ok {
+			transport = http.DefaultTransport
+		}
+		return transport.RoundTrip(req.WithContext(ctx))
+	})
+}
+
+// ErrNoResponse is error returned by RoundTripper created using
+// AsRoundTripper when handler returns neither response nor error.
+var ErrNoResponse = errors.New("cliware: handler returned no response")
+
+// handlerRoundTripper is http.RoundTripper that sends requests using
+// handler.
+type handlerRoundTripper struct {
+	handler Handler
+}
+
+// AsRoundTripper returns http.RoundTripper that sends requests using
+// provided handler, with context of request. This allows using handlers,
+// e.g. executed chains, as transport of http.Client. Since RoundTripper must
+// not modify request, handler gets shallow copy of request with own copy of
+// header and URL. If handler returns response along with error, body of
+// response is drained and closed and only error is returned, as
+// http.RoundTripper contract requires.
+func AsRoundTripper(h Handler) http.RoundTripper {
+	return &handlerRoundTripper{handler: h}
+}
+
+// RoundTrip is implementation of http.RoundTripper interface.
+func (hrt *handlerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	clone := req.WithContext(req.Context())
+	clone.Header = cloneHeader(req.Header)
+	if clone.Header == nil {
+		clone.Header = make(http.Header)
+	}
+	if req.URL != nil {
+		u := *req.URL
+		clone.URL = &u
+	}
+	resp, err := hrt.handler.Handle(req.Context(), clone)
+	if err != nil {
+		// http.Client ignores response returned along with error, so its
+		// body would never be closed.
+		drainAndClose(resp)
+		return nil, err
+	}
+	if resp == nil {
+		return nil, ErrNoResponse
+	}
+	return resp, nil
+}
+
+// RoundTripper returns http.RoundTripper that sends requests through chain,
+// using final as terminal transport, or http.DefaultTransport if final is
+// nil. This plugs chain into http.Client:
+//
+//	client := &http.Client{Transport: chain.RoundTripper(nil)}
+//
+// Middlewares get context of request and run for every request client sends,
+// and responses and errors of final transport are returned through them.
+func (c *Chain) RoundTripper(final http.RoundTripper) http.RoundTripper {
+	return AsRoundTripper(c.Exec(RoundTripperHandler(final)))
+}
+
+// RoundTripperHandler returns terminal handler that sends requests using
+// provided transport, with context passed to handler. If rt is nil,
+// http.DefaultTransport is used. This allows using existing transports
+// (e.g. authenticating or mocking ones) as final handler of chain:
+//
+//	chain.Exec(m.RoundTripperHandler(transport))
+func RoundTripperHandler(rt http.RoundTripper) Handler {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		return rt.RoundTrip(req.WithContext(ensureContext(ctx)))
+	})
+}
@@ -0,0 +1,162 @@
+package cliware_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	m "go.delic.rs/cliware"
+)
+
+type namedTransport string
+
+func (nt namedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp := &http.Response{StatusCode: 200, Header: make(http.Header), Body: http.NoBody}
+	resp.Header.Set("Transport", string(nt))
+	return resp, nil
+}
+
+func TestSelectTransport(t *testing.T) {
+	selector := func(req *http.Request) http.RoundTripper {
+		if req.URL.Host == "internal" {
+			return namedTransport("direct")
+		}
+		return nil
+	}
+	h := m.SelectTransport(selector, namedTransport("proxy")).Exec(m.SelectedTransport())
+	for host, expected := range map[string]string{"internal": "direct", "external": "proxy"} {
+		req, _ := http.NewRequest("GET", "http://"+host+"/", nil)
+		resp, err := h.Handle(nil, req)
+		if err != nil {
+			t.Fatal("Handle returned error: ", err)
+		}
+		if got := resp.Header.Get("Transport"); got != expected {
+			t.Errorf("Wrong transport for %s. Got: %s, expected: %s", host, got, expected)
+		}
+	}
+	if _, ok := m.TransportFromContext(nil); ok {
+		t.Error("Transport found in nil context.")
+	}
+}
+
+func TestChainRoundTripper(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Received", r.Header.Get("X-Chain"))
+		w.WriteHeader(201)
+	}))
+	defer server.Close()
+
+	var status int
+	chain := m.NewChain(
+		m.RequestProcessor(func(req *http.Request) error {
+			req.Header.Set("X-Chain", "yes")
+			return nil
+		}),
+		m.ResponseProcessor(func(resp *http.Response, err error) error {
+			if resp != nil {
+				status = resp.StatusCode
+			}
+			return err
+		}),
+	)
+	client := &http.Client{Transport: chain.RoundTripper(nil)}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal("Do returned error: ", err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("Received") != "yes" || status != 201 {
+		t.Errorf("Middlewares not applied. Received: %q, status: %d", resp.Header.Get("Received"), status)
+	}
+	if req.Header.Get("X-Chain") != "" {
+		t.Error("Original request modified by round tripper.")
+	}
+}
+
+func TestChainRoundTripperError(t *testing.T) {
+	expected := errors.New("transport failed")
+	var seen error
+	chain := m.NewChain(m.ResponseProcessor(func(resp *http.Response, err error) error {
+		seen = err
+		return nil
+	}))
+	rt := chain.RoundTripper(failingTransport{expected})
+	if _, err := rt.RoundTrip(m.EmptyRequest()); err != expected {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", expected, err)
+	}
+	if seen != expected {
+		t.Errorf("Error not passed through middlewares. Got: %v", seen)
+	}
+
+	rt = m.AsRoundTripper(m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		return nil, nil
+	}))
+	if _, err := rt.RoundTrip(m.EmptyRequest()); err != m.ErrNoResponse {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", m.ErrNoResponse, err)
+	}
+}
+
+func TestChainRoundTripperClosesBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("invalid"))
+	}))
+	defer server.Close()
+
+	expected := errors.New("invalid response")
+	var body *trackedBody
+	final := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err == nil {
+			body = &trackedBody{Reader: resp.Body}
+			resp.Body = body
+		}
+		return resp, err
+	})
+	chain := m.NewChain(m.ResponseProcessor(func(resp *http.Response, err error) error {
+		return m.KeepBody(expected)
+	}))
+	client := &http.Client{Transport: chain.RoundTripper(final)}
+	resp, err := client.Get(server.URL)
+	if !errors.Is(err, expected) || resp != nil {
+		t.Fatalf("Expected error: \"%s\", got: %v, \"%v\"", expected, resp, err)
+	}
+	if body == nil || !body.isClosed() {
+		t.Error("Body of response returned with error not closed.")
+	}
+}
+
+type failingTransport struct {
+	err error
+}
+
+func (ft failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, ft.err
+}
+
+func TestRoundTripperHandler(t *testing.T) {
+	var received context.Context
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		received = req.Context()
+		return namedTransport("custom").RoundTrip(req)
+	})
+	ctx := context.WithValue(context.Background(), roleKey{}, "admin")
+	resp, err := m.NewChain().Exec(m.RoundTripperHandler(rt)).Handle(ctx, m.EmptyRequest())
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	if resp.Header.Get("Transport") != "custom" {
+		t.Error("Request not sent using provided transport.")
+	}
+	if received == nil || received.Value(roleKey{}) != "admin" {
+		t.Error("Request not sent with handler context.")
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
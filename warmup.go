@@ -0,0 +1,115 @@
+package cliware
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Warmer is middleware that establishes connections to hosts before first
+// real requests are sent to them. It is created using WarmUp function.
+type Warmer struct {
+	// Method is HTTP method of warm-up requests. Defaults to HEAD.
+	Method string
+
+	handler Handler
+
+	mu      sync.Mutex
+	warming map[string]chan struct{}
+}
+
+// WarmUp returns Warmer that sends warm-up requests through provided
+// handler, which should be the same handler real requests are sent with
+// (e.g. chain executing http.Client.Do). Use Warm method to warm up hosts,
+// e.g. at startup, so that first requests do not pay cost of establishing
+// connection (DNS lookup, TCP and TLS handshake). Requests to host that is
+// being warmed up wait until warm-up completes, so they reuse warmed up
+// connection instead of opening another one.
+//
+// Warm-up only helps if handler keeps connections alive and reuses them, as
+// http.Transport does by default. Cliware does not own connections, so it
+// can not do more than sending lightweight request and releasing its
+// response.
+func WarmUp(handler Handler) *Warmer {
+	return &Warmer{Method: "HEAD", handler: handler, warming: make(map[string]chan struct{})}
+}
+
+// Warm concurrently sends warm-up request to each of provided hosts and
+// waits for all of them to complete. Hosts are either base URLs (e.g.
+// "http://example.com:8080") or host names, in which case https is used.
+// Status codes of responses are ignored. First error encountered is
+// returned, after all hosts are warmed up.
+func (w *Warmer) Warm(ctx context.Context, hosts ...string) error {
+	ctx = ensureContext(ctx)
+	errs := make(chan error, len(hosts))
+	for _, host := range hosts {
+		go func(host string) {
+			errs <- w.warm(ctx, host)
+		}(host)
+	}
+	var first error
+	for range hosts {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// warm sends warm-up request to single host.
+func (w *Warmer) warm(ctx context.Context, host string) error {
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	target, err := url.Parse(host)
+	if err != nil {
+		return err
+	}
+	method := w.Method
+	if method == "" {
+		method = "HEAD"
+	}
+	req, err := http.NewRequest(method, target.Scheme+"://"+target.Host+"/", nil)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	w.mu.Lock()
+	w.warming[target.Host] = done
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		if w.warming[target.Host] == done {
+			delete(w.warming, target.Host)
+		}
+		w.mu.Unlock()
+		close(done)
+	}()
+
+	resp, err := w.handler.Handle(ctx, req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	drainAndClose(resp)
+	return nil
+}
+
+// Exec is implementation of Middleware interface.
+func (w *Warmer) Exec(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+		w.mu.Lock()
+		done, ok := w.warming[req.URL.Host]
+		w.mu.Unlock()
+		if ok {
+			select {
+			case <-done:
+			case <-ensureContext(ctx).Done():
+				return nil, ctx.Err()
+			}
+		}
+		return next.Handle(ctx, req)
+	})
+}
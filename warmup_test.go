@@ -0,0 +1,86 @@
+package cliware_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	m "go.delic.rs/cliware"
+)
+
+func TestWarmUp(t *testing.T) {
+	var mu sync.Mutex
+	var methods []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.Method)
+		mu.Unlock()
+	}))
+	defer server.Close()
+	transport := &http.Transport{}
+	defer transport.CloseIdleConnections()
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		return transport.RoundTrip(req.WithContext(ctx))
+	})
+
+	warmer := m.WarmUp(handler)
+	if err := warmer.Warm(context.Background(), server.URL); err != nil {
+		t.Fatal("Warm returned error: ", err)
+	}
+
+	var reused bool
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused },
+	})
+	req, _ := http.NewRequest("GET", server.URL+"/resource", nil)
+	resp, err := warmer.Exec(handler).Handle(ctx, req)
+	if err != nil {
+		t.Fatal("Handle returned error: ", err)
+	}
+	resp.Body.Close()
+	if !reused {
+		t.Error("First request did not reuse warmed up connection.")
+	}
+	if strings.Join(methods, " ") != "HEAD GET" {
+		t.Errorf("Wrong requests received. Got: %v", methods)
+	}
+}
+
+func TestWarmUpWaits(t *testing.T) {
+	release := make(chan struct{})
+	handler, calls := createBlockingHandler(release, nil)
+	warmer := m.WarmUp(handler)
+	go warmer.Warm(context.Background(), "example.com")
+	waitForCall(calls)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", "https://example.com/", nil)
+	if _, err := warmer.Exec(handler).Handle(ctx, req); err != context.DeadlineExceeded {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", context.DeadlineExceeded, err)
+	}
+	close(release)
+
+	req, _ = http.NewRequest("GET", "https://other.com/", nil)
+	if _, err := warmer.Exec(handler).Handle(nil, req); err != nil {
+		t.Error("Request to other host failed: ", err)
+	}
+}
+
+func TestWarmUpError(t *testing.T) {
+	handler := m.HandlerFunc(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		if req.URL.Host == "down.com" {
+			return nil, context.DeadlineExceeded
+		}
+		return createStatusHandler(200).Handle(ctx, req)
+	})
+	err := m.WarmUp(handler).Warm(nil, "up.com", "down.com", "http://up.com:8080")
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected error: \"%s\", got: \"%v\"", context.DeadlineExceeded, err)
+	}
+}